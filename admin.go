@@ -0,0 +1,680 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/handlers"
+	"forum/store"
+)
+
+// Noms des rôles acceptés par user create et user promote
+var roleNames = map[string]int{
+	"user":      handlers.RoleUser,
+	"moderator": handlers.RoleModerator,
+	"admin":     handlers.RoleAdmin,
+}
+
+func roleName(role int) string {
+	for name, id := range roleNames {
+		if id == role {
+			return name
+		}
+	}
+	return strconv.Itoa(role)
+}
+
+func parseRole(name string) (int, error) {
+	role, ok := roleNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown role %q (user, moderator or admin)", errUsage, name)
+	}
+	return role, nil
+}
+
+// recordCLIAudit enregistre une action de la ligne de commande dans le journal
+// d'audit, sous l'auteur « console »
+func recordCLIAudit(event handlers.AuditEvent) error {
+	if err := handlers.RecordAudit(db, 0, "", event); err != nil {
+		return fmt.Errorf("action done but not audited: %w", err)
+	}
+	return nil
+}
+
+func findUser(username string) (store.UserProfile, error) {
+	profile, err := db.Users().ProfileByName(username)
+	if err == store.ErrNotFound {
+		return profile, fmt.Errorf("unknown user %q", username)
+	}
+	return profile, err
+}
+
+// randomPassword génère un mot de passe de 24 caractères
+func randomPassword() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// choosePassword renvoie le mot de passe donné en option ou en génère un ; generated
+// indique qu'il faut l'afficher
+func choosePassword(password string) (chosen string, generated bool, err error) {
+	if password == "" {
+		password, err = randomPassword()
+		return password, true, err
+	}
+	if len(password) < handlers.MinPasswordLength {
+		return "", false, fmt.Errorf("password must be at least %d characters", handlers.MinPasswordLength)
+	}
+	return password, false, nil
+}
+
+// userResult est la sortie des sous-commandes user
+type userResult struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+	Password string `json:"password,omitempty"` // seulement s'il a été généré
+}
+
+func (u userResult) printPassword(w io.Writer) {
+	if u.Password != "" {
+		fmt.Fprintf(w, "Mot de passe :\t%s\n", u.Password)
+	}
+}
+
+// runUser implémente user create, promote, ban et reset-password
+func runUser(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing user command", errUsage)
+	}
+	switch args[0] {
+	case "create":
+		return userCreate(args[1:])
+	case "promote":
+		return userPromote(args[1:])
+	case "ban":
+		return userBan(args[1:])
+	case "reset-password":
+		return userResetPassword(args[1:])
+	case "export":
+		return userExport(args[1:])
+	case "delete":
+		return userDelete(args[1:])
+	}
+	return fmt.Errorf("%w: unknown user command %q", errUsage, args[0])
+}
+
+func userCreate(args []string) error {
+	flags, out := commandFlags("user create")
+	password := flags.String("password", "", "mot de passe")
+	roleFlag := flags.String("role", "user", "rôle")
+	positional, err := parseCommand(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	username, mail := strings.TrimSpace(positional[0]), strings.TrimSpace(positional[1])
+	if username == "" || !strings.Contains(mail, "@") {
+		return fmt.Errorf("%w: a username and an e-mail address are required", errUsage)
+	}
+	if strings.EqualFold(username, handlers.DeletedAccountName) {
+		return fmt.Errorf("username %q is reserved", handlers.DeletedAccountName)
+	}
+	role, err := parseRole(*roleFlag)
+	if err != nil {
+		return err
+	}
+	chosen, generated, err := choosePassword(*password)
+	if err != nil {
+		return err
+	}
+
+	taken, err := db.Users().Taken(username, mail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("username or e-mail address already in use")
+	}
+	hash, err := handlers.HashPassword(chosen)
+	if err != nil {
+		return err
+	}
+	id, err := db.Users().Create(store.NewUser{Username: username, Mail: mail, PasswordHash: hash, RoleID: role})
+	if err != nil {
+		return err
+	}
+
+	result := userResult{ID: int(id), Username: username, Role: roleName(role)}
+	if generated {
+		result.Password = chosen
+	}
+	err = recordCLIAudit(handlers.AuditEvent{
+		Action:      handlers.AuditUserCreate,
+		TargetType:  handlers.AuditTargetUser,
+		TargetID:    result.ID,
+		TargetLabel: username,
+		After:       map[string]string{"mail": mail, "role": result.Role},
+	})
+	if printErr := out.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Utilisateur %s créé (id %d, rôle %s)\n", username, result.ID, result.Role)
+		result.printPassword(w)
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+func userPromote(args []string) error {
+	flags, out := commandFlags("user promote")
+	reason := flags.String("reason", "", "motif")
+	positional, err := parseCommand(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	role, err := parseRole(positional[1])
+	if err != nil {
+		return err
+	}
+	profile, err := findUser(positional[0])
+	if err != nil {
+		return err
+	}
+	previous, err := handlers.UserRole(db, profile.ID)
+	if err != nil {
+		return err
+	}
+
+	if previous != role {
+		if err := db.Users().SetRole(profile.ID, role); err != nil {
+			return err
+		}
+		err = recordCLIAudit(handlers.AuditEvent{
+			Action:      handlers.AuditUserRole,
+			TargetType:  handlers.AuditTargetUser,
+			TargetID:    profile.ID,
+			TargetLabel: profile.Username,
+			Before:      map[string]int{"role": previous},
+			After:       map[string]int{"role": role},
+			Reason:      *reason,
+		})
+	}
+
+	result := userResult{ID: profile.ID, Username: profile.Username, Role: roleName(role)}
+	if printErr := out.print(result, func(w io.Writer) {
+		if previous == role {
+			fmt.Fprintf(w, "%s a déjà le rôle %s\n", profile.Username, result.Role)
+		} else {
+			fmt.Fprintf(w, "%s : %s → %s\n", profile.Username, roleName(previous), result.Role)
+		}
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+func userBan(args []string) error {
+	flags, out := commandFlags("user ban")
+	reason := flags.String("reason", "", "motif (obligatoire)")
+	days := flags.Int("days", 0, "durée de la suspension en jours ; 0 pour un bannissement définitif")
+	positional, err := parseCommand(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	*reason = strings.TrimSpace(*reason)
+	if *reason == "" || utf8.RuneCountInString(*reason) > handlers.MaxSanctionReason {
+		return fmt.Errorf("%w: --reason is required (at most %d characters)", errUsage, handlers.MaxSanctionReason)
+	}
+	if *days < 0 || *days > handlers.MaxSuspensionDays {
+		return fmt.Errorf("%w: --days must be at most %d", errUsage, handlers.MaxSuspensionDays)
+	}
+	profile, err := findUser(positional[0])
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		ID        int    `json:"id"`
+		Username  string `json:"username"`
+		Sanction  string `json:"sanction"`
+		ExpiresAt string `json:"expires_at,omitempty"`
+	}{ID: profile.ID, Username: profile.Username, Sanction: handlers.SanctionBan}
+	event := handlers.AuditEvent{
+		Action:      handlers.AuditUserBan,
+		TargetType:  handlers.AuditTargetUser,
+		TargetID:    profile.ID,
+		TargetLabel: profile.Username,
+		After:       map[string]string{"type": handlers.SanctionBan},
+		Reason:      *reason,
+	}
+	if *days > 0 {
+		until := time.Now().AddDate(0, 0, *days)
+		result.Sanction = handlers.SanctionSuspension
+		result.ExpiresAt = until.Format("2006-01-02 15:04:05")
+		event.Action = handlers.AuditUserSuspend
+		event.After = map[string]string{"type": handlers.SanctionSuspension, "expires_at": result.ExpiresAt}
+		err = handlers.Suspend(db, 0, profile.ID, *reason, until)
+	} else {
+		err = handlers.Ban(db, 0, profile.ID, *reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = recordCLIAudit(event)
+	if printErr := out.print(result, func(w io.Writer) {
+		if result.ExpiresAt != "" {
+			fmt.Fprintf(w, "%s suspendu jusqu'au %s\n", profile.Username, result.ExpiresAt)
+		} else {
+			fmt.Fprintf(w, "%s banni\n", profile.Username)
+		}
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+func userResetPassword(args []string) error {
+	flags, out := commandFlags("user reset-password")
+	password := flags.String("password", "", "nouveau mot de passe")
+	positional, err := parseCommand(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	profile, err := findUser(positional[0])
+	if err != nil {
+		return err
+	}
+	chosen, generated, err := choosePassword(*password)
+	if err != nil {
+		return err
+	}
+	hash, err := handlers.HashPassword(chosen)
+	if err != nil {
+		return err
+	}
+	if err := db.Users().SetPassword(profile.ID, hash); err != nil {
+		return err
+	}
+
+	result := userResult{ID: profile.ID, Username: profile.Username}
+	if generated {
+		result.Password = chosen
+	}
+	// Le journal garde la trace du changement, jamais le mot de passe
+	err = recordCLIAudit(handlers.AuditEvent{
+		Action:      handlers.AuditUserPassword,
+		TargetType:  handlers.AuditTargetUser,
+		TargetID:    profile.ID,
+		TargetLabel: profile.Username,
+	})
+	if printErr := out.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Mot de passe de %s réinitialisé\n", profile.Username)
+		result.printPassword(w)
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// userExport écrit en JSON les données d'un compte, comme le téléchargement proposé
+// sur son profil, pour répondre à une demande reçue hors du forum
+func userExport(args []string) error {
+	flags, _ := commandFlags("user export")
+	positional, err := parseCommand(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	profile, err := findUser(positional[0])
+	if err != nil {
+		return err
+	}
+	data, err := handlers.ExportPersonalData(db, profile.ID)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+func userDelete(args []string) error {
+	flags, out := commandFlags("user delete")
+	policy := flags.String("policy", handlers.AccountDeletionPolicy(), "anonymize ou delete")
+	positional, err := parseCommand(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *policy != handlers.DeletionAnonymize && *policy != handlers.DeletionDelete {
+		return fmt.Errorf("%w: unknown policy %q (anonymize or delete)", errUsage, *policy)
+	}
+	profile, err := findUser(positional[0])
+	if err != nil {
+		return err
+	}
+	deletion, err := handlers.DeleteAccount(db, profile.ID, *policy)
+	if err != nil {
+		return err
+	}
+
+	// Comme pour une suppression depuis le profil, le journal ne garde que l'identifiant
+	err = recordCLIAudit(handlers.AuditEvent{
+		Action:     handlers.AuditUserDelete,
+		TargetType: handlers.AuditTargetUser,
+		TargetID:   profile.ID,
+		After:      deletion,
+	})
+	if printErr := out.print(deletion, func(w io.Writer) {
+		fmt.Fprintf(w, "Compte %s supprimé (%s)\n", profile.Username, deletion.Policy)
+		fmt.Fprintln(w, "\tsupprimés\tanonymisés")
+		for _, name := range []string{"topics", "messages", "replies", "private_messages"} {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", name, deletion.Deleted[name], deletion.Anonymized[name])
+		}
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// categorySlug accepte des minuscules, chiffres et tirets, comme les thèmes existants
+var categorySlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// runCategory implémente category add et category list
+func runCategory(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing category command", errUsage)
+	}
+	switch args[0] {
+	case "add":
+		return categoryAdd(args[1:])
+	case "list":
+		return categoryList(args[1:])
+	}
+	return fmt.Errorf("%w: unknown category command %q", errUsage, args[0])
+}
+
+func categoryAdd(args []string) error {
+	flags, out := commandFlags("category add")
+	positional, err := parseCommand(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	slug, label := positional[0], strings.TrimSpace(positional[1])
+	if !categorySlug.MatchString(slug) {
+		return fmt.Errorf("%w: the slug must be lowercase letters, digits and dashes", errUsage)
+	}
+	if label == "" || utf8.RuneCountInString(label) > 100 {
+		return fmt.Errorf("%w: the label must be 1 to 100 characters", errUsage)
+	}
+	if _, err := db.Categories().Get(slug); err != store.ErrNotFound {
+		if err == nil {
+			err = fmt.Errorf("category %q already exists", slug)
+		}
+		return err
+	}
+
+	id, err := db.Categories().Create(slug, label)
+	if err != nil {
+		return err
+	}
+	category := store.Category{ID: int(id), Slug: slug, Label: label}
+	err = recordCLIAudit(handlers.AuditEvent{
+		Action:      handlers.AuditCategoryCreate,
+		TargetType:  handlers.AuditTargetCategory,
+		TargetID:    category.ID,
+		TargetLabel: label,
+		After:       category,
+	})
+	if printErr := out.print(category, func(w io.Writer) {
+		fmt.Fprintf(w, "Catégorie %s (%s) ajoutée\n", slug, label)
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+func categoryList(args []string) error {
+	flags, out := commandFlags("category list")
+	if _, err := parseCommand(flags, args, 0, 0); err != nil {
+		return err
+	}
+	categories, err := db.Categories().List()
+	if err != nil {
+		return err
+	}
+	counts, err := topicsPerTag()
+	if err != nil {
+		return err
+	}
+
+	type categoryResult struct {
+		store.Category
+		Topics int `json:"topics"`
+	}
+	results := make([]categoryResult, 0, len(categories))
+	for _, category := range categories {
+		results = append(results, categoryResult{category, counts[category.Slug]})
+	}
+	return out.print(results, func(w io.Writer) {
+		fmt.Fprintln(w, "SLUG\tLIBELLÉ\tTOPICS")
+		for _, category := range results {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", category.Slug, category.Label, category.Topics)
+		}
+	})
+}
+
+// topicsPerTag compte les topics publiés de chaque tag
+func topicsPerTag() (map[string]int, error) {
+	topicTags, err := db.Topics().Tags()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, tags := range topicTags {
+		for _, tag := range strings.Split(tags, ",") {
+			counts[strings.TrimSpace(tag)]++
+		}
+	}
+	return counts, nil
+}
+
+// runTopic implémente topic close et topic move
+func runTopic(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing topic command", errUsage)
+	}
+	switch args[0] {
+	case "close":
+		return topicClose(args[1:])
+	case "move":
+		return topicMove(args[1:])
+	}
+	return fmt.Errorf("%w: unknown topic command %q", errUsage, args[0])
+}
+
+func findTopic(rawID string) (store.Topic, error) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return store.Topic{}, fmt.Errorf("%w: invalid topic id %q", errUsage, rawID)
+	}
+	topic, err := db.Topics().Get(id, store.Viewer{Moderator: true})
+	if err == store.ErrNotFound {
+		return topic, fmt.Errorf("unknown topic %d", id)
+	}
+	return topic, err
+}
+
+// topicResult est la sortie des sous-commandes topic
+type topicResult struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	StateID int    `json:"state_id"`
+	Tags    string `json:"tags"`
+}
+
+func topicClose(args []string) error {
+	flags, out := commandFlags("topic close")
+	reason := flags.String("reason", "", "motif")
+	positional, err := parseCommand(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	topic, err := findTopic(positional[0])
+	if err != nil {
+		return err
+	}
+
+	previous := topic.StateID
+	if previous != handlers.StateClosed {
+		if err := handlers.ChangeTopicState(db, topic, handlers.StateClosed, 0); err != nil {
+			return err
+		}
+		err = recordCLIAudit(handlers.AuditEvent{
+			Action:      handlers.AuditTopicState,
+			TargetType:  handlers.AuditTargetTopic,
+			TargetID:    topic.ID,
+			TargetLabel: topic.Title,
+			Before:      map[string]int{"state": previous},
+			After:       map[string]int{"state": handlers.StateClosed},
+			Reason:      *reason,
+		})
+	}
+
+	result := topicResult{ID: topic.ID, Title: topic.Title, StateID: handlers.StateClosed, Tags: topic.Tags}
+	if printErr := out.print(result, func(w io.Writer) {
+		if previous == handlers.StateClosed {
+			fmt.Fprintf(w, "Le topic %d est déjà fermé\n", topic.ID)
+		} else {
+			fmt.Fprintf(w, "Topic %d « %s » fermé\n", topic.ID, topic.Title)
+		}
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// topicMove range le topic dans une seule catégorie, à la place de ses tags
+func topicMove(args []string) error {
+	flags, out := commandFlags("topic move")
+	reason := flags.String("reason", "", "motif")
+	positional, err := parseCommand(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	topic, err := findTopic(positional[0])
+	if err != nil {
+		return err
+	}
+	category, err := db.Categories().Get(positional[1])
+	if err == store.ErrNotFound {
+		return fmt.Errorf("unknown category %q (see `forum category list`)", positional[1])
+	}
+	if err != nil {
+		return err
+	}
+
+	if topic.Tags != category.Slug {
+		if err := db.Topics().SetTags(topic.ID, category.Slug); err != nil {
+			return err
+		}
+		err = recordCLIAudit(handlers.AuditEvent{
+			Action:      handlers.AuditTopicMove,
+			TargetType:  handlers.AuditTargetTopic,
+			TargetID:    topic.ID,
+			TargetLabel: topic.Title,
+			Before:      map[string]string{"tags": topic.Tags},
+			After:       map[string]string{"tags": category.Slug},
+			Reason:      *reason,
+		})
+	}
+
+	result := topicResult{ID: topic.ID, Title: topic.Title, StateID: topic.StateID, Tags: category.Slug}
+	if printErr := out.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Topic %d « %s » rangé dans %s\n", topic.ID, topic.Title, category.Label)
+	}); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// forumStats est la sortie de la commande stats
+type forumStats struct {
+	SchemaVersion   int `json:"schema_version"`
+	Users           int `json:"users"`
+	Moderators      int `json:"moderators"`
+	Admins          int `json:"admins"`
+	Topics          int `json:"topics"`
+	PendingTopics   int `json:"pending_topics"`
+	Messages        int `json:"messages"`
+	PendingMessages int `json:"pending_messages"`
+	Replies         int `json:"replies"`
+	Categories      int `json:"categories"`
+	OpenReports     int `json:"open_reports"`
+	ActiveSanctions int `json:"active_sanctions"`
+	NewUsers7Days   int `json:"new_users_7_days"`
+	NewTopics7Days  int `json:"new_topics_7_days"`
+}
+
+func runStats(args []string) error {
+	flags, out := commandFlags("stats")
+	if _, err := parseCommand(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	var stats forumStats
+	var err error
+	if stats.SchemaVersion, err = db.SchemaVersion(); err != nil {
+		return err
+	}
+	weekAgo := time.Now().AddDate(0, 0, -7).Format("2006-01-02 15:04:05")
+	err = db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM user),
+			(SELECT COUNT(*) FROM user WHERE role_id = ?),
+			(SELECT COUNT(*) FROM user WHERE role_id = ?),
+			(SELECT COUNT(*) FROM topic WHERE status = ?),
+			(SELECT COUNT(*) FROM topic WHERE status = ?),
+			(SELECT COUNT(*) FROM message WHERE status = ?),
+			(SELECT COUNT(*) FROM message WHERE status = ?),
+			(SELECT COUNT(*) FROM response),
+			(SELECT COUNT(*) FROM category),
+			(SELECT COUNT(*) FROM report WHERE status = ?),
+			(SELECT COUNT(*) FROM user WHERE created_at >= ?),
+			(SELECT COUNT(*) FROM topic WHERE created_at >= ?)
+	`, handlers.RoleModerator, handlers.RoleAdmin, store.StatusPublished, store.StatusPending,
+		store.StatusPublished, store.StatusPending, handlers.ReportOpen, weekAgo, weekAgo).
+		Scan(&stats.Users, &stats.Moderators, &stats.Admins, &stats.Topics, &stats.PendingTopics,
+			&stats.Messages, &stats.PendingMessages, &stats.Replies, &stats.Categories, &stats.OpenReports,
+			&stats.NewUsers7Days, &stats.NewTopics7Days)
+	if err != nil {
+		return err
+	}
+	sanctions, err := handlers.ActiveSanctions(db)
+	if err != nil {
+		return err
+	}
+	stats.ActiveSanctions = len(sanctions)
+
+	return out.print(stats, func(w io.Writer) {
+		fmt.Fprintf(w, "Utilisateurs\t%d\t(%d modérateurs, %d administrateurs, %d ces 7 derniers jours)\n",
+			stats.Users, stats.Moderators, stats.Admins, stats.NewUsers7Days)
+		fmt.Fprintf(w, "Topics\t%d\t(%d en attente, %d ces 7 derniers jours)\n", stats.Topics, stats.PendingTopics, stats.NewTopics7Days)
+		fmt.Fprintf(w, "Messages\t%d\t(%d en attente)\n", stats.Messages, stats.PendingMessages)
+		fmt.Fprintf(w, "Réponses\t%d\n", stats.Replies)
+		fmt.Fprintf(w, "Catégories\t%d\n", stats.Categories)
+		fmt.Fprintf(w, "Signalements ouverts\t%d\n", stats.OpenReports)
+		fmt.Fprintf(w, "Sanctions en cours\t%d\n", stats.ActiveSanctions)
+		fmt.Fprintf(w, "Version du schéma\t%d\n", stats.SchemaVersion)
+	})
+}
@@ -0,0 +1,340 @@
+// Package archive lit et écrit les archives du forum : un flux JSON Lines versionné,
+// éventuellement compressé en gzip, qui sert de sauvegarde et de moyen de déplacer
+// une instance d'un serveur à l'autre.
+//
+// La première ligne est l'en-tête ({"type":"header","data":{...}}), viennent ensuite
+// les enregistrements dans l'ordre des clés étrangères (catégories, utilisateurs,
+// topics, messages, réponses puis likes) et la dernière ligne est un pied qui compte
+// les enregistrements de chaque type, ce qui permet de détecter une archive tronquée.
+// Rien n'est chargé en mémoire au-delà d'un enregistrement.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Format et version des archives produites ; une archive d'une version plus récente
+// est refusée
+const (
+	Format  = "forumforall-archive"
+	Version = 1
+)
+
+// Types d'enregistrements, dans l'ordre où ils apparaissent
+const (
+	TypeHeader       = "header"
+	TypeCategory     = "category"
+	TypeUser         = "user"
+	TypeTopic        = "topic"
+	TypeMessage      = "message"
+	TypeResponse     = "response"
+	TypeTopicLike    = "topic_like"
+	TypeResponseLike = "response_like"
+	TypeFooter       = "footer"
+)
+
+var recordOrder = map[string]int{
+	TypeHeader:       0,
+	TypeCategory:     1,
+	TypeUser:         2,
+	TypeTopic:        3,
+	TypeMessage:      4,
+	TypeResponse:     5,
+	TypeTopicLike:    6,
+	TypeResponseLike: 7,
+	TypeFooter:       8,
+}
+
+// dateTime est le format des dates dans les archives, celui des colonnes de la base
+const dateTime = "2006-01-02 15:04:05"
+
+// ErrTruncated indique une archive qui s'arrête avant son pied
+var ErrTruncated = errors.New("archive is truncated: no footer")
+
+// Header décrit l'archive
+type Header struct {
+	Format        string `json:"format"`
+	Version       int    `json:"version"`
+	ID            string `json:"id"`
+	CreatedAt     string `json:"created_at"`
+	Source        string `json:"source"` // logiciel d'origine, "forumforall" pour un export
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	// PasswordHashes indique que les hachages de mots de passe sont inclus ; sinon
+	// les comptes importés devront recevoir un nouveau mot de passe
+	PasswordHashes bool `json:"password_hashes"`
+}
+
+// Footer termine l'archive
+type Footer struct {
+	Counts map[string]int `json:"counts"`
+}
+
+// Category est une catégorie, identifiée par son slug
+type Category struct {
+	Slug      string  `json:"slug"`
+	Label     string  `json:"label"`
+	Position  int     `json:"position"`
+	CreatedAt *string `json:"created_at,omitempty"`
+}
+
+// User est un compte. Il n'y a pas de pièces jointes dans le forum : seul le chemin
+// de l'image de profil est conservé, pas le fichier.
+type User struct {
+	ID               int     `json:"id"`
+	Username         string  `json:"username"`
+	Mail             string  `json:"mail"`
+	PasswordHash     string  `json:"password_hash,omitempty"`
+	Bio              *string `json:"bio,omitempty"`
+	LastConnection   *string `json:"last_connection,omitempty"`
+	TopicNbr         int     `json:"topic_nbr"`
+	CreatedAt        *string `json:"created_at,omitempty"`
+	ProfileImagePath *string `json:"profile_image_path,omitempty"`
+	RoleID           *int    `json:"role_id,omitempty"`
+}
+
+// Topic est un sujet ; Tags contient les slugs de ses catégories séparés par des
+// virgules
+type Topic struct {
+	ID          int     `json:"id"`
+	Title       string  `json:"title"`
+	Tags        *string `json:"tags,omitempty"`
+	Description *string `json:"description,omitempty"`
+	UserID      *int    `json:"user_id,omitempty"`
+	StateID     *int    `json:"state_id,omitempty"`
+	Status      string  `json:"status"`
+	HeldReason  *string `json:"held_reason,omitempty"`
+	CreatedAt   *string `json:"created_at,omitempty"`
+	UpdatedAt   *string `json:"updated_at,omitempty"`
+}
+
+// Message est un message d'un topic
+type Message struct {
+	ID          int     `json:"id"`
+	TopicID     *int    `json:"topic_id,omitempty"`
+	UserID      *int    `json:"user_id,omitempty"`
+	Content     string  `json:"content"`
+	ContentHTML *string `json:"content_html,omitempty"`
+	Status      string  `json:"status"`
+	HeldReason  *string `json:"held_reason,omitempty"`
+	CreatedAt   *string `json:"created_at,omitempty"`
+}
+
+// Response est une réponse à un message
+type Response struct {
+	ID         int     `json:"id"`
+	MessageID  *int    `json:"message_id,omitempty"`
+	UserID     *int    `json:"user_id,omitempty"`
+	Content    string  `json:"content"`
+	Status     string  `json:"status"`
+	HeldReason *string `json:"held_reason,omitempty"`
+	CreatedAt  *string `json:"created_at,omitempty"`
+}
+
+// TopicLike est le vote d'un utilisateur sur un topic
+type TopicLike struct {
+	UserID  int  `json:"user_id"`
+	TopicID int  `json:"topic_id"`
+	Liked   bool `json:"liked"`
+}
+
+// ResponseLike est le vote d'un utilisateur sur une réponse
+type ResponseLike struct {
+	UserID     int  `json:"user_id"`
+	ResponseID int  `json:"response_id"`
+	Liked      bool `json:"liked"`
+}
+
+type line struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Writer écrit une archive enregistrement par enregistrement
+type Writer struct {
+	encoder *json.Encoder
+	counts  map[string]int
+	last    string
+}
+
+// NewWriter écrit l'en-tête de l'archive dans w ; le format, la version, l'identifiant
+// et la date sont complétés s'ils sont vides
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	header.Format, header.Version = Format, Version
+	if header.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		header.ID = hex.EncodeToString(id)
+	}
+	if header.CreatedAt == "" {
+		header.CreatedAt = time.Now().Format(dateTime)
+	}
+
+	writer := &Writer{encoder: json.NewEncoder(w), counts: map[string]int{}}
+	writer.encoder.SetEscapeHTML(false)
+	if err := writer.write(TypeHeader, header); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *Writer) WriteCategory(c Category) error         { return w.write(TypeCategory, c) }
+func (w *Writer) WriteUser(u User) error                 { return w.write(TypeUser, u) }
+func (w *Writer) WriteTopic(t Topic) error               { return w.write(TypeTopic, t) }
+func (w *Writer) WriteMessage(m Message) error           { return w.write(TypeMessage, m) }
+func (w *Writer) WriteResponse(r Response) error         { return w.write(TypeResponse, r) }
+func (w *Writer) WriteTopicLike(l TopicLike) error       { return w.write(TypeTopicLike, l) }
+func (w *Writer) WriteResponseLike(l ResponseLike) error { return w.write(TypeResponseLike, l) }
+
+// Counts renvoie le nombre d'enregistrements écrits par type
+func (w *Writer) Counts() map[string]int { return w.counts }
+
+// Close écrit le pied de l'archive ; il ne ferme pas le io.Writer sous-jacent
+func (w *Writer) Close() error {
+	return w.write(TypeFooter, Footer{Counts: w.counts})
+}
+
+// write refuse un enregistrement qui arrive après ceux d'un type suivant, l'import
+// reposant sur l'ordre des clés étrangères
+func (w *Writer) write(kind string, value interface{}) error {
+	if recordOrder[kind] < recordOrder[w.last] || w.last == TypeFooter {
+		return fmt.Errorf("archive: %s record written after %s records", kind, w.last)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := w.encoder.Encode(line{Type: kind, Data: data}); err != nil {
+		return err
+	}
+	w.last = kind
+	if kind != TypeHeader && kind != TypeFooter {
+		w.counts[kind]++
+	}
+	return nil
+}
+
+// Record est un enregistrement lu ; Decode le transforme dans la structure de son type
+type Record struct {
+	Type string
+	data json.RawMessage
+}
+
+func (r Record) Decode(value interface{}) error {
+	if err := json.Unmarshal(r.data, value); err != nil {
+		return fmt.Errorf("archive: invalid %s record: %w", r.Type, err)
+	}
+	return nil
+}
+
+// Reader lit une archive, compressée ou non
+type Reader struct {
+	Header  Header
+	decoder *json.Decoder
+	counts  map[string]int
+	last    string
+	line    int
+}
+
+// NewReader lit et vérifie l'en-tête de l'archive
+func NewReader(r io.Reader) (*Reader, error) {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		unzipped, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		r = unzipped
+	} else {
+		r = buffered
+	}
+
+	reader := &Reader{decoder: json.NewDecoder(r), counts: map[string]int{}}
+	record, err := reader.read()
+	if err == io.EOF {
+		return nil, errors.New("archive: empty file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.Type != TypeHeader {
+		return nil, errors.New("archive: missing header")
+	}
+	if err := record.Decode(&reader.Header); err != nil {
+		return nil, err
+	}
+	if reader.Header.Format != Format {
+		return nil, fmt.Errorf("archive: unknown format %q", reader.Header.Format)
+	}
+	if reader.Header.Version < 1 || reader.Header.Version > Version {
+		return nil, fmt.Errorf("archive: version %d is not supported (1 to %d)", reader.Header.Version, Version)
+	}
+	if reader.Header.ID == "" {
+		return nil, errors.New("archive: header has no id")
+	}
+	return reader, nil
+}
+
+// Next renvoie l'enregistrement suivant, puis io.EOF une fois le pied lu et vérifié
+func (r *Reader) Next() (Record, error) {
+	if r.last == TypeFooter {
+		return Record{}, io.EOF
+	}
+	record, err := r.read()
+	if err == io.EOF {
+		return Record{}, ErrTruncated
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	order, known := recordOrder[record.Type]
+	if !known || record.Type == TypeHeader {
+		return Record{}, fmt.Errorf("archive: line %d: unexpected %q record", r.line, record.Type)
+	}
+	if order < recordOrder[r.last] {
+		return Record{}, fmt.Errorf("archive: line %d: %s record after %s records", r.line, record.Type, r.last)
+	}
+	r.last = record.Type
+
+	if record.Type != TypeFooter {
+		r.counts[record.Type]++
+		return record, nil
+	}
+	var footer Footer
+	if err := record.Decode(&footer); err != nil {
+		return Record{}, err
+	}
+	for kind := range recordOrder {
+		if footer.Counts[kind] != r.counts[kind] {
+			return Record{}, fmt.Errorf("archive: %d %s records read, footer announces %d", r.counts[kind], kind, footer.Counts[kind])
+		}
+	}
+	if r.decoder.More() {
+		return Record{}, errors.New("archive: data after footer")
+	}
+	return Record{}, io.EOF
+}
+
+func (r *Reader) read() (Record, error) {
+	var l line
+	if err := r.decoder.Decode(&l); err != nil {
+		if err == io.EOF {
+			return Record{}, err
+		}
+		if err == io.ErrUnexpectedEOF {
+			return Record{}, ErrTruncated
+		}
+		return Record{}, fmt.Errorf("archive: line %d: %w", r.line+1, err)
+	}
+	r.line++
+	return Record{Type: l.Type, data: l.Data}, nil
+}
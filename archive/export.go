@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"io"
+
+	"forum/store"
+)
+
+// exportBatch est le nombre de lignes lues par requête : les tables sont parcourues
+// par pages dans l'ordre de leur clé, sans garder de requête ouverte pendant
+// l'écriture de toute la table
+const exportBatch = 1000
+
+// ExportOptions règle le contenu d'un export
+type ExportOptions struct {
+	PasswordHashes bool
+}
+
+// Export écrit une archive complète de db dans w et renvoie le nombre
+// d'enregistrements par type.
+//
+// Le forum peut rester en service : les identifiants maximaux sont relevés au départ
+// et les lignes créées ensuite sont ignorées, ce qui garantit que chaque message,
+// réponse ou like exporté désigne des lignes présentes plus haut dans l'archive.
+func Export(db store.Store, w io.Writer, options ExportOptions) (map[string]int, error) {
+	var limits struct{ user, topic, message, response int }
+	err := db.QueryRow(`
+		SELECT (SELECT COALESCE(MAX(user_id), 0) FROM user),
+			(SELECT COALESCE(MAX(topic_id), 0) FROM topic),
+			(SELECT COALESCE(MAX(message_id), 0) FROM message),
+			(SELECT COALESCE(MAX(response_id), 0) FROM response)`,
+	).Scan(&limits.user, &limits.topic, &limits.message, &limits.response)
+	if err != nil {
+		return nil, err
+	}
+	schemaVersion, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewWriter(w, Header{Source: "forumforall", SchemaVersion: schemaVersion, PasswordHashes: options.PasswordHashes})
+	if err != nil {
+		return nil, err
+	}
+
+	// Les catégories sont peu nombreuses et lues d'une seule requête
+	rows, err := db.Query("SELECT slug, label, position, created_at FROM category ORDER BY position, slug")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.Slug, &c.Label, &c.Position, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := writer.WriteCategory(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	lastID := 0
+	err = exportPages(db, `
+		SELECT user_id, username, mail, password, bio, last_connection, COALESCE(topic_nbr, 0),
+			created_at, profil_img_path, role_id
+		FROM user WHERE user_id > ? AND user_id <= ? ORDER BY user_id LIMIT ?`,
+		func() []interface{} { return []interface{}{lastID, limits.user} },
+		func(rows store.Rows) error {
+			var u User
+			err := rows.Scan(&u.ID, &u.Username, &u.Mail, &u.PasswordHash, &u.Bio, &u.LastConnection,
+				&u.TopicNbr, &u.CreatedAt, &u.ProfileImagePath, &u.RoleID)
+			if err != nil {
+				return err
+			}
+			if !options.PasswordHashes {
+				u.PasswordHash = ""
+			}
+			lastID = u.ID
+			return writer.WriteUser(u)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	lastID = 0
+	err = exportPages(db, `
+		SELECT topic_id, title, tags, description, user_id, state_id, status, held_reason,
+			created_at, updated_at
+		FROM topic WHERE topic_id > ? AND topic_id <= ? ORDER BY topic_id LIMIT ?`,
+		func() []interface{} { return []interface{}{lastID, limits.topic} },
+		func(rows store.Rows) error {
+			var t Topic
+			err := rows.Scan(&t.ID, &t.Title, &t.Tags, &t.Description, &t.UserID, &t.StateID, &t.Status,
+				&t.HeldReason, &t.CreatedAt, &t.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			lastID = t.ID
+			return writer.WriteTopic(t)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	lastID = 0
+	err = exportPages(db, `
+		SELECT message_id, topic_id, user_id, content, content_html, status, held_reason, created_at
+		FROM message WHERE message_id > ? AND message_id <= ? ORDER BY message_id LIMIT ?`,
+		func() []interface{} { return []interface{}{lastID, limits.message} },
+		func(rows store.Rows) error {
+			var m Message
+			err := rows.Scan(&m.ID, &m.TopicID, &m.UserID, &m.Content, &m.ContentHTML, &m.Status,
+				&m.HeldReason, &m.CreatedAt)
+			if err != nil {
+				return err
+			}
+			lastID = m.ID
+			return writer.WriteMessage(m)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	lastID = 0
+	err = exportPages(db, `
+		SELECT response_id, message_id, user_id, content, status, held_reason, created_at
+		FROM response WHERE response_id > ? AND response_id <= ? ORDER BY response_id LIMIT ?`,
+		func() []interface{} { return []interface{}{lastID, limits.response} },
+		func(rows store.Rows) error {
+			var r Response
+			if err := rows.Scan(&r.ID, &r.MessageID, &r.UserID, &r.Content, &r.Status, &r.HeldReason, &r.CreatedAt); err != nil {
+				return err
+			}
+			lastID = r.ID
+			return writer.WriteResponse(r)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var lastUser, lastTarget int
+	err = exportPages(db, `
+		SELECT user_id, topic_id, COALESCE(liked, TRUE) FROM topic_user_like
+		WHERE (user_id > ? OR (user_id = ? AND topic_id > ?)) AND user_id <= ? AND topic_id <= ?
+		ORDER BY user_id, topic_id LIMIT ?`,
+		func() []interface{} { return []interface{}{lastUser, lastUser, lastTarget, limits.user, limits.topic} },
+		func(rows store.Rows) error {
+			var l TopicLike
+			if err := rows.Scan(&l.UserID, &l.TopicID, &l.Liked); err != nil {
+				return err
+			}
+			lastUser, lastTarget = l.UserID, l.TopicID
+			return writer.WriteTopicLike(l)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	lastUser, lastTarget = 0, 0
+	err = exportPages(db, `
+		SELECT user_id, response_id, COALESCE(liked, TRUE) FROM response_user_like
+		WHERE (user_id > ? OR (user_id = ? AND response_id > ?)) AND user_id <= ? AND response_id <= ?
+		ORDER BY user_id, response_id LIMIT ?`,
+		func() []interface{} {
+			return []interface{}{lastUser, lastUser, lastTarget, limits.user, limits.response}
+		},
+		func(rows store.Rows) error {
+			var l ResponseLike
+			if err := rows.Scan(&l.UserID, &l.ResponseID, &l.Liked); err != nil {
+				return err
+			}
+			lastUser, lastTarget = l.UserID, l.ResponseID
+			return writer.WriteResponseLike(l)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return writer.Counts(), writer.Close()
+}
+
+// exportPages lit une table par pages de exportBatch lignes. after donne les
+// arguments qui situent la page suivante, que scan met à jour à chaque ligne ; la
+// taille de page est ajoutée en dernier argument.
+func exportPages(db store.Store, query string, after func() []interface{}, scan func(rows store.Rows) error) error {
+	for {
+		rows, err := db.Query(query, append(after(), exportBatch)...)
+		if err != nil {
+			return err
+		}
+		read := 0
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+			read++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil || read < exportBatch {
+			return err
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"forum/store"
+)
+
+// importBatch est le nombre d'enregistrements écrits par transaction
+const importBatch = 500
+
+// ErrNotEmpty indique que la base contient déjà des données qui ne viennent pas de
+// l'archive
+var ErrNotEmpty = errors.New("database is not empty: an archive is only restored into an empty database")
+
+// ImportResult résume un import
+type ImportResult struct {
+	Counts map[string]int `json:"counts"` // enregistrements lus par type
+	// Resumed indique la reprise d'un import déjà commencé de la même archive
+	Resumed bool `json:"resumed"`
+}
+
+// Import restaure l'archive lue par r dans db, qui doit être vide : seules les
+// catégories créées par les migrations sont tolérées et mises à jour.
+//
+// Les lignes gardent leurs identifiants et celles déjà présentes sont ignorées. Les
+// enregistrements sont écrits par transactions de importBatch ; après une
+// interruption, il suffit de relancer l'import de la même archive, que la table
+// archive_import autorise sur une base qui n'est plus vide.
+func Import(db store.Store, r *Reader) (ImportResult, error) {
+	result := ImportResult{Counts: map[string]int{}}
+
+	var finishedAt *string
+	err := db.QueryRow("SELECT finished_at FROM archive_import WHERE archive_id = ?", r.Header.ID).Scan(&finishedAt)
+	switch {
+	case err == nil:
+		result.Resumed = true
+	case err == store.ErrNotFound:
+		var content int
+		err := db.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM (SELECT 1 FROM user LIMIT 1) u)
+				+ (SELECT COUNT(*) FROM (SELECT 1 FROM topic LIMIT 1) t)
+				+ (SELECT COUNT(*) FROM (SELECT 1 FROM message LIMIT 1) m)`,
+		).Scan(&content)
+		if err != nil {
+			return result, err
+		}
+		if content > 0 {
+			return result, ErrNotEmpty
+		}
+		_, err = db.Exec("INSERT INTO archive_import (archive_id, started_at) VALUES (?, ?)",
+			r.Header.ID, time.Now().Format(dateTime))
+		if err != nil {
+			return result, err
+		}
+	default:
+		return result, err
+	}
+
+	dialect := db.Dialect()
+	queries := map[string]string{
+		TypeCategory: `INSERT INTO category (slug, label, position, created_at)
+			VALUES (?, ?, ?, COALESCE(?, NOW())) ` + dialect.Upsert("slug") + `
+			label = ` + dialect.Inserted("label") + `, position = ` + dialect.Inserted("position"),
+		TypeUser: dialect.InsertIgnore() + ` INTO user (user_id, username, mail, password, bio,
+			last_connection, topic_nbr, created_at, profil_img_path, role_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, COALESCE(?, NOW()), ?, ?)`,
+		TypeTopic: dialect.InsertIgnore() + ` INTO topic (topic_id, title, tags, description, user_id,
+			state_id, status, held_reason, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, NOW()), COALESCE(?, NOW()))`,
+		TypeMessage: dialect.InsertIgnore() + ` INTO message (message_id, topic_id, user_id, content,
+			content_html, status, held_reason, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, COALESCE(?, NOW()))`,
+		TypeResponse: dialect.InsertIgnore() + ` INTO response (response_id, message_id, user_id,
+			content, status, held_reason, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, COALESCE(?, NOW()))`,
+		TypeTopicLike: dialect.InsertIgnore() + ` INTO topic_user_like (user_id, topic_id, liked)
+			VALUES (?, ?, ?)`,
+		TypeResponseLike: dialect.InsertIgnore() + ` INTO response_user_like (user_id, response_id, liked)
+			VALUES (?, ?, ?)`,
+	}
+
+	batch := &batchWriter{db: db}
+	defer batch.rollback()
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		args, err := recordArgs(record)
+		if err != nil {
+			return result, err
+		}
+		if err := batch.exec(queries[record.Type], args...); err != nil {
+			return result, fmt.Errorf("%s record %d: %w", record.Type, result.Counts[record.Type]+1, err)
+		}
+		result.Counts[record.Type]++
+	}
+	if err := batch.commit(); err != nil {
+		return result, err
+	}
+
+	_, err = db.Exec("UPDATE archive_import SET finished_at = ? WHERE archive_id = ?",
+		time.Now().Format(dateTime), r.Header.ID)
+	return result, err
+}
+
+// recordArgs décode record dans l'ordre des colonnes de sa requête d'insertion
+func recordArgs(record Record) ([]interface{}, error) {
+	switch record.Type {
+	case TypeCategory:
+		var c Category
+		err := record.Decode(&c)
+		return []interface{}{c.Slug, c.Label, c.Position, c.CreatedAt}, err
+	case TypeUser:
+		var u User
+		err := record.Decode(&u)
+		// Sans hachage, le mot de passe vide ne permet aucune connexion avant un
+		// forum user reset-password
+		return []interface{}{u.ID, u.Username, u.Mail, u.PasswordHash, u.Bio, u.LastConnection,
+			u.TopicNbr, u.CreatedAt, u.ProfileImagePath, u.RoleID}, err
+	case TypeTopic:
+		var t Topic
+		err := record.Decode(&t)
+		return []interface{}{t.ID, t.Title, t.Tags, t.Description, t.UserID, t.StateID, status(t.Status),
+			t.HeldReason, t.CreatedAt, t.UpdatedAt}, err
+	case TypeMessage:
+		var m Message
+		err := record.Decode(&m)
+		return []interface{}{m.ID, m.TopicID, m.UserID, m.Content, m.ContentHTML, status(m.Status),
+			m.HeldReason, m.CreatedAt}, err
+	case TypeResponse:
+		var resp Response
+		err := record.Decode(&resp)
+		return []interface{}{resp.ID, resp.MessageID, resp.UserID, resp.Content, status(resp.Status),
+			resp.HeldReason, resp.CreatedAt}, err
+	case TypeTopicLike:
+		var l TopicLike
+		err := record.Decode(&l)
+		return []interface{}{l.UserID, l.TopicID, l.Liked}, err
+	case TypeResponseLike:
+		var l ResponseLike
+		err := record.Decode(&l)
+		return []interface{}{l.UserID, l.ResponseID, l.Liked}, err
+	}
+	return nil, fmt.Errorf("archive: unexpected %q record", record.Type)
+}
+
+func status(value string) string {
+	if value == "" {
+		return store.StatusPublished
+	}
+	return value
+}
+
+// batchWriter regroupe les insertions en transactions de importBatch requêtes, avec
+// une requête préparée par type d'enregistrement
+type batchWriter struct {
+	db         store.Store
+	tx         store.Tx
+	statements map[string]store.Stmt
+	pending    int
+}
+
+func (b *batchWriter) exec(query string, args ...interface{}) error {
+	if b.tx == nil {
+		tx, err := b.db.Begin()
+		if err != nil {
+			return err
+		}
+		b.tx, b.statements = tx, map[string]store.Stmt{}
+	}
+	statement := b.statements[query]
+	if statement == nil {
+		var err error
+		if statement, err = b.tx.Prepare(query); err != nil {
+			return err
+		}
+		b.statements[query] = statement
+	}
+	if _, err := statement.Exec(args...); err != nil {
+		return err
+	}
+	if b.pending++; b.pending >= importBatch {
+		return b.commit()
+	}
+	return nil
+}
+
+// commit valide la transaction en cours ; les requêtes préparées sont fermées avec
+// elle
+func (b *batchWriter) commit() error {
+	if b.tx == nil {
+		return nil
+	}
+	err := b.tx.Commit()
+	b.tx, b.pending = nil, 0
+	return err
+}
+
+func (b *batchWriter) rollback() {
+	if b.tx != nil {
+		b.tx.Rollback()
+		b.tx = nil
+	}
+}
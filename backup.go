@@ -0,0 +1,118 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"forum/archive"
+)
+
+// runExport implémente forum export <fichier> : l'archive est compressée si le nom
+// se termine par .gz, et écrite sur la sortie standard si le fichier est « - »
+func runExport(args []string) error {
+	flags, out := commandFlags("export")
+	withHashes := flags.Bool("with-password-hashes", false, "inclure les hachages de mots de passe")
+	positional, err := parseCommand(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	path := positional[0]
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if path != "-" {
+		if file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600); err != nil {
+			return err
+		}
+		w = file
+	}
+	var zipped *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zipped = gzip.NewWriter(w)
+		w = zipped
+	}
+
+	counts, err := archive.Export(db, w, archive.ExportOptions{PasswordHashes: *withHashes})
+	if err == nil && zipped != nil {
+		err = zipped.Close()
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// Une archive incomplète ne doit pas passer pour une sauvegarde
+			os.Remove(path)
+		}
+	}
+	if err != nil || path == "-" {
+		return err
+	}
+
+	result := struct {
+		File           string         `json:"file"`
+		PasswordHashes bool           `json:"password_hashes"`
+		Counts         map[string]int `json:"counts"`
+	}{path, *withHashes, counts}
+	return out.print(result, func(w io.Writer) {
+		printCounts(w, counts)
+		fmt.Fprintf(w, "Archive écrite dans %s\n", path)
+		if !*withHashes {
+			fmt.Fprintln(w, "Sans les mots de passe : après import, utilisez forum user reset-password")
+		}
+	})
+}
+
+// runImport implémente forum import <fichier>, « - » lisant l'entrée standard
+func runImport(args []string) error {
+	flags, out := commandFlags("import")
+	positional, err := parseCommand(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if path := positional[0]; path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	reader, err := archive.NewReader(r)
+	if err != nil {
+		return err
+	}
+	result, err := archive.Import(db, reader)
+	if err != nil {
+		return err
+	}
+
+	return out.print(result, func(w io.Writer) {
+		printCounts(w, result.Counts)
+		if result.Resumed {
+			fmt.Fprintf(w, "Import de l'archive %s repris et terminé\n", reader.Header.ID)
+		} else {
+			fmt.Fprintf(w, "Archive %s importée\n", reader.Header.ID)
+		}
+		if !reader.Header.PasswordHashes {
+			fmt.Fprintln(w, "L'archive ne contient pas les mots de passe : utilisez forum user reset-password")
+		}
+	})
+}
+
+func printCounts(w io.Writer, counts map[string]int) {
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "%s\t%d\n", kind, counts[kind])
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"forum/handlers"
+	"forum/store"
+)
+
+const usage = `usage: forum <commande> [arguments] [--json]
+
+Commandes :
+  serve                                   démarre le serveur (par défaut)
+  migrate [up | down [n] | to <version> | status]
+  user create <nom> <e-mail> [--password P] [--role user|moderator|admin]
+  user promote <nom> <user|moderator|admin> [--reason R]
+  user ban <nom> --reason R [--days N]    bannit, ou suspend N jours
+  user reset-password <nom> [--password P]
+  user export <nom>                       données personnelles du compte en JSON
+  user delete <nom> [--policy anonymize|delete]
+  category add <slug> <libellé>
+  category list
+  topic close <id> [--reason R]
+  topic move <id> <catégorie> [--reason R]
+  stats
+  export <fichier> [--with-password-hashes]
+  import <fichier>                        restaure une archive dans une base vide
+  convert phpbb|discourse <source> <archive> [--redirects F] [--prefix P]
+          [--old-prefix /chemin] [--with-password-hashes]
+
+Les commandes lisent la même configuration que le serveur (DB_DRIVER, DB_PATH,
+DB_USER...). Sans --password, un mot de passe aléatoire est généré et affiché.
+L'archive d'export est compressée si son nom finit par .gz ; « - » désigne la
+sortie ou l'entrée standard.`
+
+// errUsage signale des arguments invalides
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	var run func(args []string) error
+	switch command {
+	case "serve":
+		run = serve
+	case "migrate":
+		run = runMigrate
+	case "user":
+		run = runUser
+	case "category":
+		run = runCategory
+	case "topic":
+		run = runTopic
+	case "stats":
+		run = runStats
+	case "export":
+		run = runExport
+	case "import":
+		run = runImport
+	case "convert":
+		run = runConvert
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "forum: unknown command %q\n\n%s\n", command, usage)
+		os.Exit(2)
+	}
+
+	// convert ne lit et n'écrit que des fichiers
+	if command != "convert" {
+		initDB()
+		defer db.Close()
+		// Seule la migration accepte un schéma qui n'est pas à la version attendue
+		if command != "migrate" {
+			if err := store.CheckSchema(db); err != nil {
+				fmt.Fprintln(os.Stderr, "forum:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	err := run(args)
+	// Les webhooks émis par la commande doivent être en file avant de quitter
+	handlers.WaitWebhookDispatches()
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "forum: %v (voir forum help)\n", err)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "forum:", err)
+		os.Exit(1)
+	}
+}
+
+// cliOutput écrit le résultat d'une commande, lisible ou en JSON avec --json
+type cliOutput struct {
+	json bool
+}
+
+// commandFlags crée les options d'une sous-commande, dont --json commun à toutes
+func commandFlags(name string) (*flag.FlagSet, *cliOutput) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	out := &cliOutput{}
+	flags.BoolVar(&out.json, "json", false, "sortie JSON")
+	return flags, out
+}
+
+// parseCommand lit les options, placées avant, entre ou après les arguments, et
+// vérifie qu'il y a entre min et max arguments
+func parseCommand(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) < min || len(positional) > max {
+		return nil, fmt.Errorf("%w: wrong number of arguments for %s", errUsage, flags.Name())
+	}
+	return positional, nil
+}
+
+// print écrit value en JSON, ou appelle text pour la sortie lisible
+func (o *cliOutput) print(value interface{}, text func(w io.Writer)) error {
+	if o.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
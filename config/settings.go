@@ -0,0 +1,16 @@
+package config
+
+import "os"
+
+// Env renvoie la variable d'environnement key ou fallback si elle est vide
+func Env(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// BaseURL est l'adresse publique du forum, utilisée dans les liens des e-mails
+func BaseURL() string {
+	return Env("FORUM_BASE_URL", "http://localhost:8001")
+}
@@ -1,28 +0,0 @@
-package config
-
-// Themes représente la liste des thèmes prédéfinis disponibles
-var Themes = []struct {
-	ID    string
-	Label string
-}{
-	{"technologie", "Technologie"},
-	{"sport", "Sport"},
-	{"musique", "Musique"},
-	{"cinema", "Cinéma"},
-	{"litterature", "Littérature"},
-	{"cuisine", "Cuisine"},
-	{"voyage", "Voyage"},
-	{"science", "Science"},
-	{"art", "Art"},
-	{"jeux", "Jeux"},
-	{"mode", "Mode"},
-	{"sante", "Santé"},
-	{"education", "Éducation"},
-	{"politique", "Politique"},
-	{"economie", "Économie"},
-	{"environnement", "Environnement"},
-	{"histoire", "Histoire"},
-	{"philosophie", "Philosophie"},
-	{"psychologie", "Psychologie"},
-	{"societe", "Société"},
-}
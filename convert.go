@@ -0,0 +1,107 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"forum/importer"
+)
+
+// runConvert implémente forum convert phpbb|discourse <source> <archive> : la source
+// est convertie en archive, à restaurer ensuite avec forum import. Seule commande à
+// ne pas ouvrir la base, elle ne lit et n'écrit que des fichiers.
+func runConvert(args []string) error {
+	flags, out := commandFlags("convert")
+	var options importer.Options
+	flags.StringVar(&options.TablePrefix, "prefix", "phpbb_", "préfixe des tables phpBB")
+	flags.StringVar(&options.OldPrefix, "old-prefix", "", "chemin de l'ancien forum dans les redirections")
+	flags.BoolVar(&options.PasswordHashes, "with-password-hashes", false, "garder les hachages bcrypt")
+	redirectsPath := flags.String("redirects", "", "fichier CSV de la table de redirection")
+	positional, err := parseCommand(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	software, source, path := positional[0], positional[1], positional[2]
+
+	var convert func(source string, w io.Writer, options importer.Options) (importer.Report, error)
+	switch software {
+	case "phpbb":
+		convert = importer.PhpBB
+	case "discourse":
+		convert = importer.Discourse
+	default:
+		return fmt.Errorf("%w: unknown forum software %q (phpbb or discourse)", errUsage, software)
+	}
+
+	var created []string
+	create := func(name string) (*os.File, error) {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			created = append(created, name)
+		}
+		return file, err
+	}
+	report, err := func() (report importer.Report, err error) {
+		file, err := create(path)
+		if err != nil {
+			return report, err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		var w io.Writer = file
+		var zipped *gzip.Writer
+		if strings.HasSuffix(path, ".gz") {
+			zipped = gzip.NewWriter(file)
+			w = zipped
+		}
+		if *redirectsPath != "" {
+			redirects, err := create(*redirectsPath)
+			if err != nil {
+				return report, err
+			}
+			defer func() {
+				if closeErr := redirects.Close(); err == nil {
+					err = closeErr
+				}
+			}()
+			options.Redirects = redirects
+		}
+		if report, err = convert(source, w, options); err == nil && zipped != nil {
+			err = zipped.Close()
+		}
+		return report, err
+	}()
+	if err != nil {
+		// Une conversion interrompue ne doit pas laisser d'archive à importer
+		for _, name := range created {
+			os.Remove(name)
+		}
+		return err
+	}
+
+	return out.print(report, func(w io.Writer) {
+		printCounts(w, report.Counts)
+		if len(report.Skipped) > 0 {
+			fmt.Fprintln(w, "\nNon repris :")
+			reasons := make([]string, 0, len(report.Skipped))
+			for reason := range report.Skipped {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			for _, reason := range reasons {
+				fmt.Fprintf(w, "%s\t%d\n", reason, report.Skipped[reason])
+			}
+		}
+		fmt.Fprintf(w, "\nArchive écrite dans %s, à restaurer avec forum import\n", path)
+		if *redirectsPath != "" {
+			fmt.Fprintf(w, "%d redirections écrites dans %s\n", report.Redirects, *redirectsPath)
+		}
+	})
+}
@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"forum/config"
+	"forum/store"
+)
+
+// Politiques de suppression de compte, choisies par ACCOUNT_DELETION_POLICY
+const (
+	// DeletionAnonymize conserve tous les contenus du compte sous DeletedAccountName
+	DeletionAnonymize = "anonymize"
+	// DeletionDelete supprime les contenus du compte, sauf ceux auxquels d'autres
+	// utilisateurs ont répondu, anonymisés pour ne pas couper les discussions
+	DeletionDelete = "delete"
+)
+
+// DeletedAccountName est le compte auquel passent les contenus conservés d'un compte
+// supprimé. forum convert crée le même compte pour les auteurs disparus ; sans mot
+// de passe, personne ne peut s'y connecter et le nom est refusé à l'inscription.
+const DeletedAccountName = "Anonymous"
+
+// AccountDeletionPolicy lit ACCOUNT_DELETION_POLICY (anonymize par défaut)
+func AccountDeletionPolicy() string {
+	policy := config.Env("ACCOUNT_DELETION_POLICY", DeletionAnonymize)
+	if policy != DeletionAnonymize && policy != DeletionDelete {
+		log.Printf("Invalid ACCOUNT_DELETION_POLICY %q, using %s", policy, DeletionAnonymize)
+		return DeletionAnonymize
+	}
+	return policy
+}
+
+// PersonalData regroupe les données d'un compte, téléchargées depuis son profil
+type PersonalData struct {
+	ExportedAt      string            `json:"exported_at"`
+	Profile         PersonalProfile   `json:"profile"`
+	Topics          []PersonalTopic   `json:"topics"`
+	Messages        []PersonalMessage `json:"messages"`
+	Replies         []PersonalReply   `json:"replies"`
+	Likes           []PersonalLike    `json:"likes"`
+	Notifications   []Notification    `json:"notifications"`
+	PrivateMessages []PersonalPrivate `json:"private_messages"`
+	Subscriptions   []int             `json:"subscribed_topic_ids"`
+	BlockedUsers    []string          `json:"blocked_users"`
+}
+
+type PersonalProfile struct {
+	ID               int    `json:"id"`
+	Username         string `json:"username"`
+	Mail             string `json:"mail"`
+	Bio              string `json:"bio"`
+	Role             string `json:"role"`
+	TopicNbr         int    `json:"topic_nbr"`
+	ProfileImagePath string `json:"profile_image_path,omitempty"`
+	LastConnection   string `json:"last_connection,omitempty"`
+	CreatedAt        string `json:"created_at"`
+}
+
+type PersonalTopic struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Tags        string `json:"tags"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type PersonalMessage struct {
+	ID        int    `json:"id"`
+	TopicID   int    `json:"topic_id"`
+	Content   string `json:"content"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+type PersonalReply struct {
+	ID        int    `json:"id"`
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+// PersonalLike est un vote : like (true) ou dislike (false) sur un topic ou une réponse
+type PersonalLike struct {
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	Liked      bool   `json:"liked"`
+}
+
+type PersonalPrivate struct {
+	ID             int    `json:"id"`
+	ConversationID int    `json:"conversation_id"`
+	Subject        string `json:"subject"`
+	Content        string `json:"content"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// scanRows appelle scan pour chaque ligne de query
+func scanRows(db store.Store, scan func(rows store.Rows) error, query string, args ...interface{}) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportPersonalData rassemble tout ce que le forum conserve sur un compte, en dehors
+// du journal d'audit ; le hachage du mot de passe n'est pas exporté
+func ExportPersonalData(db store.Store, userID int) (PersonalData, error) {
+	data := PersonalData{
+		ExportedAt:      time.Now().UTC().Format(time.RFC3339),
+		Topics:          []PersonalTopic{},
+		Messages:        []PersonalMessage{},
+		Replies:         []PersonalReply{},
+		Likes:           []PersonalLike{},
+		PrivateMessages: []PersonalPrivate{},
+		Subscriptions:   []int{},
+		BlockedUsers:    []string{},
+	}
+
+	var roleID int
+	p := &data.Profile
+	err := db.QueryRow(`
+		SELECT user_id, username, mail, COALESCE(bio, ''), COALESCE(role_id, 0), COALESCE(topic_nbr, 0),
+			COALESCE(profil_img_path, ''), COALESCE(last_connection, ''), COALESCE(created_at, '')
+		FROM user WHERE user_id = ?
+	`, userID).Scan(&p.ID, &p.Username, &p.Mail, &p.Bio, &roleID, &p.TopicNbr, &p.ProfileImagePath, &p.LastConnection, &p.CreatedAt)
+	if err != nil {
+		return data, err
+	}
+	for _, role := range Roles {
+		if role.ID == roleID {
+			p.Role = role.Label
+		}
+	}
+
+	err = scanRows(db, func(rows store.Rows) error {
+		var t PersonalTopic
+		err := rows.Scan(&t.ID, &t.Title, &t.Tags, &t.Description, &t.Status, &t.CreatedAt)
+		data.Topics = append(data.Topics, t)
+		return err
+	}, `SELECT topic_id, title, COALESCE(tags, ''), COALESCE(description, ''), status, created_at
+		FROM topic WHERE user_id = ? ORDER BY topic_id`, userID)
+	if err != nil {
+		return data, err
+	}
+
+	err = scanRows(db, func(rows store.Rows) error {
+		var m PersonalMessage
+		err := rows.Scan(&m.ID, &m.TopicID, &m.Content, &m.Status, &m.CreatedAt)
+		data.Messages = append(data.Messages, m)
+		return err
+	}, "SELECT message_id, topic_id, content, status, created_at FROM message WHERE user_id = ? ORDER BY message_id", userID)
+	if err != nil {
+		return data, err
+	}
+
+	err = scanRows(db, func(rows store.Rows) error {
+		var reply PersonalReply
+		err := rows.Scan(&reply.ID, &reply.MessageID, &reply.Content, &reply.CreatedAt)
+		data.Replies = append(data.Replies, reply)
+		return err
+	}, "SELECT response_id, message_id, content, created_at FROM response WHERE user_id = ? ORDER BY response_id", userID)
+	if err != nil {
+		return data, err
+	}
+
+	err = scanRows(db, func(rows store.Rows) error {
+		var like PersonalLike
+		err := rows.Scan(&like.TargetType, &like.TargetID, &like.Liked)
+		data.Likes = append(data.Likes, like)
+		return err
+	}, `SELECT 'topic', topic_id, liked FROM topic_user_like WHERE user_id = ?
+		UNION ALL
+		SELECT 'reply', response_id, liked FROM response_user_like WHERE user_id = ?`, userID, userID)
+	if err != nil {
+		return data, err
+	}
+
+	if data.Notifications, err = fetchNotifications(db, userID, 0); err != nil {
+		return data, err
+	}
+
+	err = scanRows(db, func(rows store.Rows) error {
+		var pm PersonalPrivate
+		err := rows.Scan(&pm.ID, &pm.ConversationID, &pm.Subject, &pm.Content, &pm.CreatedAt)
+		data.PrivateMessages = append(data.PrivateMessages, pm)
+		return err
+	}, `SELECT pm.private_message_id, pm.conversation_id, c.subject, pm.content, pm.created_at
+		FROM private_message pm
+		JOIN conversation c ON pm.conversation_id = c.conversation_id
+		WHERE pm.user_id = ?
+		ORDER BY pm.private_message_id`, userID)
+	if err != nil {
+		return data, err
+	}
+
+	err = scanRows(db, func(rows store.Rows) error {
+		var topicID int
+		err := rows.Scan(&topicID)
+		data.Subscriptions = append(data.Subscriptions, topicID)
+		return err
+	}, "SELECT topic_id FROM topic_subscription WHERE user_id = ? ORDER BY topic_id", userID)
+	if err != nil {
+		return data, err
+	}
+
+	err = scanRows(db, func(rows store.Rows) error {
+		var username string
+		err := rows.Scan(&username)
+		data.BlockedUsers = append(data.BlockedUsers, username)
+		return err
+	}, `SELECT u.username FROM user_block b JOIN user u ON b.blocked_id = u.user_id
+		WHERE b.blocker_id = ? ORDER BY u.username`, userID)
+	return data, err
+}
+
+// AccountDeletion résume la suppression d'un compte : nombre de topics, messages,
+// réponses et messages privés supprimés ou passés au compte anonyme
+type AccountDeletion struct {
+	Policy     string         `json:"policy"`
+	Deleted    map[string]int `json:"deleted"`
+	Anonymized map[string]int `json:"anonymized"`
+}
+
+// deletedAccountID renvoie le compte anonyme, créé au premier besoin
+func deletedAccountID(tx store.Tx) (int, error) {
+	var id int
+	err := tx.QueryRow("SELECT user_id FROM user WHERE username = ? AND password = ''", DeletedAccountName).Scan(&id)
+	if err != store.ErrNotFound {
+		return id, err
+	}
+
+	var taken bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM user WHERE username = ?)", DeletedAccountName).Scan(&taken); err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, fmt.Errorf("username %q is used by a regular account", DeletedAccountName)
+	}
+	result, err := tx.Exec("INSERT INTO user (username, mail, password, role_id) VALUES (?, '', '', ?)", DeletedAccountName, RoleUser)
+	if err != nil {
+		return 0, err
+	}
+	inserted, err := result.LastInsertId()
+	return int(inserted), err
+}
+
+// txIDs renvoie la première colonne des lignes de query
+func txIDs(tx store.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func txAffected(tx store.Tx, query string, args ...interface{}) (int, error) {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// DeleteAccount supprime un compte selon policy (DeletionAnonymize ou DeletionDelete).
+// Les contenus conservés passent au compte anonyme, dont topic_nbr est recalculé ; les
+// votes du compte sont retirés, ce qui met à jour les compteurs de likes, et ses
+// notifications, abonnements, préférences et sanctions sont effacés. Le journal
+// d'audit, en ajout seul, garde son nom dans les actions qui le concernent.
+func DeleteAccount(db store.Store, userID int, policy string) (AccountDeletion, error) {
+	deletion := AccountDeletion{Policy: policy, Deleted: map[string]int{}, Anonymized: map[string]int{}}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return deletion, err
+	}
+	defer tx.Rollback()
+
+	anonymousID, err := deletedAccountID(tx)
+	if err != nil {
+		return deletion, err
+	}
+	if anonymousID == userID {
+		return deletion, fmt.Errorf("the %s account cannot be deleted", DeletedAccountName)
+	}
+
+	if policy == DeletionDelete {
+		// Un topic n'est supprimé que si personne d'autre n'y a écrit
+		topics, err := txIDs(tx, `
+			SELECT topic_id FROM topic t
+			WHERE t.user_id = ?
+				AND NOT EXISTS (SELECT 1 FROM message m WHERE m.topic_id = t.topic_id AND m.user_id <> t.user_id)
+				AND NOT EXISTS (SELECT 1 FROM response r JOIN message m ON r.message_id = m.message_id
+					WHERE m.topic_id = t.topic_id AND r.user_id <> t.user_id)
+		`, userID)
+		if err != nil {
+			return deletion, err
+		}
+		for _, topicID := range topics {
+			if err := deleteTopicTx(tx, topicID); err != nil {
+				return deletion, err
+			}
+		}
+		deletion.Deleted["topics"] = len(topics)
+
+		// ni un message auquel quelqu'un d'autre a répondu
+		messages, err := txIDs(tx, `
+			SELECT message_id FROM message m
+			WHERE m.user_id = ?
+				AND NOT EXISTS (SELECT 1 FROM response r WHERE r.message_id = m.message_id AND r.user_id <> m.user_id)
+		`, userID)
+		if err != nil {
+			return deletion, err
+		}
+		for _, messageID := range messages {
+			if err := deleteMessageTx(tx, messageID); err != nil {
+				return deletion, err
+			}
+		}
+		deletion.Deleted["messages"] = len(messages)
+
+		if _, err := tx.Exec("DELETE FROM response_user_like WHERE response_id IN (SELECT response_id FROM response WHERE user_id = ?)", userID); err != nil {
+			return deletion, err
+		}
+		if deletion.Deleted["replies"], err = txAffected(tx, "DELETE FROM response WHERE user_id = ?", userID); err != nil {
+			return deletion, err
+		}
+		if deletion.Deleted["private_messages"], err = txAffected(tx, "DELETE FROM private_message WHERE user_id = ?", userID); err != nil {
+			return deletion, err
+		}
+	}
+
+	// Ce qui reste est conservé sous le compte anonyme
+	contents := []struct{ name, table string }{
+		{"topics", "topic"}, {"messages", "message"}, {"replies", "response"}, {"private_messages", "private_message"},
+	}
+	for _, content := range contents {
+		n, err := txAffected(tx, "UPDATE "+content.table+" SET user_id = ? WHERE user_id = ?", anonymousID, userID)
+		if err != nil {
+			return deletion, err
+		}
+		deletion.Anonymized[content.name] = n
+	}
+
+	// Les lignes qui désignent le compte sans lui appartenir passent elles aussi au
+	// compte anonyme
+	references := []string{
+		"UPDATE notification SET actor_id = ? WHERE actor_id = ?",
+		"UPDATE conversation SET created_by = ? WHERE created_by = ?",
+		"UPDATE report SET reporter_id = ? WHERE reporter_id = ?",
+		"UPDATE report SET resolved_by = ? WHERE resolved_by = ?",
+		"UPDATE sanction SET moderator_id = ? WHERE moderator_id = ?",
+		"UPDATE registration_ban SET created_by = ? WHERE created_by = ?",
+		"UPDATE content_rule SET created_by = ? WHERE created_by = ?",
+		"UPDATE spam_training SET trained_by = ? WHERE trained_by = ?",
+	}
+	for _, statement := range references {
+		if _, err := tx.Exec(statement, anonymousID, userID); err != nil {
+			return deletion, err
+		}
+	}
+
+	conversations, err := txIDs(tx, "SELECT conversation_id FROM conversation_participant WHERE user_id = ?", userID)
+	if err != nil {
+		return deletion, err
+	}
+
+	statements := []string{
+		"DELETE FROM topic_user_like WHERE user_id = ?",
+		"DELETE FROM response_user_like WHERE user_id = ?",
+		"DELETE FROM notification WHERE user_id = ?",
+		"DELETE FROM notification_preference WHERE user_id = ?",
+		"DELETE FROM topic_subscription WHERE user_id = ?",
+		"DELETE FROM mail_queue WHERE user_id = ?",
+		"DELETE FROM digest_preference WHERE user_id = ?",
+		"DELETE FROM digest_log WHERE user_id = ?",
+		"DELETE FROM category_follow WHERE user_id = ?",
+		"DELETE FROM user_block WHERE blocker_id = ?",
+		"DELETE FROM user_block WHERE blocked_id = ?",
+		"DELETE FROM content_rule_hit WHERE user_id = ?",
+		"DELETE FROM sanction WHERE user_id = ?",
+		"DELETE FROM conversation_participant WHERE user_id = ?",
+		"DELETE FROM user WHERE user_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return deletion, err
+		}
+	}
+
+	// Une conversation dont il était le dernier participant n'est plus lisible par personne
+	for _, conversationID := range conversations {
+		for _, statement := range []string{
+			"DELETE FROM private_message WHERE conversation_id = ? AND NOT EXISTS (SELECT 1 FROM conversation_participant WHERE conversation_id = ?)",
+			"DELETE FROM conversation WHERE conversation_id = ? AND NOT EXISTS (SELECT 1 FROM conversation_participant WHERE conversation_id = ?)",
+		} {
+			if _, err := tx.Exec(statement, conversationID, conversationID); err != nil {
+				return deletion, err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user SET topic_nbr = (SELECT COUNT(*) FROM topic WHERE user_id = ? AND status = 'published')
+		WHERE user_id = ?
+	`, anonymousID, anonymousID)
+	if err != nil {
+		return deletion, err
+	}
+	return deletion, tx.Commit()
+}
+
+// accountExists indique si le compte existe encore : le jeton d'un compte supprimé
+// reste valide jusqu'à son expiration
+func accountExists(db store.Store, userID int) (bool, error) {
+	return db.Users().Exists(userID)
+}
+
+// ExportAccountHandler télécharge les données de l'utilisateur connecté en JSON
+func ExportAccountHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		data, err := ExportPersonalData(db, claims.UserID)
+		if err != nil {
+			log.Printf("Error exporting personal data of user %d: %v", claims.UserID, err)
+			http.Error(w, "Error exporting data", http.StatusInternalServerError)
+			return
+		}
+
+		filename := mime.FormatMediaType("attachment", map[string]string{"filename": "forum-" + data.Profile.Username + ".json"})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Disposition", filename)
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			log.Printf("Error writing personal data export: %v", err)
+		}
+	}
+}
+
+// DeleteAccountHandler supprime le compte de l'utilisateur connecté après
+// vérification de son mot de passe, puis le déconnecte
+func DeleteAccountHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		if _, _, err := checkCredentials(db, claims.Username, r.FormValue("password")); err != nil {
+			http.Error(w, "Invalid password", http.StatusForbidden)
+			return
+		}
+
+		deletion, err := DeleteAccount(db, claims.UserID, AccountDeletionPolicy())
+		if err != nil {
+			log.Printf("Error deleting account %d: %v", claims.UserID, err)
+			http.Error(w, "Error deleting account", http.StatusInternalServerError)
+			return
+		}
+
+		// Le compte n'existe plus : l'entrée est rattachée à son identifiant seulement
+		audit(db, r, AuditEvent{
+			Action:     AuditUserDelete,
+			TargetType: AuditTargetUser,
+			TargetID:   claims.UserID,
+			After:      deletion,
+		})
+
+		http.SetCookie(w, &http.Cookie{
+			Name:    "token_form",
+			Value:   "",
+			Path:    "/",
+			Expires: time.Unix(0, 0),
+			MaxAge:  -1,
+		})
+		http.Redirect(w, r, "/register", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import "testing"
+
+// TestDeleteAccount supprime un compte selon chaque politique et vérifie ce qui est
+// supprimé, ce qui passe au compte anonyme et le nombre de topics publiés de ce
+// dernier, recalculé à chaque suppression
+func TestDeleteAccount(t *testing.T) {
+	cases := []struct {
+		policy     string
+		deleted    map[string]int // topics et messages supprimés du premier compte
+		anonymized map[string]int // topics et messages conservés sous le compte anonyme
+		topicNbr   []int          // topic_nbr du compte anonyme après chaque suppression
+	}{
+		{
+			policy:     DeletionAnonymize,
+			deleted:    map[string]int{},
+			anonymized: map[string]int{"topics": 3, "messages": 3},
+			topicNbr:   []int{2, 3},
+		},
+		{
+			policy:     DeletionDelete,
+			deleted:    map[string]int{"topics": 2, "messages": 1},
+			anonymized: map[string]int{"topics": 1, "messages": 1},
+			topicNbr:   []int{1, 1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.policy, func(t *testing.T) {
+			api := newTestAPI(t)
+			bobID, _ := api.createUser(t, "bob", RoleModerator)
+			carolID, _ := api.createUser(t, "carol", RoleModerator)
+
+			createTopic := func(userID int, title string) int {
+				t.Helper()
+				submission, err := CreateTopic(api.db, userID, title, "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return int(submission.ID)
+			}
+			postMessage := func(userID, topicID int) int {
+				t.Helper()
+				submission, err := PostMessage(api.db, userID, topicID, "Un message")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return int(submission.ID)
+			}
+			postReply := func(userID, messageID int) {
+				t.Helper()
+				if _, err := PostReply(api.db, userID, messageID, "Une réponse"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Un topic de l'administrateur, où bob a écrit un message auquel on a
+			// répondu et un autre resté sans réponse, et qu'il a aimé
+			adminTopic := createTopic(api.adminID, "Sujet de l'administrateur")
+			postReply(api.adminID, postMessage(bobID, adminTopic))
+			postMessage(bobID, adminTopic)
+			liked := true
+			if err := SetTopicVote(api.db, bobID, adminTopic, &liked); err != nil {
+				t.Fatal(err)
+			}
+
+			// Un topic de bob où il est seul à écrire, un où l'administrateur a
+			// répondu, et un troisième retenu en attente de validation
+			postReply(bobID, postMessage(bobID, createTopic(bobID, "Seul")))
+			postMessage(api.adminID, createTopic(bobID, "Discuté"))
+			pending := createTopic(bobID, "En attente")
+			if _, err := api.db.Exec("UPDATE topic SET status = ? WHERE topic_id = ?", ContentPending, pending); err != nil {
+				t.Fatal(err)
+			}
+			createTopic(carolID, "Sujet de carol")
+			WaitWebhookDispatches()
+
+			deletion, err := DeleteAccount(api.db, bobID, c.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"topics", "messages"} {
+				if deletion.Deleted[name] != c.deleted[name] || deletion.Anonymized[name] != c.anonymized[name] {
+					t.Errorf("%s: %d deleted, %d anonymized, want %d and %d", name,
+						deletion.Deleted[name], deletion.Anonymized[name], c.deleted[name], c.anonymized[name])
+				}
+			}
+
+			var remaining int
+			err = api.db.QueryRow(`
+				SELECT (SELECT COUNT(*) FROM user WHERE user_id = ?)
+					+ (SELECT COUNT(*) FROM topic_user_like WHERE user_id = ?)
+					+ (SELECT COUNT(*) FROM topic WHERE user_id = ?)
+					+ (SELECT COUNT(*) FROM message WHERE user_id = ?)
+					+ (SELECT COUNT(*) FROM response WHERE user_id = ?)
+			`, bobID, bobID, bobID, bobID, bobID).Scan(&remaining)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remaining != 0 {
+				t.Errorf("%d rows still refer to the deleted account", remaining)
+			}
+
+			anonymousTopics := func() int {
+				t.Helper()
+				var topicNbr int
+				err := api.db.QueryRow("SELECT topic_nbr FROM user WHERE username = ?", DeletedAccountName).Scan(&topicNbr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return topicNbr
+			}
+			if n := anonymousTopics(); n != c.topicNbr[0] {
+				t.Errorf("%s has topic_nbr %d, want %d", DeletedAccountName, n, c.topicNbr[0])
+			}
+
+			// Une seconde suppression recompte les topics déjà conservés
+			if _, err := DeleteAccount(api.db, carolID, c.policy); err != nil {
+				t.Fatal(err)
+			}
+			if n := anonymousTopics(); n != c.topicNbr[1] {
+				t.Errorf("%s has topic_nbr %d after a second deletion, want %d", DeletedAccountName, n, c.topicNbr[1])
+			}
+
+			var anonymousID int
+			if err := api.db.QueryRow("SELECT user_id FROM user WHERE username = ?", DeletedAccountName).Scan(&anonymousID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := DeleteAccount(api.db, anonymousID, c.policy); err == nil {
+				t.Errorf("deleted the %s account", DeletedAccountName)
+			}
+		})
+	}
+}
@@ -0,0 +1,780 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/store"
+)
+
+// Préfixe de la version courante de l'API JSON
+const APIPrefix = "/api/v1"
+
+const (
+	apiDefaultLimit = 20
+	apiMaxLimit     = 100
+	apiMaxBodySize  = 1 << 20
+	maxTitleLength  = 255
+	maxContentSize  = 65535
+)
+
+// APIError est le corps de toutes les réponses d'erreur de l'API
+type APIError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// apiRequest regroupe les paramètres extraits du chemin et l'utilisateur connecté
+type apiRequest struct {
+	*http.Request
+	claims *Claims
+	id     int
+	name   string
+}
+
+type apiRoute struct {
+	method  string
+	pattern string
+	public  bool
+	handler func(db store.Store, w http.ResponseWriter, r *apiRequest)
+
+	// Description de la route, utilisée pour générer la spécification OpenAPI
+	summary  string
+	query    []string
+	request  interface{}
+	response interface{}
+	list     bool
+	status   int
+	errors   []int
+	rate     string // budget de limitation des écritures (voir RateLimit)
+	location bool   // la réponse donne l'adresse de la ressource créée (en-tête Location)
+}
+
+// apiRoutes est la table de routage de l'API ; {id} n'accepte qu'un entier
+var apiRoutes = []apiRoute{
+	{method: http.MethodPost, pattern: "/auth/token", public: true, handler: apiCreateToken,
+		summary: "Échange un identifiant et un mot de passe contre un jeton", request: TokenRequest{}, response: TokenResponse{}, status: http.StatusCreated,
+		errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests}},
+	{method: http.MethodGet, pattern: "/me", handler: apiGetMe,
+		summary: "Profil de l'utilisateur connecté", response: UserProfile{}},
+	{method: http.MethodGet, pattern: "/tags", handler: apiListTags,
+		summary: "Thèmes disponibles et nombre de topics par thème", response: []Tag{}},
+	{method: http.MethodGet, pattern: "/topics", handler: apiListTopics,
+		summary: "Liste paginée des topics", query: []string{"tags", "sort", "limit", "offset"}, response: Topic{}, list: true},
+	{method: http.MethodPost, pattern: "/topics", handler: apiCreateTopic,
+		summary: "Crée un topic", request: TopicInput{}, response: Topic{}, status: http.StatusCreated, rate: RateTopic, location: true},
+	{method: http.MethodGet, pattern: "/topics/{id}", handler: apiGetTopic,
+		summary: "Détail d'un topic", response: Topic{}},
+	{method: http.MethodPut, pattern: "/topics/{id}/vote", handler: apiVoteTopic,
+		summary: "Like ou dislike un topic", request: VoteInput{}, response: Topic{}, rate: RateLike},
+	{method: http.MethodDelete, pattern: "/topics/{id}/vote", handler: apiVoteTopic,
+		summary: "Retire le vote sur un topic", response: Topic{}, rate: RateLike},
+	{method: http.MethodGet, pattern: "/topics/{id}/messages", handler: apiListMessages,
+		summary: "Messages d'un topic", query: []string{"limit", "offset"}, response: Message{}, list: true},
+	{method: http.MethodPost, pattern: "/topics/{id}/messages", handler: apiCreateMessage,
+		summary: "Poste un message dans un topic", request: ContentInput{}, response: Message{}, status: http.StatusCreated,
+		errors: []int{http.StatusConflict}, rate: RateMessage, location: true},
+	{method: http.MethodGet, pattern: "/messages/{id}", handler: apiGetMessage,
+		summary: "Détail d'un message", response: Message{}},
+	{method: http.MethodGet, pattern: "/messages/{id}/replies", handler: apiListReplies,
+		summary: "Réponses à un message", query: []string{"limit", "offset"}, response: Reply{}, list: true},
+	{method: http.MethodPost, pattern: "/messages/{id}/replies", handler: apiCreateReply,
+		summary: "Répond à un message", request: ContentInput{}, response: Reply{}, status: http.StatusCreated,
+		errors: []int{http.StatusConflict}, rate: RateMessage, location: true},
+	{method: http.MethodGet, pattern: "/replies/{id}", handler: apiGetReply,
+		summary: "Détail d'une réponse", response: Reply{}},
+	{method: http.MethodPut, pattern: "/replies/{id}/vote", handler: apiVoteReply,
+		summary: "Like ou dislike une réponse", request: VoteInput{}, response: Reply{}, rate: RateLike},
+	{method: http.MethodDelete, pattern: "/replies/{id}/vote", handler: apiVoteReply,
+		summary: "Retire le vote sur une réponse", response: Reply{}, rate: RateLike},
+	{method: http.MethodGet, pattern: "/users/{name}", handler: apiGetUser,
+		summary: "Profil public d'un utilisateur", response: UserProfile{}},
+}
+
+// match compare le chemin (sans préfixe) au motif de la route
+func (route apiRoute) match(path string) (int, string, bool) {
+	patternParts := strings.Split(strings.Trim(route.pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return 0, "", false
+	}
+
+	var id int
+	var name string
+	for i, part := range patternParts {
+		switch part {
+		case "{id}":
+			value, err := strconv.Atoi(pathParts[i])
+			if err != nil || value <= 0 {
+				return 0, "", false
+			}
+			id = value
+		case "{name}":
+			if pathParts[i] == "" {
+				return 0, "", false
+			}
+			name = pathParts[i]
+		default:
+			if part != pathParts[i] {
+				return 0, "", false
+			}
+		}
+	}
+	return id, name, true
+}
+
+// APIHandler sert l'API JSON versionnée. Elle n'effectue jamais de redirection :
+// l'authentification se fait par jeton (Authorization: Bearer) ou par le cookie
+// de session, et toutes les erreurs sont renvoyées sous forme d'objet JSON.
+func APIHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, APIPrefix)
+
+		var allowed []string
+		for _, route := range apiRoutes {
+			id, name, ok := route.match(path)
+			if !ok {
+				continue
+			}
+			if route.method != r.Method {
+				allowed = append(allowed, route.method)
+				continue
+			}
+
+			route.serveValidated(w, func(w http.ResponseWriter) {
+				req := &apiRequest{Request: r, id: id, name: name}
+				if !route.public {
+					claims, err := currentClaims(r)
+					if err != nil {
+						w.Header().Set("WWW-Authenticate", "Bearer")
+						writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+						return
+					}
+					exists, err := accountExists(db, claims.UserID)
+					if err != nil {
+						apiInternalError(w, "Error checking account", err)
+						return
+					}
+					if !exists {
+						w.Header().Set("WWW-Authenticate", "Bearer")
+						writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Account deleted")
+						return
+					}
+					if apiRejectSanctioned(db, w, claims.UserID) {
+						return
+					}
+					if route.rate != "" && apiRateLimited(db, w, route.rate, r, claims.UserID, "") {
+						return
+					}
+					req.claims = claims
+				}
+				route.handler(db, w, req)
+			})
+			return
+		}
+
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, "not_found", "Unknown endpoint")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Error encoding API response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	var body APIError
+	body.Error.Code = code
+	body.Error.Message = message
+	writeJSON(w, status, body)
+}
+
+// apiInternalError journalise l'erreur sans l'exposer au client
+func apiInternalError(w http.ResponseWriter, context string, err error) {
+	log.Printf("%s: %v", context, err)
+	writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+}
+
+// decodeJSON lit le corps JSON de la requête en refusant les champs inconnus
+func decodeJSON(w http.ResponseWriter, r *apiRequest, value interface{}) bool {
+	mediaType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json")
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// pagination lit limit et offset de la requête
+func pagination(w http.ResponseWriter, r *apiRequest) (int, int, bool) {
+	limit, offset := apiDefaultLimit, 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > apiMaxLimit {
+			writeAPIError(w, http.StatusBadRequest, "invalid_parameter", "limit must be between 1 and 100")
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_parameter", "offset must be a positive integer")
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// Page est l'enveloppe des listes paginées
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+type TokenRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type TokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func apiCreateToken(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	var req TokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "username and password are required")
+		return
+	}
+	if apiRateLimited(db, w, RateLogin, r.Request, 0, req.Username) {
+		return
+	}
+
+	userID, username, err := checkCredentials(db, req.Username, req.Password)
+	if err != nil {
+		writeAPIError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid username or password")
+		return
+	}
+
+	if apiRejectSanctioned(db, w, userID) {
+		return
+	}
+
+	token, err := generateToken(userID, username)
+	if err != nil {
+		apiInternalError(w, "Error generating token", err)
+		return
+	}
+	if err := db.Users().TouchLastConnection(userID); err != nil {
+		log.Printf("Error updating last connection: %v", err)
+	}
+
+	writeJSON(w, http.StatusCreated, TokenResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339),
+	})
+}
+
+// apiRejectSanctioned répond 403 si le compte est suspendu ou banni et renvoie
+// alors true ; le message reprend le motif et la date de fin de la sanction
+func apiRejectSanctioned(db store.Store, w http.ResponseWriter, userID int) bool {
+	sanction, err := ActiveSanction(db, userID)
+	if err != nil {
+		apiInternalError(w, "Error checking account", err)
+		return true
+	}
+	if sanction == nil {
+		return false
+	}
+	writeSanctionError(w, sanction)
+	return true
+}
+
+// writeSanctionError répond 403 avec le motif et la date de fin de la sanction
+func writeSanctionError(w http.ResponseWriter, sanction *Sanction) {
+	if sanction.Permanent() {
+		writeAPIError(w, http.StatusForbidden, "account_banned", "Account banned: "+sanction.Reason)
+	} else {
+		writeAPIError(w, http.StatusForbidden, "account_suspended", "Account suspended until "+sanction.ExpiresAt+": "+sanction.Reason)
+	}
+}
+
+// apiFound répond 404 avec le message notFound si err signale un élément absent,
+// 500 pour toute autre erreur, et renvoie true si l'élément a été trouvé
+func apiFound(w http.ResponseWriter, err error, context, notFound string) bool {
+	if err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "not_found", notFound)
+		return false
+	}
+	if err != nil {
+		apiInternalError(w, context, err)
+		return false
+	}
+	return true
+}
+
+func apiGetMe(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	profile, err := db.Users().Profile(r.claims.UserID)
+	if !apiFound(w, err, "Error fetching current user", "User not found") {
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func apiGetUser(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	profile, err := db.Users().ProfileByName(r.name)
+	if !apiFound(w, err, "Error fetching user", "User not found") {
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// Tag est un thème prédéfini et le nombre de topics qui l'utilisent
+type Tag struct {
+	ID     string `json:"id"`
+	Label  string `json:"label"`
+	Topics int    `json:"topics"`
+}
+
+func apiListTags(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	topicTags, err := db.Topics().Tags()
+	if err != nil {
+		apiInternalError(w, "Error fetching tags", err)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, tags := range topicTags {
+		for _, tag := range strings.Split(tags, ",") {
+			counts[strings.TrimSpace(tag)]++
+		}
+	}
+
+	categories, err := db.Categories().List()
+	if err != nil {
+		apiInternalError(w, "Error fetching tags", err)
+		return
+	}
+	tags := make([]Tag, 0, len(categories))
+	for _, category := range categories {
+		tags = append(tags, Tag{ID: category.Slug, Label: category.Label, Topics: counts[category.Slug]})
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func apiListTopics(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	limit, offset, ok := pagination(w, r)
+	if !ok {
+		return
+	}
+
+	// Mêmes filtres et tris que la page d'accueil
+	filter := store.TopicFilter{Tag: r.URL.Query().Get("tags"), Limit: limit, Offset: offset}
+	switch r.URL.Query().Get("sort") {
+	case "", store.SortRecent:
+		filter.Sort = store.SortRecent
+	case store.SortLikes, store.SortDislikes:
+		filter.Sort = r.URL.Query().Get("sort")
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid_parameter", "sort must be recent, likes or dislikes")
+		return
+	}
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return
+	}
+	filter.Viewer = viewer
+
+	total, err := db.Topics().Count(filter)
+	if err != nil {
+		apiInternalError(w, "Error counting topics", err)
+		return
+	}
+	topics, err := db.Topics().List(filter)
+	if err != nil {
+		apiInternalError(w, "Error fetching topics", err)
+		return
+	}
+	if topics == nil {
+		topics = []Topic{}
+	}
+	writeJSON(w, http.StatusOK, Page{Items: topics, Total: total, Limit: limit, Offset: offset})
+}
+
+func apiGetTopic(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return
+	}
+	topic, err := db.Topics().Get(r.id, viewer)
+	if !apiFound(w, err, "Error fetching topic", "Topic not found") {
+		return
+	}
+	writeJSON(w, http.StatusOK, topic)
+}
+
+type TopicInput struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func apiCreateTopic(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	var input TopicInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+
+	input.Title = strings.TrimSpace(input.Title)
+	if input.Title == "" || utf8.RuneCountInString(input.Title) > maxTitleLength {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_title", "title is required and must be at most 255 characters")
+		return
+	}
+	if len(input.Description) > maxContentSize {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_description", "description is too long")
+		return
+	}
+	for _, tag := range input.Tags {
+		_, err := db.Categories().Get(tag)
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusUnprocessableEntity, "invalid_tag", "Unknown tag: "+tag)
+			return
+		}
+		if err != nil {
+			apiInternalError(w, "Error checking tags", err)
+			return
+		}
+	}
+
+	submission, err := CreateTopic(db, r.claims.UserID, input.Title, input.Description, strings.Join(input.Tags, ", "))
+	var sanctioned *SanctionedError
+	if errors.As(err, &sanctioned) {
+		writeSanctionError(w, sanctioned.Sanction)
+		return
+	}
+	var rejected *ContentRejectedError
+	if errors.As(err, &rejected) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "content_rejected", rejected.UserMessage())
+		return
+	}
+	if err != nil {
+		apiInternalError(w, "Error creating topic", err)
+		return
+	}
+
+	// Un topic en attente, visible de son auteur, est renvoyé avec le statut "pending"
+	topic, err := db.Topics().Get(int(submission.ID), store.Viewer{UserID: r.claims.UserID})
+	if err != nil {
+		apiInternalError(w, "Error fetching created topic", err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/topics/"+strconv.Itoa(topic.ID))
+	writeJSON(w, http.StatusCreated, topic)
+}
+
+type VoteInput struct {
+	Liked bool `json:"liked"`
+}
+
+// readVote renvoie le vote demandé : le corps pour PUT, aucun vote pour DELETE
+func readVote(w http.ResponseWriter, r *apiRequest) (*bool, bool) {
+	if r.Method == http.MethodDelete {
+		return nil, true
+	}
+	var input VoteInput
+	if !decodeJSON(w, r, &input) {
+		return nil, false
+	}
+	return &input.Liked, true
+}
+
+func apiVoteTopic(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	liked, ok := readVote(w, r)
+	if !ok {
+		return
+	}
+	if !r.visibleTopic(db, w) {
+		return
+	}
+
+	if err := SetTopicVote(db, r.claims.UserID, r.id, liked); err != nil {
+		apiInternalError(w, "Error updating vote", err)
+		return
+	}
+	apiGetTopic(db, w, r)
+}
+
+// viewer renvoie l'appelant tel que le voient les dépôts, modérateur ou non
+func (r *apiRequest) viewer(db store.Store) (store.Viewer, error) {
+	return UserViewer(db, r.claims.UserID)
+}
+
+// visibleTopic répond 404 si le topic de l'URL n'existe pas ou n'est pas visible de l'appelant
+func (r *apiRequest) visibleTopic(db store.Store, w http.ResponseWriter) bool {
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return false
+	}
+	_, err = db.Topics().Get(r.id, viewer)
+	return apiFound(w, err, "Error checking existence", "Topic not found")
+}
+
+// renderMessage complète le rendu HTML des messages antérieurs au cache
+func renderMessage(message Message) Message {
+	if message.ContentHTML == "" {
+		message.ContentHTML = string(RenderMarkdown(message.Content))
+	}
+	return message
+}
+
+func apiListMessages(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	limit, offset, ok := pagination(w, r)
+	if !ok {
+		return
+	}
+	if !r.visibleTopic(db, w) {
+		return
+	}
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return
+	}
+
+	total, err := db.Messages().CountByTopic(r.id, viewer)
+	if err != nil {
+		apiInternalError(w, "Error counting messages", err)
+		return
+	}
+	list, err := db.Messages().ListByTopic(r.id, viewer, limit, offset)
+	if err != nil {
+		apiInternalError(w, "Error fetching messages", err)
+		return
+	}
+
+	messages := make([]Message, 0, len(list))
+	for _, message := range list {
+		messages = append(messages, renderMessage(message))
+	}
+	writeJSON(w, http.StatusOK, Page{Items: messages, Total: total, Limit: limit, Offset: offset})
+}
+
+type ContentInput struct {
+	Content string `json:"content"`
+}
+
+// readContent lit et valide le contenu d'un message ou d'une réponse
+func readContent(w http.ResponseWriter, r *apiRequest) (string, bool) {
+	var input ContentInput
+	if !decodeJSON(w, r, &input) {
+		return "", false
+	}
+	if strings.TrimSpace(input.Content) == "" || len(input.Content) > maxContentSize {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_content", "content is required and must be at most 65535 bytes")
+		return "", false
+	}
+	return input.Content, true
+}
+
+func apiCreateMessage(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	content, ok := readContent(w, r)
+	if !ok {
+		return
+	}
+	if !r.visibleTopic(db, w) {
+		return
+	}
+
+	submission, err := PostMessage(db, r.claims.UserID, r.id, content)
+	var sanctioned *SanctionedError
+	if errors.As(err, &sanctioned) {
+		writeSanctionError(w, sanctioned.Sanction)
+		return
+	}
+	var rejected *ContentRejectedError
+	if errors.As(err, &rejected) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "content_rejected", rejected.UserMessage())
+		return
+	}
+	if errors.Is(err, ErrTopicClosed) {
+		writeAPIError(w, http.StatusConflict, "topic_closed", "Topic is closed")
+		return
+	}
+	if err != nil {
+		apiInternalError(w, "Error creating message", err)
+		return
+	}
+
+	message, err := db.Messages().Get(int(submission.ID), store.Viewer{UserID: r.claims.UserID})
+	if err != nil {
+		apiInternalError(w, "Error fetching created message", err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/messages/"+strconv.Itoa(message.ID))
+	writeJSON(w, http.StatusCreated, renderMessage(message))
+}
+
+func apiGetMessage(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return
+	}
+	message, err := db.Messages().Get(r.id, viewer)
+	if !apiFound(w, err, "Error fetching message", "Message not found") {
+		return
+	}
+	writeJSON(w, http.StatusOK, renderMessage(message))
+}
+
+// renderReply ajoute le rendu HTML d'une réponse
+func renderReply(reply Reply) Reply {
+	reply.ContentHTML = string(RenderMarkdown(reply.Content))
+	return reply
+}
+
+func apiListReplies(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	limit, offset, ok := pagination(w, r)
+	if !ok {
+		return
+	}
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return
+	}
+	if _, err := db.Messages().Get(r.id, viewer); !apiFound(w, err, "Error checking existence", "Message not found") {
+		return
+	}
+
+	total, err := db.Messages().CountReplies(r.id, viewer)
+	if err != nil {
+		apiInternalError(w, "Error counting replies", err)
+		return
+	}
+	list, err := db.Messages().ListReplies(r.id, viewer, limit, offset)
+	if err != nil {
+		apiInternalError(w, "Error fetching replies", err)
+		return
+	}
+
+	replies := make([]Reply, 0, len(list))
+	for _, reply := range list {
+		replies = append(replies, renderReply(reply))
+	}
+	writeJSON(w, http.StatusOK, Page{Items: replies, Total: total, Limit: limit, Offset: offset})
+}
+
+func apiCreateReply(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	content, ok := readContent(w, r)
+	if !ok {
+		return
+	}
+
+	submission, err := PostReply(db, r.claims.UserID, r.id, content)
+	var sanctioned *SanctionedError
+	if errors.As(err, &sanctioned) {
+		writeSanctionError(w, sanctioned.Sanction)
+		return
+	}
+	var rejected *ContentRejectedError
+	if errors.As(err, &rejected) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "content_rejected", rejected.UserMessage())
+		return
+	}
+	if errors.Is(err, ErrTopicClosed) {
+		writeAPIError(w, http.StatusConflict, "topic_closed", "Topic is closed")
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Message not found")
+		return
+	}
+	if err != nil {
+		apiInternalError(w, "Error creating reply", err)
+		return
+	}
+
+	// Une réponse en attente, visible de son auteur, est renvoyée avec le statut "pending"
+	reply, err := db.Messages().GetReply(int(submission.ID), store.Viewer{UserID: r.claims.UserID})
+	if err != nil {
+		apiInternalError(w, "Error fetching created reply", err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/replies/"+strconv.Itoa(reply.ID))
+	writeJSON(w, http.StatusCreated, renderReply(reply))
+}
+
+func apiGetReply(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return
+	}
+	reply, err := db.Messages().GetReply(r.id, viewer)
+	if !apiFound(w, err, "Error fetching reply", "Reply not found") {
+		return
+	}
+	writeJSON(w, http.StatusOK, renderReply(reply))
+}
+
+func apiVoteReply(db store.Store, w http.ResponseWriter, r *apiRequest) {
+	liked, ok := readVote(w, r)
+	if !ok {
+		return
+	}
+	viewer, err := r.viewer(db)
+	if err != nil {
+		apiInternalError(w, "Error checking permissions", err)
+		return
+	}
+	if _, err := db.Messages().GetReply(r.id, viewer); !apiFound(w, err, "Error checking existence", "Reply not found") {
+		return
+	}
+
+	if err := db.Likes().SetReplyVote(r.claims.UserID, r.id, liked); err != nil {
+		apiInternalError(w, "Error updating vote", err)
+		return
+	}
+
+	reply, err := db.Messages().GetReply(r.id, viewer)
+	if err != nil {
+		apiInternalError(w, "Error fetching reply", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, renderReply(reply))
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"forum/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "motdepasse-de-test"
+
+// testAPI est une API servie sur une base SQLite migrée, avec un administrateur
+type testAPI struct {
+	*httptest.Server
+	db      store.Store
+	adminID int
+	token   string // jeton de l'administrateur
+}
+
+// newTestAPI ouvre une base SQLite migrée avec un administrateur, et sert l'API
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Migrate(len(db.Migrations())); err != nil {
+		t.Fatal(err)
+	}
+
+	// Les seaux de débit, les règles de contenu et le modèle de spam sont globaux au
+	// package : chaque test part de seaux pleins et recharge les règles et le modèle
+	// de sa base
+	writeLimiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	contentRules = ruleCache{}
+	spamClassifier = &spamModel{}
+
+	mux := http.NewServeMux()
+	mux.Handle(APIPrefix+"/", APIHandler(db))
+	api := &testAPI{Server: httptest.NewServer(mux), db: db}
+	t.Cleanup(func() {
+		api.Close()
+		WaitWebhookDispatches()
+		db.Close()
+	})
+	api.adminID, api.token = api.createUser(t, "admin", RoleAdmin)
+	return api
+}
+
+// createUser crée un compte avec le mot de passe testPassword et renvoie son jeton
+func (api *testAPI) createUser(t *testing.T, username string, role int) (int, string) {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := api.db.Users().Create(store.NewUser{
+		Username: username, Mail: username + "@example.com", PasswordHash: string(hash), RoleID: role,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := generateToken(int(id), username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id), token
+}
+
+// do envoie une requête à l'API avec le jeton donné (aucun si vide) et renvoie
+// la réponse et son corps
+func (api *testAPI) do(t *testing.T, method, path, token, body string) (*http.Response, []byte) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, api.URL+APIPrefix+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := api.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	return resp, data.Bytes()
+}
+
+// findRoute renvoie la route de apiRoutes qui sert la requête
+func findRoute(method, path string) (apiRoute, bool) {
+	path = strings.TrimPrefix(path, APIPrefix)
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	for _, route := range apiRoutes {
+		if _, _, ok := route.match(path); ok && route.method == method {
+			return route, true
+		}
+	}
+	return apiRoute{}, false
+}
+
+// TestAPIRoutesMatchSpec appelle chaque route de l'API sur une base SQLite et
+// vérifie le statut et le corps de chaque réponse avec la spécification OpenAPI
+func TestAPIRoutesMatchSpec(t *testing.T) {
+	api := newTestAPI(t)
+
+	cases := []struct {
+		method, path, body string
+		anonymous          bool
+		status             int
+	}{
+		{method: http.MethodPost, path: "/auth/token", body: `{"username":"admin","password":"` + testPassword + `"}`, anonymous: true, status: http.StatusCreated},
+		{method: http.MethodPost, path: "/auth/token", body: `{"username":"admin","password":"mauvais"}`, anonymous: true, status: http.StatusUnauthorized},
+		{method: http.MethodPost, path: "/auth/token", body: `{}`, anonymous: true, status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/me", status: http.StatusOK},
+		{method: http.MethodGet, path: "/me", anonymous: true, status: http.StatusUnauthorized},
+		{method: http.MethodPost, path: "/topics", body: `{"title":"Premier sujet","description":"Une *description*","tags":["sport"]}`, status: http.StatusCreated},
+		{method: http.MethodPost, path: "/topics", body: `{"title":""}`, status: http.StatusUnprocessableEntity},
+		{method: http.MethodGet, path: "/tags", status: http.StatusOK},
+		{method: http.MethodGet, path: "/topics?sort=likes&limit=5", status: http.StatusOK},
+		{method: http.MethodGet, path: "/topics/1", status: http.StatusOK},
+		{method: http.MethodGet, path: "/topics/999", status: http.StatusNotFound},
+		{method: http.MethodPut, path: "/topics/1/vote", body: `{"liked":true}`, status: http.StatusOK},
+		{method: http.MethodDelete, path: "/topics/1/vote", status: http.StatusOK},
+		{method: http.MethodPost, path: "/topics/1/messages", body: `{"content":"Un message pour @admin"}`, status: http.StatusCreated},
+		{method: http.MethodGet, path: "/topics/1/messages", status: http.StatusOK},
+		{method: http.MethodGet, path: "/messages/1", status: http.StatusOK},
+		{method: http.MethodPost, path: "/messages/1/replies", body: `{"content":"Une réponse"}`, status: http.StatusCreated},
+		{method: http.MethodGet, path: "/messages/1/replies?limit=10&offset=0", status: http.StatusOK},
+		{method: http.MethodGet, path: "/replies/1", status: http.StatusOK},
+		{method: http.MethodGet, path: "/replies/999", status: http.StatusNotFound},
+		{method: http.MethodPut, path: "/replies/1/vote", body: `{"liked":false}`, status: http.StatusOK},
+		{method: http.MethodDelete, path: "/replies/1/vote", status: http.StatusOK},
+		{method: http.MethodGet, path: "/users/admin", status: http.StatusOK},
+		{method: http.MethodGet, path: "/users/inconnu", status: http.StatusNotFound},
+	}
+
+	tested := make(map[string]bool)
+	for _, c := range cases {
+		route, ok := findRoute(c.method, c.path)
+		if !ok {
+			t.Fatalf("%s %s: no API route", c.method, c.path)
+		}
+
+		token := api.token
+		if c.anonymous {
+			token = ""
+		}
+		resp, data := api.do(t, c.method, c.path, token, c.body)
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %s: status %d, want %d: %s", c.method, c.path, resp.StatusCode, c.status, data)
+			continue
+		}
+		if err := route.validate(resp.StatusCode, data); err != nil {
+			t.Errorf("%s %s (%d): %v", c.method, c.path, resp.StatusCode, err)
+		}
+		if resp.StatusCode == route.successStatus() {
+			tested[route.method+" "+route.pattern] = true
+		}
+
+		// Les créations donnent l'adresse de la ressource, qui doit se résoudre
+		location := resp.Header.Get("Location")
+		if route.location && resp.StatusCode == route.successStatus() {
+			if !strings.HasPrefix(location, APIPrefix+"/") {
+				t.Errorf("%s %s: Location %q, want an API URL", c.method, c.path, location)
+				continue
+			}
+			if resp, data := api.do(t, http.MethodGet, strings.TrimPrefix(location, APIPrefix), token, ""); resp.StatusCode != http.StatusOK {
+				t.Errorf("%s %s: GET %s: status %d: %s", c.method, c.path, location, resp.StatusCode, data)
+			}
+		} else if !route.location && location != "" {
+			t.Errorf("%s %s: unexpected Location %q", c.method, c.path, location)
+		}
+	}
+
+	for _, route := range apiRoutes {
+		if !tested[route.method+" "+route.pattern] {
+			t.Errorf("%s %s: no successful call tested", route.method, route.pattern)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/store"
+)
+
+// Actions enregistrées dans le journal d'audit
+const (
+	AuditTopicState        = "topic.state"
+	AuditTopicDelete       = "topic.delete"
+	AuditMessageDelete     = "message.delete"
+	AuditReportResolve     = "report.resolve"
+	AuditUserWarn          = "user.warn"
+	AuditUserSuspend       = "user.suspend"
+	AuditUserBan           = "user.ban"
+	AuditUserLift          = "user.lift"
+	AuditUserRole          = "user.role"
+	AuditRegistrationBan   = "registration_ban.create"
+	AuditRegistrationUnban = "registration_ban.delete"
+	AuditWebhookCreate     = "webhook.create"
+	AuditWebhookToggle     = "webhook.toggle"
+	AuditWebhookDelete     = "webhook.delete"
+	AuditWebhookRedeliver  = "webhook.redeliver"
+	AuditRuleCreate        = "content_rule.create"
+	AuditRuleUpdate        = "content_rule.update"
+	AuditRuleDelete        = "content_rule.delete"
+	AuditContentApprove    = "content.approve"
+	AuditContentReject     = "content.reject"
+	AuditUserCreate        = "user.create"
+	AuditUserPassword      = "user.password"
+	AuditUserDelete        = "user.delete"
+	AuditCategoryCreate    = "category.create"
+	AuditTopicMove         = "topic.move"
+)
+
+// Types de cible des entrées d'audit
+const (
+	AuditTargetTopic           = "topic"
+	AuditTargetMessage         = "message"
+	AuditTargetReply           = "reply"
+	AuditTargetUser            = "user"
+	AuditTargetRegistrationBan = "registration_ban"
+	AuditTargetWebhook         = "webhook"
+	AuditTargetRule            = "content_rule"
+	AuditTargetCategory        = "category"
+)
+
+// AuditConsoleActor est l'auteur enregistré pour les actions de la ligne de commande
+const AuditConsoleActor = "console"
+
+// AuditActions liste les actions proposées dans le filtre de la page d'audit
+var AuditActions = []string{
+	AuditTopicState, AuditTopicDelete, AuditMessageDelete, AuditReportResolve,
+	AuditUserWarn, AuditUserSuspend, AuditUserBan, AuditUserLift, AuditUserRole,
+	AuditRegistrationBan, AuditRegistrationUnban,
+	AuditWebhookCreate, AuditWebhookToggle, AuditWebhookDelete, AuditWebhookRedeliver,
+	AuditRuleCreate, AuditRuleUpdate, AuditRuleDelete, AuditContentApprove, AuditContentReject,
+	AuditUserCreate, AuditUserPassword, AuditUserDelete, AuditCategoryCreate, AuditTopicMove,
+}
+
+const (
+	auditPageSize    = 50
+	maxAuditExport   = 100000
+	maxAuditLabelLen = 255
+)
+
+// AuditEvent décrit une action privilégiée ; Before et After sont enregistrés en JSON
+type AuditEvent struct {
+	Action      string
+	TargetType  string
+	TargetID    int
+	TargetLabel string
+	Before      interface{}
+	After       interface{}
+	Reason      string
+}
+
+// AuditEntry est une ligne du journal d'audit
+type AuditEntry struct {
+	ID          int             `json:"id"`
+	ActorID     int             `json:"actor_id"`
+	Actor       string          `json:"actor"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type"`
+	TargetID    int             `json:"target_id"`
+	TargetLabel string          `json:"target_label"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	Reason      string          `json:"reason"`
+	IP          string          `json:"ip"`
+	CreatedAt   string          `json:"created_at"`
+}
+
+func auditJSON(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// RecordAudit ajoute une entrée au journal d'audit. Le journal est en ajout seul :
+// aucune route ne modifie ni ne supprime ses lignes et la base le refuse (voir les
+// triggers de store/migrations). Le nom de l'auteur est copié pour rester lisible si
+// le compte disparaît ; une action sans auteur (actorID 0) vient de la ligne de commande.
+func RecordAudit(db store.Store, actorID int, ip string, event AuditEvent) error {
+	before, err := auditJSON(event.Before)
+	if err != nil {
+		return err
+	}
+	after, err := auditJSON(event.After)
+	if err != nil {
+		return err
+	}
+
+	var actor interface{}
+	actorName := AuditConsoleActor
+	if actorID != 0 {
+		actor = actorID
+		actorName = ""
+	}
+	_, err = db.Exec(`
+		INSERT INTO audit_log (actor_id, actor_username, action, target_type, target_id, target_label,
+		                       before_value, after_value, reason, ip)
+		VALUES (?, COALESCE((SELECT username FROM user WHERE user_id = ?), NULLIF(?, '')), ?, ?, ?, ?, ?, ?, ?, ?)
+	`, actor, actorID, actorName, event.Action, event.TargetType, event.TargetID, truncate(event.TargetLabel, maxAuditLabelLen),
+		before, after, event.Reason, ip)
+	return err
+}
+
+// audit enregistre l'action de l'utilisateur connecté ; l'action ayant déjà eu lieu,
+// un échec est journalisé sans interrompre la requête
+func audit(db store.Store, r *http.Request, event AuditEvent) {
+	var actorID int
+	if claims, err := currentClaims(r); err == nil {
+		actorID = claims.UserID
+	}
+	var ip string
+	if addr := clientIP(r); addr != nil {
+		ip = addr.String()
+	}
+	if err := RecordAudit(db, actorID, ip, event); err != nil {
+		log.Printf("Error recording audit entry %s %s#%d: %v", event.Action, event.TargetType, event.TargetID, err)
+	}
+}
+
+// contentSnapshot renvoie l'état d'un topic ou d'un message avant sa suppression
+func contentSnapshot(db store.Store, targetType string, targetID int) (label string, snapshot map[string]interface{}) {
+	var author, title, body string
+	var topicID int
+	var err error
+	switch targetType {
+	case AuditTargetTopic:
+		err = db.QueryRow(`
+			SELECT u.username, t.title, COALESCE(t.description, '')
+			FROM topic t JOIN user u ON t.user_id = u.user_id
+			WHERE t.topic_id = ?
+		`, targetID).Scan(&author, &title, &body)
+		topicID = targetID
+	case AuditTargetMessage:
+		err = db.QueryRow(`
+			SELECT u.username, t.title, m.content, m.topic_id
+			FROM message m
+			JOIN user u ON m.user_id = u.user_id
+			JOIN topic t ON m.topic_id = t.topic_id
+			WHERE m.message_id = ?
+		`, targetID).Scan(&author, &title, &body, &topicID)
+	case AuditTargetReply:
+		err = db.QueryRow(`
+			SELECT u.username, t.title, r.content, m.topic_id
+			FROM response r
+			JOIN user u ON r.user_id = u.user_id
+			JOIN message m ON r.message_id = m.message_id
+			JOIN topic t ON m.topic_id = t.topic_id
+			WHERE r.response_id = ?
+		`, targetID).Scan(&author, &title, &body, &topicID)
+	}
+	if err != nil {
+		log.Printf("Error fetching %s %d for audit: %v", targetType, targetID, err)
+		return "", nil
+	}
+	return title, map[string]interface{}{
+		"author":   author,
+		"topic_id": topicID,
+		"title":    title,
+		"content":  body,
+	}
+}
+
+// auditFilter contient les critères de recherche de la page d'audit
+type auditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Query      string
+	From       string
+	To         string
+	Offset     int
+}
+
+func parseAuditFilter(r *http.Request) auditFilter {
+	query := r.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	return auditFilter{
+		Actor:      strings.TrimSpace(query.Get("actor")),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   strings.TrimSpace(query.Get("target_id")),
+		Query:      strings.TrimSpace(query.Get("q")),
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+		Offset:     offset,
+	}
+}
+
+// where construit la clause WHERE correspondant aux critères
+func (f auditFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if f.Actor != "" {
+		conditions = append(conditions, "actor_username = ?")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, f.TargetType)
+	}
+	if id, err := strconv.Atoi(f.TargetID); err == nil {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, id)
+	}
+	if f.Query != "" {
+		like := "%" + f.Query + "%"
+		conditions = append(conditions, "(reason LIKE ? OR target_label LIKE ? OR before_value LIKE ? OR after_value LIKE ?)")
+		args = append(args, like, like, like, like)
+	}
+	if f.From != "" {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From)
+	}
+	// La date de fin est incluse : on s'arrête au début du jour suivant
+	if to, err := time.Parse("2006-01-02", f.To); err == nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, to.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// values renvoie les critères sous forme de paramètres d'URL, sans la pagination
+func (f auditFilter) values() url.Values {
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"actor": f.Actor, "action": f.Action, "target_type": f.TargetType,
+		"target_id": f.TargetID, "q": f.Query, "from": f.From, "to": f.To,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	return values
+}
+
+func fetchAuditEntries(db store.Store, filter auditFilter, limit int) ([]AuditEntry, error) {
+	where, args := filter.where()
+	args = append(args, limit, filter.Offset)
+	rows, err := db.Query(`
+		SELECT audit_id, COALESCE(actor_id, 0), COALESCE(actor_username, ''), action, target_type, target_id,
+		       COALESCE(target_label, ''), before_value, after_value, COALESCE(reason, ''), COALESCE(ip, ''), created_at
+		FROM audit_log`+where+`
+		ORDER BY audit_id DESC
+		LIMIT ? OFFSET ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after *string
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Actor, &entry.Action, &entry.TargetType, &entry.TargetID,
+			&entry.TargetLabel, &before, &after, &entry.Reason, &entry.IP, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if before != nil {
+			entry.Before = json.RawMessage(*before)
+		}
+		if after != nil {
+			entry.After = json.RawMessage(*after)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// AuditPageHandler affiche le journal d'audit avec recherche et pagination
+func AuditPageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		filter := parseAuditFilter(r)
+		// Une ligne de plus que la page indique s'il existe une page suivante
+		entries, err := fetchAuditEntries(db, filter, auditPageSize+1)
+		if err != nil {
+			log.Printf("Error fetching audit log: %v", err)
+			http.Error(w, "Error fetching audit log", http.StatusInternalServerError)
+			return
+		}
+		hasNext := len(entries) > auditPageSize
+		if hasNext {
+			entries = entries[:auditPageSize]
+		}
+
+		query := filter.values().Encode()
+		if query != "" {
+			query = "&" + query
+		}
+		data := struct {
+			Username   string
+			Entries    []AuditEntry
+			Filter     auditFilter
+			Actions    []string
+			Query      template.URL
+			PrevOffset int
+			NextOffset int
+			HasPrev    bool
+			HasNext    bool
+		}{
+			Username:   claims.Username,
+			Entries:    entries,
+			Filter:     filter,
+			Actions:    AuditActions,
+			Query:      template.URL(query),
+			PrevOffset: filter.Offset - auditPageSize,
+			NextOffset: filter.Offset + auditPageSize,
+			HasPrev:    filter.Offset > 0,
+			HasNext:    hasNext,
+		}
+		if data.PrevOffset < 0 {
+			data.PrevOffset = 0
+		}
+
+		tmpl, err := template.New("audit.html").Funcs(template.FuncMap{
+			"str": func(raw json.RawMessage) string { return string(raw) },
+		}).ParseFiles("templates/audit.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+	}
+}
+
+// AuditExportHandler exporte les entrées correspondant aux critères en CSV ou en JSON (format)
+func AuditExportHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := parseAuditFilter(r)
+		filter.Offset = 0
+		entries, err := fetchAuditEntries(db, filter, maxAuditExport)
+		if err != nil {
+			log.Printf("Error exporting audit log: %v", err)
+			http.Error(w, "Error exporting audit log", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			if entries == nil {
+				entries = []AuditEntry{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.json"`)
+			json.NewEncoder(w).Encode(entries)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		out := csv.NewWriter(w)
+		out.Write([]string{"id", "created_at", "actor_id", "actor", "action", "target_type", "target_id", "target_label", "before", "after", "reason", "ip"})
+		for _, entry := range entries {
+			out.Write([]string{
+				strconv.Itoa(entry.ID), entry.CreatedAt, strconv.Itoa(entry.ActorID), entry.Actor, entry.Action,
+				entry.TargetType, strconv.Itoa(entry.TargetID), entry.TargetLabel,
+				string(entry.Before), string(entry.After), entry.Reason, entry.IP,
+			})
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			log.Printf("Error writing audit export: %v", err)
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"text/template"
 	"time"
 
+	"forum/store"
+
 	"github.com/dgrijalva/jwt-go"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -50,7 +52,44 @@ func generateToken(userID int, username string) (string, error) {
 	return token.SignedString(jwtKey)
 }
 
-func LoginHandler(db *sql.DB) http.HandlerFunc {
+// currentClaims renvoie les informations de l'utilisateur connecté à partir
+// de l'en-tête Authorization (clients de l'API) ou, à défaut, du cookie
+func currentClaims(r *http.Request) (*Claims, error) {
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" || tokenString == r.Header.Get("Authorization") {
+		cookie, err := r.Cookie("token_form")
+		if err != nil {
+			return nil, err
+		}
+		tokenString = cookie.Value
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.NewValidationError("invalid token", jwt.ValidationErrorSignatureInvalid)
+	}
+	return claims, nil
+}
+
+// checkCredentials vérifie le couple identifiant/mot de passe et renvoie l'utilisateur
+func checkCredentials(db store.Store, username, password string) (int, string, error) {
+	account, err := db.Users().Account(username)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)); err != nil {
+		return 0, "", err
+	}
+	return account.ID, account.Username, nil
+}
+
+func LoginHandler(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -66,39 +105,42 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		fmt.Println(req)
-
-		var userID int
-		var username string
-		var hashedPassword string
-		err := db.QueryRow("SELECT user_id, username, password FROM user WHERE username = ?", req.Username).Scan(&userID, &username, &hashedPassword)
+		account, err := db.Users().Account(req.Username)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Nom d'utilisateur ou mot de passe incorrect"})
 			return
 		}
 
-		fmt.Println(userID)
-		fmt.Println(username)
-		fmt.Println(hashedPassword)
+		userID, username := account.ID, account.Username
 
-		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)); err != nil {
+		if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(req.Password)); err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Nom d'utilisateur ou mot de passe incorrect"})
 			return
 		}
 
+		// Un compte suspendu ou banni voit la raison de la sanction au lieu d'être connecté
+		sanction, err := ActiveSanction(db, userID)
+		if err != nil {
+			log.Printf("Error checking sanctions for user %d: %v", userID, err)
+			http.Error(w, "Erreur lors de la vérification du compte", http.StatusInternalServerError)
+			return
+		}
+		if sanction != nil {
+			renderBanned(w, sanctionPage(sanction))
+			return
+		}
+
 		token, err := generateToken(userID, username)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"message": "Erreur lors de la génération du token"})
 			return
 		}
-		fmt.Println(token)
 		// Mettre à jour la dernière connexion
-		_, err = db.Exec("UPDATE user SET last_connection = NOW() WHERE user_id = ?", userID)
-		if err != nil {
-			println("Error updating last connection:", err.Error())
+		if err := db.Users().TouchLastConnection(userID); err != nil {
+			log.Printf("Error updating last connection: %v", err)
 		}
 
 		http.SetCookie(w, &http.Cookie{
@@ -111,7 +153,16 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func RegisterHandler(db *sql.DB) http.HandlerFunc {
+// MinPasswordLength est la longueur minimale d'un mot de passe
+const MinPasswordLength = 12
+
+// HashPassword renvoie le hachage bcrypt enregistré dans user.password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func RegisterHandler(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -135,18 +186,37 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		if strings.EqualFold(req.Username, DeletedAccountName) {
+			displayError("Ce nom d'utilisateur est réservé")
+			return
+		}
+
 		if req.Password != req.ConfirmPassword {
 			displayError("Les mots de passe ne correspondent pas")
 			return
 		}
 
-		if len(req.Password) < 12 {
+		if len(req.Password) < MinPasswordLength {
 			displayError("Le mot de passe doit contenir au moins 12 caractères")
 			return
 		}
 
-		var exists bool
-		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM user WHERE username = ? OR mail = ?)", req.Username, req.Email).Scan(&exists)
+		ban, err := matchRegistrationBan(db, clientIP(r), req.Email)
+		if err != nil {
+			log.Printf("Error checking registration bans: %v", err)
+			displayError("Erreur lors de la vérification de l'utilisateur")
+			return
+		}
+		if ban != nil {
+			renderBanned(w, bannedPage{
+				Title:        "Inscription refusée",
+				Reason:       ban.Reason,
+				Registration: true,
+			})
+			return
+		}
+
+		exists, err := db.Users().Taken(req.Username, req.Email)
 		if err != nil {
 			displayError("Erreur lors de la vérification de l'utilisateur")
 			return
@@ -156,13 +226,18 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		hashedPassword, err := HashPassword(req.Password)
 		if err != nil {
 			displayError("Erreur lors du hachage du mot de passe")
 			return
 		}
 
-		_, err = db.Exec("INSERT INTO user (username, mail, password, role_id) VALUES (?, ?, ?, 1)", req.Username, req.Email, string(hashedPassword))
+		_, err = db.Users().Create(store.NewUser{
+			Username:     req.Username,
+			Mail:         req.Email,
+			PasswordHash: hashedPassword,
+			RoleID:       RoleUser,
+		})
 		if err != nil {
 			displayError("Erreur lors de la création de l'utilisateur")
 			return
@@ -172,10 +247,12 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// AuthMiddleware réserve le handler aux utilisateurs connectés dont le compte
+// n'est ni suspendu ni banni
+func AuthMiddleware(db store.Store, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString, tokenErr := r.Cookie("token_form")
-		if tokenString.Value == "" || tokenErr != nil {
+		if tokenErr != nil || tokenString.Value == "" {
 			http.Redirect(w, r, "/register", http.StatusSeeOther)
 			return
 		}
@@ -195,6 +272,21 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		exists, err := accountExists(db, claims.UserID)
+		if err != nil {
+			log.Printf("Error checking account %d: %v", claims.UserID, err)
+			http.Error(w, "Error checking account", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Redirect(w, r, "/register", http.StatusSeeOther)
+			return
+		}
+
+		if rejectSanctioned(db, w, claims.UserID) {
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	}
 }
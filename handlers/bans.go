@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/config"
+	"forum/store"
+)
+
+// Types d'interdiction d'inscription (table registration_ban)
+const (
+	BanIP          = "ip"
+	BanEmailDomain = "email_domain"
+)
+
+// MaxSanctionReason est la longueur maximale du motif d'une sanction
+const MaxSanctionReason = 255
+
+// RegistrationBan interdit les inscriptions depuis une adresse IP (ou une plage
+// CIDR) ou avec une adresse e-mail d'un domaine donné
+type RegistrationBan struct {
+	ID        int
+	Kind      string
+	Value     string
+	Reason    string
+	CreatedBy string
+	CreatedAt string
+}
+
+// bannedPage alimente la page d'explication affichée aux comptes sanctionnés
+type bannedPage struct {
+	Title        string
+	Reason       string
+	Until        string
+	Registration bool
+}
+
+func renderBanned(w http.ResponseWriter, page bannedPage) {
+	tmpl, err := template.ParseFiles("templates/banned.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusForbidden)
+	tmpl.Execute(w, page)
+}
+
+func sanctionPage(sanction *Sanction) bannedPage {
+	if sanction.Permanent() {
+		return bannedPage{Title: "Votre compte a été banni", Reason: sanction.Reason}
+	}
+	return bannedPage{Title: "Votre compte est suspendu", Reason: sanction.Reason, Until: sanction.ExpiresAt}
+}
+
+// rejectSanctioned affiche la page d'explication et efface la session si
+// l'utilisateur est suspendu ou banni ; elle renvoie true si la requête a été traitée
+func rejectSanctioned(db store.Store, w http.ResponseWriter, userID int) bool {
+	sanction, err := ActiveSanction(db, userID)
+	if err != nil {
+		log.Printf("Error checking sanctions for user %d: %v", userID, err)
+		http.Error(w, "Error checking account", http.StatusInternalServerError)
+		return true
+	}
+	if sanction == nil {
+		return false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    "token_form",
+		Value:   "",
+		Path:    "/",
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+	})
+	renderBanned(w, sanctionPage(sanction))
+	return true
+}
+
+// clientIP renvoie l'adresse du client. X-Forwarded-For n'est pris en compte que si
+// TRUST_PROXY_HEADERS donne le nombre de reverse proxies de confiance devant le forum
+// (1 si la valeur n'est pas un nombre) : chacun ajoute à droite l'adresse qu'il a
+// vue, l'entrée retenue est donc la N-ième en partant de la droite. Les entrées plus
+// à gauche viennent du client et ne sont jamais utilisées.
+func clientIP(r *http.Request) net.IP {
+	if trusted := config.Env("TRUST_PROXY_HEADERS", ""); trusted != "" {
+		hops, err := strconv.Atoi(trusted)
+		if err != nil || hops < 1 {
+			hops = 1
+		}
+		var entries []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			entries = append(entries, strings.Split(header, ",")...)
+		}
+		if len(entries) > 0 {
+			i := len(entries) - hops
+			if i < 0 {
+				i = 0
+			}
+			if ip := net.ParseIP(strings.TrimSpace(entries[i])); ip != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// emailDomain renvoie le domaine d'une adresse e-mail, en minuscules
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// banMatches indique si l'interdiction s'applique à l'adresse IP ou au domaine donnés ;
+// un domaine interdit couvre aussi ses sous-domaines
+func (b *RegistrationBan) banMatches(ip net.IP, domain string) bool {
+	switch b.Kind {
+	case BanIP:
+		if ip == nil {
+			return false
+		}
+		if _, network, err := net.ParseCIDR(b.Value); err == nil {
+			return network.Contains(ip)
+		}
+		banned := net.ParseIP(b.Value)
+		return banned != nil && banned.Equal(ip)
+	case BanEmailDomain:
+		return domain != "" && (domain == b.Value || strings.HasSuffix(domain, "."+b.Value))
+	}
+	return false
+}
+
+func fetchRegistrationBans(db store.Store) ([]*RegistrationBan, error) {
+	rows, err := db.Query(`
+		SELECT b.ban_id, b.kind, b.value, b.reason, COALESCE(u.username, ''), b.created_at
+		FROM registration_ban b
+		LEFT JOIN user u ON b.created_by = u.user_id
+		ORDER BY b.created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*RegistrationBan
+	for rows.Next() {
+		var ban RegistrationBan
+		if err := rows.Scan(&ban.ID, &ban.Kind, &ban.Value, &ban.Reason, &ban.CreatedBy, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, &ban)
+	}
+	return bans, rows.Err()
+}
+
+// matchRegistrationBan renvoie l'interdiction qui bloque l'inscription, ou nil
+func matchRegistrationBan(db store.Store, ip net.IP, email string) (*RegistrationBan, error) {
+	bans, err := fetchRegistrationBans(db)
+	if err != nil {
+		return nil, err
+	}
+	domain := emailDomain(email)
+	for _, ban := range bans {
+		if ban.banMatches(ip, domain) {
+			return ban, nil
+		}
+	}
+	return nil, nil
+}
+
+// normalizeBanValue valide la valeur saisie pour une interdiction d'inscription
+func normalizeBanValue(kind, value string) (string, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch kind {
+	case BanIP:
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			return network.String(), true
+		}
+		if ip := net.ParseIP(value); ip != nil {
+			return ip.String(), true
+		}
+	case BanEmailDomain:
+		value = strings.TrimPrefix(value, "@")
+		if strings.Contains(value, ".") && !strings.ContainsAny(value, " @/") {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func bansRedirect(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/moderation/bans"
+	if errMsg != "" {
+		target += "?error=" + url.QueryEscape(errMsg)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// BansPageHandler affiche les comptes sanctionnés et les interdictions d'inscription
+func BansPageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		sanctions, err := ActiveSanctions(db)
+		if err != nil {
+			log.Printf("Error fetching sanctions: %v", err)
+			http.Error(w, "Error fetching sanctions", http.StatusInternalServerError)
+			return
+		}
+		bans, err := fetchRegistrationBans(db)
+		if err != nil {
+			log.Printf("Error fetching registration bans: %v", err)
+			http.Error(w, "Error fetching bans", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Username         string
+			Sanctions        []*Sanction
+			RegistrationBans []*RegistrationBan
+			Target           string
+			Error            string
+		}{
+			Username:         claims.Username,
+			Sanctions:        sanctions,
+			RegistrationBans: bans,
+			Target:           r.URL.Query().Get("user"),
+			Error:            r.URL.Query().Get("error"),
+		}
+
+		tmpl, err := template.ParseFiles("templates/bans.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+	}
+}
+
+// SanctionUserHandler suspend ou bannit un compte (username, type, days, reason)
+func SanctionUserHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if reason == "" {
+			bansRedirect(w, r, "Indiquez le motif de la sanction")
+			return
+		}
+		if utf8.RuneCountInString(reason) > MaxSanctionReason {
+			bansRedirect(w, r, "Le motif est trop long")
+			return
+		}
+
+		target, err := db.Users().ProfileByName(strings.TrimSpace(r.FormValue("username")))
+		if err == store.ErrNotFound {
+			bansRedirect(w, r, "Utilisateur introuvable")
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching user: %v", err)
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			return
+		}
+
+		// Un modérateur ne peut sanctionner que des comptes de rang inférieur au sien
+		moderatorRole, err := UserRole(db, claims.UserID)
+		if err == nil {
+			var targetRole int
+			targetRole, err = UserRole(db, target.ID)
+			if err == nil && targetRole >= moderatorRole {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		if err != nil {
+			log.Printf("Error fetching roles: %v", err)
+			http.Error(w, "Error checking permissions", http.StatusInternalServerError)
+			return
+		}
+
+		event := AuditEvent{TargetType: AuditTargetUser, TargetID: target.ID, TargetLabel: target.Username, Reason: reason}
+		switch r.FormValue("type") {
+		case SanctionSuspension:
+			days, convErr := strconv.Atoi(r.FormValue("days"))
+			if convErr != nil || days < 1 || days > MaxSuspensionDays {
+				bansRedirect(w, r, "Durée de suspension invalide")
+				return
+			}
+			until := time.Now().AddDate(0, 0, days)
+			err = Suspend(db, claims.UserID, target.ID, reason, until)
+			event.Action = AuditUserSuspend
+			event.After = map[string]string{"type": SanctionSuspension, "expires_at": until.Format(sqlDateTime)}
+		case SanctionBan:
+			err = Ban(db, claims.UserID, target.ID, reason)
+			event.Action = AuditUserBan
+			event.After = map[string]string{"type": SanctionBan}
+		default:
+			http.Error(w, "Invalid sanction type", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error sanctioning user %d: %v", target.ID, err)
+			http.Error(w, "Error sanctioning user", http.StatusInternalServerError)
+			return
+		}
+		audit(db, r, event)
+
+		bansRedirect(w, r, "")
+	}
+}
+
+// LiftSanctionHandler lève les suspensions et bannissements d'un compte (user_id)
+func LiftSanctionHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := strconv.Atoi(r.FormValue("user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		sanction, err := ActiveSanction(db, userID)
+		if err != nil {
+			log.Printf("Error fetching sanction of user %d: %v", userID, err)
+			http.Error(w, "Error lifting sanction", http.StatusInternalServerError)
+			return
+		}
+		if sanction == nil {
+			bansRedirect(w, r, "")
+			return
+		}
+
+		if err := LiftSanctions(db, userID); err != nil {
+			log.Printf("Error lifting sanctions of user %d: %v", userID, err)
+			http.Error(w, "Error lifting sanction", http.StatusInternalServerError)
+			return
+		}
+		audit(db, r, AuditEvent{
+			Action:      AuditUserLift,
+			TargetType:  AuditTargetUser,
+			TargetID:    userID,
+			TargetLabel: sanction.Username,
+			Before:      map[string]string{"type": sanction.Type, "reason": sanction.Reason, "expires_at": sanction.ExpiresAt},
+			Reason:      strings.TrimSpace(r.FormValue("reason")),
+		})
+
+		bansRedirect(w, r, "")
+	}
+}
+
+// CreateRegistrationBanHandler interdit les inscriptions depuis une IP ou un domaine (kind, value, reason)
+func CreateRegistrationBanHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		kind := r.FormValue("kind")
+		value, ok := normalizeBanValue(kind, r.FormValue("value"))
+		if !ok {
+			bansRedirect(w, r, "Adresse IP, plage CIDR ou domaine invalide")
+			return
+		}
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if utf8.RuneCountInString(reason) > MaxSanctionReason {
+			bansRedirect(w, r, "Le motif est trop long")
+			return
+		}
+
+		// Un ban déjà existant garde son identifiant et prend le nouveau motif
+		d := db.Dialect()
+		_, err = db.Exec(`
+			INSERT INTO registration_ban (kind, value, reason, created_by)
+			VALUES (?, ?, ?, ?)
+			`+d.Upsert("kind", "value")+` reason = `+d.Inserted("reason"), kind, value, reason, claims.UserID)
+		var banID int
+		if err == nil {
+			err = db.QueryRow("SELECT ban_id FROM registration_ban WHERE kind = ? AND value = ?", kind, value).Scan(&banID)
+		}
+		if err != nil {
+			log.Printf("Error creating registration ban: %v", err)
+			http.Error(w, "Error creating ban", http.StatusInternalServerError)
+			return
+		}
+		audit(db, r, AuditEvent{
+			Action:      AuditRegistrationBan,
+			TargetType:  AuditTargetRegistrationBan,
+			TargetID:    banID,
+			TargetLabel: value,
+			After:       map[string]string{"kind": kind, "value": value},
+			Reason:      reason,
+		})
+
+		bansRedirect(w, r, "")
+	}
+}
+
+// DeleteRegistrationBanHandler supprime une interdiction d'inscription (id)
+func DeleteRegistrationBanHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		banID, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid ban ID", http.StatusBadRequest)
+			return
+		}
+		var kind, value, reason string
+		err = db.QueryRow("SELECT kind, value, COALESCE(reason, '') FROM registration_ban WHERE ban_id = ?", banID).Scan(&kind, &value, &reason)
+		if err == store.ErrNotFound {
+			bansRedirect(w, r, "")
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching registration ban: %v", err)
+			http.Error(w, "Error deleting ban", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := db.Exec("DELETE FROM registration_ban WHERE ban_id = ?", banID); err != nil {
+			log.Printf("Error deleting registration ban: %v", err)
+			http.Error(w, "Error deleting ban", http.StatusInternalServerError)
+			return
+		}
+		audit(db, r, AuditEvent{
+			Action:      AuditRegistrationUnban,
+			TargetType:  AuditTargetRegistrationBan,
+			TargetID:    banID,
+			TargetLabel: value,
+			Before:      map[string]string{"kind": kind, "value": value, "reason": reason},
+		})
+
+		bansRedirect(w, r, "")
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name, trusted string
+		forwarded     []string
+		want          string
+	}{
+		{name: "sans proxy de confiance", forwarded: []string{"1.2.3.4"}, want: "10.0.0.9"},
+		{name: "un proxy", trusted: "1", forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "entrée usurpée à gauche", trusted: "1", forwarded: []string{"1.2.3.4, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "en-têtes répétés", trusted: "1", forwarded: []string{"1.2.3.4", "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "deux proxies", trusted: "2", forwarded: []string{"1.2.3.4, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "valeur non numérique", trusted: "yes", forwarded: []string{"1.2.3.4, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "chaîne plus courte que les proxies", trusted: "3", forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "entrée invalide", trusted: "1", forwarded: []string{"1.2.3.4, inconnu"}, want: "10.0.0.9"},
+		{name: "sans en-tête", trusted: "1", want: "10.0.0.9"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY_HEADERS", c.trusted)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.9:51234"
+			for _, value := range c.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r).String(); got != c.want {
+				t.Errorf("clientIP = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	texttemplate "text/template"
+	"time"
+
+	"forum/config"
+	"forum/mailer"
+	"forum/store"
+)
+
+// Fréquences de résumé par e-mail
+const (
+	DigestNone   = "none"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+const (
+	digestTopicsPerCategory = 5
+	digestMaxReplies        = 50
+	digestExcerptLength     = 200
+)
+
+// excerpt tronque un texte pour l'aperçu dans les e-mails
+func excerpt(content string, length int) string {
+	runes := []rune(content)
+	if len(runes) <= length {
+		return content
+	}
+	return string(runes[:length]) + "…"
+}
+
+type digestTopic struct {
+	ID     int
+	Title  string
+	Author string
+	Likes  int
+	URL    string
+}
+
+type digestCategory struct {
+	Label  string
+	Topics []digestTopic
+}
+
+type digestReply struct {
+	ID      int
+	Author  string
+	Excerpt string
+}
+
+type digestThread struct {
+	Title   string
+	URL     string
+	Replies []digestReply
+}
+
+type digestMention struct {
+	ID    int
+	Actor string
+	Title string
+	URL   string
+}
+
+type digestRecipient struct {
+	UserID    int
+	Username  string
+	Email     string
+	Frequency string
+	Since     string
+}
+
+// DigestMailer compose et envoie les résumés quotidiens et hebdomadaires.
+// Chaque élément envoyé est noté dans digest_log pour ne jamais être répété.
+type DigestMailer struct {
+	db        store.Store
+	transport mailer.Transport
+
+	htmlTemplate *htmltemplate.Template
+	textTemplate *texttemplate.Template
+}
+
+func NewDigestMailer(db store.Store, transport mailer.Transport) (*DigestMailer, error) {
+	htmlTmpl, err := htmltemplate.ParseFiles("templates/email/digest.html")
+	if err != nil {
+		return nil, err
+	}
+	textTmpl, err := texttemplate.ParseFiles("templates/email/digest.txt")
+	if err != nil {
+		return nil, err
+	}
+	return &DigestMailer{db: db, transport: transport, htmlTemplate: htmlTmpl, textTemplate: textTmpl}, nil
+}
+
+// SendDue envoie les résumés des utilisateurs dont la période est écoulée.
+// Elle est appelée périodiquement par le planificateur.
+func (d *DigestMailer) SendDue() {
+	now := time.Now()
+	dayAgo := now.AddDate(0, 0, -1).Format(sqlDateTime)
+	weekAgo := now.AddDate(0, 0, -7).Format(sqlDateTime)
+	rows, err := d.db.Query(`
+		SELECT u.user_id, u.username, u.mail, p.frequency,
+			COALESCE(p.last_sent_at, CASE p.frequency WHEN ? THEN ? ELSE ? END)
+		FROM digest_preference p
+		JOIN user u ON p.user_id = u.user_id
+		WHERE (p.frequency = ? AND (p.last_sent_at IS NULL OR p.last_sent_at <= ?))
+			OR (p.frequency = ? AND (p.last_sent_at IS NULL OR p.last_sent_at <= ?))
+	`, DigestWeekly, weekAgo, dayAgo, DigestDaily, dayAgo, DigestWeekly, weekAgo)
+	if err != nil {
+		log.Printf("Error fetching digest recipients: %v", err)
+		return
+	}
+
+	var recipients []digestRecipient
+	for rows.Next() {
+		var r digestRecipient
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Email, &r.Frequency, &r.Since); err != nil {
+			log.Printf("Error scanning digest recipients: %v", err)
+			rows.Close()
+			return
+		}
+		recipients = append(recipients, r)
+	}
+	rows.Close()
+
+	for _, r := range recipients {
+		if err := d.send(r); err != nil {
+			log.Printf("Error sending digest to user %d: %v", r.UserID, err)
+		}
+	}
+}
+
+func (d *DigestMailer) send(r digestRecipient) error {
+	categories, err := d.topCategoryTopics(r)
+	if err != nil {
+		return err
+	}
+	threads, err := d.subscribedReplies(r)
+	if err != nil {
+		return err
+	}
+	mentions, err := d.mentions(r)
+	if err != nil {
+		return err
+	}
+
+	if len(categories) > 0 || len(threads) > 0 || len(mentions) > 0 {
+		data := struct {
+			Username    string
+			Frequency   string
+			Categories  []digestCategory
+			Threads     []digestThread
+			Mentions    []digestMention
+			SettingsURL string
+		}{
+			Username:    r.Username,
+			Frequency:   r.Frequency,
+			Categories:  categories,
+			Threads:     threads,
+			Mentions:    mentions,
+			SettingsURL: config.BaseURL() + "/digest",
+		}
+
+		var htmlBody, textBody bytes.Buffer
+		if err := d.htmlTemplate.Execute(&htmlBody, data); err != nil {
+			return err
+		}
+		if err := d.textTemplate.Execute(&textBody, data); err != nil {
+			return err
+		}
+
+		subject := "Votre résumé quotidien ForumForAll"
+		if r.Frequency == DigestWeekly {
+			subject = "Votre résumé hebdomadaire ForumForAll"
+		}
+		err := d.transport.Send(mailer.Message{
+			To:      r.Email,
+			Subject: subject,
+			Text:    textBody.String(),
+			HTML:    htmlBody.String(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := d.record(r.UserID, categories, threads, mentions); err != nil {
+			return err
+		}
+	}
+
+	_, err = d.db.Exec("UPDATE digest_preference SET last_sent_at = NOW() WHERE user_id = ?", r.UserID)
+	return err
+}
+
+// topCategoryTopics renvoie les nouveaux topics les plus likés de chaque catégorie suivie
+func (d *DigestMailer) topCategoryTopics(r digestRecipient) ([]digestCategory, error) {
+	followed, err := followedCategories(d.db, r.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	themes, err := d.db.Categories().List()
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []digestCategory
+	seen := make(map[int]bool)
+	for _, theme := range themes {
+		if !followed[theme.Slug] {
+			continue
+		}
+
+		condition, args := store.TagCondition("t.tags", theme.Slug)
+		query := `
+			SELECT t.topic_id, t.title, u.username,
+				(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = t.topic_id AND liked = TRUE) AS likes
+			FROM topic t
+			JOIN user u ON t.user_id = u.user_id
+			WHERE t.created_at > ? AND t.user_id <> ? AND t.status = 'published' AND ` + condition + `
+				AND NOT EXISTS(SELECT 1 FROM digest_log g WHERE g.user_id = ? AND g.item_type = 'topic' AND g.item_id = t.topic_id)
+			ORDER BY likes DESC, t.created_at DESC
+			LIMIT ?`
+		params := append([]interface{}{r.Since, r.UserID}, args...)
+		params = append(params, r.UserID, digestTopicsPerCategory)
+
+		rows, err := d.db.Query(query, params...)
+		if err != nil {
+			return nil, err
+		}
+		category := digestCategory{Label: theme.Label}
+		for rows.Next() {
+			var t digestTopic
+			if err := rows.Scan(&t.ID, &t.Title, &t.Author, &t.Likes); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			// Un topic à plusieurs tags n'apparaît qu'une fois
+			if seen[t.ID] {
+				continue
+			}
+			seen[t.ID] = true
+			t.URL = fmt.Sprintf("%s/topic?id=%d", config.BaseURL(), t.ID)
+			category.Topics = append(category.Topics, t)
+		}
+		rows.Close()
+
+		if len(category.Topics) > 0 {
+			categories = append(categories, category)
+		}
+	}
+	return categories, nil
+}
+
+// subscribedReplies renvoie les nouveaux messages des topics suivis, groupés par topic
+func (d *DigestMailer) subscribedReplies(r digestRecipient) ([]digestThread, error) {
+	rows, err := d.db.Query(`
+		SELECT m.message_id, m.topic_id, t.title, a.username, m.content
+		FROM message m
+		JOIN topic_subscription s ON s.topic_id = m.topic_id AND s.user_id = ? AND s.mode <> ?
+		JOIN topic t ON m.topic_id = t.topic_id
+		JOIN user a ON m.user_id = a.user_id
+		WHERE m.created_at > ? AND m.user_id <> ? AND m.status = 'published'
+			AND NOT EXISTS(SELECT 1 FROM digest_log g WHERE g.user_id = ? AND g.item_type = 'message' AND g.item_id = m.message_id)
+		ORDER BY m.topic_id, m.created_at
+		LIMIT ?
+	`, r.UserID, SubscriptionNone, r.Since, r.UserID, r.UserID, digestMaxReplies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []digestThread
+	lastTopic := 0
+	for rows.Next() {
+		var reply digestReply
+		var topicID int
+		var title, content string
+		if err := rows.Scan(&reply.ID, &topicID, &title, &reply.Author, &content); err != nil {
+			return nil, err
+		}
+		reply.Excerpt = excerpt(content, digestExcerptLength)
+
+		if topicID != lastTopic {
+			threads = append(threads, digestThread{
+				Title: title,
+				URL:   fmt.Sprintf("%s/topic?id=%d", config.BaseURL(), topicID),
+			})
+			lastTopic = topicID
+		}
+		threads[len(threads)-1].Replies = append(threads[len(threads)-1].Replies, reply)
+	}
+	return threads, rows.Err()
+}
+
+// mentions renvoie les mentions reçues depuis le dernier résumé
+func (d *DigestMailer) mentions(r digestRecipient) ([]digestMention, error) {
+	rows, err := d.db.Query(`
+		SELECT n.notification_id, COALESCE(a.username, ''), COALESCE(t.title, ''), COALESCE(n.topic_id, 0)
+		FROM notification n
+		LEFT JOIN user a ON n.actor_id = a.user_id
+		LEFT JOIN topic t ON n.topic_id = t.topic_id
+		WHERE n.user_id = ? AND n.type = ? AND n.created_at > ?
+			AND NOT EXISTS(SELECT 1 FROM digest_log g WHERE g.user_id = ? AND g.item_type = 'mention' AND g.item_id = n.notification_id)
+		ORDER BY n.created_at
+	`, r.UserID, NotificationMention, r.Since, r.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []digestMention
+	for rows.Next() {
+		var m digestMention
+		var topicID int
+		if err := rows.Scan(&m.ID, &m.Actor, &m.Title, &topicID); err != nil {
+			return nil, err
+		}
+		m.URL = fmt.Sprintf("%s/topic?id=%d", config.BaseURL(), topicID)
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
+
+// record note les éléments envoyés pour ne pas les répéter dans un prochain résumé
+func (d *DigestMailer) record(userID int, categories []digestCategory, threads []digestThread, mentions []digestMention) error {
+	insert := func(itemType string, itemID int) error {
+		_, err := d.db.Exec(d.db.Dialect().InsertIgnore()+" INTO digest_log (user_id, item_type, item_id) VALUES (?, ?, ?)", userID, itemType, itemID)
+		return err
+	}
+
+	for _, c := range categories {
+		for _, t := range c.Topics {
+			if err := insert("topic", t.ID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range threads {
+		for _, reply := range t.Replies {
+			if err := insert("message", reply.ID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, m := range mentions {
+		if err := insert("mention", m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func followedCategories(db store.Store, userID int) (map[string]bool, error) {
+	rows, err := db.Query("SELECT tag FROM category_follow WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	followed := make(map[string]bool)
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		followed[tag] = true
+	}
+	return followed, rows.Err()
+}
+
+// DigestSettingsHandler affiche (GET) ou enregistre (POST) la fréquence du résumé
+// et les catégories suivies
+func DigestSettingsHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			frequency := DigestNone
+			err := db.QueryRow("SELECT frequency FROM digest_preference WHERE user_id = ?", claims.UserID).Scan(&frequency)
+			if err != nil && err != store.ErrNotFound {
+				log.Printf("Error fetching digest preference: %v", err)
+				http.Error(w, "Error fetching preferences", http.StatusInternalServerError)
+				return
+			}
+
+			followed, err := followedCategories(db, claims.UserID)
+			if err != nil {
+				log.Printf("Error fetching followed categories: %v", err)
+				http.Error(w, "Error fetching preferences", http.StatusInternalServerError)
+				return
+			}
+			themes, err := db.Categories().List()
+			if err != nil {
+				log.Printf("Error fetching categories: %v", err)
+				http.Error(w, "Error fetching preferences", http.StatusInternalServerError)
+				return
+			}
+
+			type category struct {
+				ID       string
+				Label    string
+				Followed bool
+			}
+			categories := make([]category, len(themes))
+			for i, theme := range themes {
+				categories[i] = category{ID: theme.Slug, Label: theme.Label, Followed: followed[theme.Slug]}
+			}
+
+			data := struct {
+				Frequency  string
+				Categories []category
+				Username   string
+			}{
+				Frequency:  frequency,
+				Categories: categories,
+				Username:   claims.Username,
+			}
+
+			tmpl, err := htmltemplate.ParseFiles("templates/digest.html")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			tmpl.Execute(w, data)
+
+		case http.MethodPost:
+			frequency := r.FormValue("frequency")
+			if frequency != DigestNone && frequency != DigestDaily && frequency != DigestWeekly {
+				http.Error(w, "Invalid frequency", http.StatusBadRequest)
+				return
+			}
+
+			themes, err := db.Categories().List()
+			if err != nil {
+				log.Printf("Error fetching categories: %v", err)
+				http.Error(w, "Error updating preferences", http.StatusInternalServerError)
+				return
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				log.Printf("Error starting transaction: %v", err)
+				http.Error(w, "Error updating preferences", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+
+			d := db.Dialect()
+			_, err = tx.Exec(`
+				INSERT INTO digest_preference (user_id, frequency)
+				VALUES (?, ?)
+				`+d.Upsert("user_id")+` frequency = `+d.Inserted("frequency"), claims.UserID, frequency)
+			if err == nil {
+				_, err = tx.Exec("DELETE FROM category_follow WHERE user_id = ?", claims.UserID)
+			}
+			for _, tag := range r.Form["categories"] {
+				if err != nil {
+					break
+				}
+				valid := false
+				for _, theme := range themes {
+					valid = valid || theme.Slug == tag
+				}
+				if valid {
+					_, err = tx.Exec("INSERT INTO category_follow (user_id, tag) VALUES (?, ?)", claims.UserID, tag)
+				}
+			}
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				log.Printf("Error updating digest preferences: %v", err)
+				http.Error(w, "Error updating preferences", http.StatusInternalServerError)
+				return
+			}
+
+			http.Redirect(w, r, "/digest", http.StatusSeeOther)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
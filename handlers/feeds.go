@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"forum/config"
+	"forum/store"
+)
+
+// Formats de flux disponibles
+const (
+	FeedAtom = "atom"
+	FeedRSS  = "rss"
+)
+
+const (
+	feedItemLimit = 50
+	// Format des DATETIME renvoyés par MySQL
+	sqlDateTime = "2006-01-02 15:04:05"
+)
+
+type feedItem struct {
+	ID        string
+	Title     string
+	Link      string
+	Author    string
+	Content   string
+	Published time.Time
+}
+
+type feed struct {
+	Title string
+	Link  string
+	Self  string
+	Items []feedItem
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    string      `xml:"author>name"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Author      string `xml:"dc:creator"`
+	Description string `xml:"description"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	DC      string   `xml:"xmlns:dc,attr"`
+	Channel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+func parseSQLTime(value string) time.Time {
+	t, err := time.ParseInLocation(sqlDateTime, value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// updated renvoie la date de l'élément le plus récent du flux
+func (f *feed) updated() time.Time {
+	var latest time.Time
+	for _, item := range f.Items {
+		if item.Published.After(latest) {
+			latest = item.Published
+		}
+	}
+	if latest.IsZero() {
+		latest = time.Unix(0, 0)
+	}
+	return latest.UTC().Truncate(time.Second)
+}
+
+func (f *feed) marshal(format string) ([]byte, string, error) {
+	var doc interface{}
+	contentType := "application/atom+xml; charset=utf-8"
+
+	if format == FeedRSS {
+		contentType = "application/rss+xml; charset=utf-8"
+		rss := rssFeed{Version: "2.0", DC: "http://purl.org/dc/elements/1.1/"}
+		rss.Channel.Title = f.Title
+		rss.Channel.Link = f.Link
+		rss.Channel.Description = f.Title
+		rss.Channel.LastBuildDate = f.updated().Format(time.RFC1123Z)
+		for _, item := range f.Items {
+			rss.Channel.Items = append(rss.Channel.Items, rssItem{
+				Title:       item.Title,
+				Link:        item.Link,
+				GUID:        item.ID,
+				PubDate:     item.Published.Format(time.RFC1123Z),
+				Author:      item.Author,
+				Description: item.Content,
+			})
+		}
+		doc = rss
+	} else {
+		atom := atomFeed{
+			Title:   f.Title,
+			ID:      f.Self,
+			Updated: f.updated().Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: f.Link, Rel: "alternate", Type: "text/html"},
+				{Href: f.Self, Rel: "self"},
+			},
+		}
+		for _, item := range f.Items {
+			published := item.Published.Format(time.RFC3339)
+			atom.Entries = append(atom.Entries, atomEntry{
+				Title:     item.Title,
+				ID:        item.ID,
+				Link:      atomLink{Href: item.Link, Rel: "alternate"},
+				Published: published,
+				Updated:   published,
+				Author:    item.Author,
+				Content:   atomContent{Type: "html", Body: item.Content},
+			})
+		}
+		doc = atom
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(doc); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+// serveFeed écrit le flux en gérant les requêtes conditionnelles
+// (If-None-Match / If-Modified-Since)
+func serveFeed(w http.ResponseWriter, r *http.Request, f *feed, format string) {
+	body, contentType, err := f.marshal(format)
+	if err != nil {
+		log.Printf("Error encoding feed: %v", err)
+		http.Error(w, "Error encoding feed", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	lastModified := f.updated()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+func feedURL(path string, query url.Values) string {
+	if encoded := query.Encode(); encoded != "" {
+		return config.BaseURL() + path + "?" + encoded
+	}
+	return config.BaseURL() + path
+}
+
+// TopicsFeedHandler publie les derniers topics, avec les mêmes filtres
+// (tags) et tris (sort) que la page d'accueil
+func TopicsFeedHandler(db store.Store, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sortBy := r.URL.Query().Get("sort")
+		selectedTag := r.URL.Query().Get("tags")
+
+		// Viewer anonyme : uniquement les topics publiés
+		topics, err := db.Topics().List(store.TopicFilter{Tag: selectedTag, Sort: sortBy, Limit: feedItemLimit})
+		if err != nil {
+			log.Printf("Error fetching topics feed: %v", err)
+			http.Error(w, "Error fetching topics", http.StatusInternalServerError)
+			return
+		}
+
+		params := url.Values{}
+		if selectedTag != "" {
+			params.Set("tags", selectedTag)
+		}
+		if sortBy != "" {
+			params.Set("sort", sortBy)
+		}
+
+		f := &feed{
+			Title: "ForumForAll - Derniers sujets",
+			Link:  feedURL("/index", params),
+			Self:  feedURL("/feeds/topics."+format, params),
+		}
+		for _, topic := range topics {
+			link := fmt.Sprintf("%s/topic?id=%d", config.BaseURL(), topic.ID)
+			f.Items = append(f.Items, feedItem{
+				ID:        link,
+				Title:     topic.Title,
+				Link:      link,
+				Author:    topic.Username,
+				Content:   template.HTMLEscapeString(topic.Description),
+				Published: parseSQLTime(topic.CreatedAt),
+			})
+		}
+
+		serveFeed(w, r, f, format)
+	}
+}
+
+// TopicFeedHandler publie les messages d'un topic (?id=N)
+func TopicFeedHandler(db store.Store, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		topicID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+			return
+		}
+
+		topic, err := db.Topics().Get(topicID, store.Viewer{})
+		if err == store.ErrNotFound {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching topic feed: %v", err)
+			http.Error(w, "Error fetching topic", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT m.message_id, m.content, m.content_html, m.created_at, u.username
+			FROM message m
+			JOIN user u ON m.user_id = u.user_id
+			WHERE m.topic_id = ? AND m.status = ?
+			ORDER BY m.created_at DESC
+			LIMIT ?
+		`, topicID, ContentPublished, feedItemLimit)
+		if err != nil {
+			log.Printf("Error fetching topic feed: %v", err)
+			http.Error(w, "Error fetching messages", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		params := url.Values{"id": {strconv.Itoa(topicID)}}
+		topicURL := feedURL("/topic", params)
+		f := &feed{
+			Title: "ForumForAll - " + topic.Title,
+			Link:  topicURL,
+			Self:  feedURL("/feeds/topic."+format, params),
+		}
+		for rows.Next() {
+			var id int
+			var content, createdAt, author string
+			var contentHTML *string
+			if err := rows.Scan(&id, &content, &contentHTML, &createdAt, &author); err != nil {
+				log.Printf("Error scanning topic feed: %v", err)
+				http.Error(w, "Error fetching messages", http.StatusInternalServerError)
+				return
+			}
+			html := string(RenderMarkdown(content))
+			if contentHTML != nil {
+				html = *contentHTML
+			}
+			f.Items = append(f.Items, feedItem{
+				ID:        fmt.Sprintf("%s#message-%d", topicURL, id),
+				Title:     fmt.Sprintf("Réponse de %s", author),
+				Link:      fmt.Sprintf("%s#message-%d", topicURL, id),
+				Author:    author,
+				Content:   html,
+				Published: parseSQLTime(createdAt),
+			})
+		}
+
+		serveFeed(w, r, f, format)
+	}
+}
+
+// UserFeedHandler publie les topics et messages publics d'un utilisateur (?name=...)
+func UserFeedHandler(db store.Store, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		profile, err := db.Users().ProfileByName(name)
+		if err == store.ErrNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching user feed: %v", err)
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			return
+		}
+
+		// Uniquement les contenus publics : topics et messages, jamais les messages privés
+		rows, err := db.Query(`
+			SELECT * FROM (SELECT 'topic' AS kind, t.topic_id AS id, t.topic_id, t.title, COALESCE(t.description, '') AS content,
+					NULL AS content_html, t.created_at
+				FROM topic t WHERE t.user_id = ? AND t.status = ?
+				ORDER BY t.created_at DESC LIMIT ?) AS topics
+			UNION ALL
+			SELECT * FROM (SELECT 'message' AS kind, m.message_id AS id, m.topic_id, t.title, m.content,
+					m.content_html, m.created_at
+				FROM message m JOIN topic t ON m.topic_id = t.topic_id
+				WHERE m.user_id = ? AND m.status = ? AND t.status = ?
+				ORDER BY m.created_at DESC LIMIT ?) AS messages
+		`, profile.ID, ContentPublished, feedItemLimit, profile.ID, ContentPublished, ContentPublished, feedItemLimit)
+		if err != nil {
+			log.Printf("Error fetching user feed: %v", err)
+			http.Error(w, "Error fetching posts", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		params := url.Values{"name": {profile.Username}}
+		f := &feed{
+			Title: "ForumForAll - Publications de " + profile.Username,
+			Link:  feedURL("/user", params),
+			Self:  feedURL("/feeds/user."+format, params),
+		}
+		for rows.Next() {
+			var kind, title, content, createdAt string
+			var contentHTML *string
+			var id, topicID int
+			if err := rows.Scan(&kind, &id, &topicID, &title, &content, &contentHTML, &createdAt); err != nil {
+				log.Printf("Error scanning user feed: %v", err)
+				http.Error(w, "Error fetching posts", http.StatusInternalServerError)
+				return
+			}
+
+			topicURL := fmt.Sprintf("%s/topic?id=%d", config.BaseURL(), topicID)
+			item := feedItem{
+				ID:        topicURL,
+				Title:     title,
+				Link:      topicURL,
+				Author:    profile.Username,
+				Content:   template.HTMLEscapeString(content),
+				Published: parseSQLTime(createdAt),
+			}
+			if kind == "message" {
+				item.ID = fmt.Sprintf("%s#message-%d", topicURL, id)
+				item.Link = item.ID
+				item.Title = "Re: " + title
+				item.Content = string(RenderMarkdown(content))
+				if contentHTML != nil {
+					item.Content = *contentHTML
+				}
+			}
+			f.Items = append(f.Items, item)
+		}
+
+		sort.Slice(f.Items, func(i, j int) bool {
+			return f.Items[i].Published.After(f.Items[j].Published)
+		})
+		if len(f.Items) > feedItemLimit {
+			f.Items = f.Items[:feedItemLimit]
+		}
+
+		serveFeed(w, r, f, format)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestVoteTopicVisibility vérifie que les votes du site ne portent que sur des
+// topics visibles du votant et que seul un topic publié prévient son auteur
+func TestVoteTopicVisibility(t *testing.T) {
+	api := newTestAPI(t)
+	newbieID, newbieToken := api.createUser(t, "newbie", RoleUser)
+	_, memberToken := api.createUser(t, "member", RoleUser)
+
+	// Le topic de l'administrateur est publié, celui d'un nouveau compte est retenu
+	if resp, data := api.do(t, http.MethodPost, "/topics", api.token, `{"title":"Publié"}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create topic: %d %s", resp.StatusCode, data)
+	}
+	if resp, data := api.do(t, http.MethodPost, "/topics", newbieToken, `{"title":"En attente"}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create topic: %d %s", resp.StatusCode, data)
+	}
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		token   string
+		topicID int
+		status  int
+	}{
+		{"like d'un topic publié", LikeTopicHandler(api.db), memberToken, 1, http.StatusSeeOther},
+		{"dislike d'un topic publié", DislikeTopicHandler(api.db), memberToken, 1, http.StatusSeeOther},
+		{"like d'un topic en attente d'un autre", LikeTopicHandler(api.db), memberToken, 2, http.StatusNotFound},
+		{"dislike d'un topic en attente d'un autre", DislikeTopicHandler(api.db), memberToken, 2, http.StatusNotFound},
+		{"like d'un topic inexistant", LikeTopicHandler(api.db), memberToken, 999, http.StatusNotFound},
+		{"like de son topic en attente", LikeTopicHandler(api.db), newbieToken, 2, http.StatusSeeOther},
+		{"like d'un topic en attente par un modérateur", LikeTopicHandler(api.db), api.token, 2, http.StatusSeeOther},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			form := url.Values{"id": {strconv.Itoa(c.topicID)}}
+			r := httptest.NewRequest(http.MethodPost, "/api/topic/like", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(&http.Cookie{Name: "token_form", Value: c.token})
+			w := httptest.NewRecorder()
+			c.handler(w, r)
+			if w.Code != c.status {
+				t.Errorf("status %d, want %d: %s", w.Code, c.status, w.Body)
+			}
+		})
+	}
+
+	var notified int
+	err := api.db.QueryRow("SELECT COUNT(*) FROM notification WHERE user_id = ? AND type = ?", newbieID, NotificationLike).Scan(&notified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 0 {
+		t.Errorf("%d like notifications for a pending topic, want 0", notified)
+	}
+}
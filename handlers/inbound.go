@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"forum/config"
+	"forum/store"
+)
+
+// Taille maximale d'un e-mail entrant traité
+const maxInboundMailSize = 1 << 20
+
+var (
+	replyAddressPattern = regexp.MustCompile(`^reply\+(\d+)\.(\d+)\.([0-9a-f]{64})@`)
+
+	// Lignes qui introduisent la citation du message d'origine
+	quoteHeaderPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^On .+ wrote:\s*$`),
+		regexp.MustCompile(`^Le .+ a écrit\s?:\s*$`),
+		regexp.MustCompile(`^-+\s*(Original Message|Message d'origine)\s*-+\s*$`),
+		regexp.MustCompile(`^(From|De)\s?: .+`),
+		regexp.MustCompile(`^_{10,}\s*$`),
+	}
+	signatureSeparator = regexp.MustCompile(`^-- ?$`)
+	mobileSignature    = regexp.MustCompile(`^(Sent from my|Envoyé de mon) .+$`)
+)
+
+// ReplyDomain est le domaine des adresses de réponse ; vide, la réponse par e-mail est
+// désactivée. Elle l'est aussi sans MAIL_SECRET dédié, qui signe les adresses.
+func ReplyDomain() string {
+	if !MailSecretConfigured() {
+		return ""
+	}
+	return config.Env("REPLY_DOMAIN", "")
+}
+
+func replySignature(topicID, userID int) string {
+	mac := hmac.New(sha256.New, mailSecret)
+	fmt.Fprintf(mac, "reply:%d:%d", topicID, userID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReplyAddress renvoie l'adresse signée à laquelle l'utilisateur peut répondre
+// pour poster dans le topic, ou "" si la réponse par e-mail est désactivée
+func ReplyAddress(userID, topicID int) string {
+	domain := ReplyDomain()
+	if domain == "" {
+		return ""
+	}
+	return fmt.Sprintf("reply+%d.%d.%s@%s", topicID, userID, replySignature(topicID, userID), domain)
+}
+
+// parseReplyAddress vérifie la signature d'une adresse de réponse
+func parseReplyAddress(address string) (topicID, userID int, ok bool) {
+	if !MailSecretConfigured() {
+		return 0, 0, false
+	}
+	match := replyAddressPattern.FindStringSubmatch(strings.ToLower(address))
+	if match == nil {
+		return 0, 0, false
+	}
+	topicID, _ = strconv.Atoi(match[1])
+	userID, _ = strconv.Atoi(match[2])
+	if !hmac.Equal([]byte(match[3]), []byte(replySignature(topicID, userID))) {
+		return 0, 0, false
+	}
+	return topicID, userID, true
+}
+
+// MaildirPoller lit les réponses déposées dans un Maildir (new/) et les publie
+// comme messages. Chaque e-mail est déplacé dans cur/ avant d'être traité, puis
+// marqué lu (S) s'il a été publié ou supprimé (T) s'il a été rejeté.
+type MaildirPoller struct {
+	db  store.Store
+	dir string
+}
+
+// NewMaildirPoller refuse de créer le lecteur sans MAIL_SECRET dédié : les adresses
+// de réponse seraient signées avec une clé connue et n'importe qui pourrait poster
+// au nom d'un autre
+func NewMaildirPoller(db store.Store, dir string) (*MaildirPoller, error) {
+	if !MailSecretConfigured() {
+		return nil, errors.New("reply by email needs MAIL_SECRET, distinct from the JWT secret")
+	}
+	return &MaildirPoller{db: db, dir: dir}, nil
+}
+
+// Poll traite les e-mails en attente ; elle est appelée par le planificateur
+func (p *MaildirPoller) Poll() {
+	entries, err := os.ReadDir(filepath.Join(p.dir, "new"))
+	if err != nil {
+		log.Printf("Error reading maildir: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		// L'e-mail est réservé en le déplaçant dans cur/ avant d'être publié : une autre
+		// instance, ou le prochain passage si le déplacement échoue, ne le reprend pas
+		claimed := filepath.Join(p.dir, "cur", entry.Name()+":2,")
+		if err := os.Rename(filepath.Join(p.dir, "new", entry.Name()), claimed); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Error claiming inbound mail %s: %v", entry.Name(), err)
+			}
+			continue
+		}
+
+		flag := "S"
+		if err := p.process(claimed); err != nil {
+			log.Printf("Rejected inbound mail %s: %v", entry.Name(), err)
+			flag = "T"
+		}
+		if err := os.Rename(claimed, claimed+flag); err != nil {
+			log.Printf("Error flagging inbound mail %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+func (p *MaildirPoller) process(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	msg, err := mail.ReadMessage(bufio.NewReader(io.LimitReader(file, maxInboundMailSize)))
+	if err != nil {
+		return err
+	}
+
+	if isAutomatedMail(msg.Header) {
+		return errors.New("bounce or automatic reply")
+	}
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	topicID, userID, ok := findReplyAddress(msg.Header)
+	if !ok {
+		return errors.New("no valid reply address")
+	}
+
+	// L'expéditeur doit être le propriétaire de l'adresse signée
+	email, err := p.db.Users().Mail(userID)
+	if err == store.ErrNotFound || (err == nil && !strings.EqualFold(email, from.Address)) {
+		return fmt.Errorf("unknown sender %s", from.Address)
+	}
+	if err != nil {
+		return err
+	}
+
+	body, err := plainTextBody(msg)
+	if err != nil {
+		return err
+	}
+	content := stripQuotedReply(body)
+	if content == "" {
+		return errors.New("empty reply")
+	}
+
+	_, err = p.db.Topics().Get(topicID, store.Viewer{Moderator: true})
+	if err == store.ErrNotFound {
+		return fmt.Errorf("topic %d not found", topicID)
+	}
+	if err != nil {
+		return err
+	}
+
+	submission, err := PostMessage(p.db, userID, topicID, content)
+	if err != nil {
+		return err
+	}
+	if submission.Pending {
+		log.Printf("Held message %d from email reply by user %d for review", submission.ID, userID)
+		return nil
+	}
+	log.Printf("Posted message %d from email reply by user %d", submission.ID, userID)
+	return nil
+}
+
+// isAutomatedMail reconnaît les rapports de non-remise et les réponses automatiques
+func isAutomatedMail(header mail.Header) bool {
+	if strings.TrimSpace(header.Get("Return-Path")) == "<>" {
+		return true
+	}
+	if auto := strings.ToLower(header.Get("Auto-Submitted")); auto != "" && auto != "no" {
+		return true
+	}
+	if header.Get("X-Autoreply") != "" || header.Get("X-Autorespond") != "" {
+		return true
+	}
+	switch strings.ToLower(header.Get("Precedence")) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "multipart/report" {
+		return true
+	}
+
+	from := strings.ToLower(header.Get("From"))
+	return strings.Contains(from, "mailer-daemon") || strings.Contains(from, "postmaster@")
+}
+
+func findReplyAddress(header mail.Header) (topicID, userID int, ok bool) {
+	for _, field := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		addresses, err := header.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if topicID, userID, ok := parseReplyAddress(address.Address); ok {
+				return topicID, userID, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// plainTextBody extrait la partie text/plain d'un e-mail, décodée
+func plainTextBody(msg *mail.Message) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	return textPart(mediaType, params, msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+}
+
+func textPart(mediaType string, params map[string]string, encoding string, body io.Reader) (string, error) {
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", errors.New("no text/plain part")
+			}
+			if err != nil {
+				return "", err
+			}
+			partType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if err != nil {
+				partType = "text/plain"
+			}
+			if partType != "text/plain" && !strings.HasPrefix(partType, "multipart/") {
+				continue
+			}
+			text, err := textPart(partType, partParams, part.Header.Get("Content-Transfer-Encoding"), part)
+			if err == nil {
+				return text, nil
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("unsupported content type %s", mediaType)
+	}
+	if charset := strings.ToLower(params["charset"]); charset != "" && charset != "utf-8" && charset != "us-ascii" {
+		return "", fmt.Errorf("unsupported charset %s", charset)
+	}
+
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := io.ReadAll(body)
+	return string(data), err
+}
+
+// stripQuotedReply ne garde que le texte écrit par l'utilisateur : tout ce qui suit
+// l'en-tête de citation ou la signature est ignoré, ainsi que les lignes citées (>)
+func stripQuotedReply(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+
+	var kept []string
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if signatureSeparator.MatchString(line) || mobileSignature.MatchString(trimmed) {
+			break
+		}
+		stop := false
+		for _, pattern := range quoteHeaderPatterns {
+			if pattern.MatchString(trimmed) {
+				stop = true
+				break
+			}
+		}
+		if stop {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMaildirPollerClaimsMail vérifie qu'un e-mail n'est publié qu'une fois, et
+// jamais s'il ne peut pas être réservé dans cur/
+func TestMaildirPollerClaimsMail(t *testing.T) {
+	api := newTestAPI(t)
+	previous := mailSecret
+	mailSecret = []byte("secret-de-test-des-reponses")
+	t.Cleanup(func() { mailSecret = previous })
+	t.Setenv("REPLY_DOMAIN", "forum.example.com")
+
+	if resp, data := api.do(t, http.MethodPost, "/topics", api.token, `{"title":"Par e-mail"}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create topic: %d %s", resp.StatusCode, data)
+	}
+	reply := fmt.Sprintf("From: admin@example.com\r\nTo: %s\r\nSubject: Re: Par e-mail\r\n\r\nRéponse par e-mail\r\n",
+		ReplyAddress(api.adminID, 1))
+
+	cases := []struct {
+		name     string
+		withCur  bool
+		polls    int
+		messages int
+		newLeft  int
+	}{
+		{name: "publié une seule fois", withCur: true, polls: 3, messages: 1},
+		{name: "cur/ absent", withCur: false, polls: 2, messages: 0, newLeft: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := api.db.Exec("DELETE FROM message"); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "new"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if c.withCur {
+				if err := os.Mkdir(filepath.Join(dir, "cur"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, "new", "1700000000.1.host"), []byte(reply), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			poller, err := NewMaildirPoller(api.db, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < c.polls; i++ {
+				poller.Poll()
+			}
+
+			var messages int
+			if err := api.db.QueryRow("SELECT COUNT(*) FROM message").Scan(&messages); err != nil {
+				t.Fatal(err)
+			}
+			if messages != c.messages {
+				t.Errorf("%d messages posted, want %d", messages, c.messages)
+			}
+			left, _ := os.ReadDir(filepath.Join(dir, "new"))
+			if len(left) != c.newLeft {
+				t.Errorf("%d mails left in new/, want %d", len(left), c.newLeft)
+			}
+			if c.withCur {
+				if _, err := os.Stat(filepath.Join(dir, "cur", "1700000000.1.host:2,S")); err != nil {
+					t.Errorf("published mail not flagged in cur/: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"forum/store"
+)
+
+// Types d'événements diffusés aux pages de topic
+const (
+	EventMessageCreated = "message.created"
+	EventTopicVotes     = "topic.votes"
+	EventTopicState     = "topic.state"
+)
+
+const (
+	// Taille du tampon de chaque abonné ; au-delà le client est jugé trop lent
+	subscriberBuffer = 32
+	heartbeatPeriod  = 25 * time.Second
+)
+
+type LiveEvent struct {
+	Type    string      `json:"type"`
+	TopicID int         `json:"topic_id"`
+	Data    interface{} `json:"data"`
+}
+
+type subscriber struct {
+	events chan LiveEvent
+}
+
+// Hub diffuse les événements aux abonnés d'un topic. Publish ne bloque jamais :
+// un abonné dont le tampon est plein est déconnecté (EventSource se reconnecte seul).
+type Hub struct {
+	mu     sync.Mutex
+	topics map[int]map[*subscriber]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{topics: make(map[int]map[*subscriber]struct{})}
+}
+
+// Live est le hub partagé par les handlers d'écriture et le flux SSE
+var Live = NewHub()
+
+func (h *Hub) Subscribe(topicID int) *subscriber {
+	s := &subscriber{events: make(chan LiveEvent, subscriberBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.topics[topicID] == nil {
+		h.topics[topicID] = make(map[*subscriber]struct{})
+	}
+	h.topics[topicID][s] = struct{}{}
+	return s
+}
+
+func (h *Hub) Unsubscribe(topicID int, s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(topicID, s)
+}
+
+// remove suppose que h.mu est déjà verrouillé
+func (h *Hub) remove(topicID int, s *subscriber) {
+	subscribers, ok := h.topics[topicID]
+	if !ok {
+		return
+	}
+	if _, ok := subscribers[s]; !ok {
+		return
+	}
+	delete(subscribers, s)
+	close(s.events)
+	if len(subscribers) == 0 {
+		delete(h.topics, topicID)
+	}
+}
+
+func (h *Hub) Publish(event LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.topics[event.TopicID] {
+		select {
+		case s.events <- event:
+		default:
+			log.Printf("Dropping slow live subscriber on topic %d", event.TopicID)
+			h.remove(event.TopicID, s)
+		}
+	}
+}
+
+// PublishMessage diffuse un message nouvellement créé
+func PublishMessage(db store.Store, messageID int64) {
+	message, err := db.Messages().Get(int(messageID), store.Viewer{Moderator: true})
+	if err != nil {
+		log.Printf("Error fetching message for live update: %v", err)
+		return
+	}
+
+	Live.Publish(LiveEvent{Type: EventMessageCreated, TopicID: message.TopicID, Data: message})
+}
+
+// PublishTopicVotes diffuse les compteurs de likes/dislikes d'un topic
+func PublishTopicVotes(db store.Store, topicID int) {
+	var votes struct {
+		Likes    int `json:"likes"`
+		Dislikes int `json:"dislikes"`
+	}
+	var err error
+	votes.Likes, votes.Dislikes, err = db.Likes().TopicVotes(topicID)
+	if err != nil {
+		log.Printf("Error fetching votes for live update: %v", err)
+		return
+	}
+
+	Live.Publish(LiveEvent{Type: EventTopicVotes, TopicID: topicID, Data: votes})
+}
+
+// PublishTopicState diffuse le nouvel état d'un topic
+func PublishTopicState(topicID, stateID int) {
+	Live.Publish(LiveEvent{
+		Type:    EventTopicState,
+		TopicID: topicID,
+		Data:    map[string]int{"state_id": stateID},
+	})
+}
+
+// TopicEventsHandler ouvre un flux Server-Sent Events pour un topic (/api/topic/events?id=N)
+func TopicEventsHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if _, err := currentClaims(r); err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		topicID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+			return
+		}
+
+		_, err = db.Topics().Get(topicID, store.Viewer{})
+		if err == store.ErrNotFound {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking if topic exists: %v", err)
+			http.Error(w, "Error checking topic", http.StatusInternalServerError)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		flusher.Flush()
+
+		s := Live.Subscribe(topicID)
+		defer Live.Unsubscribe(topicID, s)
+
+		heartbeat := time.NewTicker(heartbeatPeriod)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			case event, ok := <-s.events:
+				if !ok {
+					// Client trop lent, déconnecté par le hub
+					return
+				}
+				payload, err := json.Marshal(event.Data)
+				if err != nil {
+					log.Printf("Error encoding live event: %v", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdown convertit le CommonMark (plus tableaux, autoliens et barré) en HTML.
+// Le HTML brut saisi par l'utilisateur n'est jamais recopié tel quel.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Linkify,
+		extension.Strikethrough,
+	),
+	goldmark.WithParserOptions(
+		parser.WithInlineParsers(util.Prioritized(&mentionParser{}, 500)),
+	),
+)
+
+// sanitizer applique une liste blanche sur le HTML produit par goldmark
+var sanitizer = newSanitizer()
+
+func newSanitizer() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Conserver la classe de langage des blocs de code (```go)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9_+-]+$`)).OnElements("code")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("a")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// RenderMarkdown transforme le contenu d'un message en HTML sûr à afficher
+func RenderMarkdown(content string) template.HTML {
+	return RenderMessage(content, nil)
+}
+
+// RenderMessage fait le rendu Markdown en liant les mentions résolues
+// (nom d'utilisateur en minuscules -> user_id) vers le profil correspondant
+func RenderMessage(content string, mentions map[string]int) template.HTML {
+	ctx := parser.NewContext()
+	ctx.Set(mentionsKey, mentions)
+
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(content), &buf, parser.WithContext(ctx)); err != nil {
+		log.Printf("Error rendering markdown: %v", err)
+		return template.HTML(template.HTMLEscapeString(content))
+	}
+	return template.HTML(sanitizer.SanitizeBytes(buf.Bytes()))
+}
+
+// PreviewMarkdownHandler renvoie le rendu HTML d'un brouillon sans l'enregistrer
+func PreviewMarkdownHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		content := r.FormValue("content")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(RenderMarkdown(content)))
+	}
+}
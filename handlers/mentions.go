@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"forum/store"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	// Nombre maximal de personnes notifiées pour un seul message
+	maxMentionsPerMessage = 10
+	// Nombre maximal de notifications de mention qu'un auteur peut déclencher par fenêtre
+	maxMentionsPerWindow = 30
+	mentionWindow        = 10 * time.Minute
+)
+
+var (
+	mentionPattern   = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]*\w)`)
+	codeBlockPattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+
+	// mentionsKey transporte les mentions résolues jusqu'au parseur Markdown
+	mentionsKey = parser.NewContextKey()
+)
+
+// ParseMentions extrait les noms d'utilisateurs mentionnés (@nom) d'un message,
+// sans doublons et en ignorant le code
+func ParseMentions(content string) []string {
+	content = codeBlockPattern.ReplaceAllString(content, " ")
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		key := strings.ToLower(name)
+		if len(name) > 50 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// ResolveMentions associe les noms mentionnés aux comptes existants.
+// La clé de la map est le nom d'utilisateur en minuscules.
+func ResolveMentions(db store.Store, names []string) (map[string]int, error) {
+	ids, err := db.Users().IDsByName(names)
+	if err != nil {
+		return nil, err
+	}
+
+	mentions := make(map[string]int, len(ids))
+	for username, userID := range ids {
+		mentions[strings.ToLower(username)] = userID
+	}
+	return mentions, nil
+}
+
+// NotifyMentions crée une notification pour chaque utilisateur mentionné,
+// dans la limite de maxMentionsPerMessage et du quota de l'auteur
+func NotifyMentions(db store.Store, authorID, topicID int, messageID int64, mentions map[string]int) {
+	notified := 0
+	for _, userID := range mentions {
+		if userID == authorID {
+			continue
+		}
+		if notified >= maxMentionsPerMessage {
+			log.Printf("Mention limit per message reached for user %d", authorID)
+			return
+		}
+
+		if !mentionLimiter.allow(authorID) {
+			log.Printf("Mention rate limit reached for user %d", authorID)
+			return
+		}
+
+		// CreateNotification ignore les destinataires ayant bloqué l'auteur
+		err := CreateNotification(db, Notification{
+			UserID:    userID,
+			ActorID:   authorID,
+			Type:      NotificationMention,
+			TopicID:   topicID,
+			MessageID: int(messageID),
+		})
+		if err != nil {
+			log.Printf("Error creating mention notification: %v", err)
+			continue
+		}
+		notified++
+	}
+}
+
+// mentionRateLimiter compte les mentions envoyées par auteur sur une fenêtre glissante
+type mentionRateLimiter struct {
+	mu     sync.Mutex
+	events map[int][]time.Time
+}
+
+var mentionLimiter = &mentionRateLimiter{events: make(map[int][]time.Time)}
+
+func (l *mentionRateLimiter) allow(userID int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.events[userID][:0]
+	for _, t := range l.events[userID] {
+		if now.Sub(t) < mentionWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= maxMentionsPerWindow {
+		l.events[userID] = recent
+		return false
+	}
+	l.events[userID] = append(recent, now)
+	return true
+}
+
+// prune oublie les auteurs sans mention dans la fenêtre
+func (l *mentionRateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for userID, events := range l.events {
+		if len(events) == 0 || now.Sub(events[len(events)-1]) >= mentionWindow {
+			delete(l.events, userID)
+		}
+	}
+}
+
+// mentionParser transforme les mentions résolues en liens vers le profil
+type mentionParser struct{}
+
+func (p *mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (p *mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	mentions, _ := pc.Get(mentionsKey).(map[string]int)
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	// Ne pas confondre avec une adresse e-mail (bob@exemple.fr)
+	if prev := block.PrecendingCharacter(); isMentionChar(prev) || prev == '@' {
+		return nil
+	}
+
+	line, segment := block.PeekLine()
+	end := 1
+	for end < len(line) && isMentionChar(rune(line[end])) {
+		end++
+	}
+	name := strings.TrimRight(string(line[1:end]), ".-")
+	if name == "" {
+		return nil
+	}
+	if _, ok := mentions[strings.ToLower(name)]; !ok {
+		return nil
+	}
+
+	length := len(name) + 1
+	block.Advance(length)
+
+	link := ast.NewLink()
+	link.Destination = []byte("/user?name=" + url.QueryEscape(name))
+	link.SetAttributeString("class", []byte("mention"))
+	link.AppendChild(link, ast.NewTextSegment(text.NewSegment(segment.Start, segment.Start+length)))
+	return link
+}
+
+func isMentionChar(r rune) bool {
+	return r == '_' || r == '.' || r == '-' || (r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"time"
+
+	"forum/store"
+)
+
+// Types de sanction (table sanction)
+const (
+	SanctionWarning    = "warning"
+	SanctionSuspension = "suspension"
+	SanctionBan        = "ban"
+)
+
+// Sanction est une suspension ou un bannissement en cours
+type Sanction struct {
+	ID                int
+	UserID            int
+	Username          string
+	Type              string
+	Reason            string
+	ModeratorUsername string
+	CreatedAt         string
+	ExpiresAt         string // vide pour un bannissement définitif
+}
+
+// Permanent indique que la sanction n'a pas de date de fin
+func (s *Sanction) Permanent() bool {
+	return s.ExpiresAt == ""
+}
+
+// Warn enregistre un avertissement et en prévient l'utilisateur
+func Warn(db store.Store, moderatorID, userID int, reason string, topicID int) error {
+	_, err := db.Exec(`
+		INSERT INTO sanction (user_id, type, reason, moderator_id)
+		VALUES (?, ?, ?, ?)
+	`, userID, SanctionWarning, reason, moderatorID)
+	if err != nil {
+		return err
+	}
+
+	return CreateNotification(db, Notification{
+		UserID:  userID,
+		ActorID: moderatorID,
+		Type:    NotificationWarning,
+		TopicID: topicID,
+		Detail:  reason,
+	})
+}
+
+// Suspend empêche l'utilisateur d'agir sur le forum jusqu'à la date donnée ;
+// moderatorID vaut 0 pour une sanction prononcée en ligne de commande
+func Suspend(db store.Store, moderatorID, userID int, reason string, until time.Time) error {
+	var moderator interface{}
+	if moderatorID != 0 {
+		moderator = moderatorID
+	}
+	_, err := db.Exec(`
+		INSERT INTO sanction (user_id, type, reason, moderator_id, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, SanctionSuspension, reason, moderator, until.Format(sqlDateTime))
+	return err
+}
+
+// Ban exclut définitivement l'utilisateur du forum ; moderatorID vaut 0 pour un
+// bannissement prononcé en ligne de commande
+func Ban(db store.Store, moderatorID, userID int, reason string) error {
+	var moderator interface{}
+	if moderatorID != 0 {
+		moderator = moderatorID
+	}
+	_, err := db.Exec(`
+		INSERT INTO sanction (user_id, type, reason, moderator_id)
+		VALUES (?, ?, ?, ?)
+	`, userID, SanctionBan, reason, moderator)
+	return err
+}
+
+// LiftSanctions lève les suspensions et bannissements en cours de l'utilisateur
+func LiftSanctions(db store.Store, userID int) error {
+	_, err := db.Exec(`
+		UPDATE sanction SET revoked_at = NOW()
+		WHERE user_id = ? AND type IN (?, ?) AND revoked_at IS NULL
+	`, userID, SanctionSuspension, SanctionBan)
+	return err
+}
+
+// activeSanctionQuery sélectionne les suspensions et bannissements encore en vigueur
+const activeSanctionQuery = `
+	SELECT s.sanction_id, s.user_id, u.username, s.type, s.reason, COALESCE(m.username, ''),
+	       s.created_at, COALESCE(s.expires_at, '')
+	FROM sanction s
+	JOIN user u ON s.user_id = u.user_id
+	LEFT JOIN user m ON s.moderator_id = m.user_id
+	WHERE s.type IN ('suspension', 'ban') AND s.revoked_at IS NULL
+	  AND (s.expires_at IS NULL OR s.expires_at > NOW())
+`
+
+func scanSanction(row interface{ Scan(...interface{}) error }) (*Sanction, error) {
+	var s Sanction
+	err := row.Scan(&s.ID, &s.UserID, &s.Username, &s.Type, &s.Reason, &s.ModeratorUsername, &s.CreatedAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ActiveSanction renvoie la sanction la plus longue en cours pour l'utilisateur,
+// ou nil s'il peut utiliser le forum
+func ActiveSanction(db store.Store, userID int) (*Sanction, error) {
+	sanction, err := scanSanction(db.QueryRow(activeSanctionQuery+`
+		AND s.user_id = ?
+		ORDER BY s.expires_at IS NULL DESC, s.expires_at DESC
+		LIMIT 1
+	`, userID))
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+	return sanction, err
+}
+
+// SanctionedError est renvoyée quand un compte suspendu ou banni tente de publier
+type SanctionedError struct {
+	Sanction *Sanction
+}
+
+func (e *SanctionedError) Error() string {
+	if e.Sanction.Permanent() {
+		return "account banned: " + e.Sanction.Reason
+	}
+	return "account suspended until " + e.Sanction.ExpiresAt + ": " + e.Sanction.Reason
+}
+
+// checkSanction renvoie une SanctionedError si l'utilisateur est suspendu ou banni.
+// CreateTopic, PostMessage et PostReply l'appellent pour que tous les points d'entrée
+// (web, API et réponses par e-mail) appliquent les sanctions.
+func checkSanction(db store.Store, userID int) error {
+	sanction, err := ActiveSanction(db, userID)
+	if err != nil {
+		return err
+	}
+	if sanction != nil {
+		return &SanctionedError{Sanction: sanction}
+	}
+	return nil
+}
+
+// ActiveSanctions liste toutes les suspensions et bannissements en cours
+func ActiveSanctions(db store.Store) ([]*Sanction, error) {
+	rows, err := db.Query(activeSanctionQuery + " ORDER BY s.created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sanctions []*Sanction
+	for rows.Next() {
+		sanction, err := scanSanction(rows)
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, sanction)
+	}
+	return sanctions, rows.Err()
+}
+
+// deleteMessageTx supprime un message et tout ce qui en dépend
+func deleteMessageTx(tx store.Tx, messageID int) error {
+	statements := []string{
+		"DELETE FROM response_user_like WHERE response_id IN (SELECT response_id FROM response WHERE message_id = ?)",
+		"DELETE FROM response WHERE message_id = ?",
+		"DELETE FROM notification WHERE message_id = ?",
+		"DELETE FROM mail_queue WHERE message_id = ?",
+		"DELETE FROM message WHERE message_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, messageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMessage supprime définitivement un message, ses réponses et ses notifications
+func DeleteMessage(db store.Store, messageID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteMessageTx(tx, messageID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteReply supprime définitivement une réponse et ses votes
+func DeleteReply(db store.Store, replyID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"DELETE FROM response_user_like WHERE response_id = ?",
+		"DELETE FROM response WHERE response_id = ?",
+	} {
+		if _, err := tx.Exec(statement, replyID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteTopic supprime définitivement un topic avec ses messages, votes et abonnements
+func DeleteTopic(db store.Store, topicID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteTopicTx(tx, topicID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteTopicTx supprime un topic et tout ce qui en dépend
+func deleteTopicTx(tx store.Tx, topicID int) error {
+	statements := []string{
+		`DELETE FROM response_user_like WHERE response_id IN (
+			SELECT r.response_id FROM response r JOIN message m ON r.message_id = m.message_id WHERE m.topic_id = ?)`,
+		"DELETE FROM response WHERE message_id IN (SELECT message_id FROM message WHERE topic_id = ?)",
+		"DELETE FROM notification WHERE topic_id = ?",
+		"DELETE FROM mail_queue WHERE topic_id = ?",
+		"DELETE FROM message WHERE topic_id = ?",
+		"DELETE FROM topic_user_like WHERE topic_id = ?",
+		"DELETE FROM topic_subscription WHERE topic_id = ?",
+		`UPDATE user SET topic_nbr = GREATEST(topic_nbr - 1, 0)
+			WHERE user_id = (SELECT user_id FROM topic WHERE topic_id = ? AND status = 'published')`,
+		"DELETE FROM topic WHERE topic_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, topicID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"forum/store"
+)
+
+// Types de notifications
+const (
+	NotificationMention = "mention"
+	NotificationReply   = "reply"
+	NotificationLike    = "like"
+	// Avertissement d'un modérateur : ni bloquable ni mis en sourdine
+	NotificationWarning = "warning"
+)
+
+// NotificationTypes liste les types que l'utilisateur peut mettre en sourdine
+var NotificationTypes = []struct {
+	ID    string
+	Label string
+}{
+	{NotificationReply, "Réponses à mes sujets"},
+	{NotificationLike, "Likes sur mes sujets"},
+	{NotificationMention, "Mentions"},
+}
+
+type Notification struct {
+	ID            int    `json:"id"`
+	UserID        int    `json:"user_id"`
+	ActorID       int    `json:"actor_id"`
+	ActorUsername string `json:"actor_username"`
+	Type          string `json:"type"`
+	TopicID       int    `json:"topic_id"`
+	TopicTitle    string `json:"topic_title"`
+	MessageID     int    `json:"message_id,omitempty"`
+	Detail        string `json:"detail,omitempty"`
+	CreatedAt     string `json:"created_at"`
+	Read          bool   `json:"read"`
+}
+
+// CreateNotification enregistre une notification pour son destinataire, sauf s'il
+// est lui-même l'auteur de l'action, s'il a bloqué l'auteur ou mis ce type en sourdine
+func CreateNotification(db store.Store, n Notification) error {
+	if n.UserID == n.ActorID {
+		return nil
+	}
+
+	if n.Type != NotificationWarning {
+		var skip bool
+		err := db.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM user_block WHERE blocker_id = ? AND blocked_id = ?)
+				OR EXISTS(SELECT 1 FROM notification_preference WHERE user_id = ? AND type = ? AND muted = TRUE)
+		`, n.UserID, n.ActorID, n.UserID, n.Type).Scan(&skip)
+		if err != nil {
+			return err
+		}
+		if skip {
+			return nil
+		}
+	}
+
+	var topicID, messageID, detail interface{}
+	if n.TopicID != 0 {
+		topicID = n.TopicID
+	}
+	if n.MessageID != 0 {
+		messageID = n.MessageID
+	}
+	if n.Detail != "" {
+		detail = n.Detail
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO notification (user_id, actor_id, type, topic_id, message_id, detail)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, n.UserID, n.ActorID, n.Type, topicID, messageID, detail)
+	return err
+}
+
+// NotifyTopicAuthor prévient l'auteur d'un topic d'une action sur celui-ci
+func NotifyTopicAuthor(db store.Store, actorID, topicID int, messageID int64, notificationType string) {
+	topic, err := db.Topics().Get(topicID, store.Viewer{Moderator: true})
+	if err != nil {
+		log.Printf("Error fetching topic author: %v", err)
+		return
+	}
+
+	err = CreateNotification(db, Notification{
+		UserID:    topic.UserID,
+		ActorID:   actorID,
+		Type:      notificationType,
+		TopicID:   topicID,
+		MessageID: int(messageID),
+	})
+	if err != nil {
+		log.Printf("Error creating %s notification: %v", notificationType, err)
+	}
+}
+
+// UnreadNotificationCount renvoie le nombre de notifications non lues
+func UnreadNotificationCount(db store.Store, userID int) int {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM notification WHERE user_id = ? AND read_at IS NULL", userID).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting notifications: %v", err)
+	}
+	return count
+}
+
+// notificationPageSize est le nombre de notifications affichées et renvoyées par l'API
+const notificationPageSize = 100
+
+// fetchNotifications renvoie les limit dernières notifications de l'utilisateur, ou
+// toutes si limit vaut 0
+func fetchNotifications(db store.Store, userID, limit int) ([]Notification, error) {
+	query := `
+		SELECT n.notification_id, n.user_id, COALESCE(n.actor_id, 0), COALESCE(a.username, ''), n.type,
+			COALESCE(n.topic_id, 0), COALESCE(t.title, ''), COALESCE(n.message_id, 0), COALESCE(n.detail, ''), n.created_at, n.read_at IS NOT NULL
+		FROM notification n
+		LEFT JOIN user a ON n.actor_id = a.user_id
+		LEFT JOIN topic t ON n.topic_id = t.topic_id
+		WHERE n.user_id = ?
+		ORDER BY n.created_at DESC, n.notification_id DESC`
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(
+			&n.ID,
+			&n.UserID,
+			&n.ActorID,
+			&n.ActorUsername,
+			&n.Type,
+			&n.TopicID,
+			&n.TopicTitle,
+			&n.MessageID,
+			&n.Detail,
+			&n.CreatedAt,
+			&n.Read,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func mutedNotificationTypes(db store.Store, userID int) (map[string]bool, error) {
+	rows, err := db.Query("SELECT type FROM notification_preference WHERE user_id = ? AND muted = TRUE", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	muted := make(map[string]bool)
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		muted[t] = true
+	}
+	return muted, rows.Err()
+}
+
+// NotificationsPageHandler affiche la liste des notifications et les préférences
+func NotificationsPageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		notifications, err := fetchNotifications(db, claims.UserID, notificationPageSize)
+		if err != nil {
+			log.Printf("Error fetching notifications: %v", err)
+			http.Error(w, "Error fetching notifications", http.StatusInternalServerError)
+			return
+		}
+
+		muted, err := mutedNotificationTypes(db, claims.UserID)
+		if err != nil {
+			log.Printf("Error fetching notification preferences: %v", err)
+			http.Error(w, "Error fetching notifications", http.StatusInternalServerError)
+			return
+		}
+
+		type preference struct {
+			ID    string
+			Label string
+			Muted bool
+		}
+		preferences := make([]preference, len(NotificationTypes))
+		for i, t := range NotificationTypes {
+			preferences[i] = preference{ID: t.ID, Label: t.Label, Muted: muted[t.ID]}
+		}
+
+		data := struct {
+			Notifications []Notification
+			Preferences   []preference
+			Username      string
+			Unread        int
+		}{
+			Notifications: notifications,
+			Preferences:   preferences,
+			Username:      claims.Username,
+			Unread:        UnreadNotificationCount(db, claims.UserID),
+		}
+
+		tmpl, err := template.ParseFiles("templates/notifications.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+	}
+}
+
+// GetNotificationsHandler renvoie les notifications de l'utilisateur en JSON
+func GetNotificationsHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		notifications, err := fetchNotifications(db, claims.UserID, notificationPageSize)
+		if err != nil {
+			log.Printf("Error fetching notifications: %v", err)
+			http.Error(w, "Error fetching notifications", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Unread        int            `json:"unread"`
+			Notifications []Notification `json:"notifications"`
+		}{
+			Unread:        UnreadNotificationCount(db, claims.UserID),
+			Notifications: notifications,
+		})
+	}
+}
+
+// MarkNotificationsReadHandler marque une notification (id) ou toutes comme lues
+func MarkNotificationsReadHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		if idParam := r.FormValue("id"); idParam != "" {
+			id, err := strconv.Atoi(idParam)
+			if err != nil {
+				http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+				return
+			}
+			_, err = db.Exec("UPDATE notification SET read_at = NOW() WHERE notification_id = ? AND user_id = ? AND read_at IS NULL", id, claims.UserID)
+		} else {
+			_, err = db.Exec("UPDATE notification SET read_at = NOW() WHERE user_id = ? AND read_at IS NULL", claims.UserID)
+		}
+		if err != nil {
+			log.Printf("Error marking notifications as read: %v", err)
+			http.Error(w, "Error updating notifications", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("Accept") == "application/json" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+	}
+}
+
+// NotificationPreferencesHandler enregistre les types de notifications en sourdine
+func NotificationPreferencesHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+			return
+		}
+		muted := make(map[string]bool)
+		for _, t := range r.Form["muted"] {
+			muted[t] = true
+		}
+
+		d := db.Dialect()
+		for _, t := range NotificationTypes {
+			_, err := db.Exec(`
+				INSERT INTO notification_preference (user_id, type, muted)
+				VALUES (?, ?, ?)
+				`+d.Upsert("user_id", "type")+` muted = `+d.Inserted("muted"), claims.UserID, t.ID, muted[t.ID])
+			if err != nil {
+				log.Printf("Error updating notification preferences: %v", err)
+				http.Error(w, "Error updating preferences", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"forum/config"
+)
+
+// Schéma JSON (sous-ensemble utilisé par OpenAPI 3.0)
+type schema map[string]interface{}
+
+// Paramètres de requête reconnus par les routes de l'API
+var apiQueryParams = map[string]schema{
+	"tags":   {"type": "string", "description": "Ne garde que les topics portant ce thème"},
+	"sort":   {"type": "string", "enum": []string{"recent", "likes", "dislikes"}, "default": "recent"},
+	"limit":  {"type": "integer", "minimum": 1, "maximum": apiMaxLimit, "default": apiDefaultLimit},
+	"offset": {"type": "integer", "minimum": 0, "default": 0},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  []byte
+	openAPISpec map[string]interface{}
+)
+
+// OpenAPISpec construit la spécification à partir de la table apiRoutes et des
+// types Go encodés par les handlers : elle ne peut pas diverger du code.
+func OpenAPISpec() map[string]interface{} {
+	openAPIOnce.Do(func() {
+		openAPISpec = buildOpenAPISpec()
+		doc, err := json.MarshalIndent(openAPISpec, "", "  ")
+		if err != nil {
+			log.Printf("Error encoding OpenAPI document: %v", err)
+		}
+		openAPIDoc = doc
+	})
+	return openAPISpec
+}
+
+// OpenAPIHandler sert la spécification OpenAPI de l'API (/api/v1/openapi.json)
+func OpenAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+		OpenAPISpec()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write(openAPIDoc)
+	}
+}
+
+type schemaBuilder struct {
+	components map[string]schema
+}
+
+// of renvoie le schéma d'un type Go ; les structures nommées deviennent des composants
+func (b *schemaBuilder) of(t reflect.Type) schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.of(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return schema{"allOf": []schema{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": b.of(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": b.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = nil // évite la récursion infinie
+			b.components[t.Name()] = b.object(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return schema{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) schema {
+	properties := make(map[string]schema)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.of(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// operationID dérive un identifiant stable de la méthode et du chemin (GET /topics/{id} → getTopicsById)
+func operationID(method, pattern string) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(method))
+	for _, part := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if strings.HasPrefix(part, "{") {
+			part = "by_" + strings.Trim(part, "{}")
+		}
+		for _, word := range strings.FieldsFunc(part, func(r rune) bool { return r == '_' || r == '.' }) {
+			id.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return id.String()
+}
+
+func errorResponse(description string) schema {
+	return schema{
+		"description": description,
+		"content": schema{
+			"application/json": schema{"schema": schema{"$ref": "#/components/schemas/APIError"}},
+		},
+	}
+}
+
+// successStatus renvoie le statut d'une réponse réussie de la route
+func (route apiRoute) successStatus() int {
+	if route.status == 0 {
+		return http.StatusOK
+	}
+	return route.status
+}
+
+func buildOpenAPISpec() map[string]interface{} {
+	b := &schemaBuilder{components: make(map[string]schema)}
+	b.of(reflect.TypeOf(APIError{}))
+
+	paths := make(map[string]schema)
+	for _, route := range apiRoutes {
+		status := route.successStatus()
+
+		operation := schema{
+			"operationId": operationID(route.method, route.pattern),
+			"summary":     route.summary,
+			"tags":        []string{strings.Split(strings.Trim(route.pattern, "/"), "/")[0]},
+		}
+
+		var parameters []schema
+		for _, part := range strings.Split(route.pattern, "/") {
+			switch part {
+			case "{id}":
+				parameters = append(parameters, schema{"name": "id", "in": "path", "required": true,
+					"schema": schema{"type": "integer", "minimum": 1}})
+			case "{name}":
+				parameters = append(parameters, schema{"name": "name", "in": "path", "required": true,
+					"schema": schema{"type": "string"}})
+			}
+		}
+		for _, name := range route.query {
+			parameters = append(parameters, schema{"name": name, "in": "query", "required": false, "schema": apiQueryParams[name]})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.request != nil {
+			operation["requestBody"] = schema{
+				"required": true,
+				"content": schema{
+					"application/json": schema{"schema": b.of(reflect.TypeOf(route.request))},
+				},
+			}
+		}
+
+		responses := schema{
+			strconv.Itoa(status): schema{
+				"description": http.StatusText(status),
+				"content": schema{
+					"application/json": schema{"schema": routeSchema(b, route)},
+				},
+			},
+			"500": errorResponse("Erreur interne"),
+		}
+		if route.location {
+			responses[strconv.Itoa(status)].(schema)["headers"] = schema{
+				"Location": schema{
+					"description": "Adresse de la ressource créée",
+					"schema":      schema{"type": "string"},
+				},
+			}
+		}
+		if route.public {
+			operation["security"] = []schema{}
+		} else {
+			responses["401"] = errorResponse("Jeton absent ou invalide")
+			responses["403"] = errorResponse("Compte suspendu ou banni")
+		}
+		if strings.Contains(route.pattern, "{") {
+			responses["404"] = errorResponse("Ressource introuvable")
+		}
+		if route.request != nil || len(route.query) > 0 {
+			responses["400"] = errorResponse("Paramètre ou corps invalide")
+		}
+		if route.request != nil {
+			responses["415"] = errorResponse("Le corps doit être en JSON")
+			responses["422"] = errorResponse("Données refusées par la validation")
+		}
+		if route.rate != "" {
+			responses["429"] = errorResponse("Trop de requêtes, réessayer après le délai de l'en-tête Retry-After")
+		}
+		for _, status := range route.errors {
+			responses[strconv.Itoa(status)] = errorResponse(http.StatusText(status))
+		}
+		operation["responses"] = responses
+
+		path := paths[route.pattern]
+		if path == nil {
+			path = schema{}
+			paths[route.pattern] = path
+		}
+		path[strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":       "ForumForAll API",
+			"version":     "1.0.0",
+			"description": "API JSON de ForumForAll. Les listes sont paginées avec limit et offset ; toutes les erreurs utilisent l'objet APIError.",
+		},
+		"servers":  []schema{{"url": config.BaseURL() + APIPrefix}},
+		"security": []schema{{"bearerAuth": []string{}}},
+		"paths":    paths,
+		"components": schema{
+			"schemas": b.components,
+			"securitySchemes": schema{
+				"bearerAuth": schema{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+// routeSchema renvoie le schéma de la réponse en succès, enveloppé dans Page pour les listes
+func routeSchema(b *schemaBuilder, route apiRoute) schema {
+	item := b.of(reflect.TypeOf(route.response))
+	if !route.list {
+		return item
+	}
+	page := b.object(reflect.TypeOf(Page{}))
+	page["properties"].(map[string]schema)["items"] = schema{"type": "array", "items": item}
+	return page
+}
+
+// validatingWriter garde une copie de la réponse pour la comparer au schéma
+type validatingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *validatingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *validatingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// validateResponses est activé par API_VALIDATE_RESPONSES=1 (développement, recette) :
+// chaque réponse réelle est vérifiée contre la spécification et les écarts sont journalisés.
+var validateResponses = config.Env("API_VALIDATE_RESPONSES", "") != ""
+
+func (route apiRoute) serveValidated(w http.ResponseWriter, run func(http.ResponseWriter)) {
+	if !validateResponses {
+		run(w)
+		return
+	}
+
+	recorder := &validatingWriter{ResponseWriter: w}
+	run(recorder)
+
+	if err := route.validate(recorder.status, recorder.body.Bytes()); err != nil {
+		log.Printf("OpenAPI mismatch for %s %s%s (%d): %v", route.method, APIPrefix, route.pattern, recorder.status, err)
+	}
+}
+
+// validate vérifie un corps de réponse par rapport à la spécification de la route
+func (route apiRoute) validate(status int, body []byte) error {
+	spec := OpenAPISpec()
+	paths := spec["paths"].(map[string]schema)
+	operation := paths[route.pattern][strings.ToLower(route.method)].(schema)
+	response, ok := operation["responses"].(schema)[strconv.Itoa(status)].(schema)
+	if !ok {
+		return fmt.Errorf("undocumented status %d", status)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	expected := response["content"].(schema)["application/json"].(schema)["schema"].(schema)
+	components := spec["components"].(schema)["schemas"].(map[string]schema)
+	return validateValue(components, expected, value, "$")
+}
+
+func validateValue(components map[string]schema, s schema, value interface{}, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		return validateValue(components, components[strings.TrimPrefix(ref, "#/components/schemas/")], value, path)
+	}
+	if value == nil {
+		if nullable, _ := s["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+	if all, ok := s["allOf"].([]schema); ok {
+		for _, sub := range all {
+			if err := validateValue(components, sub, value, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch s["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object", path)
+		}
+		required, _ := s["required"].([]string)
+		for _, name := range required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing property %q", path, name)
+			}
+		}
+		properties, _ := s["properties"].(map[string]schema)
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, known := properties[name]
+			if !known {
+				return fmt.Errorf("%s: undocumented property %q", path, name)
+			}
+			if err := validateValue(components, sub, object[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array", path)
+		}
+		items, _ := s["items"].(schema)
+		for i, item := range array {
+			if err := validateValue(components, items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected string", path)
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: expected integer", path)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected number", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean", path)
+		}
+	}
+	return nil
+}
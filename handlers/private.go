@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"forum/store"
+)
+
+// Nombre maximal de participants d'une conversation privée (auteur compris)
+const maxConversationParticipants = 10
+
+type Conversation struct {
+	ID           int
+	Subject      string
+	Participants string
+	UpdatedAt    string
+	Unread       bool
+}
+
+type PrivateMessage struct {
+	ID          int
+	Content     string
+	ContentHTML template.HTML
+	CreatedAt   string
+	UserID      int
+	Username    string
+}
+
+// isParticipant vérifie que l'utilisateur fait toujours partie de la conversation
+func isParticipant(db store.Store, conversationID, userID int) (bool, error) {
+	var ok bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM conversation_participant
+			WHERE conversation_id = ? AND user_id = ? AND left_at IS NULL
+		)
+	`, conversationID, userID).Scan(&ok)
+	return ok, err
+}
+
+// UnreadConversationCount renvoie le nombre de conversations contenant des messages non lus
+func UnreadConversationCount(db store.Store, userID int) int {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM conversation_participant cp
+		WHERE cp.user_id = ? AND cp.left_at IS NULL
+			AND EXISTS(
+				SELECT 1 FROM private_message pm
+				WHERE pm.conversation_id = cp.conversation_id
+					AND pm.user_id <> cp.user_id
+					AND (cp.last_read_at IS NULL OR pm.created_at > cp.last_read_at)
+			)
+	`, userID).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting unread conversations: %v", err)
+	}
+	return count
+}
+
+// InboxHandler affiche les conversations de l'utilisateur connecté
+func InboxHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT c.conversation_id, c.subject, c.updated_at,
+				(SELECT `+db.Dialect().GroupConcat("u.username", "u.username", ", ")+`
+					FROM conversation_participant p
+					JOIN user u ON p.user_id = u.user_id
+					WHERE p.conversation_id = c.conversation_id AND p.left_at IS NULL) AS participants,
+				EXISTS(
+					SELECT 1 FROM private_message pm
+					WHERE pm.conversation_id = c.conversation_id
+						AND pm.user_id <> cp.user_id
+						AND (cp.last_read_at IS NULL OR pm.created_at > cp.last_read_at)
+				) AS unread
+			FROM conversation c
+			JOIN conversation_participant cp ON cp.conversation_id = c.conversation_id
+			WHERE cp.user_id = ? AND cp.left_at IS NULL
+			ORDER BY c.updated_at DESC
+		`, claims.UserID)
+		if err != nil {
+			log.Printf("Error fetching conversations: %v", err)
+			http.Error(w, "Error fetching conversations", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var conversations []Conversation
+		for rows.Next() {
+			var c Conversation
+			var participants *string
+			if err := rows.Scan(&c.ID, &c.Subject, &c.UpdatedAt, &participants, &c.Unread); err != nil {
+				log.Printf("Error scanning conversations: %v", err)
+				http.Error(w, "Error scanning conversations", http.StatusInternalServerError)
+				return
+			}
+			if participants != nil {
+				c.Participants = *participants
+			}
+			conversations = append(conversations, c)
+		}
+
+		data := struct {
+			Conversations []Conversation
+			Username      string
+			Error         string
+		}{
+			Conversations: conversations,
+			Username:      claims.Username,
+			Error:         r.URL.Query().Get("error"),
+		}
+
+		tmpl, err := template.ParseFiles("templates/inbox.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+	}
+}
+
+// CreateConversationHandler démarre une conversation avec un ou plusieurs destinataires
+func CreateConversationHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		subject := strings.TrimSpace(r.FormValue("subject"))
+		content := strings.TrimSpace(r.FormValue("content"))
+		if subject == "" || content == "" {
+			http.Error(w, "Subject and content are required", http.StatusBadRequest)
+			return
+		}
+
+		// Destinataires séparés par des virgules, sans doublons ni l'auteur lui-même
+		seen := map[string]bool{strings.ToLower(claims.Username): true}
+		var names []string
+		for _, name := range strings.Split(r.FormValue("recipients"), ",") {
+			name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+			if name != "" && !seen[strings.ToLower(name)] {
+				seen[strings.ToLower(name)] = true
+				names = append(names, name)
+			}
+		}
+		recipients, err := ResolveMentions(db, names)
+		if err != nil {
+			log.Printf("Error resolving recipients: %v", err)
+			http.Error(w, "Error resolving recipients", http.StatusInternalServerError)
+			return
+		}
+
+		redirectError := func(msg string) {
+			http.Redirect(w, r, "/inbox?error="+template.URLQueryEscaper(msg), http.StatusSeeOther)
+		}
+		if len(names) == 0 || len(recipients) != len(names) {
+			redirectError("Destinataire inconnu")
+			return
+		}
+		if len(recipients)+1 > maxConversationParticipants {
+			redirectError(fmt.Sprintf("Une conversation est limitée à %d participants", maxConversationParticipants))
+			return
+		}
+		for _, userID := range recipients {
+			blocked, err := IsBlocked(db, userID, claims.UserID)
+			if err != nil {
+				log.Printf("Error checking block: %v", err)
+				http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+				return
+			}
+			if blocked {
+				redirectError("Un des destinataires n'accepte pas vos messages")
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		result, err := tx.Exec("INSERT INTO conversation (subject, created_by) VALUES (?, ?)", subject, claims.UserID)
+		if err != nil {
+			log.Printf("Error creating conversation: %v", err)
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+		conversationID, err := result.LastInsertId()
+		if err != nil {
+			log.Printf("Error getting last insert ID: %v", err)
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+
+		participants := []int{claims.UserID}
+		for _, userID := range recipients {
+			participants = append(participants, userID)
+		}
+		for _, userID := range participants {
+			_, err = tx.Exec("INSERT INTO conversation_participant (conversation_id, user_id) VALUES (?, ?)", conversationID, userID)
+			if err != nil {
+				log.Printf("Error adding participant: %v", err)
+				http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO private_message (conversation_id, user_id, content, content_html)
+			VALUES (?, ?, ?, ?)
+		`, conversationID, claims.UserID, content, string(RenderMarkdown(content)))
+		if err != nil {
+			log.Printf("Error creating private message: %v", err)
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+		_, err = tx.Exec("UPDATE conversation_participant SET last_read_at = NOW() WHERE conversation_id = ? AND user_id = ?", conversationID, claims.UserID)
+		if err != nil {
+			log.Printf("Error updating read status: %v", err)
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing conversation: %v", err)
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/conversation?id=%d", conversationID), http.StatusSeeOther)
+	}
+}
+
+// ConversationPageHandler affiche une conversation à l'un de ses participants
+func ConversationPageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		conversationID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+			return
+		}
+
+		// Un non-participant ne doit pas apprendre que la conversation existe
+		ok, err := isParticipant(db, conversationID, claims.UserID)
+		if err != nil {
+			log.Printf("Error checking participant: %v", err)
+			http.Error(w, "Error fetching conversation", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		var conversation Conversation
+		var participants *string
+		err = db.QueryRow(`
+			SELECT c.conversation_id, c.subject, c.updated_at,
+				(SELECT `+db.Dialect().GroupConcat("u.username", "u.username", ", ")+`
+					FROM conversation_participant p
+					JOIN user u ON p.user_id = u.user_id
+					WHERE p.conversation_id = c.conversation_id AND p.left_at IS NULL)
+			FROM conversation c
+			WHERE c.conversation_id = ?
+		`, conversationID).Scan(&conversation.ID, &conversation.Subject, &conversation.UpdatedAt, &participants)
+		if err != nil {
+			log.Printf("Error fetching conversation: %v", err)
+			http.Error(w, "Error fetching conversation", http.StatusInternalServerError)
+			return
+		}
+		if participants != nil {
+			conversation.Participants = *participants
+		}
+
+		rows, err := db.Query(`
+			SELECT pm.private_message_id, pm.content, pm.content_html, pm.created_at, pm.user_id, u.username
+			FROM private_message pm
+			JOIN user u ON pm.user_id = u.user_id
+			WHERE pm.conversation_id = ?
+			ORDER BY pm.created_at ASC, pm.private_message_id ASC
+		`, conversationID)
+		if err != nil {
+			log.Printf("Error fetching private messages: %v", err)
+			http.Error(w, "Error fetching conversation", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var messages []PrivateMessage
+		for rows.Next() {
+			var m PrivateMessage
+			var contentHTML *string
+			if err := rows.Scan(&m.ID, &m.Content, &contentHTML, &m.CreatedAt, &m.UserID, &m.Username); err != nil {
+				log.Printf("Error scanning private messages: %v", err)
+				http.Error(w, "Error fetching conversation", http.StatusInternalServerError)
+				return
+			}
+			if contentHTML != nil {
+				m.ContentHTML = template.HTML(*contentHTML)
+			} else {
+				m.ContentHTML = RenderMarkdown(m.Content)
+			}
+			messages = append(messages, m)
+		}
+
+		_, err = db.Exec("UPDATE conversation_participant SET last_read_at = NOW() WHERE conversation_id = ? AND user_id = ?", conversationID, claims.UserID)
+		if err != nil {
+			log.Printf("Error updating read status: %v", err)
+		}
+
+		data := struct {
+			Conversation Conversation
+			Messages     []PrivateMessage
+			Username     string
+		}{
+			Conversation: conversation,
+			Messages:     messages,
+			Username:     claims.Username,
+		}
+
+		tmpl, err := template.ParseFiles("templates/conversation.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+	}
+}
+
+// SendPrivateMessageHandler ajoute un message à une conversation
+func SendPrivateMessageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		conversationID, err := strconv.Atoi(r.FormValue("conversation_id"))
+		if err != nil {
+			http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+			return
+		}
+		content := strings.TrimSpace(r.FormValue("content"))
+		if content == "" {
+			http.Error(w, "Content is required", http.StatusBadRequest)
+			return
+		}
+
+		ok, err := isParticipant(db, conversationID, claims.UserID)
+		if err != nil {
+			log.Printf("Error checking participant: %v", err)
+			http.Error(w, "Error sending message", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO private_message (conversation_id, user_id, content, content_html)
+			VALUES (?, ?, ?, ?)
+		`, conversationID, claims.UserID, content, string(RenderMarkdown(content)))
+		if err != nil {
+			log.Printf("Error creating private message: %v", err)
+			http.Error(w, "Error sending message", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = db.Exec("UPDATE conversation SET updated_at = NOW() WHERE conversation_id = ?", conversationID)
+		if err != nil {
+			log.Printf("Error updating conversation: %v", err)
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/conversation?id=%d", conversationID), http.StatusSeeOther)
+	}
+}
+
+// LeaveConversationHandler retire l'utilisateur connecté d'une conversation
+func LeaveConversationHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		conversationID, err := strconv.Atoi(r.FormValue("conversation_id"))
+		if err != nil {
+			http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+			return
+		}
+
+		result, err := db.Exec(`
+			UPDATE conversation_participant SET left_at = NOW()
+			WHERE conversation_id = ? AND user_id = ? AND left_at IS NULL
+		`, conversationID, claims.UserID)
+		if err != nil {
+			log.Printf("Error leaving conversation: %v", err)
+			http.Error(w, "Error leaving conversation", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.Redirect(w, r, "/inbox", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/config"
+	"forum/store"
+)
+
+// Budgets de limitation des écritures, chacun configurable par RATE_LIMIT_{BUDGET}
+// au format "nombre/durée" (par exemple "5/10m")
+const (
+	RateTopic   = "topic"
+	RateMessage = "message"
+	RateLike    = "like"
+	RateLogin   = "login"
+)
+
+// rateBudget autorise Burst actions d'affilée, le seau se remplissant en Per
+type rateBudget struct {
+	Burst int
+	Per   time.Duration
+}
+
+var defaultRateBudgets = map[string]rateBudget{
+	RateTopic:   {5, 10 * time.Minute},
+	RateMessage: {30, 5 * time.Minute},
+	RateLike:    {60, time.Minute},
+	RateLogin:   {10, 15 * time.Minute},
+}
+
+// Part du budget accordée selon le niveau de confiance du compte ; une adresse IP,
+// qui peut être partagée par plusieurs comptes, a droit à rateIPFactor budgets
+var trustRateFactors = map[int]float64{TrustNew: 0.25, TrustBasic: 0.5, TrustRegular: 1}
+
+const rateIPFactor = 2
+
+// rateBudgetFor lit le budget configuré, ou celui par défaut si la variable est absente ou invalide
+func rateBudgetFor(name string) rateBudget {
+	budget := defaultRateBudgets[name]
+	value := config.Env("RATE_LIMIT_"+strings.ToUpper(name), "")
+	if value == "" {
+		return budget
+	}
+	count, period, ok := strings.Cut(value, "/")
+	burst, err := strconv.Atoi(strings.TrimSpace(count))
+	per, perErr := time.ParseDuration(strings.TrimSpace(period))
+	if !ok || err != nil || perErr != nil || burst < 1 || per <= 0 {
+		log.Printf("Invalid RATE_LIMIT_%s %q, using the default", strings.ToUpper(name), value)
+		return budget
+	}
+	return rateBudget{Burst: burst, Per: per}
+}
+
+// tokenBucket contient des jetons qui se régénèrent en continu jusqu'à sa capacité
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64 // jetons par seconde
+	updated  time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+}
+
+// rateLimiter garde les seaux en mémoire ; chaque instance a donc ses propres compteurs
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+var writeLimiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+// rateKey désigne un seau et sa taille
+type rateKey struct {
+	key      string
+	capacity float64
+	rate     float64
+}
+
+// take prend un jeton dans chacun des seaux, ou aucun si l'un d'eux est vide ;
+// il renvoie alors le délai avant qu'un jeton soit disponible dans tous
+func (l *rateLimiter) take(keys []rateKey, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait float64
+	buckets := make([]*tokenBucket, len(keys))
+	for i, k := range keys {
+		b := l.buckets[k.key]
+		if b == nil {
+			b = &tokenBucket{tokens: k.capacity, updated: now}
+			l.buckets[k.key] = b
+		}
+		// La taille peut changer avec la configuration ou le niveau de confiance
+		b.capacity, b.rate = k.capacity, k.rate
+		b.refill(now)
+		if b.tokens < 1 {
+			wait = math.Max(wait, (1-b.tokens)/b.rate)
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return time.Duration(math.Ceil(wait)) * time.Second
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0
+}
+
+// prune oublie les seaux redevenus pleins
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// PruneRateLimits est la tâche périodique qui libère la mémoire des seaux inutilisés
+// et des compteurs de mentions expirés
+func PruneRateLimits() {
+	now := time.Now()
+	writeLimiter.prune(now)
+	mentionLimiter.prune(now)
+}
+
+func scaledRateKey(key string, budget rateBudget, factor float64) rateKey {
+	capacity := math.Max(1, float64(budget.Burst)*factor)
+	return rateKey{key: key, capacity: capacity, rate: capacity / budget.Per.Seconds()}
+}
+
+// takeRate consomme une action du budget pour l'adresse IP, le compte (userID, 0 si
+// anonyme, budget réduit selon son niveau de confiance) et l'identifiant saisi (name,
+// pour les connexions) ; il renvoie le délai d'attente si la limite est atteinte
+func takeRate(db store.Store, name string, r *http.Request, userID int, login string) time.Duration {
+	budget := rateBudgetFor(name)
+	keys := []rateKey{scaledRateKey(name+":ip:"+clientIP(r).String(), budget, rateIPFactor)}
+	if userID != 0 {
+		factor := trustRateFactors[TrustNew]
+		if trust, err := UserTrust(db, userID); err != nil {
+			log.Printf("Error computing trust level of user %d: %v", userID, err)
+		} else {
+			factor = trustRateFactors[trust.Level]
+		}
+		keys = append(keys, scaledRateKey(fmt.Sprintf("%s:user:%d", name, userID), budget, factor))
+	}
+	if login != "" {
+		keys = append(keys, scaledRateKey(name+":login:"+strings.ToLower(login), budget, 1))
+	}
+	return writeLimiter.take(keys, time.Now())
+}
+
+// retryAfter renvoie la valeur de l'en-tête Retry-After, en secondes
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// humanDelay formate un délai pour les pages HTML
+func humanDelay(wait time.Duration) string {
+	if wait < time.Minute {
+		return fmt.Sprintf("%d seconde(s)", int(math.Ceil(wait.Seconds())))
+	}
+	return fmt.Sprintf("%d minute(s)", int(math.Ceil(wait.Minutes())))
+}
+
+var rateLimitMessages = map[string]string{
+	RateTopic:   "Vous créez des sujets trop rapidement.",
+	RateMessage: "Vous publiez des messages trop rapidement.",
+	RateLike:    "Vous votez trop rapidement.",
+	RateLogin:   "Trop de tentatives de connexion.",
+}
+
+// RateLimit limite les requêtes POST du flux HTML selon le budget ; au-delà, il
+// répond 429 avec Retry-After et une page expliquant quand réessayer
+func RateLimit(db store.Store, budget string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		var userID int
+		if claims, err := currentClaims(r); err == nil {
+			userID = claims.UserID
+		}
+		var login string
+		if budget == RateLogin {
+			login = r.FormValue("username")
+		}
+
+		wait := takeRate(db, budget, r, userID, login)
+		if wait == 0 {
+			next(w, r)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/ratelimited.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Retry-After", retryAfter(wait))
+		w.WriteHeader(http.StatusTooManyRequests)
+		tmpl.Execute(w, struct {
+			Message string
+			Delay   string
+		}{
+			Message: rateLimitMessages[budget],
+			Delay:   humanDelay(wait),
+		})
+	}
+}
+
+// apiRateLimited applique le budget d'une route de l'API ; il répond 429 avec
+// Retry-After et renvoie true si la limite est atteinte
+func apiRateLimited(db store.Store, w http.ResponseWriter, budget string, r *http.Request, userID int, login string) bool {
+	wait := takeRate(db, budget, r, userID, login)
+	if wait == 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfter(wait))
+	writeAPIError(w, http.StatusTooManyRequests, "rate_limited",
+		fmt.Sprintf("Rate limit exceeded, retry in %s seconds", retryAfter(wait)))
+	return true
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestTokenBucket vérifie la consommation et la régénération des seaux : un seau
+// vide bloque l'action dans tous les seaux, et un seau ne dépasse pas sa capacité
+func TestTokenBucket(t *testing.T) {
+	user := rateKey{key: "user", capacity: 2, rate: 0.25} // 2 jetons, un toutes les 4 s
+	ip := rateKey{key: "ip", capacity: 3, rate: 0.25}
+
+	steps := []struct {
+		at   time.Duration
+		keys []rateKey
+		wait time.Duration
+	}{
+		{0, []rateKey{user, ip}, 0},
+		{0, []rateKey{user, ip}, 0},
+		{0, []rateKey{user, ip}, 4 * time.Second},
+		{time.Second, []rateKey{user, ip}, 3 * time.Second},
+		// Le seau de l'adresse n'a pas été entamé par les refus
+		{time.Second, []rateKey{ip}, 0},
+		{time.Second, []rateKey{ip}, 3 * time.Second},
+		{4 * time.Second, []rateKey{user, ip}, 0},
+		{4 * time.Second, []rateKey{user}, 4 * time.Second},
+		// Après une longue pause, le seau est plein mais pas au-delà
+		{time.Hour, []rateKey{user}, 0},
+		{time.Hour, []rateKey{user}, 0},
+		{time.Hour, []rateKey{user}, 4 * time.Second},
+	}
+
+	limiter := &rateLimiter{buckets: make(map[string]*tokenBucket)}
+	start := time.Now()
+	for i, step := range steps {
+		if wait := limiter.take(step.keys, start.Add(step.at)); wait != step.wait {
+			t.Errorf("step %d at %v: wait %v, want %v", i, step.at, wait, step.wait)
+		}
+	}
+
+	// Les seaux redevenus pleins sont oubliés
+	limiter.prune(start.Add(time.Hour + time.Second))
+	if _, ok := limiter.buckets["user"]; !ok {
+		t.Errorf("pruned a bucket that is not full")
+	}
+	if _, ok := limiter.buckets["ip"]; ok {
+		t.Errorf("kept a full bucket")
+	}
+	limiter.prune(start.Add(2 * time.Hour))
+	if len(limiter.buckets) != 0 {
+		t.Errorf("%d buckets left after prune, want 0", len(limiter.buckets))
+	}
+}
+
+// TestAPIRateLimit vérifie les réponses 429 de l'API : budget par compte selon le
+// niveau de confiance, budget partagé par l'adresse IP et tentatives de connexion
+func TestAPIRateLimit(t *testing.T) {
+	type step struct {
+		method, path, body string
+		user               string // compte qui envoie la requête, aucun si vide
+		status             int
+		retryAfter         string
+	}
+	topic := func(user string, status int, retryAfter string) step {
+		return step{http.MethodPost, "/topics", `{"title":"Un sujet"}`, user, status, retryAfter}
+	}
+	login := func(status int, retryAfter string) step {
+		return step{http.MethodPost, "/auth/token", `{"username":"admin","password":"mauvais"}`, "", status, retryAfter}
+	}
+
+	cases := []struct {
+		name        string
+		env, budget string
+		steps       []step
+	}{
+		{"compte de confiance", "RATE_LIMIT_TOPIC", "2/1m", []step{
+			topic("admin", http.StatusCreated, ""),
+			topic("admin", http.StatusCreated, ""),
+			topic("admin", http.StatusTooManyRequests, "30"),
+			topic("modo", http.StatusCreated, ""),
+		}},
+		{"nouveau compte", "RATE_LIMIT_TOPIC", "2/1m", []step{
+			topic("newbie", http.StatusCreated, ""),
+			topic("newbie", http.StatusTooManyRequests, "60"),
+		}},
+		{"adresse IP partagée", "RATE_LIMIT_TOPIC", "2/1m", []step{
+			topic("admin", http.StatusCreated, ""),
+			topic("admin", http.StatusCreated, ""),
+			topic("modo", http.StatusCreated, ""),
+			topic("modo", http.StatusCreated, ""),
+			topic("newbie", http.StatusTooManyRequests, "15"),
+		}},
+		{"tentatives de connexion", "RATE_LIMIT_LOGIN", "1/1m", []step{
+			login(http.StatusUnauthorized, ""),
+			login(http.StatusTooManyRequests, "60"),
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv(c.env, c.budget)
+			api := newTestAPI(t)
+			tokens := map[string]string{"admin": api.token}
+			_, tokens["modo"] = api.createUser(t, "modo", RoleModerator)
+			_, tokens["newbie"] = api.createUser(t, "newbie", RoleUser)
+
+			for i, s := range c.steps {
+				resp, data := api.do(t, s.method, s.path, tokens[s.user], s.body)
+				if resp.StatusCode != s.status {
+					t.Fatalf("step %d: status %d, want %d: %s", i, resp.StatusCode, s.status, data)
+				}
+				if got := resp.Header.Get("Retry-After"); got != s.retryAfter {
+					t.Errorf("step %d: Retry-After %q, want %q", i, got, s.retryAfter)
+				}
+				route, _ := findRoute(s.method, s.path)
+				if err := route.validate(resp.StatusCode, data); err != nil {
+					t.Errorf("step %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
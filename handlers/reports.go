@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/store"
+)
+
+// Types de contenu signalable
+const (
+	ReportTopic   = "topic"
+	ReportMessage = "message"
+)
+
+// États d'un signalement
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportResolved  = "resolved"
+)
+
+// Actions de résolution proposées aux modérateurs
+const (
+	ResolutionDismiss = "dismiss"
+	ResolutionDelete  = "delete"
+	ResolutionWarn    = "warn"
+	ResolutionSuspend = "suspend"
+)
+
+const (
+	maxReportDetails  = 1000
+	MaxSuspensionDays = 365 // durée maximale d'une suspension
+)
+
+// ReportReasons liste les motifs de signalement
+var ReportReasons = []struct {
+	ID    string
+	Label string
+}{
+	{"spam", "Spam ou publicité"},
+	{"abuse", "Propos injurieux ou harcèlement"},
+	{"illegal", "Contenu illégal"},
+	{"off_topic", "Hors sujet"},
+	{"other", "Autre"},
+}
+
+func reportReasonLabel(id string) string {
+	for _, reason := range ReportReasons {
+		if reason.ID == id {
+			return reason.Label
+		}
+	}
+	return ""
+}
+
+type Report struct {
+	ID               int
+	ReporterUsername string
+	Reason           string
+	ReasonLabel      string
+	Details          string
+	CreatedAt        string
+}
+
+// ReportGroup regroupe les signalements ouverts sur un même contenu
+type ReportGroup struct {
+	TargetType     string
+	TargetID       int
+	TopicID        int
+	Title          string
+	Content        template.HTML
+	AuthorID       int
+	AuthorUsername string
+	Deleted        bool
+	Reports        []Report
+	Spam           SpamScore
+}
+
+// reportTarget renvoie l'auteur et le topic du contenu signalé
+func reportTarget(db store.Store, targetType string, targetID int) (authorID, topicID int, err error) {
+	switch targetType {
+	case ReportTopic:
+		topic, err := db.Topics().Get(targetID, store.Viewer{Moderator: true})
+		return topic.UserID, topic.ID, err
+	case ReportMessage:
+		message, err := db.Messages().Get(targetID, store.Viewer{Moderator: true})
+		return message.UserID, message.TopicID, err
+	}
+	return 0, 0, store.ErrNotFound
+}
+
+// CreateReportHandler enregistre le signalement d'un topic ou d'un message
+func CreateReportHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		targetType := r.FormValue("target_type")
+		targetID, err := strconv.Atoi(r.FormValue("target_id"))
+		if err != nil {
+			http.Error(w, "Invalid target", http.StatusBadRequest)
+			return
+		}
+		reason := r.FormValue("reason")
+		if reportReasonLabel(reason) == "" {
+			http.Error(w, "Invalid reason", http.StatusBadRequest)
+			return
+		}
+		details := strings.TrimSpace(r.FormValue("details"))
+		if utf8.RuneCountInString(details) > maxReportDetails {
+			http.Error(w, "Details are too long", http.StatusBadRequest)
+			return
+		}
+
+		_, topicID, err := reportTarget(db, targetType, targetID)
+		if err == store.ErrNotFound {
+			http.Error(w, "Reported content not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching reported content: %v", err)
+			http.Error(w, "Error creating report", http.StatusInternalServerError)
+			return
+		}
+
+		// Un seul signalement ouvert par utilisateur et par contenu
+		_, err = db.Exec(`
+			INSERT INTO report (reporter_id, target_type, target_id, reason, details)
+			SELECT ?, ?, ?, ?, ?
+			FROM (SELECT 1) AS one
+			WHERE NOT EXISTS(
+				SELECT 1 FROM report
+				WHERE reporter_id = ? AND target_type = ? AND target_id = ? AND status = ?
+			)
+		`, claims.UserID, targetType, targetID, reason, details, claims.UserID, targetType, targetID, ReportOpen)
+		if err != nil {
+			log.Printf("Error creating report: %v", err)
+			http.Error(w, "Error creating report", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/topic?id=%d&reported=1", topicID), http.StatusSeeOther)
+	}
+}
+
+// fetchReportGroups renvoie les signalements ouverts, regroupés par contenu,
+// les contenus les plus signalés en premier
+func fetchReportGroups(db store.Store) ([]*ReportGroup, error) {
+	rows, err := db.Query(`
+		SELECT r.report_id, r.target_type, r.target_id, u.username, r.reason, COALESCE(r.details, ''), r.created_at
+		FROM report r
+		JOIN user u ON r.reporter_id = u.user_id
+		WHERE r.status = ?
+		ORDER BY r.created_at ASC, r.report_id ASC
+	`, ReportOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []*ReportGroup
+	byTarget := make(map[string]*ReportGroup)
+	for rows.Next() {
+		var report Report
+		var targetType string
+		var targetID int
+		err := rows.Scan(&report.ID, &targetType, &targetID, &report.ReporterUsername, &report.Reason, &report.Details, &report.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		report.ReasonLabel = reportReasonLabel(report.Reason)
+
+		key := fmt.Sprintf("%s:%d", targetType, targetID)
+		group, ok := byTarget[key]
+		if !ok {
+			group = &ReportGroup{TargetType: targetType, TargetID: targetID}
+			byTarget[key] = group
+			groups = append(groups, group)
+		}
+		group.Reports = append(group.Reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		if err := loadReportedContent(db, group); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Reports) > len(groups[j].Reports)
+	})
+	return groups, nil
+}
+
+func loadReportedContent(db store.Store, group *ReportGroup) error {
+	var err error
+	moderator := store.Viewer{Moderator: true}
+	switch group.TargetType {
+	case ReportTopic:
+		var topic Topic
+		topic, err = db.Topics().Get(group.TargetID, moderator)
+		group.TopicID, group.Title = topic.ID, topic.Title
+		group.AuthorID, group.AuthorUsername = topic.UserID, topic.Username
+		group.Content = RenderMarkdown(topic.Description)
+		group.Spam = ClassifySpam(db, topic.Title+"\n"+topic.Description)
+	case ReportMessage:
+		var message Message
+		var topic Topic
+		message, err = db.Messages().Get(group.TargetID, moderator)
+		if err == nil {
+			topic, err = db.Topics().Get(message.TopicID, moderator)
+		}
+		group.TopicID, group.Title = message.TopicID, topic.Title
+		group.AuthorID, group.AuthorUsername = message.UserID, message.Username
+		if message.ContentHTML != "" {
+			group.Content = template.HTML(message.ContentHTML)
+		} else {
+			group.Content = RenderMarkdown(message.Content)
+		}
+		group.Spam = ClassifySpam(db, message.Content)
+	}
+	if err == store.ErrNotFound {
+		group.Deleted = true
+		return nil
+	}
+	return err
+}
+
+// ReportQueueHandler affiche la file des signalements ouverts aux modérateurs
+func ReportQueueHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		groups, err := fetchReportGroups(db)
+		if err != nil {
+			log.Printf("Error fetching reports: %v", err)
+			http.Error(w, "Error fetching reports", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/reports.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, struct {
+			Username string
+			Groups   []*ReportGroup
+			Error    string
+		}{
+			Username: claims.Username,
+			Groups:   groups,
+			Error:    r.URL.Query().Get("error"),
+		})
+	}
+}
+
+// ResolveReportHandler clôt tous les signalements ouverts d'un contenu : classement
+// sans suite, suppression du contenu, avertissement ou suspension de l'auteur
+func ResolveReportHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		targetType := r.FormValue("target_type")
+		targetID, err := strconv.Atoi(r.FormValue("target_id"))
+		if err != nil || (targetType != ReportTopic && targetType != ReportMessage) {
+			http.Error(w, "Invalid target", http.StatusBadRequest)
+			return
+		}
+		action := r.FormValue("action")
+		note := strings.TrimSpace(r.FormValue("note"))
+		deleteContent := action == ResolutionDelete || r.FormValue("delete_content") != ""
+
+		authorID, topicID, err := reportTarget(db, targetType, targetID)
+		contentExists := err == nil
+		if err != nil && err != store.ErrNotFound {
+			log.Printf("Error fetching reported content: %v", err)
+			http.Error(w, "Error resolving report", http.StatusInternalServerError)
+			return
+		}
+
+		status := ReportResolved
+		switch action {
+		case ResolutionDismiss:
+			status = ReportDismissed
+			deleteContent = false
+		case ResolutionDelete:
+		case ResolutionWarn, ResolutionSuspend:
+			if !contentExists {
+				http.Redirect(w, r, "/moderation/reports?error="+url.QueryEscape("Le contenu a déjà été supprimé"), http.StatusSeeOther)
+				return
+			}
+			if note == "" {
+				http.Redirect(w, r, "/moderation/reports?error="+url.QueryEscape("Indiquez le motif de la sanction"), http.StatusSeeOther)
+				return
+			}
+		default:
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+
+		// La sanction est enregistrée avant la suppression, qui efface le lien vers le topic
+		sanctionEvent := AuditEvent{TargetType: AuditTargetUser, TargetID: authorID, Reason: note}
+		switch action {
+		case ResolutionWarn:
+			warnTopic := topicID
+			if deleteContent && targetType == ReportTopic {
+				warnTopic = 0
+			}
+			err = Warn(db, claims.UserID, authorID, note, warnTopic)
+			sanctionEvent.Action = AuditUserWarn
+			sanctionEvent.After = map[string]interface{}{"type": SanctionWarning, targetType + "_id": targetID}
+		case ResolutionSuspend:
+			days, convErr := strconv.Atoi(r.FormValue("days"))
+			if convErr != nil || days < 1 || days > MaxSuspensionDays {
+				http.Error(w, "Invalid suspension length", http.StatusBadRequest)
+				return
+			}
+			until := time.Now().AddDate(0, 0, days)
+			err = Suspend(db, claims.UserID, authorID, note, until)
+			sanctionEvent.Action = AuditUserSuspend
+			sanctionEvent.After = map[string]interface{}{"type": SanctionSuspension, "expires_at": until.Format(sqlDateTime), targetType + "_id": targetID}
+		}
+		if err != nil {
+			log.Printf("Error sanctioning user %d: %v", authorID, err)
+			http.Error(w, "Error resolving report", http.StatusInternalServerError)
+			return
+		}
+		if sanctionEvent.Action != "" {
+			if author, err := db.Users().Profile(authorID); err == nil {
+				sanctionEvent.TargetLabel = author.Username
+			}
+			audit(db, r, sanctionEvent)
+		}
+
+		// Le classifieur apprend des contenus classés sans suite et des contenus
+		// supprimés pour spam, avant leur suppression
+		if contentExists {
+			if action == ResolutionDismiss {
+				trainSpamDecision(db, claims.UserID, targetType, targetID, false)
+			} else if deleteContent && reportedAsSpam(db, targetType, targetID) {
+				trainSpamDecision(db, claims.UserID, targetType, targetID, true)
+			}
+		}
+
+		if deleteContent && contentExists {
+			label, snapshot := contentSnapshot(db, targetType, targetID)
+			deleteEvent := AuditEvent{
+				Action:      AuditMessageDelete,
+				TargetType:  targetType,
+				TargetID:    targetID,
+				TargetLabel: label,
+				Before:      snapshot,
+				Reason:      note,
+			}
+			if targetType == ReportTopic {
+				deleteEvent.Action = AuditTopicDelete
+				err = DeleteTopic(db, targetID)
+			} else {
+				err = DeleteMessage(db, targetID)
+			}
+			if err != nil {
+				log.Printf("Error deleting reported %s %d: %v", targetType, targetID, err)
+				http.Error(w, "Error deleting content", http.StatusInternalServerError)
+				return
+			}
+			audit(db, r, deleteEvent)
+		}
+
+		resolution := action
+		if deleteContent && action != ResolutionDelete {
+			resolution += "+" + ResolutionDelete
+		}
+		result, err := db.Exec(`
+			UPDATE report
+			SET status = ?, resolution = ?, note = ?, resolved_by = ?, resolved_at = NOW()
+			WHERE target_type = ? AND target_id = ? AND status = ?
+		`, status, resolution, note, claims.UserID, targetType, targetID, ReportOpen)
+		if err != nil {
+			log.Printf("Error resolving reports: %v", err)
+			http.Error(w, "Error resolving report", http.StatusInternalServerError)
+			return
+		}
+		resolved, _ := result.RowsAffected()
+		audit(db, r, AuditEvent{
+			Action:     AuditReportResolve,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Before:     map[string]interface{}{"status": ReportOpen, "reports": resolved},
+			After:      map[string]interface{}{"status": status, "resolution": resolution},
+			Reason:     note,
+		})
+
+		http.Redirect(w, r, "/moderation/reports", http.StatusSeeOther)
+	}
+}
+
+// reportedAsSpam indique si un signalement ouvert du contenu a pour motif le spam
+func reportedAsSpam(db store.Store, targetType string, targetID int) bool {
+	var spam bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM report WHERE target_type = ? AND target_id = ? AND status = ? AND reason = 'spam')
+	`, targetType, targetID, ReportOpen).Scan(&spam)
+	if err != nil {
+		log.Printf("Error checking report reasons: %v", err)
+	}
+	return spam
+}
+
+// OpenReportCount renvoie le nombre de contenus signalés en attente
+func OpenReportCount(db store.Store) int {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM (SELECT DISTINCT target_type, target_id FROM report WHERE status = ?) AS pending
+	`, ReportOpen).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting reports: %v", err)
+	}
+	return count
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"forum/store"
+)
+
+// Statut de publication des topics et des messages (colonne status)
+const (
+	ContentPublished = store.StatusPublished
+	ContentPending   = store.StatusPending
+)
+
+// ContentReply désigne une réponse dans la file de validation, les règles de contenu
+// et le journal d'audit ; les topics et messages reprennent ReportTopic et ReportMessage
+const ContentReply = "reply"
+
+// contentTable renvoie la table et la clé primaire d'un type de contenu
+func contentTable(targetType string) (table, column string) {
+	switch targetType {
+	case ReportTopic:
+		return "topic", "topic_id"
+	case ContentReply:
+		return "response", "response_id"
+	}
+	return "message", "message_id"
+}
+
+// Décisions de la file de validation
+const (
+	ReviewApprove = "approve"
+	ReviewReject  = "reject"
+)
+
+// Submission est le résultat de CreateTopic, PostMessage et PostReply : un contenu en attente
+// n'est visible que de son auteur et des modérateurs jusqu'à sa validation
+type Submission struct {
+	ID      int64
+	Pending bool
+	Message string
+}
+
+// reviewStatus décide du statut d'un nouveau contenu : il est mis en attente par une
+// règle de contenu, par le classifieur de spam ou parce que son auteur est un nouveau
+// compte. Il renvoie le statut et le motif de la mise en attente.
+func reviewStatus(db store.Store, userID int, verdict RuleVerdict, text string) (string, string) {
+	if verdict.Action == RuleHold {
+		return ContentPending, verdict.Reason()
+	}
+	if spam := ClassifySpam(db, text); spam.Held() {
+		return ContentPending, spam.Reason()
+	}
+	trust, err := UserTrust(db, userID)
+	if err != nil {
+		log.Printf("Error computing trust level of user %d: %v", userID, err)
+		return ContentPending, trustHeldReason
+	}
+	if trust.Pending() {
+		return ContentPending, trustHeldReason
+	}
+	return ContentPublished, ""
+}
+
+// publishTopic déclenche ce qui accompagne la publication d'un topic
+func publishTopic(db store.Store, userID, topicID int) error {
+	// Abonner l'auteur à son propre topic
+	if err := AutoSubscribe(db, userID, topicID); err != nil {
+		log.Printf("Error subscribing author: %v", err)
+	}
+	DispatchTopicCreated(db, topicID)
+
+	return db.Users().AddTopicCount(userID, 1)
+}
+
+// publishMessage déclenche ce qui accompagne la publication d'un message : mentions,
+// diffusion aux pages ouvertes, webhooks, e-mails aux abonnés et notification de l'auteur du topic
+func publishMessage(db store.Store, userID, topicID int, messageID int64, mentions map[string]int) {
+	NotifyMentions(db, userID, topicID, messageID, mentions)
+	PublishMessage(db, messageID)
+	DispatchMessageCreated(db, messageID)
+
+	// Abonner l'auteur puis prévenir les abonnés par e-mail
+	if err := AutoSubscribe(db, userID, topicID); err != nil {
+		log.Printf("Error subscribing poster: %v", err)
+	}
+	if err := QueueSubscriptionMails(db, topicID, messageID, userID); err != nil {
+		log.Printf("Error queueing subscription mails: %v", err)
+	}
+
+	// L'auteur du topic déjà mentionné n'est pas notifié une seconde fois
+	topic, err := db.Topics().Get(topicID, store.Viewer{Moderator: true})
+	if err != nil {
+		log.Printf("Error fetching topic author: %v", err)
+		return
+	}
+	for _, id := range mentions {
+		if id == topic.UserID {
+			return
+		}
+	}
+	err = CreateNotification(db, Notification{
+		UserID:    topic.UserID,
+		ActorID:   userID,
+		Type:      NotificationReply,
+		TopicID:   topicID,
+		MessageID: int(messageID),
+	})
+	if err != nil {
+		log.Printf("Error creating reply notification: %v", err)
+	}
+}
+
+// publishReply prévient l'auteur du message d'une réponse publiée
+func publishReply(db store.Store, userID int, message store.Message) {
+	err := CreateNotification(db, Notification{
+		UserID:    message.UserID,
+		ActorID:   userID,
+		Type:      NotificationReply,
+		TopicID:   message.TopicID,
+		MessageID: message.ID,
+	})
+	if err != nil {
+		log.Printf("Error creating reply notification: %v", err)
+	}
+}
+
+// ApproveContent publie un topic, un message ou une réponse en attente ; il renvoie
+// false si le contenu n'existe pas ou n'est plus en attente
+func ApproveContent(db store.Store, targetType string, id int) (bool, error) {
+	table, column := contentTable(targetType)
+	result, err := db.Exec("UPDATE "+table+" SET status = ? WHERE "+column+" = ? AND status = ?", ContentPublished, id, ContentPending)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	if targetType == ReportTopic {
+		topic, err := db.Topics().Get(id, store.Viewer{Moderator: true})
+		if err != nil {
+			return true, err
+		}
+		return true, publishTopic(db, topic.UserID, id)
+	}
+
+	if targetType == ContentReply {
+		reply, err := db.Messages().GetReply(id, store.Viewer{Moderator: true})
+		if err != nil {
+			return true, err
+		}
+		message, err := db.Messages().Get(reply.MessageID, store.Viewer{Moderator: true})
+		if err != nil {
+			return true, err
+		}
+		publishReply(db, reply.UserID, message)
+		return true, nil
+	}
+
+	message, err := db.Messages().Get(id, store.Viewer{Moderator: true})
+	if err != nil {
+		return true, err
+	}
+	mentions, err := ResolveMentions(db, ParseMentions(message.Content))
+	if err != nil {
+		return true, err
+	}
+	publishMessage(db, message.UserID, message.TopicID, int64(id), mentions)
+	return true, nil
+}
+
+// PendingItem est un topic, un message ou une réponse en attente de validation
+type PendingItem struct {
+	TargetType string
+	ID         int
+	TopicID    int
+	Title      string
+	Content    template.HTML
+	UserID     int
+	Username   string
+	Trust      Trust
+	Reason     string
+	CreatedAt  string
+	Spam       SpamScore
+}
+
+func fetchPendingItems(db store.Store) ([]PendingItem, error) {
+	rows, err := db.Query(`
+		SELECT 'topic', t.topic_id, t.topic_id, t.title, COALESCE(t.description, ''), u.user_id, u.username,
+		       COALESCE(t.held_reason, ''), t.created_at AS created_at
+		FROM topic t JOIN user u ON t.user_id = u.user_id
+		WHERE t.status = ?
+		UNION ALL
+		SELECT 'message', m.message_id, m.topic_id, t.title, m.content, u.user_id, u.username,
+		       COALESCE(m.held_reason, ''), m.created_at
+		FROM message m
+		JOIN user u ON m.user_id = u.user_id
+		JOIN topic t ON m.topic_id = t.topic_id
+		WHERE m.status = ?
+		UNION ALL
+		SELECT 'reply', r.response_id, m.topic_id, t.title, r.content, u.user_id, u.username,
+		       COALESCE(r.held_reason, ''), r.created_at
+		FROM response r
+		JOIN user u ON r.user_id = u.user_id
+		JOIN message m ON r.message_id = m.message_id
+		JOIN topic t ON m.topic_id = t.topic_id
+		WHERE r.status = ?
+		ORDER BY created_at ASC
+	`, ContentPending, ContentPending, ContentPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []PendingItem
+	for rows.Next() {
+		var item PendingItem
+		var content string
+		if err := rows.Scan(&item.TargetType, &item.ID, &item.TopicID, &item.Title, &content, &item.UserID, &item.Username, &item.Reason, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		item.Content = RenderMarkdown(content)
+		if item.TargetType == ReportTopic {
+			item.Spam = ClassifySpam(db, item.Title+"\n"+content)
+		} else {
+			item.Spam = ClassifySpam(db, content)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Niveau de confiance des auteurs, calculé une fois par auteur
+	trusts := make(map[int]Trust)
+	for i := range items {
+		trust, ok := trusts[items[i].UserID]
+		if !ok {
+			var err error
+			if trust, err = UserTrust(db, items[i].UserID); err != nil {
+				return nil, err
+			}
+			trusts[items[i].UserID] = trust
+		}
+		items[i].Trust = trust
+	}
+	return items, nil
+}
+
+// PendingCount renvoie le nombre de contenus en attente de validation
+func PendingCount(db store.Store) int {
+	var count int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM topic WHERE status = ?) + (SELECT COUNT(*) FROM message WHERE status = ?)
+		     + (SELECT COUNT(*) FROM response WHERE status = ?)
+	`, ContentPending, ContentPending, ContentPending).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting pending content: %v", err)
+	}
+	return count
+}
+
+// PendingQueueHandler affiche les topics, messages et réponses en attente de validation
+func PendingQueueHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		items, err := fetchPendingItems(db)
+		if err != nil {
+			log.Printf("Error fetching pending content: %v", err)
+			http.Error(w, "Error fetching pending content", http.StatusInternalServerError)
+			return
+		}
+		spamDocs, hamDocs := SpamModelStats(db)
+
+		tmpl, err := template.ParseFiles("templates/pending.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, struct {
+			Username    string
+			Items       []PendingItem
+			SpamDocs    int
+			HamDocs     int
+			SpamMinDocs int
+		}{
+			Username:    claims.Username,
+			Items:       items,
+			SpamDocs:    spamDocs,
+			HamDocs:     hamDocs,
+			SpamMinDocs: spamMinDocs,
+		})
+	}
+}
+
+// maxBulkReview limite le nombre de contenus traités en une fois
+const maxBulkReview = 200
+
+// reviewTarget désigne un contenu de la file de validation
+type reviewTarget struct {
+	Type string
+	ID   int
+}
+
+// parseReviewTargets lit les contenus cochés (items=topic:12, items=message:34, items=reply:56)
+// ou, à défaut, le contenu unique (target_type, id)
+func parseReviewTargets(r *http.Request) ([]reviewTarget, bool) {
+	r.ParseForm()
+	values := r.Form["items"]
+	if len(values) == 0 {
+		values = []string{r.FormValue("target_type") + ":" + r.FormValue("id")}
+	}
+	if len(values) > maxBulkReview {
+		return nil, false
+	}
+
+	targets := make([]reviewTarget, 0, len(values))
+	for _, value := range values {
+		targetType, rawID, _ := strings.Cut(value, ":")
+		id, err := strconv.Atoi(rawID)
+		if err != nil || (targetType != ReportTopic && targetType != ReportMessage && targetType != ContentReply) {
+			return nil, false
+		}
+		targets = append(targets, reviewTarget{Type: targetType, ID: id})
+	}
+	return targets, true
+}
+
+// reviewContent publie ou refuse un contenu en attente ; un contenu qui n'est plus
+// en attente est ignoré
+func reviewContent(db store.Store, r *http.Request, moderatorID int, target reviewTarget, action, note string) error {
+	table, column := contentTable(target.Type)
+	var status string
+	err := db.QueryRow("SELECT status FROM "+table+" WHERE "+column+" = ?", target.ID).Scan(&status)
+	if err == store.ErrNotFound || (err == nil && status != ContentPending) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	label, snapshot := contentSnapshot(db, target.Type, target.ID)
+	event := AuditEvent{
+		TargetType:  target.Type,
+		TargetID:    target.ID,
+		TargetLabel: label,
+		Before:      snapshot,
+		Reason:      note,
+	}
+
+	if action == ReviewApprove {
+		approved, err := ApproveContent(db, target.Type, target.ID)
+		if !approved {
+			return err
+		}
+		trainSpamDecision(db, moderatorID, target.Type, target.ID, false)
+		event.Action = AuditContentApprove
+		event.After = map[string]string{"status": ContentPublished}
+		audit(db, r, event)
+		return err
+	}
+
+	// Le classifieur apprend de la décision avant la suppression
+	trainSpamDecision(db, moderatorID, target.Type, target.ID, true)
+	switch target.Type {
+	case ReportTopic:
+		err = DeleteTopic(db, target.ID)
+	case ContentReply:
+		err = DeleteReply(db, target.ID)
+	default:
+		err = DeleteMessage(db, target.ID)
+	}
+	if err != nil {
+		return err
+	}
+	event.Action = AuditContentReject
+	audit(db, r, event)
+	return nil
+}
+
+// ReviewPendingHandler valide ou refuse (action, note) un contenu en attente
+// (target_type, id) ou une sélection de contenus (items) ; un contenu refusé est supprimé
+func ReviewPendingHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		targets, ok := parseReviewTargets(r)
+		if !ok {
+			http.Error(w, "Invalid target", http.StatusBadRequest)
+			return
+		}
+		action := r.FormValue("action")
+		if action != ReviewApprove && action != ReviewReject {
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+		note := strings.TrimSpace(r.FormValue("note"))
+
+		for _, target := range targets {
+			if err := reviewContent(db, r, claims.UserID, target, action, note); err != nil {
+				log.Printf("Error reviewing %s %d: %v", target.Type, target.ID, err)
+				http.Error(w, "Error reviewing content", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		http.Redirect(w, r, "/moderation/pending", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"forum/store"
+)
+
+// Rôles (table role), du moins au plus privilégié
+const (
+	RoleUser      = 1
+	RoleModerator = 2
+	RoleAdmin     = 3
+)
+
+// États d'un topic (table state)
+const (
+	StateOpen     = 1
+	StateClosed   = 2
+	StateArchived = 3
+)
+
+// TopicStates liste les états proposés aux modérateurs
+var TopicStates = []struct {
+	ID    int
+	Label string
+}{
+	{StateOpen, "Ouvert"},
+	{StateClosed, "Fermé"},
+	{StateArchived, "Archivé"},
+}
+
+// ErrTopicClosed est renvoyée quand on poste dans un topic qui n'est pas ouvert
+var ErrTopicClosed = errors.New("topic is not open")
+
+// Roles liste les rôles proposés aux administrateurs
+var Roles = []struct {
+	ID    int
+	Label string
+}{
+	{RoleUser, "Utilisateur"},
+	{RoleModerator, "Modérateur"},
+	{RoleAdmin, "Administrateur"},
+}
+
+// UserRole renvoie le rôle de l'utilisateur (RoleUser par défaut)
+func UserRole(db store.Store, userID int) (int, error) {
+	role, err := db.Users().Role(userID)
+	if err != nil {
+		return 0, err
+	}
+	if role == 0 {
+		return RoleUser, nil
+	}
+	return role, nil
+}
+
+// UserViewer renvoie l'utilisateur tel que le voient les dépôts, modérateur ou non
+func UserViewer(db store.Store, userID int) (store.Viewer, error) {
+	role, err := UserRole(db, userID)
+	if err != nil {
+		return store.Viewer{}, err
+	}
+	return store.Viewer{UserID: userID, Moderator: role >= RoleModerator}, nil
+}
+
+// RequireRole réserve le handler aux utilisateurs ayant au moins le rôle demandé
+func RequireRole(db store.Store, minRole int, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+		exists, err := accountExists(db, claims.UserID)
+		if err != nil {
+			log.Printf("Error checking account %d: %v", claims.UserID, err)
+			http.Error(w, "Error checking account", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if rejectSanctioned(db, w, claims.UserID) {
+			return
+		}
+
+		role, err := UserRole(db, claims.UserID)
+		if err != nil {
+			log.Printf("Error fetching user role: %v", err)
+			http.Error(w, "Error checking permissions", http.StatusInternalServerError)
+			return
+		}
+		if role < minRole {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// ChangeTopicState passe le topic dans l'état stateID et prévient les clients connectés
+// et les webhooks ; moderatorID vaut 0 pour un changement fait en ligne de commande
+func ChangeTopicState(db store.Store, topic Topic, stateID, moderatorID int) error {
+	if err := db.Topics().SetState(topic.ID, stateID); err != nil {
+		return err
+	}
+	PublishTopicState(topic.ID, stateID)
+	DispatchTopicStateChanged(db, topic.ID, topic.StateID, stateID, moderatorID)
+	return nil
+}
+
+// SetTopicStateHandler permet aux modérateurs d'ouvrir, fermer ou archiver un topic
+func SetTopicStateHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		topicID, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+			return
+		}
+		stateID, err := strconv.Atoi(r.FormValue("state"))
+		if err != nil || stateID < StateOpen || stateID > StateArchived {
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+
+		topic, err := db.Topics().Get(topicID, store.Viewer{Moderator: true})
+		if err == store.ErrNotFound {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching topic state: %v", err)
+			http.Error(w, "Error fetching topic", http.StatusInternalServerError)
+			return
+		}
+
+		if topic.StateID != stateID {
+			if err := ChangeTopicState(db, topic, stateID, claims.UserID); err != nil {
+				log.Printf("Error updating topic state: %v", err)
+				http.Error(w, "Error updating topic", http.StatusInternalServerError)
+				return
+			}
+			audit(db, r, AuditEvent{
+				Action:      AuditTopicState,
+				TargetType:  AuditTargetTopic,
+				TargetID:    topicID,
+				TargetLabel: topic.Title,
+				Before:      map[string]int{"state": topic.StateID},
+				After:       map[string]int{"state": stateID},
+				Reason:      strings.TrimSpace(r.FormValue("reason")),
+			})
+		}
+
+		http.Redirect(w, r, "/topic?id="+strconv.Itoa(topicID), http.StatusSeeOther)
+	}
+}
+
+// SetUserRoleHandler permet aux administrateurs de changer le rôle d'un compte (user_id, role, reason)
+func SetUserRoleHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := strconv.Atoi(r.FormValue("user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		role, err := strconv.Atoi(r.FormValue("role"))
+		if err != nil || role < RoleUser || role > RoleAdmin {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		// Un administrateur ne peut pas se retirer ses propres droits
+		if userID == claims.UserID {
+			http.Error(w, "Cannot change your own role", http.StatusForbidden)
+			return
+		}
+
+		profile, err := db.Users().Profile(userID)
+		if err == store.ErrNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching user: %v", err)
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			return
+		}
+		previous, err := UserRole(db, userID)
+		if err != nil {
+			log.Printf("Error fetching user role: %v", err)
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			return
+		}
+
+		if previous != role {
+			if err := db.Users().SetRole(userID, role); err != nil {
+				log.Printf("Error updating user role: %v", err)
+				http.Error(w, "Error updating role", http.StatusInternalServerError)
+				return
+			}
+			audit(db, r, AuditEvent{
+				Action:      AuditUserRole,
+				TargetType:  AuditTargetUser,
+				TargetID:    userID,
+				TargetLabel: profile.Username,
+				Before:      map[string]int{"role": previous},
+				After:       map[string]int{"role": role},
+				Reason:      strings.TrimSpace(r.FormValue("reason")),
+			})
+		}
+
+		http.Redirect(w, r, "/user?name="+url.QueryEscape(profile.Username), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,731 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"forum/store"
+)
+
+// Types de règle de contenu
+const (
+	RuleWord   = "word"   // liste de mots ou d'expressions, un par ligne, sans tenir compte de la casse
+	RuleRegex  = "regex"  // expression régulière Go (RE2)
+	RuleDomain = "domain" // liste de domaines, un par ligne ; les sous-domaines sont couverts
+)
+
+// Actions d'une règle de contenu, de la plus à la moins sévère
+const (
+	RuleReject  = "reject"
+	RuleHold    = "hold"
+	RuleRewrite = "rewrite"
+)
+
+const (
+	defaultRuleReplacement = "***"
+	maxRuleExcerpt         = 120
+	ruleHitsShown          = 50
+)
+
+// RuleKinds et RuleActions alimentent les listes de la page d'administration
+var RuleKinds = []struct {
+	ID    string
+	Label string
+}{
+	{RuleWord, "Mots ou expressions"},
+	{RuleRegex, "Expression régulière"},
+	{RuleDomain, "Domaines de liens"},
+}
+
+var RuleActions = []struct {
+	ID    string
+	Label string
+}{
+	{RuleReject, "Refuser"},
+	{RuleHold, "Mettre en attente de validation"},
+	{RuleRewrite, "Réécrire"},
+}
+
+// ContentRule est une règle appliquée aux nouveaux topics et messages. Une règle
+// en essai (DryRun) n'agit pas : ses correspondances sont seulement journalisées.
+type ContentRule struct {
+	ID          int
+	Kind        string
+	Pattern     string
+	Action      string
+	Replacement string
+	Message     string // affiché à l'auteur en cas de refus ou de mise en attente
+	Enabled     bool
+	DryRun      bool
+	UpdatedAt   string
+	Hits        int
+
+	matcher ruleMatcher
+}
+
+// ruleMatcher renvoie les positions [début, fin) des passages correspondants
+type ruleMatcher interface {
+	find(text string) [][]int
+}
+
+// wordMatcher compare chaque mot du texte à la liste ; les entrées de plusieurs
+// mots sont cherchées comme expressions
+type wordMatcher struct {
+	words   map[string]bool
+	phrases []*regexp.Regexp
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+
+func (m *wordMatcher) find(text string) [][]int {
+	var spans [][]int
+	for _, span := range wordPattern.FindAllStringIndex(text, -1) {
+		if m.words[strings.ToLower(text[span[0]:span[1]])] {
+			spans = append(spans, span)
+		}
+	}
+	for _, phrase := range m.phrases {
+		spans = append(spans, phrase.FindAllStringIndex(text, -1)...)
+	}
+	return spans
+}
+
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m *regexMatcher) find(text string) [][]int {
+	return m.re.FindAllStringIndex(text, -1)
+}
+
+// domainMatcher repère les liens (http, https ou www.) vers les domaines listés
+type domainMatcher struct {
+	domains []string
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>()\[\]"']+|\bwww\.[^\s<>()\[\]"']+`)
+
+func (m *domainMatcher) find(text string) [][]int {
+	var spans [][]int
+	for _, span := range linkPattern.FindAllStringIndex(text, -1) {
+		link := text[span[0]:span[1]]
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		parsed, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(parsed.Hostname())
+		for _, domain := range m.domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				spans = append(spans, span)
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// ruleLines découpe une liste saisie par l'administrateur (une entrée par ligne)
+func ruleLines(pattern string) []string {
+	var lines []string
+	for _, line := range strings.Split(pattern, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// compileRule prépare la règle ; l'erreur est affichée à l'administrateur
+func compileRule(rule *ContentRule) error {
+	switch rule.Kind {
+	case RuleWord:
+		m := &wordMatcher{words: map[string]bool{}}
+		for _, entry := range ruleLines(rule.Pattern) {
+			entry = strings.ToLower(entry)
+			if wordPattern.FindString(entry) == entry {
+				m.words[entry] = true
+			} else {
+				// Les mots d'une expression peuvent être séparés par plusieurs espaces
+				words := strings.Fields(entry)
+				for i, word := range words {
+					words[i] = regexp.QuoteMeta(word)
+				}
+				m.phrases = append(m.phrases, regexp.MustCompile(`(?i)`+strings.Join(words, `\s+`)))
+			}
+		}
+		if len(m.words) == 0 && len(m.phrases) == 0 {
+			return fmt.Errorf("la liste de mots est vide")
+		}
+		rule.matcher = m
+	case RuleRegex:
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("expression régulière invalide : %v", err)
+		}
+		if re.MatchString("") {
+			return fmt.Errorf("l'expression régulière correspond au texte vide")
+		}
+		rule.matcher = &regexMatcher{re: re}
+	case RuleDomain:
+		m := &domainMatcher{}
+		for _, entry := range ruleLines(rule.Pattern) {
+			domain, ok := normalizeBanValue(BanEmailDomain, entry)
+			if !ok {
+				return fmt.Errorf("domaine invalide : %s", entry)
+			}
+			m.domains = append(m.domains, domain)
+		}
+		if len(m.domains) == 0 {
+			return fmt.Errorf("la liste de domaines est vide")
+		}
+		rule.matcher = m
+	default:
+		return fmt.Errorf("type de règle inconnu")
+	}
+	return nil
+}
+
+// RuleMatch décrit une correspondance d'une règle sur un contenu
+type RuleMatch struct {
+	RuleID  int
+	Kind    string
+	Action  string
+	DryRun  bool
+	Excerpt string
+}
+
+// RuleVerdict est le résultat de l'application des règles : l'action la plus
+// sévère parmi les règles actives (vide si aucune), et toutes les correspondances
+type RuleVerdict struct {
+	Action  string
+	RuleID  int
+	Message string
+	Matches []RuleMatch
+}
+
+// Reason résume la règle décisive pour la file de modération
+func (v RuleVerdict) Reason() string {
+	for _, match := range v.Matches {
+		if match.RuleID == v.RuleID && !match.DryRun {
+			return fmt.Sprintf("Règle n°%d (%s) : « %s »", match.RuleID, match.Kind, match.Excerpt)
+		}
+	}
+	return ""
+}
+
+var actionSeverity = map[string]int{RuleRewrite: 1, RuleHold: 2, RuleReject: 3}
+
+// evaluateRules applique les règles aux champs ; les réécritures modifient les champs
+// en place. Avec dryRunAsLive, les règles en essai agissent aussi (page de test).
+func evaluateRules(rules []*ContentRule, fields []*string, dryRunAsLive bool) RuleVerdict {
+	var verdict RuleVerdict
+	for _, rule := range rules {
+		live := !rule.DryRun || dryRunAsLive
+		for _, field := range fields {
+			spans := rule.matcher.find(*field)
+			if len(spans) == 0 {
+				continue
+			}
+			sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+			verdict.Matches = append(verdict.Matches, RuleMatch{
+				RuleID:  rule.ID,
+				Kind:    rule.Kind,
+				Action:  rule.Action,
+				DryRun:  rule.DryRun,
+				Excerpt: truncate((*field)[spans[0][0]:spans[0][1]], maxRuleExcerpt),
+			})
+			if !live {
+				continue
+			}
+			if rule.Action == RuleRewrite {
+				*field = replaceSpans(*field, spans, rule.Replacement)
+			}
+			if actionSeverity[rule.Action] > actionSeverity[verdict.Action] {
+				verdict.Action = rule.Action
+				verdict.RuleID = rule.ID
+				verdict.Message = rule.Message
+			}
+		}
+	}
+	return verdict
+}
+
+// replaceSpans remplace les passages triés, en ignorant ceux qui en chevauchent un autre
+func replaceSpans(text string, spans [][]int, replacement string) string {
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		if span[0] < last {
+			continue
+		}
+		b.WriteString(text[last:span[0]])
+		b.WriteString(replacement)
+		last = span[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// ruleCache garde en mémoire les règles actives compilées. Il est rechargé
+// immédiatement après une modification faite sur cette instance, et la tâche
+// "content-rules" détecte les modifications faites par les autres instances.
+type ruleCache struct {
+	mu        sync.RWMutex
+	rules     []*ContentRule
+	signature string
+	loaded    bool
+}
+
+var contentRules ruleCache
+
+func ruleSignature(db store.Store) (string, error) {
+	var count, sum int
+	var updated string
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(rule_id), 0), COALESCE(MAX(updated_at), '') FROM content_rule
+	`).Scan(&count, &sum, &updated)
+	return fmt.Sprintf("%d/%d/%s", count, sum, updated), err
+}
+
+func fetchContentRules(db store.Store, enabledOnly bool) ([]*ContentRule, error) {
+	query := `
+		SELECT r.rule_id, r.kind, r.pattern, r.action, COALESCE(r.replacement, ''), COALESCE(r.message, ''),
+		       r.enabled, r.dry_run, r.updated_at,
+		       (SELECT COUNT(*) FROM content_rule_hit h WHERE h.rule_id = r.rule_id)
+		FROM content_rule r`
+	if enabledOnly {
+		query += " WHERE r.enabled = TRUE"
+	}
+	rows, err := db.Query(query + " ORDER BY r.rule_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*ContentRule
+	for rows.Next() {
+		var rule ContentRule
+		err := rows.Scan(&rule.ID, &rule.Kind, &rule.Pattern, &rule.Action, &rule.Replacement, &rule.Message,
+			&rule.Enabled, &rule.DryRun, &rule.UpdatedAt, &rule.Hits)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, rows.Err()
+}
+
+// reload recharge les règles si elles ont changé en base (ou si force est vrai)
+func (c *ruleCache) reload(db store.Store, force bool) error {
+	signature, err := ruleSignature(db)
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	unchanged := c.loaded && c.signature == signature
+	c.mu.RUnlock()
+	if unchanged && !force {
+		return nil
+	}
+
+	rules, err := fetchContentRules(db, true)
+	if err != nil {
+		return err
+	}
+	compiled := rules[:0]
+	for _, rule := range rules {
+		if err := compileRule(rule); err != nil {
+			log.Printf("Skipping content rule %d: %v", rule.ID, err)
+			continue
+		}
+		compiled = append(compiled, rule)
+	}
+
+	c.mu.Lock()
+	c.rules, c.signature, c.loaded = compiled, signature, true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ruleCache) get(db store.Store) ([]*ContentRule, error) {
+	c.mu.RLock()
+	rules, loaded := c.rules, c.loaded
+	c.mu.RUnlock()
+	if loaded {
+		return rules, nil
+	}
+	if err := c.reload(db, true); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rules, nil
+}
+
+// RefreshContentRules renvoie la tâche périodique qui recharge les règles modifiées
+func RefreshContentRules(db store.Store) func() {
+	return func() {
+		if err := contentRules.reload(db, false); err != nil {
+			log.Printf("Error refreshing content rules: %v", err)
+		}
+	}
+}
+
+// ContentRejectedError est renvoyée quand une règle refuse un topic ou un message
+type ContentRejectedError struct {
+	Message string
+}
+
+func (e *ContentRejectedError) Error() string {
+	return "content rejected: " + e.Message
+}
+
+// UserMessage renvoie l'explication à afficher à l'auteur
+func (e *ContentRejectedError) UserMessage() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return "Ce contenu n'est pas autorisé sur le forum."
+}
+
+// ApplyContentRules applique les règles actives aux champs d'un nouveau contenu
+// (targetType : topic, message ou reply) et journalise toutes les correspondances
+func ApplyContentRules(db store.Store, userID int, targetType string, fields ...*string) (RuleVerdict, error) {
+	rules, err := contentRules.get(db)
+	if err != nil {
+		return RuleVerdict{}, err
+	}
+
+	verdict := evaluateRules(rules, fields, false)
+	for _, match := range verdict.Matches {
+		_, err := db.Exec(`
+			INSERT INTO content_rule_hit (rule_id, user_id, target_type, action, dry_run, excerpt)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, match.RuleID, userID, targetType, match.Action, match.DryRun, match.Excerpt)
+		if err != nil {
+			log.Printf("Error recording content rule hit: %v", err)
+		}
+	}
+	return verdict, nil
+}
+
+// RuleHit est une correspondance journalisée
+type RuleHit struct {
+	RuleID     int
+	Username   string
+	TargetType string
+	Action     string
+	DryRun     bool
+	Excerpt    string
+	CreatedAt  string
+}
+
+func fetchRuleHits(db store.Store, limit int) ([]RuleHit, error) {
+	rows, err := db.Query(`
+		SELECT h.rule_id, COALESCE(u.username, ''), h.target_type, h.action, h.dry_run, h.excerpt, h.created_at
+		FROM content_rule_hit h
+		LEFT JOIN user u ON h.user_id = u.user_id
+		ORDER BY h.hit_id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []RuleHit
+	for rows.Next() {
+		var hit RuleHit
+		if err := rows.Scan(&hit.RuleID, &hit.Username, &hit.TargetType, &hit.Action, &hit.DryRun, &hit.Excerpt, &hit.CreatedAt); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// ruleTest est le résultat de la page de test : ce qui se passerait pour le texte
+// saisi, en faisant agir aussi les règles en essai
+type ruleTest struct {
+	Text    string
+	Result  string
+	Verdict RuleVerdict
+}
+
+// ruleForm alimente le formulaire d'édition d'une règle (template "rule-fields")
+type ruleForm struct {
+	Rule    *ContentRule
+	Kinds   interface{}
+	Actions interface{}
+}
+
+// ContentRulesPageHandler affiche les règles, les dernières correspondances et
+// le formulaire de test (?test=...)
+func ContentRulesPageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		rules, err := fetchContentRules(db, false)
+		if err != nil {
+			log.Printf("Error fetching content rules: %v", err)
+			http.Error(w, "Error fetching rules", http.StatusInternalServerError)
+			return
+		}
+		hits, err := fetchRuleHits(db, ruleHitsShown)
+		if err != nil {
+			log.Printf("Error fetching content rule hits: %v", err)
+			http.Error(w, "Error fetching rules", http.StatusInternalServerError)
+			return
+		}
+
+		var test *ruleTest
+		if text := r.URL.Query().Get("test"); text != "" {
+			var active []*ContentRule
+			for _, rule := range rules {
+				if rule.Enabled && compileRule(rule) == nil {
+					active = append(active, rule)
+				}
+			}
+			result := text
+			test = &ruleTest{Text: text, Verdict: evaluateRules(active, []*string{&result}, true)}
+			test.Result = result
+		}
+
+		forms := make([]ruleForm, len(rules))
+		for i, rule := range rules {
+			forms[i] = ruleForm{Rule: rule, Kinds: RuleKinds, Actions: RuleActions}
+		}
+		data := struct {
+			Username string
+			Rules    []ruleForm
+			New      ruleForm
+			Hits     []RuleHit
+			Test     *ruleTest
+			Error    string
+		}{
+			Username: claims.Username,
+			Rules:    forms,
+			New: ruleForm{
+				Rule:    &ContentRule{Kind: RuleWord, Action: RuleReject, Enabled: true, DryRun: true},
+				Kinds:   RuleKinds,
+				Actions: RuleActions,
+			},
+			Hits:  hits,
+			Test:  test,
+			Error: r.URL.Query().Get("error"),
+		}
+
+		tmpl, err := template.ParseFiles("templates/rules.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+	}
+}
+
+func rulesRedirect(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/admin/rules"
+	if errMsg != "" {
+		target += "?error=" + url.QueryEscape(errMsg)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func ruleSnapshot(rule *ContentRule) map[string]interface{} {
+	return map[string]interface{}{
+		"kind": rule.Kind, "pattern": rule.Pattern, "action": rule.Action, "replacement": rule.Replacement,
+		"message": rule.Message, "enabled": rule.Enabled, "dry_run": rule.DryRun,
+	}
+}
+
+func fetchContentRule(db store.Store, ruleID int) (*ContentRule, error) {
+	var rule ContentRule
+	err := db.QueryRow(`
+		SELECT rule_id, kind, pattern, action, COALESCE(replacement, ''), COALESCE(message, ''), enabled, dry_run, updated_at
+		FROM content_rule WHERE rule_id = ?
+	`, ruleID).Scan(&rule.ID, &rule.Kind, &rule.Pattern, &rule.Action, &rule.Replacement, &rule.Message,
+		&rule.Enabled, &rule.DryRun, &rule.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// SaveContentRuleHandler crée (sans id) ou modifie une règle
+// (kind, pattern, action, replacement, message, enabled, dry_run)
+func SaveContentRuleHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rule := &ContentRule{
+			Kind:        r.FormValue("kind"),
+			Pattern:     strings.TrimSpace(r.FormValue("pattern")),
+			Action:      r.FormValue("action"),
+			Replacement: r.FormValue("replacement"),
+			Message:     strings.TrimSpace(r.FormValue("message")),
+			Enabled:     r.FormValue("enabled") != "",
+			DryRun:      r.FormValue("dry_run") != "",
+		}
+		if _, ok := actionSeverity[rule.Action]; !ok {
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+		if rule.Action == RuleRewrite && rule.Replacement == "" {
+			rule.Replacement = defaultRuleReplacement
+		}
+		if utf8.RuneCountInString(rule.Message) > 255 || utf8.RuneCountInString(rule.Replacement) > 255 {
+			rulesRedirect(w, r, "Le message et le remplacement sont limités à 255 caractères")
+			return
+		}
+		if err := compileRule(rule); err != nil {
+			rulesRedirect(w, r, err.Error())
+			return
+		}
+
+		var previous *ContentRule
+		var err error
+		if id := r.FormValue("id"); id != "" {
+			rule.ID, err = strconv.Atoi(id)
+			if err != nil {
+				http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+				return
+			}
+			previous, err = fetchContentRule(db, rule.ID)
+			if err == store.ErrNotFound {
+				http.Error(w, "Rule not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error fetching content rule: %v", err)
+				http.Error(w, "Error saving rule", http.StatusInternalServerError)
+				return
+			}
+			_, err = db.Exec(`
+				UPDATE content_rule
+				SET kind = ?, pattern = ?, action = ?, replacement = ?, message = ?, enabled = ?, dry_run = ?
+				WHERE rule_id = ?
+			`, rule.Kind, rule.Pattern, rule.Action, rule.Replacement, rule.Message, rule.Enabled, rule.DryRun, rule.ID)
+		} else {
+			var claims *Claims
+			claims, err = currentClaims(r)
+			if err != nil {
+				http.Error(w, "Not authenticated", http.StatusUnauthorized)
+				return
+			}
+			var result store.Result
+			result, err = db.Exec(`
+				INSERT INTO content_rule (kind, pattern, action, replacement, message, enabled, dry_run, created_by)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			`, rule.Kind, rule.Pattern, rule.Action, rule.Replacement, rule.Message, rule.Enabled, rule.DryRun, claims.UserID)
+			if err == nil {
+				var id int64
+				id, err = result.LastInsertId()
+				rule.ID = int(id)
+			}
+		}
+		if err != nil {
+			log.Printf("Error saving content rule: %v", err)
+			http.Error(w, "Error saving rule", http.StatusInternalServerError)
+			return
+		}
+
+		event := AuditEvent{
+			Action:      AuditRuleCreate,
+			TargetType:  AuditTargetRule,
+			TargetID:    rule.ID,
+			TargetLabel: rule.Kind + " → " + rule.Action,
+			After:       ruleSnapshot(rule),
+		}
+		if previous != nil {
+			event.Action = AuditRuleUpdate
+			event.Before = ruleSnapshot(previous)
+		}
+		audit(db, r, event)
+
+		if err := contentRules.reload(db, true); err != nil {
+			log.Printf("Error reloading content rules: %v", err)
+		}
+		rulesRedirect(w, r, "")
+	}
+}
+
+// DeleteContentRuleHandler supprime une règle et ses correspondances (id)
+func DeleteContentRuleHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ruleID, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+			return
+		}
+		rule, err := fetchContentRule(db, ruleID)
+		if err == store.ErrNotFound {
+			rulesRedirect(w, r, "")
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching content rule: %v", err)
+			http.Error(w, "Error deleting rule", http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Error deleting content rule: %v", err)
+			http.Error(w, "Error deleting rule", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		for _, statement := range []string{
+			"DELETE FROM content_rule_hit WHERE rule_id = ?",
+			"DELETE FROM content_rule WHERE rule_id = ?",
+		} {
+			if _, err := tx.Exec(statement, ruleID); err != nil {
+				log.Printf("Error deleting content rule: %v", err)
+				http.Error(w, "Error deleting rule", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error deleting content rule: %v", err)
+			http.Error(w, "Error deleting rule", http.StatusInternalServerError)
+			return
+		}
+		audit(db, r, AuditEvent{
+			Action:      AuditRuleDelete,
+			TargetType:  AuditTargetRule,
+			TargetID:    ruleID,
+			TargetLabel: rule.Kind + " → " + rule.Action,
+			Before:      ruleSnapshot(rule),
+		})
+
+		if err := contentRules.reload(db, true); err != nil {
+			log.Printf("Error reloading content rules: %v", err)
+		}
+		rulesRedirect(w, r, "")
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestApplyContentRules vérifie, à travers la création d'un topic par l'API, que
+// chaque action des règles publie, retient, réécrit ou refuse le contenu, et que
+// toutes les correspondances sont journalisées
+func TestApplyContentRules(t *testing.T) {
+	cases := []struct {
+		name    string
+		kind    string
+		pattern string
+		action  string
+		dryRun  bool
+		title   string
+		status  int    // statut HTTP de la création
+		content string // statut du topic créé, vide si refusé
+		held    bool   // une raison de mise en attente est enregistrée
+		message string // message d'erreur attendu en cas de refus
+		want    string // titre enregistré
+		hits    int
+	}{
+		{name: "aucune correspondance", kind: RuleWord, pattern: "casino", action: RuleHold,
+			title: "Un sujet ordinaire", status: http.StatusCreated, content: ContentPublished, want: "Un sujet ordinaire"},
+		{name: "mot retenu", kind: RuleWord, pattern: "casino", action: RuleHold,
+			title: "Le CASINO en ligne", status: http.StatusCreated, content: ContentPending, held: true, want: "Le CASINO en ligne", hits: 1},
+		{name: "expression retenue", kind: RuleRegex, pattern: `(?i)gagne[rz]\s+\d+`, action: RuleHold,
+			title: "Gagnez 1000 euros", status: http.StatusCreated, content: ContentPending, held: true, want: "Gagnez 1000 euros", hits: 1},
+		{name: "domaine refusé", kind: RuleDomain, pattern: "spam.example", action: RuleReject,
+			title: "Voir https://www.spam.example/offre", status: http.StatusUnprocessableEntity, message: "Lien interdit", hits: 1},
+		{name: "refus en essai", kind: RuleDomain, pattern: "spam.example", action: RuleReject, dryRun: true,
+			title: "Voir https://spam.example", status: http.StatusCreated, content: ContentPublished, want: "Voir https://spam.example", hits: 1},
+		{name: "mot réécrit", kind: RuleWord, pattern: "zut", action: RuleRewrite,
+			title: "Zut alors", status: http.StatusCreated, content: ContentPublished, want: "*** alors", hits: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			replacement := ""
+			if c.action == RuleRewrite {
+				replacement = defaultRuleReplacement
+			}
+			_, err := api.db.Exec(`
+				INSERT INTO content_rule (kind, pattern, action, replacement, message, enabled, dry_run, created_by)
+				VALUES (?, ?, ?, ?, ?, TRUE, ?, ?)
+			`, c.kind, c.pattern, c.action, replacement, "Lien interdit", c.dryRun, api.adminID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := json.Marshal(TopicInput{Title: c.title})
+			resp, data := api.do(t, http.MethodPost, "/topics", api.token, string(body))
+			if resp.StatusCode != c.status {
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, c.status, data)
+			}
+			if c.message != "" && !strings.Contains(string(data), c.message) {
+				t.Errorf("body %s, want message %q", data, c.message)
+			}
+
+			var hits int
+			if err := api.db.QueryRow("SELECT COUNT(*) FROM content_rule_hit").Scan(&hits); err != nil {
+				t.Fatal(err)
+			}
+			if hits != c.hits {
+				t.Errorf("%d rule hits, want %d", hits, c.hits)
+			}
+
+			var topics int
+			if err := api.db.QueryRow("SELECT COUNT(*) FROM topic").Scan(&topics); err != nil {
+				t.Fatal(err)
+			}
+			if c.content == "" {
+				if topics != 0 {
+					t.Errorf("%d topics created, want none", topics)
+				}
+				return
+			}
+
+			var title, status string
+			var reason *string
+			err = api.db.QueryRow("SELECT title, status, held_reason FROM topic").Scan(&title, &status, &reason)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if title != c.want || status != c.content {
+				t.Errorf("topic %q (%s), want %q (%s)", title, status, c.want, c.content)
+			}
+			held := reason != nil && strings.HasPrefix(*reason, "Règle n°")
+			if held != c.held {
+				t.Errorf("held by a rule: %v, want %v", held, c.held)
+			}
+		})
+	}
+}
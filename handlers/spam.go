@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"forum/config"
+	"forum/store"
+)
+
+// Classifieur bayésien naïf des nouveaux contenus. Il apprend des décisions des
+// modérateurs (file des signalements et file de validation) ; le modèle est
+// conservé en base (spam_token, spam_training) et rechargé au démarrage.
+const (
+	spamMinDocs          = 10  // exemples de chaque classe avant de retenir des contenus
+	spamInterestingCount = 15  // jetons les plus significatifs retenus pour le score
+	spamTopTokensShown   = 8   // jetons affichés aux modérateurs
+	spamDefaultThreshold = 0.9 // score à partir duquel un contenu est mis en attente
+	spamMinTokenRunes    = 3
+	spamMaxTokenRunes    = 30
+	spamProbabilityFloor = 0.01 // borne les probabilités pour qu'un jeton ne décide pas seul
+)
+
+// SpamToken est un jeton du contenu et sa probabilité de spam selon le modèle
+type SpamToken struct {
+	Token       string
+	Probability float64
+}
+
+// SpamScore est le résultat du classifieur pour un contenu
+type SpamScore struct {
+	Score   float64
+	Tokens  []SpamToken // les plus significatifs, du plus au moins marqué
+	Trained bool        // faux tant que le modèle n'a pas assez d'exemples
+}
+
+// Percent renvoie le score en pourcentage arrondi
+func (s SpamScore) Percent() int {
+	return int(math.Round(s.Score * 100))
+}
+
+// Held indique si le contenu doit être mis en attente de validation
+func (s SpamScore) Held() bool {
+	return s.Trained && s.Score >= spamThreshold()
+}
+
+// Reason résume le score pour la file de validation
+func (s SpamScore) Reason() string {
+	words := make([]string, 0, len(s.Tokens))
+	for _, token := range s.Tokens {
+		if token.Probability > 0.5 {
+			words = append(words, token.Token)
+		}
+	}
+	return truncate(fmt.Sprintf("Spam probable (%d %%) : %s", s.Percent(), strings.Join(words, ", ")), 255)
+}
+
+// spamThreshold lit SPAM_HOLD_THRESHOLD (entre 0 et 1)
+func spamThreshold() float64 {
+	threshold, err := strconv.ParseFloat(config.Env("SPAM_HOLD_THRESHOLD", ""), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		return spamDefaultThreshold
+	}
+	return threshold
+}
+
+// spamTokens découpe un texte en jetons distincts : mots en minuscules et
+// domaines des liens (préfixés par "url:")
+func spamTokens(text string) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		if parsed, err := url.Parse(link); err == nil && parsed.Hostname() != "" {
+			add("url:" + strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www."))
+		}
+	}
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		length := utf8.RuneCountInString(word)
+		if length >= spamMinTokenRunes && length <= spamMaxTokenRunes {
+			add(word)
+		}
+	}
+	return tokens
+}
+
+// spamModel garde en mémoire le nombre d'exemples de spam et de contenus légitimes
+// contenant chaque jeton
+type spamModel struct {
+	mu       sync.RWMutex
+	loaded   bool
+	spam     map[string]int
+	ham      map[string]int
+	spamDocs int
+	hamDocs  int
+}
+
+var spamClassifier = &spamModel{}
+
+func (m *spamModel) reload(db store.Store) error {
+	spam, ham := make(map[string]int), make(map[string]int)
+	rows, err := db.Query("SELECT token, spam_count, ham_count FROM spam_token")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token string
+		var spamCount, hamCount int
+		if err := rows.Scan(&token, &spamCount, &hamCount); err != nil {
+			return err
+		}
+		if spamCount > 0 {
+			spam[token] = spamCount
+		}
+		if hamCount > 0 {
+			ham[token] = hamCount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var spamDocs, hamDocs int
+	err = db.QueryRow(`
+		SELECT COALESCE(SUM(is_spam), 0), COALESCE(SUM(NOT is_spam), 0) FROM spam_training
+	`).Scan(&spamDocs, &hamDocs)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.spam, m.ham, m.spamDocs, m.hamDocs, m.loaded = spam, ham, spamDocs, hamDocs, true
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *spamModel) ensureLoaded(db store.Store) error {
+	m.mu.RLock()
+	loaded := m.loaded
+	m.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return m.reload(db)
+}
+
+// add ajoute (delta = 1) ou retire (delta = -1) un exemple du modèle en mémoire
+func (m *spamModel) add(tokens []string, isSpam bool, delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts, docs := m.ham, &m.hamDocs
+	if isSpam {
+		counts, docs = m.spam, &m.spamDocs
+	}
+	*docs += delta
+	for _, token := range tokens {
+		counts[token] += delta
+		if counts[token] <= 0 {
+			delete(counts, token)
+		}
+	}
+}
+
+// tokenProbability est l'estimation de Robinson : la fréquence relative du jeton dans
+// le spam, ramenée vers 0,5 quand le jeton a été vu peu de fois
+func (m *spamModel) tokenProbability(token string) float64 {
+	spamCount, hamCount := m.spam[token], m.ham[token]
+	if spamCount+hamCount == 0 {
+		return 0.5
+	}
+	spamFreq := float64(spamCount) / math.Max(float64(m.spamDocs), 1)
+	hamFreq := float64(hamCount) / math.Max(float64(m.hamDocs), 1)
+	p := spamFreq / (spamFreq + hamFreq)
+	n := float64(spamCount + hamCount)
+	return (0.5 + n*p) / (1 + n)
+}
+
+func (m *spamModel) classify(text string) SpamScore {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tokens []SpamToken
+	for _, token := range spamTokens(text) {
+		p := m.tokenProbability(token)
+		if p != 0.5 {
+			tokens = append(tokens, SpamToken{Token: token, Probability: p})
+		}
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return math.Abs(tokens[i].Probability-0.5) > math.Abs(tokens[j].Probability-0.5)
+	})
+	if len(tokens) > spamInterestingCount {
+		tokens = tokens[:spamInterestingCount]
+	}
+
+	// Combinaison naïve des jetons retenus, en log-cotes
+	var logOdds float64
+	for _, token := range tokens {
+		p := math.Min(math.Max(token.Probability, spamProbabilityFloor), 1-spamProbabilityFloor)
+		logOdds += math.Log(p / (1 - p))
+	}
+	score := SpamScore{
+		Score:   1 / (1 + math.Exp(-logOdds)),
+		Trained: m.spamDocs >= spamMinDocs && m.hamDocs >= spamMinDocs,
+	}
+	if len(tokens) > spamTopTokensShown {
+		tokens = tokens[:spamTopTokensShown]
+	}
+	score.Tokens = tokens
+	return score
+}
+
+// SpamModelStats renvoie le nombre d'exemples de spam et de contenus légitimes appris
+func SpamModelStats(db store.Store) (spamDocs, hamDocs int) {
+	if err := spamClassifier.ensureLoaded(db); err != nil {
+		log.Printf("Error loading spam model: %v", err)
+	}
+	spamClassifier.mu.RLock()
+	defer spamClassifier.mu.RUnlock()
+	return spamClassifier.spamDocs, spamClassifier.hamDocs
+}
+
+// ClassifySpam évalue un texte ; en cas d'erreur de chargement du modèle, le
+// contenu est considéré comme légitime
+func ClassifySpam(db store.Store, text string) SpamScore {
+	if err := spamClassifier.ensureLoaded(db); err != nil {
+		log.Printf("Error loading spam model: %v", err)
+		return SpamScore{Score: 0.5}
+	}
+	return spamClassifier.classify(text)
+}
+
+// RefreshSpamModel renvoie la tâche qui recharge le modèle depuis la base, pour
+// prendre en compte l'apprentissage fait par les autres instances
+func RefreshSpamModel(db store.Store) func() {
+	return func() {
+		if err := spamClassifier.reload(db); err != nil {
+			log.Printf("Error reloading spam model: %v", err)
+		}
+	}
+}
+
+// spamContentText renvoie le texte classé d'un topic (titre et description), d'un
+// message ou d'une réponse
+func spamContentText(db store.Store, targetType string, id int) (string, error) {
+	moderator := store.Viewer{Moderator: true}
+	switch targetType {
+	case ReportTopic:
+		topic, err := db.Topics().Get(id, moderator)
+		return topic.Title + "\n" + topic.Description, err
+	case ContentReply:
+		reply, err := db.Messages().GetReply(id, moderator)
+		return reply.Content, err
+	}
+	message, err := db.Messages().Get(id, moderator)
+	return message.Content, err
+}
+
+// TrainSpam apprend qu'un contenu est du spam ou non. Un contenu déjà appris dans
+// la même classe est ignoré ; dans l'autre classe, l'ancien exemple est retiré.
+func TrainSpam(db store.Store, moderatorID int, targetType string, id int, isSpam bool) error {
+	if err := spamClassifier.ensureLoaded(db); err != nil {
+		return err
+	}
+	text, err := spamContentText(db, targetType, id)
+	if err != nil {
+		return err
+	}
+	tokens := spamTokens(text)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	d := db.Dialect()
+	var previousSpam bool
+	var previousTokens string
+	err = tx.QueryRow(`
+		SELECT is_spam, tokens FROM spam_training WHERE target_type = ? AND target_id = ? `+d.ForUpdate(),
+		targetType, id).Scan(&previousSpam, &previousTokens)
+	var unlearn []string
+	switch {
+	case err == store.ErrNotFound:
+	case err != nil:
+		return err
+	case previousSpam == isSpam:
+		return nil
+	default:
+		unlearn = strings.Fields(previousTokens)
+		if err := updateSpamTokens(tx, d, unlearn, previousSpam, -1); err != nil {
+			return err
+		}
+	}
+	if err := updateSpamTokens(tx, d, tokens, isSpam, 1); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO spam_training (target_type, target_id, is_spam, tokens, trained_by)
+		VALUES (?, ?, ?, ?, ?)
+		`+d.Upsert("target_type", "target_id")+` is_spam = `+d.Inserted("is_spam")+`, tokens = `+d.Inserted("tokens")+`,
+			trained_by = `+d.Inserted("trained_by")+`, created_at = NOW()
+	`, targetType, id, isSpam, strings.Join(tokens, " "), moderatorID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if unlearn != nil {
+		spamClassifier.add(unlearn, previousSpam, -1)
+	}
+	spamClassifier.add(tokens, isSpam, 1)
+	return nil
+}
+
+func updateSpamTokens(tx store.Tx, d store.Dialect, tokens []string, isSpam bool, delta int) error {
+	spamDelta, hamDelta := 0, delta
+	if isSpam {
+		spamDelta, hamDelta = delta, 0
+	}
+	for _, token := range tokens {
+		_, err := tx.Exec(`
+			INSERT INTO spam_token (token, spam_count, ham_count) VALUES (?, GREATEST(?, 0), GREATEST(?, 0))
+			`+d.Upsert("token")+` spam_count = GREATEST(spam_count + ?, 0), ham_count = GREATEST(ham_count + ?, 0)
+		`, token, spamDelta, hamDelta, spamDelta, hamDelta)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trainSpamDecision apprend d'une décision de modération sans la faire échouer
+func trainSpamDecision(db store.Store, moderatorID int, targetType string, id int, isSpam bool) {
+	if err := TrainSpam(db, moderatorID, targetType, id, isSpam); err != nil && err != store.ErrNotFound {
+		log.Printf("Error training spam classifier on %s %d: %v", targetType, id, err)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestSpamClassifier entraîne le modèle sur des topics jugés par un modérateur, puis
+// vérifie les scores, le changement de classe d'un exemple et la mise en attente
+// d'un nouveau topic
+func TestSpamClassifier(t *testing.T) {
+	api := newTestAPI(t)
+
+	createTopic := func(title string) int {
+		t.Helper()
+		submission, err := CreateTopic(api.db, api.adminID, title, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int(submission.ID)
+	}
+	train := func(id int, isSpam bool) {
+		t.Helper()
+		if err := TrainSpam(api.db, api.adminID, ReportTopic, id, isSpam); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Tant que chaque classe n'a pas spamMinDocs exemples, rien n'est retenu
+	var last int
+	for i := 0; i < spamMinDocs; i++ {
+		train(createTopic(fmt.Sprintf("Casino bonus gratuit https://promo.example/%d", i)), true)
+		last = createTopic(fmt.Sprintf("Question sur la recette du gratin numéro %d", i))
+		if i < spamMinDocs-1 {
+			train(last, false)
+		}
+	}
+	if score := ClassifySpam(api.db, "Casino bonus"); score.Trained || score.Held() {
+		t.Fatalf("score %+v with %d ham examples, want an untrained model", score, spamMinDocs-1)
+	}
+	train(last, false)
+
+	cases := []struct {
+		name string
+		text string
+		held bool
+	}{
+		{"mots de spam", "Casino bonus gratuit", true},
+		{"domaine de spam", "Voir www.promo.example", true},
+		{"contenu légitime", "Une recette de gratin", false},
+		{"mots inconnus", "Bonjour tout le monde", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			score := ClassifySpam(api.db, c.text)
+			if !score.Trained {
+				t.Fatalf("model not trained")
+			}
+			if score.Held() != c.held {
+				t.Errorf("score %d %%, held %v, want %v", score.Percent(), score.Held(), c.held)
+			}
+		})
+	}
+
+	// Un nouveau topic classé comme spam est retenu, même pour un administrateur
+	id := createTopic("Casino bonus gratuit chez https://promo.example")
+	var status string
+	var reason *string
+	if err := api.db.QueryRow("SELECT status, held_reason FROM topic WHERE topic_id = ?", id).Scan(&status, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if status != ContentPending || reason == nil || !strings.HasPrefix(*reason, "Spam probable") {
+		t.Errorf("spam topic is %s, want %s held as probable spam", status, ContentPending)
+	}
+
+	// Un exemple rejugé change de classe sans être compté deux fois ; il manque
+	// alors un exemple légitime et le modèle ne retient plus rien
+	train(last, true)
+	train(last, true)
+	if spamDocs, hamDocs := SpamModelStats(api.db); spamDocs != spamMinDocs+1 || hamDocs != spamMinDocs-1 {
+		t.Errorf("model has %d spam and %d ham examples, want %d and %d", spamDocs, hamDocs, spamMinDocs+1, spamMinDocs-1)
+	}
+	var spamCount, hamCount int
+	err := api.db.QueryRow("SELECT spam_count, ham_count FROM spam_token WHERE token = ?", "gratin").Scan(&spamCount, &hamCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spamCount != 1 || hamCount != spamMinDocs-1 {
+		t.Errorf("token gratin counted %d spam, %d ham, want 1 and %d", spamCount, hamCount, spamMinDocs-1)
+	}
+	if score := ClassifySpam(api.db, "Casino bonus gratuit"); score.Trained {
+		t.Errorf("model trained with %d ham examples", spamMinDocs-1)
+	}
+
+	// Le modèle rechargé depuis la base donne le même score
+	before := ClassifySpam(api.db, "Casino bonus gratuit")
+	spamClassifier = &spamModel{}
+	if after := ClassifySpam(api.db, "Casino bonus gratuit"); after.Percent() != before.Percent() {
+		t.Errorf("reloaded model scores %d %%, want %d %%", after.Percent(), before.Percent())
+	}
+}
@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+
+	"forum/config"
+	"forum/mailer"
+	"forum/store"
+)
+
+// Modes d'abonnement à un topic
+const (
+	SubscriptionImmediate = "immediate"
+	SubscriptionBatched   = "batched"
+	// Désabonnement explicite : empêche le réabonnement automatique
+	SubscriptionNone = "none"
+)
+
+const (
+	// Nombre d'essais d'envoi avant d'abandonner un e-mail
+	maxMailAttempts = 5
+	// Durée de réservation d'une ligne de file par l'instance qui la traite ; passé
+	// ce délai, l'instance est supposée arrêtée et une autre peut la reprendre
+	queueLease = 10 * time.Minute
+)
+
+// mailSecret signe les liens envoyés par e-mail. Il doit être propre à ces liens :
+// vide si MAIL_SECRET n'est pas défini ou reprend la clé des jetons JWT, qui est publique
+// dans conf.env, et les e-mails aux abonnés ne partent pas.
+var mailSecret = loadMailSecret()
+
+func loadMailSecret() []byte {
+	secret := config.Env("MAIL_SECRET", "")
+	if secret == "" || secret == string(jwtKey) || secret == config.Env("JWT_SECRET", "") {
+		return nil
+	}
+	return []byte(secret)
+}
+
+// MailSecretConfigured indique si MAIL_SECRET permet de signer les liens des e-mails
+func MailSecretConfigured() bool {
+	return len(mailSecret) > 0
+}
+
+// AutoSubscribe abonne l'utilisateur au topic sans écraser un choix existant
+func AutoSubscribe(db store.Store, userID, topicID int) error {
+	_, err := db.Exec(db.Dialect().InsertIgnore()+` INTO topic_subscription (user_id, topic_id, mode)
+		VALUES (?, ?, ?)
+	`, userID, topicID, SubscriptionImmediate)
+	return err
+}
+
+// SetSubscription enregistre le mode d'abonnement choisi par l'utilisateur
+func SetSubscription(db store.Store, userID, topicID int, mode string) error {
+	d := db.Dialect()
+	_, err := db.Exec(`
+		INSERT INTO topic_subscription (user_id, topic_id, mode)
+		VALUES (?, ?, ?)
+		`+d.Upsert("user_id", "topic_id")+` mode = `+d.Inserted("mode"), userID, topicID, mode)
+	if err != nil {
+		return err
+	}
+
+	if mode == SubscriptionNone {
+		// Ne pas envoyer ce qui était déjà en attente pour ce topic
+		_, err = db.Exec("DELETE FROM mail_queue WHERE user_id = ? AND topic_id = ? AND sent_at IS NULL", userID, topicID)
+	}
+	return err
+}
+
+// SubscriptionMode renvoie le mode d'abonnement de l'utilisateur au topic
+func SubscriptionMode(db store.Store, userID, topicID int) (string, error) {
+	var mode string
+	err := db.QueryRow("SELECT mode FROM topic_subscription WHERE user_id = ? AND topic_id = ?", userID, topicID).Scan(&mode)
+	if err == store.ErrNotFound {
+		return SubscriptionNone, nil
+	}
+	return mode, err
+}
+
+// QueueSubscriptionMails met en file un e-mail pour chaque abonné du topic
+func QueueSubscriptionMails(db store.Store, topicID int, messageID int64, authorID int) error {
+	_, err := db.Exec(`
+		INSERT INTO mail_queue (user_id, topic_id, message_id, mode)
+		SELECT s.user_id, s.topic_id, ?, s.mode
+		FROM topic_subscription s
+		WHERE s.topic_id = ? AND s.user_id <> ? AND s.mode <> ?
+			AND NOT EXISTS(SELECT 1 FROM user_block b WHERE b.blocker_id = s.user_id AND b.blocked_id = ?)
+	`, messageID, topicID, authorID, SubscriptionNone, authorID)
+	return err
+}
+
+// unsubscribeSignature signe le couple utilisateur/topic du lien de désabonnement
+func unsubscribeSignature(userID, topicID int) string {
+	mac := hmac.New(sha256.New, mailSecret)
+	fmt.Fprintf(mac, "unsubscribe:%d:%d", userID, topicID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// UnsubscribeURL renvoie le lien de désabonnement en un clic
+func UnsubscribeURL(userID, topicID int) string {
+	return fmt.Sprintf("%s/unsubscribe?u=%d&t=%d&sig=%s", config.BaseURL(), userID, topicID, unsubscribeSignature(userID, topicID))
+}
+
+// SubscribeTopicHandler change l'abonnement de l'utilisateur connecté à un topic
+func SubscribeTopicHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		topicID, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+			return
+		}
+
+		mode := r.FormValue("mode")
+		if mode != SubscriptionImmediate && mode != SubscriptionBatched && mode != SubscriptionNone {
+			http.Error(w, "Invalid subscription mode", http.StatusBadRequest)
+			return
+		}
+
+		if err := SetSubscription(db, claims.UserID, topicID, mode); err != nil {
+			log.Printf("Error updating subscription: %v", err)
+			http.Error(w, "Error updating subscription", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/topic?id=%d", topicID), http.StatusSeeOther)
+	}
+}
+
+// UnsubscribeHandler traite le lien de désabonnement des e-mails, sans connexion requise.
+// Le POST correspond au désabonnement en un clic des clients mail (RFC 8058).
+func UnsubscribeHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, errUser := strconv.Atoi(r.FormValue("u"))
+		topicID, errTopic := strconv.Atoi(r.FormValue("t"))
+		if errUser != nil || errTopic != nil {
+			http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+			return
+		}
+		if !MailSecretConfigured() || !hmac.Equal([]byte(r.FormValue("sig")), []byte(unsubscribeSignature(userID, topicID))) {
+			http.Error(w, "Invalid unsubscribe link", http.StatusForbidden)
+			return
+		}
+
+		if err := SetSubscription(db, userID, topicID, SubscriptionNone); err != nil {
+			log.Printf("Error unsubscribing: %v", err)
+			http.Error(w, "Error updating subscription", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "Vous ne recevrez plus d'e-mails pour ce sujet.")
+	}
+}
+
+type queuedMail struct {
+	ID        int
+	UserID    int
+	Email     string
+	Username  string
+	TopicID   int
+	Title     string
+	Author    string
+	Content   string
+	CreatedAt string
+}
+
+// SubscriptionMailer envoie les e-mails de la file mail_queue :
+// immédiatement pour le mode "immediate", regroupés par utilisateur pour "batched"
+type SubscriptionMailer struct {
+	db            store.Store
+	transport     mailer.Transport
+	batchInterval time.Duration
+	lastBatch     time.Time
+
+	htmlTemplate *htmltemplate.Template
+	textTemplate *texttemplate.Template
+}
+
+func NewSubscriptionMailer(db store.Store, transport mailer.Transport) (*SubscriptionMailer, error) {
+	htmlTmpl, err := htmltemplate.ParseFiles("templates/email/subscription.html")
+	if err != nil {
+		return nil, err
+	}
+	textTmpl, err := texttemplate.ParseFiles("templates/email/subscription.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SubscriptionMailer{
+		db:            db,
+		transport:     transport,
+		batchInterval: time.Hour,
+		lastBatch:     time.Now(),
+		htmlTemplate:  htmlTmpl,
+		textTemplate:  textTmpl,
+	}, nil
+}
+
+// Flush envoie les e-mails immédiats, et les lots si leur intervalle est écoulé
+// ou si force est vrai. Elle est appelée périodiquement par le planificateur.
+func (m *SubscriptionMailer) Flush(force bool) {
+	pending, err := m.pending(SubscriptionImmediate)
+	if err != nil {
+		log.Printf("Error fetching mail queue: %v", err)
+		return
+	}
+	for _, mail := range pending {
+		m.send(mail.UserID, mail.Email, mail.Username, mail.TopicID, []queuedMail{mail})
+	}
+
+	if !force && time.Since(m.lastBatch) < m.batchInterval {
+		return
+	}
+	m.lastBatch = time.Now()
+
+	pending, err = m.pending(SubscriptionBatched)
+	if err != nil {
+		log.Printf("Error fetching mail queue: %v", err)
+		return
+	}
+
+	// Un e-mail par utilisateur et par topic, regroupant les messages en attente
+	type key struct{ userID, topicID int }
+	var order []key
+	batches := make(map[key][]queuedMail)
+	for _, mail := range pending {
+		k := key{mail.UserID, mail.TopicID}
+		if _, ok := batches[k]; !ok {
+			order = append(order, k)
+		}
+		batches[k] = append(batches[k], mail)
+	}
+	for _, k := range order {
+		mails := batches[k]
+		m.send(k.userID, mails[0].Email, mails[0].Username, k.topicID, mails)
+	}
+}
+
+func (m *SubscriptionMailer) pending(mode string) ([]queuedMail, error) {
+	rows, err := m.db.Query(`
+		SELECT q.mail_id, q.user_id, u.mail, u.username, q.topic_id, t.title, a.username, msg.content, msg.created_at
+		FROM mail_queue q
+		JOIN user u ON q.user_id = u.user_id
+		JOIN topic t ON q.topic_id = t.topic_id
+		JOIN message msg ON q.message_id = msg.message_id
+		JOIN user a ON msg.user_id = a.user_id
+		WHERE q.sent_at IS NULL AND q.mode = ? AND q.attempts < ?
+			AND (q.locked_at IS NULL OR q.locked_at < ?)
+		ORDER BY q.user_id, q.topic_id, q.mail_id
+		LIMIT 500
+	`, mode, maxMailAttempts, time.Now().Add(-queueLease).Format(sqlDateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mails []queuedMail
+	for rows.Next() {
+		var q queuedMail
+		err := rows.Scan(&q.ID, &q.UserID, &q.Email, &q.Username, &q.TopicID, &q.Title, &q.Author, &q.Content, &q.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		mails = append(mails, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Plusieurs instances peuvent lire les mêmes lignes : seules celles réservées
+	// ici sont envoyées
+	claimed := mails[:0]
+	for _, mail := range mails {
+		ok, err := claimQueued(m.db, "mail_queue", "mail_id", mail.ID, "sent_at IS NULL")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			claimed = append(claimed, mail)
+		}
+	}
+	return claimed, nil
+}
+
+// claimQueued réserve la ligne id d'une file pour l'instance courante si elle vérifie
+// encore condition et qu'aucune autre instance ne l'a réservée depuis moins de queueLease
+func claimQueued(db store.Store, table, idColumn string, id int, condition string) (bool, error) {
+	now := time.Now()
+	result, err := db.Exec(`
+		UPDATE `+table+` SET locked_at = ?
+		WHERE `+idColumn+` = ? AND `+condition+` AND (locked_at IS NULL OR locked_at < ?)
+	`, now.Format(sqlDateTime), id, now.Add(-queueLease).Format(sqlDateTime))
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+func (m *SubscriptionMailer) send(userID int, email, username string, topicID int, mails []queuedMail) {
+	data := struct {
+		Username       string
+		Title          string
+		TopicURL       string
+		UnsubscribeURL string
+		ReplyAddress   string
+		Messages       []queuedMail
+	}{
+		Username:       username,
+		Title:          mails[0].Title,
+		TopicURL:       fmt.Sprintf("%s/topic?id=%d", config.BaseURL(), topicID),
+		UnsubscribeURL: UnsubscribeURL(userID, topicID),
+		ReplyAddress:   ReplyAddress(userID, topicID),
+		Messages:       mails,
+	}
+
+	var htmlBody, textBody bytes.Buffer
+	if err := m.htmlTemplate.Execute(&htmlBody, data); err != nil {
+		log.Printf("Error rendering subscription mail: %v", err)
+		return
+	}
+	if err := m.textTemplate.Execute(&textBody, data); err != nil {
+		log.Printf("Error rendering subscription mail: %v", err)
+		return
+	}
+
+	subject := fmt.Sprintf("Nouveau message dans « %s »", data.Title)
+	if len(mails) > 1 {
+		subject = fmt.Sprintf("%d nouveaux messages dans « %s »", len(mails), data.Title)
+	}
+
+	headers := map[string]string{
+		"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	// Répondre à l'e-mail publie un message dans le topic
+	if data.ReplyAddress != "" {
+		headers["Reply-To"] = data.ReplyAddress
+	}
+
+	err := m.transport.Send(mailer.Message{
+		To:      email,
+		Subject: subject,
+		Text:    textBody.String(),
+		HTML:    htmlBody.String(),
+		Headers: headers,
+	})
+
+	for _, mail := range mails {
+		var updateErr error
+		if err != nil {
+			_, updateErr = m.db.Exec("UPDATE mail_queue SET attempts = attempts + 1, locked_at = NULL WHERE mail_id = ?", mail.ID)
+		} else {
+			_, updateErr = m.db.Exec("UPDATE mail_queue SET sent_at = NOW() WHERE mail_id = ?", mail.ID)
+		}
+		if updateErr != nil {
+			log.Printf("Error updating mail queue: %v", updateErr)
+		}
+	}
+	if err != nil {
+		log.Printf("Error sending mail to user %d: %v", userID, err)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"forum/store"
+)
+
+// Niveaux de confiance, calculés à partir de l'ancienneté du compte, des contributions
+// publiées et des likes reçus. Les contributions des nouveaux comptes sont mises en
+// attente de validation.
+const (
+	TrustNew     = 0
+	TrustBasic   = 1
+	TrustRegular = 2
+)
+
+// Seuils des niveaux de confiance
+const (
+	trustBasicHours      = 24
+	trustBasicPosts      = 3
+	trustRegularHours    = 30 * 24
+	trustRegularPosts    = 20
+	trustRegularLikes    = 10
+	trustPendingMaxLevel = TrustNew // niveau jusqu'auquel les contributions sont mises en attente
+)
+
+const trustHeldReason = "Nouveau compte (niveau de confiance « Nouveau »)"
+
+// TrustLevels associe un libellé à chaque niveau
+var TrustLevels = []struct {
+	ID    int
+	Label string
+}{
+	{TrustNew, "Nouveau"},
+	{TrustBasic, "Membre"},
+	{TrustRegular, "Habitué"},
+}
+
+// Trust décrit le niveau de confiance d'un compte et ce qui le détermine
+type Trust struct {
+	Level         int
+	AccountHours  int
+	ApprovedPosts int
+	LikesReceived int
+}
+
+// Label renvoie le libellé du niveau
+func (t Trust) Label() string {
+	for _, level := range TrustLevels {
+		if level.ID == t.Level {
+			return level.Label
+		}
+	}
+	return ""
+}
+
+// Next décrit ce qui manque pour atteindre le niveau suivant
+func (t Trust) Next() string {
+	switch t.Level {
+	case TrustNew:
+		return fmt.Sprintf("%d contribution(s) publiée(s) sur %d, compte de plus de %d h",
+			t.ApprovedPosts, trustBasicPosts, trustBasicHours)
+	case TrustBasic:
+		return fmt.Sprintf("%d contribution(s) publiée(s) sur %d, %d like(s) reçu(s) sur %d, compte de plus de %d jours",
+			t.ApprovedPosts, trustRegularPosts, t.LikesReceived, trustRegularLikes, trustRegularHours/24)
+	}
+	return ""
+}
+
+// Pending indique si les contributions du compte doivent être validées
+func (t Trust) Pending() bool {
+	return t.Level <= trustPendingMaxLevel
+}
+
+// UserTrust calcule le niveau de confiance d'un compte ; les modérateurs et
+// administrateurs ont toujours le niveau le plus élevé
+func UserTrust(db store.Store, userID int) (Trust, error) {
+	activity, err := db.Users().Activity(userID)
+	if err != nil {
+		return Trust{}, err
+	}
+	trust := Trust{ApprovedPosts: activity.Published, LikesReceived: activity.LikesReceived}
+	if created, err := time.ParseInLocation(sqlDateTime, activity.CreatedAt, time.Local); err == nil && time.Since(created) > 0 {
+		trust.AccountHours = int(time.Since(created).Hours())
+	}
+
+	switch {
+	case activity.RoleID >= RoleModerator:
+		trust.Level = TrustRegular
+	case trust.AccountHours >= trustRegularHours && trust.ApprovedPosts >= trustRegularPosts && trust.LikesReceived >= trustRegularLikes:
+		trust.Level = TrustRegular
+	case trust.AccountHours >= trustBasicHours && trust.ApprovedPosts >= trustBasicPosts:
+		trust.Level = TrustBasic
+	default:
+		trust.Level = TrustNew
+	}
+	return trust, nil
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"forum/store"
+)
+
+type UserProfile = store.UserProfile
+
+// IsBlocked indique si blockerID a bloqué blockedID
+func IsBlocked(db store.Store, blockerID, blockedID int) (bool, error) {
+	var blocked bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM user_block WHERE blocker_id = ? AND blocked_id = ?)", blockerID, blockedID).Scan(&blocked)
+	return blocked, err
+}
+
+// UserProfileHandler affiche la page de profil d'un utilisateur (/user?name=...)
+func UserProfileHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Username is required", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		profile, err := db.Users().ProfileByName(name)
+		if err == store.ErrNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching user profile: %v", err)
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			return
+		}
+
+		blocked, err := IsBlocked(db, claims.UserID, profile.ID)
+		if err != nil {
+			log.Printf("Error checking block: %v", err)
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			return
+		}
+
+		// Les modérateurs voient la sanction en cours et un lien pour sanctionner le compte
+		role, err := UserRole(db, claims.UserID)
+		if err != nil {
+			log.Printf("Error fetching user role: %v", err)
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			return
+		}
+		var sanction *Sanction
+		profileRole := RoleUser
+		if role >= RoleModerator {
+			sanction, err = ActiveSanction(db, profile.ID)
+			if err != nil {
+				log.Printf("Error fetching sanction: %v", err)
+				http.Error(w, "Error fetching user", http.StatusInternalServerError)
+				return
+			}
+			profileRole, err = UserRole(db, profile.ID)
+			if err != nil {
+				log.Printf("Error fetching user role: %v", err)
+				http.Error(w, "Error fetching user", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Le niveau de confiance est visible du titulaire du compte et des modérateurs
+		var trust *Trust
+		if claims.UserID == profile.ID || role >= RoleModerator {
+			userTrust, err := UserTrust(db, profile.ID)
+			if err != nil {
+				log.Printf("Error computing trust level: %v", err)
+				http.Error(w, "Error fetching user", http.StatusInternalServerError)
+				return
+			}
+			trust = &userTrust
+		}
+
+		data := struct {
+			Profile     UserProfile
+			Username    string
+			IsSelf      bool
+			Blocked     bool
+			IsModerator bool
+			IsAdmin     bool
+			Sanction    *Sanction
+			ProfileRole int
+			Roles       interface{}
+			Trust       *Trust
+			// DeletionPolicy décrit au titulaire du compte le sort de ses contenus
+			DeletionPolicy string
+		}{
+			Profile:     profile,
+			Username:    claims.Username,
+			IsSelf:      claims.UserID == profile.ID,
+			Blocked:     blocked,
+			IsModerator: role >= RoleModerator,
+			IsAdmin:     role >= RoleAdmin,
+			Sanction:    sanction,
+			ProfileRole: profileRole,
+			Roles:       Roles,
+			Trust:       trust,
+		}
+		if data.IsSelf {
+			data.DeletionPolicy = AccountDeletionPolicy()
+		}
+
+		tmpl, err := template.ParseFiles("templates/user.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, data)
+	}
+}
+
+// BlockUserHandler bloque ou débloque un utilisateur pour l'utilisateur connecté
+func BlockUserHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		blockedID, err := strconv.Atoi(r.FormValue("user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if blockedID == claims.UserID {
+			http.Error(w, "Cannot block yourself", http.StatusBadRequest)
+			return
+		}
+
+		blocked, err := IsBlocked(db, claims.UserID, blockedID)
+		if err != nil {
+			log.Printf("Error checking block: %v", err)
+			http.Error(w, "Error updating block", http.StatusInternalServerError)
+			return
+		}
+
+		if blocked {
+			_, err = db.Exec("DELETE FROM user_block WHERE blocker_id = ? AND blocked_id = ?", claims.UserID, blockedID)
+		} else {
+			_, err = db.Exec("INSERT INTO user_block (blocker_id, blocked_id) VALUES (?, ?)", claims.UserID, blockedID)
+		}
+		if err != nil {
+			log.Printf("Error updating block: %v", err)
+			http.Error(w, "Error updating block", http.StatusInternalServerError)
+			return
+		}
+
+		// Rediriger vers la page précédente
+		http.Redirect(w, r, r.Header.Get("Referer"), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,704 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"forum/config"
+	"forum/store"
+)
+
+// Événements transmis aux webhooks
+const (
+	WebhookTopicCreated      = "topic.created"
+	WebhookMessageCreated    = "message.created"
+	WebhookTopicLiked        = "topic.liked"
+	WebhookTopicStateChanged = "topic.state_changed"
+)
+
+// WebhookEvents liste les événements proposés dans l'administration
+var WebhookEvents = []string{
+	WebhookTopicCreated,
+	WebhookMessageCreated,
+	WebhookTopicLiked,
+	WebhookTopicStateChanged,
+}
+
+// États d'une livraison
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// Nombre d'essais avant d'abandonner une livraison (environ 2 h de reprises)
+	maxWebhookAttempts = 8
+	webhookBaseDelay   = 30 * time.Second
+	webhookMaxDelay    = 6 * time.Hour
+	webhookTimeout     = 10 * time.Second
+	webhookBatchSize   = 50
+)
+
+type Webhook struct {
+	ID        int
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	CreatedAt string
+}
+
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	LastStatusCode int
+	LastError      string
+	NextAttemptAt  string
+	CreatedAt      string
+	DeliveredAt    string
+}
+
+// pendingDispatches compte les événements en cours de mise en file
+var pendingDispatches sync.WaitGroup
+
+// WaitWebhookDispatches attend que les événements déjà émis soient en file ; la ligne
+// de commande l'appelle avant de quitter
+func WaitWebhookDispatches() {
+	pendingDispatches.Wait()
+}
+
+// DispatchWebhook met l'événement en file pour chaque webhook actif qui y est abonné.
+// La charge utile est construite hors de la requête HTTP en cours ; l'envoi est
+// assuré par le WebhookDispatcher.
+func DispatchWebhook(db store.Store, event string, build func() (interface{}, error)) {
+	pendingDispatches.Add(1)
+	go func() {
+		defer pendingDispatches.Done()
+		data, err := build()
+		if err != nil {
+			log.Printf("Error building %s webhook payload: %v", event, err)
+			return
+		}
+
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			log.Printf("Error generating webhook event ID: %v", err)
+			return
+		}
+		payload, err := json.Marshal(struct {
+			ID        string      `json:"id"`
+			Event     string      `json:"event"`
+			CreatedAt string      `json:"created_at"`
+			Data      interface{} `json:"data"`
+		}{hex.EncodeToString(id), event, time.Now().UTC().Format(time.RFC3339), data})
+		if err != nil {
+			log.Printf("Error encoding %s webhook payload: %v", event, err)
+			return
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO webhook_delivery (webhook_id, event, payload, status, next_attempt_at)
+			SELECT webhook_id, ?, ?, ?, NOW()
+			FROM webhook
+			WHERE active = TRUE AND FIND_IN_SET(?, events)
+		`, event, string(payload), DeliveryPending, event)
+		if err != nil {
+			log.Printf("Error queueing %s webhook: %v", event, err)
+		}
+	}()
+}
+
+type webhookUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type webhookTopic struct {
+	ID          int         `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Tags        string      `json:"tags"`
+	StateID     int         `json:"state_id"`
+	URL         string      `json:"url"`
+	Author      webhookUser `json:"author"`
+	CreatedAt   string      `json:"created_at"`
+}
+
+func fetchWebhookTopic(db store.Store, topicID int) (webhookTopic, error) {
+	topic, err := db.Topics().Get(topicID, store.Viewer{Moderator: true})
+	return webhookTopic{
+		ID:          topic.ID,
+		Title:       topic.Title,
+		Description: topic.Description,
+		Tags:        topic.Tags,
+		StateID:     topic.StateID,
+		URL:         fmt.Sprintf("%s/topic?id=%d", config.BaseURL(), topicID),
+		Author:      webhookUser{ID: topic.UserID, Username: topic.Username},
+		CreatedAt:   topic.CreatedAt,
+	}, err
+}
+
+func fetchWebhookUser(db store.Store, userID int) (webhookUser, error) {
+	profile, err := db.Users().Profile(userID)
+	return webhookUser{ID: userID, Username: profile.Username}, err
+}
+
+// DispatchTopicCreated publie l'événement topic.created
+func DispatchTopicCreated(db store.Store, topicID int) {
+	DispatchWebhook(db, WebhookTopicCreated, func() (interface{}, error) {
+		topic, err := fetchWebhookTopic(db, topicID)
+		return map[string]interface{}{"topic": topic}, err
+	})
+}
+
+// DispatchMessageCreated publie l'événement message.created
+func DispatchMessageCreated(db store.Store, messageID int64) {
+	DispatchWebhook(db, WebhookMessageCreated, func() (interface{}, error) {
+		var message struct {
+			ID          int         `json:"id"`
+			Content     string      `json:"content"`
+			ContentHTML string      `json:"content_html"`
+			URL         string      `json:"url"`
+			Author      webhookUser `json:"author"`
+			CreatedAt   string      `json:"created_at"`
+		}
+		stored, err := db.Messages().Get(int(messageID), store.Viewer{Moderator: true})
+		if err != nil {
+			return nil, err
+		}
+		message.ID, message.Content, message.ContentHTML = stored.ID, stored.Content, stored.ContentHTML
+		message.CreatedAt = stored.CreatedAt
+		message.Author = webhookUser{ID: stored.UserID, Username: stored.Username}
+		message.URL = fmt.Sprintf("%s/topic?id=%d#message-%d", config.BaseURL(), stored.TopicID, message.ID)
+
+		topic, err := fetchWebhookTopic(db, stored.TopicID)
+		return map[string]interface{}{"message": message, "topic": topic}, err
+	})
+}
+
+// DispatchTopicLiked publie l'événement topic.liked
+func DispatchTopicLiked(db store.Store, topicID, userID int) {
+	DispatchWebhook(db, WebhookTopicLiked, func() (interface{}, error) {
+		topic, err := fetchWebhookTopic(db, topicID)
+		if err != nil {
+			return nil, err
+		}
+		user, err := fetchWebhookUser(db, userID)
+		return map[string]interface{}{"topic": topic, "user": user}, err
+	})
+}
+
+// DispatchTopicStateChanged publie l'événement topic.state_changed
+func DispatchTopicStateChanged(db store.Store, topicID, previousState, state, moderatorID int) {
+	DispatchWebhook(db, WebhookTopicStateChanged, func() (interface{}, error) {
+		topic, err := fetchWebhookTopic(db, topicID)
+		if err != nil {
+			return nil, err
+		}
+		// Pas de modérateur pour un changement fait en ligne de commande
+		var moderator interface{}
+		if moderatorID != 0 {
+			if moderator, err = fetchWebhookUser(db, moderatorID); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{
+			"topic":             topic,
+			"previous_state_id": previousState,
+			"state_id":          state,
+			"moderator":         moderator,
+		}, nil
+	})
+}
+
+// WebhookSignature signe le corps avec le secret du webhook. Le destinataire
+// recalcule HMAC-SHA256(secret, timestamp + "." + corps) pour vérifier l'en-tête
+// X-Forum-Signature: t=<timestamp>,v1=<signature hexadécimale>.
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookBackoff renvoie le délai avant le prochain essai (30 s, 1 min, 2 min...)
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseDelay << uint(attempts-1)
+	if delay <= 0 || delay > webhookMaxDelay {
+		return webhookMaxDelay
+	}
+	return delay
+}
+
+// WebhookDispatcher envoie les livraisons en attente ; Deliver est appelée
+// périodiquement par le planificateur
+type WebhookDispatcher struct {
+	db     store.Store
+	client *http.Client
+}
+
+func NewWebhookDispatcher(db store.Store) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db: db,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			// Une redirection est traitée comme un échec
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (d *WebhookDispatcher) Deliver() {
+	rows, err := d.db.Query(`
+		SELECT d.delivery_id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_delivery d
+		JOIN webhook w ON d.webhook_id = w.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= NOW() AND w.active = TRUE
+			AND (d.locked_at IS NULL OR d.locked_at < ?)
+		ORDER BY d.delivery_id
+		LIMIT ?
+	`, DeliveryPending, time.Now().Add(-queueLease).Format(sqlDateTime), webhookBatchSize)
+	if err != nil {
+		log.Printf("Error fetching webhook deliveries: %v", err)
+		return
+	}
+
+	type pending struct {
+		id       int
+		event    string
+		payload  string
+		attempts int
+		url      string
+		secret   string
+	}
+	var deliveries []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.event, &p.payload, &p.attempts, &p.url, &p.secret); err != nil {
+			log.Printf("Error scanning webhook delivery: %v", err)
+			rows.Close()
+			return
+		}
+		deliveries = append(deliveries, p)
+	}
+	rows.Close()
+
+	for _, p := range deliveries {
+		// Une autre instance peut avoir lu la même livraison : seule celle qui la
+		// réserve l'envoie
+		claimed, err := claimQueued(d.db, "webhook_delivery", "delivery_id", p.id, "status = '"+DeliveryPending+"'")
+		if err != nil {
+			log.Printf("Error claiming webhook delivery %d: %v", p.id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		statusCode, err := d.post(p.id, p.event, p.url, p.secret, []byte(p.payload))
+		attempts := p.attempts + 1
+
+		var updateErr error
+		switch {
+		case err == nil:
+			_, updateErr = d.db.Exec(`
+				UPDATE webhook_delivery
+				SET status = ?, attempts = ?, last_status_code = ?, last_error = NULL, delivered_at = NOW()
+				WHERE delivery_id = ?
+			`, DeliveryDelivered, attempts, statusCode, p.id)
+		case attempts >= maxWebhookAttempts:
+			_, updateErr = d.db.Exec(`
+				UPDATE webhook_delivery
+				SET status = ?, attempts = ?, last_status_code = ?, last_error = ?
+				WHERE delivery_id = ?
+			`, DeliveryFailed, attempts, statusCode, truncate(err.Error(), 255), p.id)
+		default:
+			_, updateErr = d.db.Exec(`
+				UPDATE webhook_delivery
+				SET attempts = ?, last_status_code = ?, last_error = ?, next_attempt_at = ?, locked_at = NULL
+				WHERE delivery_id = ?
+			`, attempts, statusCode, truncate(err.Error(), 255),
+				time.Now().Add(webhookBackoff(attempts)).Format(sqlDateTime), p.id)
+		}
+		if updateErr != nil {
+			log.Printf("Error updating webhook delivery %d: %v", p.id, updateErr)
+		}
+	}
+}
+
+// post envoie une livraison ; seule une réponse 2xx est considérée comme reçue
+func (d *WebhookDispatcher) post(deliveryID int, event, target, secret string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ForumForAll-Webhooks/1.0")
+	req.Header.Set("X-Forum-Event", event)
+	req.Header.Set("X-Forum-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Forum-Signature", WebhookSignature(secret, time.Now().Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	// On ne coupe pas au milieu d'un caractère UTF-8
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
+
+func fetchWebhooks(db store.Store) ([]Webhook, error) {
+	rows, err := db.Query("SELECT webhook_id, url, secret, events, active, created_at FROM webhook ORDER BY webhook_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var hook Webhook
+		var events string
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &events, &hook.Active, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		hook.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, hook)
+	}
+	return webhooks, rows.Err()
+}
+
+func fetchDeliveries(db store.Store, webhookID int) ([]WebhookDelivery, error) {
+	rows, err := db.Query(`
+		SELECT delivery_id, webhook_id, event, payload, status, attempts,
+			COALESCE(last_status_code, 0), COALESCE(last_error, ''), next_attempt_at, created_at,
+			COALESCE(delivered_at, '')
+		FROM webhook_delivery
+		WHERE webhook_id = ?
+		ORDER BY delivery_id DESC
+		LIMIT 100
+	`, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.LastStatusCode, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// WebhooksPageHandler affiche les webhooks et, avec ?id=N, le journal de livraison de l'un d'eux
+func WebhooksPageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := currentClaims(r)
+		if err != nil {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		webhooks, err := fetchWebhooks(db)
+		if err != nil {
+			log.Printf("Error fetching webhooks: %v", err)
+			http.Error(w, "Error fetching webhooks", http.StatusInternalServerError)
+			return
+		}
+
+		var selected *Webhook
+		var deliveries []WebhookDelivery
+		if id, err := strconv.Atoi(r.URL.Query().Get("id")); err == nil {
+			for i := range webhooks {
+				if webhooks[i].ID == id {
+					selected = &webhooks[i]
+				}
+			}
+			if selected == nil {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			deliveries, err = fetchDeliveries(db, id)
+			if err != nil {
+				log.Printf("Error fetching webhook deliveries: %v", err)
+				http.Error(w, "Error fetching deliveries", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		tmpl, err := template.ParseFiles("templates/webhooks.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, struct {
+			Username   string
+			Webhooks   []Webhook
+			Events     []string
+			Selected   *Webhook
+			Deliveries []WebhookDelivery
+			Error      string
+		}{
+			Username:   claims.Username,
+			Webhooks:   webhooks,
+			Events:     WebhookEvents,
+			Selected:   selected,
+			Deliveries: deliveries,
+			Error:      r.URL.Query().Get("error"),
+		})
+	}
+}
+
+// CreateWebhookHandler enregistre un webhook ; son secret de signature est généré ici
+func CreateWebhookHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.ParseForm()
+
+		target, err := url.Parse(strings.TrimSpace(r.FormValue("url")))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			http.Redirect(w, r, "/admin/webhooks?error="+url.QueryEscape("URL invalide"), http.StatusSeeOther)
+			return
+		}
+
+		var events []string
+		for _, event := range r.Form["events"] {
+			for _, known := range WebhookEvents {
+				if event == known {
+					events = append(events, event)
+				}
+			}
+		}
+		if len(events) == 0 {
+			http.Redirect(w, r, "/admin/webhooks?error="+url.QueryEscape("Choisissez au moins un événement"), http.StatusSeeOther)
+			return
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Printf("Error generating webhook secret: %v", err)
+			http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+			return
+		}
+
+		result, err := db.Exec("INSERT INTO webhook (url, secret, events, active) VALUES (?, ?, ?, TRUE)",
+			target.String(), hex.EncodeToString(secret), strings.Join(events, ","))
+		if err != nil {
+			log.Printf("Error creating webhook: %v", err)
+			http.Error(w, "Error creating webhook", http.StatusInternalServerError)
+			return
+		}
+		webhookID, _ := result.LastInsertId()
+		// Le secret n'est pas copié dans le journal d'audit
+		audit(db, r, AuditEvent{
+			Action:      AuditWebhookCreate,
+			TargetType:  AuditTargetWebhook,
+			TargetID:    int(webhookID),
+			TargetLabel: target.String(),
+			After:       map[string]interface{}{"url": target.String(), "events": events, "active": true},
+		})
+
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+	}
+}
+
+// ToggleWebhookHandler active ou désactive un webhook
+func ToggleWebhookHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+
+		var hookURL string
+		var active bool
+		err = db.QueryRow("SELECT url, active FROM webhook WHERE webhook_id = ?", id).Scan(&hookURL, &active)
+		if err == store.ErrNotFound {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching webhook: %v", err)
+			http.Error(w, "Error updating webhook", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := db.Exec("UPDATE webhook SET active = ? WHERE webhook_id = ?", !active, id); err != nil {
+			log.Printf("Error updating webhook: %v", err)
+			http.Error(w, "Error updating webhook", http.StatusInternalServerError)
+			return
+		}
+		audit(db, r, AuditEvent{
+			Action:      AuditWebhookToggle,
+			TargetType:  AuditTargetWebhook,
+			TargetID:    id,
+			TargetLabel: hookURL,
+			Before:      map[string]bool{"active": active},
+			After:       map[string]bool{"active": !active},
+		})
+
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+	}
+}
+
+// DeleteWebhookHandler supprime un webhook et son journal de livraison
+func DeleteWebhookHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+
+		var hookURL, events string
+		err = db.QueryRow("SELECT url, events FROM webhook WHERE webhook_id = ?", id).Scan(&hookURL, &events)
+		if err == store.ErrNotFound {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching webhook: %v", err)
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Error deleting webhook: %v", err)
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("DELETE FROM webhook_delivery WHERE webhook_id = ?", id); err != nil {
+			log.Printf("Error deleting webhook deliveries: %v", err)
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM webhook WHERE webhook_id = ?", id); err != nil {
+			log.Printf("Error deleting webhook: %v", err)
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error deleting webhook: %v", err)
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+		audit(db, r, AuditEvent{
+			Action:      AuditWebhookDelete,
+			TargetType:  AuditTargetWebhook,
+			TargetID:    id,
+			TargetLabel: hookURL,
+			Before:      map[string]interface{}{"url": hookURL, "events": strings.Split(events, ",")},
+		})
+
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+	}
+}
+
+// RedeliverWebhookHandler remet en file une copie d'une livraison, avec les essais remis à zéro
+func RedeliverWebhookHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+			return
+		}
+
+		var webhookID int
+		err = db.QueryRow("SELECT webhook_id FROM webhook_delivery WHERE delivery_id = ?", id).Scan(&webhookID)
+		if err == store.ErrNotFound {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching webhook delivery: %v", err)
+			http.Error(w, "Error fetching delivery", http.StatusInternalServerError)
+			return
+		}
+
+		result, err := db.Exec(`
+			INSERT INTO webhook_delivery (webhook_id, event, payload, status, next_attempt_at)
+			SELECT webhook_id, event, payload, ?, NOW()
+			FROM webhook_delivery
+			WHERE delivery_id = ?
+		`, DeliveryPending, id)
+		if err != nil {
+			log.Printf("Error redelivering webhook: %v", err)
+			http.Error(w, "Error redelivering webhook", http.StatusInternalServerError)
+			return
+		}
+		copyID, _ := result.LastInsertId()
+		audit(db, r, AuditEvent{
+			Action:     AuditWebhookRedeliver,
+			TargetType: AuditTargetWebhook,
+			TargetID:   webhookID,
+			Before:     map[string]int{"delivery_id": id},
+			After:      map[string]int64{"delivery_id": copyID},
+		})
+
+		http.Redirect(w, r, fmt.Sprintf("/admin/webhooks?id=%d", webhookID), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,228 @@
+package importer
+
+import (
+	"regexp"
+	"strings"
+)
+
+// bbNode est un nœud de l'arbre d'un texte BBCode ; un nœud sans balise est du texte
+type bbNode struct {
+	tag      string
+	arg      string
+	text     string
+	children []*bbNode
+	parent   *bbNode
+}
+
+var bbTag = regexp.MustCompile(`^\[(/?)([a-zA-Z]+|\*)(?:=([^\]\n]*))?\]`)
+
+// bbTags sont les balises reconnues ; les autres restent dans le texte
+var bbTags = map[string]bool{
+	"b": true, "i": true, "u": true, "s": true, "strike": true, "code": true, "quote": true,
+	"url": true, "email": true, "img": true, "list": true, "*": true, "color": true,
+	"size": true, "font": true, "center": true, "left": true, "right": true, "align": true,
+	"spoiler": true, "details": true, "sub": true, "sup": true, "attachment": true,
+	"youtube": true, "video": true, "media": true,
+}
+
+// bbcodeToMarkdown convertit le BBCode de text en Markdown. escape indique un texte
+// brut dont les caractères spéciaux du Markdown et les retours à la ligne doivent
+// être préservés (phpBB) ; un texte déjà en Markdown (Discourse) est gardé tel quel.
+func bbcodeToMarkdown(text string, escape bool) string {
+	root := parseBBCode(text)
+	markdown := root.markdown(escape)
+	return strings.TrimSpace(blankLines.ReplaceAllString(markdown, "\n\n"))
+}
+
+var blankLines = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+
+func parseBBCode(text string) *bbNode {
+	root := &bbNode{}
+	current := root
+	addText := func(s string) {
+		if s == "" {
+			return
+		}
+		if last := len(current.children) - 1; last >= 0 && current.children[last].tag == "" && current.children[last].children == nil {
+			current.children[last].text += s
+			return
+		}
+		current.children = append(current.children, &bbNode{text: s, parent: current})
+	}
+
+	for len(text) > 0 {
+		start := strings.IndexByte(text, '[')
+		if start < 0 {
+			addText(text)
+			break
+		}
+		addText(text[:start])
+		text = text[start:]
+
+		match := bbTag.FindStringSubmatch(text)
+		tag := ""
+		if match != nil {
+			tag = strings.ToLower(match[2])
+		}
+		if !bbTags[tag] || current.tag == "code" && tag != "code" || current.tag == "code" && match[1] == "" {
+			addText("[")
+			text = text[1:]
+			continue
+		}
+		text = text[len(match[0]):]
+
+		if match[1] == "/" {
+			closing := current
+			for closing != nil && closing.tag != tag {
+				closing = closing.parent
+			}
+			if closing == nil || closing == root {
+				if tag != "*" {
+					addText(match[0])
+				}
+				continue
+			}
+			current = closing.parent
+			continue
+		}
+
+		if tag == "*" && current.tag == "*" {
+			current = current.parent
+		}
+		node := &bbNode{tag: tag, arg: match[3], parent: current}
+		current.children = append(current.children, node)
+		current = node
+	}
+	return root
+}
+
+// raw renvoie le texte du nœud sans mise en forme
+func (n *bbNode) raw() string {
+	if n.tag == "" && n.children == nil {
+		return n.text
+	}
+	var raw strings.Builder
+	for _, child := range n.children {
+		raw.WriteString(child.raw())
+	}
+	return raw.String()
+}
+
+func (n *bbNode) markdown(escape bool) string {
+	if n.tag == "" && n.children == nil {
+		if escape {
+			return escapeMarkdown(n.text)
+		}
+		return n.text
+	}
+	var inner strings.Builder
+	for _, child := range n.children {
+		if n.tag == "list" && child.tag != "*" && strings.TrimSpace(child.raw()) == "" {
+			continue
+		}
+		inner.WriteString(child.markdown(escape))
+	}
+	content := inner.String()
+
+	switch n.tag {
+	case "b":
+		return emphasis(content, "**")
+	case "i":
+		return emphasis(content, "*")
+	case "s", "strike":
+		return emphasis(content, "~~")
+	case "code":
+		code := strings.Trim(n.raw(), "\n")
+		if !strings.Contains(code, "\n") && !strings.Contains(code, "`") {
+			return "`" + code + "`"
+		}
+		return "\n\n```\n" + code + "\n```\n\n"
+	case "quote":
+		quoted := ""
+		if author := quoteAuthor(n.arg); author != "" {
+			quoted = "**" + escapeMarkdown(author) + " a écrit :**\n\n"
+		}
+		quoted += strings.TrimSpace(content)
+		return "\n\n> " + strings.ReplaceAll(quoted, "\n", "\n> ") + "\n\n"
+	case "url", "email":
+		target := strings.Trim(n.arg, `"'`)
+		label := strings.TrimSpace(content)
+		if target == "" {
+			target = strings.TrimSpace(n.raw())
+			label = target
+			if escape {
+				label = escapeMarkdown(label)
+			}
+		}
+		if n.tag == "email" {
+			target = "mailto:" + target
+		} else if strings.HasPrefix(target, "www.") {
+			target = "http://" + target
+		}
+		return "[" + label + "](" + linkTarget(target) + ")"
+	case "img":
+		return "![](" + linkTarget(strings.TrimSpace(n.raw())) + ")"
+	case "youtube":
+		video := strings.TrimSpace(n.raw())
+		if !strings.Contains(video, "/") {
+			video = "https://www.youtube.com/watch?v=" + video
+		}
+		return "<" + video + ">"
+	case "list":
+		return "\n\n" + content + "\n\n"
+	case "*":
+		marker := "- "
+		if n.parent != nil && n.parent.tag == "list" && n.parent.arg != "" {
+			marker = "1. "
+		}
+		item := strings.TrimSpace(content)
+		return marker + strings.ReplaceAll(item, "\n", "\n   ") + "\n"
+	}
+	// Mise en forme sans équivalent : seul le contenu est gardé
+	return content
+}
+
+// emphasis entoure content de marker en laissant les espaces à l'extérieur, faute de
+// quoi le Markdown ne reconnaît pas la mise en forme
+func emphasis(content, marker string) string {
+	trimmed := strings.TrimSpace(content)
+	if trimmed == "" {
+		return content
+	}
+	start := strings.Index(content, trimmed)
+	return content[:start] + marker + trimmed + marker + content[start+len(trimmed):]
+}
+
+// quoteAuthor extrait l'auteur d'une citation : [quote="alice" post_id=3] pour phpBB,
+// [quote="alice, post:3, topic:12"] pour Discourse
+func quoteAuthor(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if strings.HasPrefix(arg, `"`) {
+		if end := strings.IndexByte(arg[1:], '"'); end >= 0 {
+			arg = arg[1 : end+1]
+		}
+	}
+	if comma := strings.IndexByte(arg, ','); comma >= 0 {
+		arg = arg[:comma]
+	}
+	return strings.TrimSpace(strings.Trim(arg, `"`))
+}
+
+func linkTarget(target string) string {
+	if strings.ContainsAny(target, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(target) + ">"
+	}
+	return target
+}
+
+var markdownSpecial = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`, `>`, `\>`,
+	`#`, `\#`, `|`, `\|`, `~`, `\~`,
+)
+
+// escapeMarkdown protège un texte brut : les caractères spéciaux sont échappés et
+// chaque retour à la ligne devient un saut de ligne Markdown
+func escapeMarkdown(text string) string {
+	text = markdownSpecial.Replace(strings.ReplaceAll(text, "\r\n", "\n"))
+	return strings.ReplaceAll(text, "\n", "  \n")
+}
@@ -0,0 +1,313 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+
+	"forum/archive"
+	"forum/handlers"
+	"forum/store"
+)
+
+const discourseSchema = `
+	CREATE TABLE category (id INTEGER PRIMARY KEY, name TEXT, slug TEXT, parent_id INT, position INT);
+	CREATE TABLE user (id INTEGER PRIMARY KEY, username TEXT, created_at TEXT, last_seen_at TEXT,
+		admin INT, moderator INT);
+	CREATE TABLE user_email (user_id INT, email TEXT, is_primary INT);
+	CREATE TABLE user_profile (user_id INTEGER PRIMARY KEY, bio TEXT);
+	CREATE TABLE topic (id INTEGER PRIMARY KEY, title TEXT, slug TEXT, category_id INT, user_id INT,
+		created_at TEXT, updated_at TEXT, closed INT, archived INT, archetype TEXT, deleted INT);
+	CREATE TABLE post (id INTEGER PRIMARY KEY, topic_id INT, user_id INT, post_number INT, raw TEXT,
+		created_at TEXT, post_type INT, deleted INT, hidden INT);
+	CREATE TABLE post_like (post_id INT, user_id INT, PRIMARY KEY (post_id, user_id));`
+
+// Nettoyage de la base de travail avant l'écriture de l'archive. Dans Discourse, les
+// messages privés sont des topics d'archetype private_message, les messages de type
+// autre que 1 sont des actions (fermeture, déplacement...) ou des messages réservés
+// aux modérateurs, et les comptes d'id négatif sont des comptes système ; ces
+// derniers et les auteurs supprimés sont remplacés par le compte Placeholder, créé au
+// besoin avec le premier id libre.
+var discourseCleanup = []cleanupStep{
+	{"private_messages", "DELETE FROM topic WHERE archetype <> 'regular'"},
+	{"deleted_topics", "DELETE FROM topic WHERE deleted = 1"},
+	{"deleted_posts", "DELETE FROM post WHERE deleted = 1"},
+	{"small_actions_and_whispers", "DELETE FROM post WHERE post_type <> 1"},
+	{"", "CREATE INDEX post_topic ON post (topic_id, post_number)"},
+	{"topics_without_first_post", "DELETE FROM topic WHERE id NOT IN (SELECT topic_id FROM post WHERE post_number = 1)"},
+	{"posts_of_skipped_topics", "DELETE FROM post WHERE topic_id NOT IN (SELECT id FROM topic)"},
+	{"system_accounts", "DELETE FROM user WHERE id <= 0"},
+	{"", `INSERT INTO user (id, username, admin, moderator)
+		SELECT next.id, '` + Placeholder + `', 0, 0 FROM (SELECT COALESCE(MAX(id), 0) + 1 AS id FROM user) next
+		WHERE EXISTS (SELECT 1 FROM topic WHERE user_id IS NULL OR user_id NOT IN (SELECT id FROM user))
+			OR EXISTS (SELECT 1 FROM post WHERE user_id IS NULL OR user_id NOT IN (SELECT id FROM user))`},
+	{"topics_of_deleted_users", `UPDATE topic SET user_id = (SELECT MAX(id) FROM user)
+		WHERE user_id IS NULL OR user_id NOT IN (SELECT id FROM user)`},
+	{"posts_of_deleted_users", `UPDATE post SET user_id = (SELECT MAX(id) FROM user)
+		WHERE user_id IS NULL OR user_id NOT IN (SELECT id FROM user)`},
+	{"likes_of_skipped_content", "DELETE FROM post_like WHERE user_id NOT IN (SELECT id FROM user) OR post_id NOT IN (SELECT id FROM post)"},
+	{"likes_on_replies", "DELETE FROM post_like WHERE post_id NOT IN (SELECT id FROM post WHERE post_number = 1)"},
+	{"", "CREATE INDEX topic_user ON topic (user_id)"},
+}
+
+// discourseLike est le post_action_type_id d'un like
+const discourseLike = 2
+
+// Discourse convertit une sauvegarde Discourse (l'archive .tar.gz produite par
+// l'administration, ou le dump.sql.gz qu'elle contient) en archive écrite dans w.
+//
+// Les catégories gardent leur slug, préfixé de celui du parent pour une
+// sous-catégorie. Le premier message d'un topic en devient la description, au format
+// Markdown de Discourse comme les messages suivants. Les likes ne sont gardés que sur
+// le premier message, le forum n'ayant de votes que sur les topics et les réponses.
+// Les mots de passe ne sont jamais repris, Discourse ne les hachant pas avec bcrypt.
+func Discourse(backup string, w io.Writer, options Options) (Report, error) {
+	report := Report{Skipped: map[string]int{}}
+	options.PasswordHashes = false
+
+	s, err := openStaging(discourseSchema)
+	if err != nil {
+		return report, err
+	}
+	defer s.Close()
+
+	r, file, err := openDump(backup)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+	tables := map[string]bool{
+		"categories": true, "users": true, "user_emails": true, "user_profiles": true,
+		"topics": true, "posts": true, "post_actions": true,
+	}
+	if err := readPostgresDump(r, tables, func(table string, values map[string]*string) error {
+		return discourseRow(s, table, values)
+	}); err != nil {
+		return report, err
+	}
+	if err := s.checkLoaded("category", "user", "topic"); err != nil {
+		return report, fmt.Errorf("not a Discourse backup: %w", err)
+	}
+	if err := s.cleanup(discourseCleanup, report.Skipped); err != nil {
+		return report, err
+	}
+
+	writer, err := newWriter(w, "discourse", options)
+	if err != nil {
+		return report, err
+	}
+	redirects := newRedirects(options)
+
+	// Les parents sont lus avant leurs sous-catégories
+	categories := map[int]string{}
+	used := slugs{}
+	err = s.each(`
+		SELECT c.id, c.name, c.slug, COALESCE(p.slug, '') FROM category c
+		LEFT JOIN category p ON p.id = c.parent_id
+		ORDER BY c.parent_id IS NOT NULL, c.position, c.id`,
+		func(rows *sql.Rows) error {
+			var id int
+			var name, own, parent string
+			if err := rows.Scan(&id, &name, &own, &parent); err != nil {
+				return err
+			}
+			slug := own
+			if slug == "" {
+				slug = name
+			}
+			if parent != "" {
+				slug = parent + "-" + slug
+			}
+			slug = used.make(slug, "categorie-"+strconv.Itoa(id))
+			categories[id] = slug
+			if err := writer.WriteCategory(archive.Category{Slug: slug, Label: name, Position: len(categories)}); err != nil {
+				return err
+			}
+			if own == "" {
+				// Discourse adresse alors la catégorie par son seul id
+				return redirects.add("/c/"+strconv.Itoa(id), categoryURL(slug))
+			}
+			old := "/c/" + own
+			if parent != "" {
+				old = "/c/" + parent + "/" + own
+			}
+			if err := redirects.add(old+"/"+strconv.Itoa(id), categoryURL(slug)); err != nil {
+				return err
+			}
+			return redirects.add(old, categoryURL(slug))
+		})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT u.id, u.username, COALESCE((SELECT e.email FROM user_email e WHERE e.user_id = u.id
+				ORDER BY e.is_primary DESC LIMIT 1), ''),
+			p.bio, u.created_at, u.last_seen_at, u.admin, u.moderator,
+			(SELECT COUNT(*) FROM topic t WHERE t.user_id = u.id)
+		FROM user u LEFT JOIN user_profile p ON p.user_id = u.id ORDER BY u.id`,
+		func(rows *sql.Rows) error {
+			var u archive.User
+			var created, seen *string
+			var admin, moderator bool
+			err := rows.Scan(&u.ID, &u.Username, &u.Mail, &u.Bio, &created, &seen, &admin, &moderator, &u.TopicNbr)
+			if err != nil {
+				return err
+			}
+			u.CreatedAt, u.LastConnection = postgresDate(created), postgresDate(seen)
+			role := handlers.RoleUser
+			if admin {
+				role = handlers.RoleAdmin
+			} else if moderator {
+				role = handlers.RoleModerator
+			}
+			u.RoleID = &role
+			if err := writer.WriteUser(u); err != nil {
+				return err
+			}
+			if created == nil {
+				// Compte Placeholder, absent de Discourse
+				return nil
+			}
+			return redirects.add("/u/"+u.Username, userURL(u.Username))
+		})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT t.id, t.title, t.slug, t.category_id, t.user_id, t.created_at, t.updated_at, t.closed,
+			t.archived, p.raw, p.hidden
+		FROM topic t JOIN post p ON p.topic_id = t.id AND p.post_number = 1 ORDER BY t.id`,
+		func(rows *sql.Rows) error {
+			var t archive.Topic
+			var slug string
+			var category *int
+			var author int
+			var created, updated *string
+			var closed, archived, hidden bool
+			var raw string
+			err := rows.Scan(&t.ID, &t.Title, &slug, &category, &author, &created, &updated, &closed, &archived, &raw, &hidden)
+			if err != nil {
+				return err
+			}
+			if category != nil {
+				if tags, ok := categories[*category]; ok {
+					t.Tags = &tags
+				}
+			}
+			state := handlers.StateOpen
+			if archived {
+				state = handlers.StateArchived
+			} else if closed {
+				state = handlers.StateClosed
+			}
+			description := bbcodeToMarkdown(raw, false)
+			t.Description, t.UserID, t.StateID = &description, &author, &state
+			t.Status, t.HeldReason = discourseStatus(hidden)
+			t.CreatedAt, t.UpdatedAt = postgresDate(created), postgresDate(updated)
+			if err := writer.WriteTopic(t); err != nil {
+				return err
+			}
+			id := strconv.Itoa(t.ID)
+			for _, old := range []string{"/t/" + slug + "/" + id, "/t/" + id, "/t/" + slug + "/" + id + "/1"} {
+				if err := redirects.add(old, topicURL(t.ID)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT p.id, p.topic_id, t.slug, p.post_number, p.user_id, p.raw, p.created_at, p.hidden
+		FROM post p JOIN topic t ON t.id = p.topic_id
+		WHERE p.post_number > 1 ORDER BY p.id`,
+		func(rows *sql.Rows) error {
+			var m archive.Message
+			var topic, number, author int
+			var slug, raw string
+			var created *string
+			var hidden bool
+			if err := rows.Scan(&m.ID, &topic, &slug, &number, &author, &raw, &created, &hidden); err != nil {
+				return err
+			}
+			m.TopicID, m.UserID, m.Content = &topic, &author, bbcodeToMarkdown(raw, false)
+			m.Status, m.HeldReason = discourseStatus(hidden)
+			m.CreatedAt = postgresDate(created)
+			if err := writer.WriteMessage(m); err != nil {
+				return err
+			}
+			return redirects.add("/t/"+slug+"/"+strconv.Itoa(topic)+"/"+strconv.Itoa(number), messageURL(topic, m.ID))
+		})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT l.user_id, p.topic_id FROM post_like l JOIN post p ON p.id = l.post_id
+		ORDER BY l.user_id, p.topic_id`,
+		func(rows *sql.Rows) error {
+			like := archive.TopicLike{Liked: true}
+			if err := rows.Scan(&like.UserID, &like.TopicID); err != nil {
+				return err
+			}
+			return writer.WriteTopicLike(like)
+		})
+	if err != nil {
+		return report, err
+	}
+
+	if err := redirects.flush(); err != nil {
+		return report, err
+	}
+	report.Counts, report.Redirects = writer.Counts(), redirects.count
+	return report, writer.Close()
+}
+
+// discourseRow copie dans la base de travail les colonnes utiles d'une ligne du dump
+func discourseRow(s *staging, table string, v map[string]*string) error {
+	switch table {
+	case "categories":
+		return s.insert("INSERT OR IGNORE INTO category VALUES (?, ?, ?, ?, ?)",
+			intValue(v["id"]), stringValue(v["name"]), stringValue(v["slug"]), v["parent_category_id"],
+			intValue(v["position"]))
+	case "users":
+		return s.insert("INSERT OR IGNORE INTO user VALUES (?, ?, ?, ?, ?, ?)",
+			intValue(v["id"]), stringValue(v["username"]), v["created_at"], v["last_seen_at"],
+			boolValue(v["admin"]), boolValue(v["moderator"]))
+	case "user_emails":
+		return s.insert("INSERT INTO user_email VALUES (?, ?, ?)",
+			intValue(v["user_id"]), stringValue(v["email"]), boolValue(v["primary"]))
+	case "user_profiles":
+		return s.insert("INSERT OR IGNORE INTO user_profile VALUES (?, ?)", intValue(v["user_id"]), v["bio_raw"])
+	case "topics":
+		return s.insert("INSERT OR IGNORE INTO topic VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			intValue(v["id"]), stringValue(v["title"]), stringValue(v["slug"]), v["category_id"],
+			v["user_id"], v["created_at"], v["updated_at"], boolValue(v["closed"]), boolValue(v["archived"]),
+			stringValue(v["archetype"]), v["deleted_at"] != nil)
+	case "posts":
+		return s.insert("INSERT OR IGNORE INTO post VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			intValue(v["id"]), intValue(v["topic_id"]), v["user_id"], intValue(v["post_number"]),
+			stringValue(v["raw"]), v["created_at"], intValue(v["post_type"]), v["deleted_at"] != nil,
+			boolValue(v["hidden"]))
+	case "post_actions":
+		if intValue(v["post_action_type_id"]) != discourseLike || v["deleted_at"] != nil {
+			return nil
+		}
+		return s.insert("INSERT OR IGNORE INTO post_like VALUES (?, ?)", intValue(v["post_id"]), intValue(v["user_id"]))
+	}
+	return nil
+}
+
+// discourseStatus met en attente un message masqué après des signalements
+func discourseStatus(hidden bool) (string, *string) {
+	if hidden {
+		reason := "masqué après des signalements dans Discourse"
+		return store.StatusPending, &reason
+	}
+	return store.StatusPublished, nil
+}
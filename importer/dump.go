@@ -0,0 +1,591 @@
+package importer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rowFunc reçoit une ligne d'une table du dump, NULL valant nil
+type rowFunc func(table string, values map[string]*string) error
+
+// openDump ouvre un dump SQL, éventuellement compressé en gzip ou contenu dans une
+// archive tar (sauvegarde Discourse) sous le nom dump.sql ou dump.sql.gz
+func openDump(name string) (io.Reader, io.Closer, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := gunzip(bufio.NewReaderSize(file, 1<<20))
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if header, _ := r.Peek(262); len(header) == 262 && string(header[257:262]) == "ustar" {
+		archive := tar.NewReader(r)
+		for {
+			entry, err := archive.Next()
+			if err == io.EOF {
+				file.Close()
+				return nil, nil, fmt.Errorf("%s: no dump.sql or dump.sql.gz in the archive", name)
+			}
+			if err != nil {
+				file.Close()
+				return nil, nil, err
+			}
+			if base := path.Base(entry.Name); base == "dump.sql" || base == "dump.sql.gz" {
+				r, err = gunzip(bufio.NewReaderSize(archive, 1<<20))
+				if err != nil {
+					file.Close()
+					return nil, nil, err
+				}
+				break
+			}
+		}
+	}
+	return r, file, nil
+}
+
+// gunzip décompresse r s'il commence par l'en-tête gzip
+func gunzip(r *bufio.Reader) (*bufio.Reader, error) {
+	if magic, err := r.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return r, nil
+	}
+	unzipped, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReaderSize(unzipped, 1<<20), nil
+}
+
+// mysqlDump lit un dump produit par mysqldump. Les colonnes des INSERT viennent de
+// leur liste explicite ou, à défaut, du CREATE TABLE qui précède. Les instructions
+// des autres tables sont sautées sans être analysées.
+type mysqlDump struct {
+	r       *bufio.Reader
+	columns map[string][]string
+}
+
+func readMySQLDump(r io.Reader, tables map[string]bool, row rowFunc) error {
+	d := &mysqlDump{r: bufio.NewReaderSize(r, 1<<20), columns: map[string][]string{}}
+	for {
+		if err := d.skipSpace(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if c, _ := d.r.Peek(1); c[0] == ';' {
+			d.r.ReadByte()
+			continue
+		}
+
+		word, err := d.word()
+		if err != nil {
+			return err
+		}
+		switch strings.ToUpper(word) {
+		case "CREATE":
+			err = d.create(tables)
+		case "INSERT", "REPLACE":
+			err = d.insert(tables, row)
+		default:
+			err = d.skipStatement()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// create lit les colonnes d'un CREATE TABLE
+func (d *mysqlDump) create(tables map[string]bool) error {
+	if word, err := d.word(); err != nil || !strings.EqualFold(word, "TABLE") {
+		return d.skipStatementAfter(err)
+	}
+	table, err := d.tableName()
+	if err != nil || !tables[table] {
+		return d.skipStatementAfter(err)
+	}
+	if err := d.expect('('); err != nil {
+		return err
+	}
+
+	var columns []string
+	for {
+		if err := d.skipSpace(); err != nil {
+			return err
+		}
+		if c, _ := d.r.Peek(1); c[0] == '`' {
+			name, err := d.identifier()
+			if err != nil {
+				return err
+			}
+			columns = append(columns, name)
+		}
+		// Fin de la définition : type, index ou contrainte
+		end, err := d.skipUntil(",)")
+		if err != nil {
+			return err
+		}
+		if end == ')' {
+			break
+		}
+	}
+	d.columns[table] = columns
+	return d.skipStatement()
+}
+
+// insert lit les lignes d'un INSERT [IGNORE] INTO t [(colonnes)] VALUES (...), ...
+func (d *mysqlDump) insert(tables map[string]bool, row rowFunc) error {
+	for {
+		word, err := d.word()
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(word, "INTO") {
+			break
+		}
+	}
+	table, err := d.tableName()
+	if err != nil || !tables[table] {
+		return d.skipStatementAfter(err)
+	}
+
+	columns := d.columns[table]
+	if err := d.skipSpace(); err != nil {
+		return err
+	}
+	if c, _ := d.r.Peek(1); c[0] == '(' {
+		d.r.ReadByte()
+		columns = nil
+		for {
+			name, err := d.identifier()
+			if err != nil {
+				return err
+			}
+			columns = append(columns, name)
+			end, err := d.skipUntil(",)")
+			if err != nil {
+				return err
+			}
+			if end == ')' {
+				break
+			}
+		}
+	}
+	if columns == nil {
+		return fmt.Errorf("mysql dump: no column list for table %s", table)
+	}
+	if word, err := d.word(); err != nil || !strings.HasPrefix(strings.ToUpper(word), "VALUE") {
+		return fmt.Errorf("mysql dump: VALUES expected after INSERT INTO %s", table)
+	}
+
+	for {
+		if err := d.expect('('); err != nil {
+			return err
+		}
+		values := make(map[string]*string, len(columns))
+		for i := 0; ; i++ {
+			value, err := d.value()
+			if err != nil {
+				return err
+			}
+			if i < len(columns) {
+				values[columns[i]] = value
+			}
+			end, err := d.skipUntil(",)")
+			if err != nil {
+				return err
+			}
+			if end == ')' {
+				break
+			}
+		}
+		if err := row(table, values); err != nil {
+			return err
+		}
+		end, err := d.skipUntil(",;")
+		if err != nil {
+			return err
+		}
+		if end == ';' {
+			return nil
+		}
+	}
+}
+
+// value lit une valeur : chaîne, NULL, nombre ou hexadécimal
+func (d *mysqlDump) value() (*string, error) {
+	if err := d.skipSpace(); err != nil {
+		return nil, err
+	}
+	c, err := d.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if c[0] == '_' {
+		// Introducteur de jeu de caractères : _binary '...'
+		if _, err := d.word(); err != nil {
+			return nil, err
+		}
+		return d.value()
+	}
+	if c[0] == '\'' {
+		value, err := d.quoted()
+		return &value, err
+	}
+
+	var raw strings.Builder
+	for {
+		c, err := d.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if c[0] == ',' || c[0] == ')' {
+			break
+		}
+		d.r.ReadByte()
+		raw.WriteByte(c[0])
+	}
+	value := strings.TrimSpace(raw.String())
+	switch {
+	case strings.EqualFold(value, "NULL"):
+		return nil, nil
+	case strings.HasPrefix(value, "0x"):
+		decoded, err := hex.DecodeString(value[2:])
+		if err != nil {
+			return nil, fmt.Errorf("mysql dump: invalid hexadecimal value %.20s", value)
+		}
+		value = string(decoded)
+	}
+	return &value, nil
+}
+
+// quoted lit une chaîne entre apostrophes avec les échappements de MySQL
+func (d *mysqlDump) quoted() (string, error) {
+	d.r.ReadByte()
+	var value strings.Builder
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return "", unexpected(err)
+		}
+		switch c {
+		case '\\':
+			c, err = d.r.ReadByte()
+			if err != nil {
+				return "", unexpected(err)
+			}
+			switch c {
+			case '0':
+				c = 0
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'Z':
+				c = 0x1a
+			}
+		case '\'':
+			if next, _ := d.r.Peek(1); len(next) == 0 || next[0] != '\'' {
+				return value.String(), nil
+			}
+			d.r.ReadByte()
+		}
+		value.WriteByte(c)
+	}
+}
+
+// word lit un mot-clé
+func (d *mysqlDump) word() (string, error) {
+	if err := d.skipSpace(); err != nil {
+		return "", err
+	}
+	var word strings.Builder
+	for {
+		c, err := d.r.Peek(1)
+		if err == io.EOF && word.Len() > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if !isWordByte(c[0]) {
+			break
+		}
+		d.r.ReadByte()
+		word.WriteByte(c[0])
+	}
+	if word.Len() == 0 {
+		c, _ := d.r.ReadByte()
+		word.WriteByte(c)
+	}
+	return word.String(), nil
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// tableName lit un nom de table, sans son éventuel schéma ; IF NOT EXISTS est sauté
+func (d *mysqlDump) tableName() (string, error) {
+	name, err := d.identifier()
+	if strings.EqualFold(name, "IF") {
+		d.word()
+		d.word()
+		name, err = d.identifier()
+	}
+	if err != nil {
+		return "", err
+	}
+	if c, _ := d.r.Peek(1); len(c) == 1 && c[0] == '.' {
+		d.r.ReadByte()
+		return d.identifier()
+	}
+	return name, nil
+}
+
+// identifier lit un identifiant, entre accents graves ou non
+func (d *mysqlDump) identifier() (string, error) {
+	if err := d.skipSpace(); err != nil {
+		return "", err
+	}
+	if c, _ := d.r.Peek(1); c[0] != '`' {
+		return d.word()
+	}
+	d.r.ReadByte()
+	name, err := d.r.ReadString('`')
+	if err != nil {
+		return "", unexpected(err)
+	}
+	return strings.TrimSuffix(name, "`"), nil
+}
+
+func (d *mysqlDump) expect(c byte) error {
+	if err := d.skipSpace(); err != nil {
+		return unexpected(err)
+	}
+	got, err := d.r.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+	if got != c {
+		return fmt.Errorf("mysql dump: %q expected, found %q", c, got)
+	}
+	return nil
+}
+
+// skipSpace saute les blancs et les commentaires, y compris /*!... */
+func (d *mysqlDump) skipSpace() error {
+	for {
+		c, err := d.r.Peek(2)
+		if len(c) == 0 {
+			return err
+		}
+		switch {
+		case c[0] == ' ' || c[0] == '\t' || c[0] == '\n' || c[0] == '\r':
+			d.r.ReadByte()
+		case c[0] == '#' || len(c) == 2 && c[0] == '-' && c[1] == '-':
+			if _, err := d.r.ReadString('\n'); err != nil {
+				return err
+			}
+		case len(c) == 2 && c[0] == '/' && c[1] == '*':
+			d.r.Discard(2)
+			for {
+				if _, err := d.r.ReadString('*'); err != nil {
+					return unexpected(err)
+				}
+				if next, _ := d.r.Peek(1); len(next) == 1 && next[0] == '/' {
+					d.r.ReadByte()
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// skipUntil avance jusqu'au premier des caractères stops hors chaînes et hors
+// parenthèses, et le renvoie
+func (d *mysqlDump) skipUntil(stops string) (byte, error) {
+	depth := 0
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			if err := d.skipQuoted(c); err != nil {
+				return 0, err
+			}
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth == 0 && strings.IndexByte(stops, c) >= 0:
+			return c, nil
+		}
+	}
+}
+
+func (d *mysqlDump) skipQuoted(quote byte) error {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		if c == '\\' && quote != '`' {
+			if _, err := d.r.ReadByte(); err != nil {
+				return unexpected(err)
+			}
+		} else if c == quote {
+			return nil
+		}
+	}
+}
+
+// skipStatement avance jusqu'à la fin de l'instruction courante
+func (d *mysqlDump) skipStatement() error {
+	_, err := d.skipUntil(";")
+	return err
+}
+
+func (d *mysqlDump) skipStatementAfter(err error) error {
+	if err != nil {
+		return err
+	}
+	return d.skipStatement()
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return errors.New("dump is truncated")
+	}
+	return err
+}
+
+// copyHeader reconnaît le début des données d'une table dans un dump pg_dump
+var copyHeader = regexp.MustCompile(`^COPY ([\w."]+) \(([^)]*)\) FROM stdin;`)
+
+// readPostgresDump lit les blocs COPY ... FROM stdin d'un dump pg_dump au format
+// texte ; le schéma est retiré des noms de tables
+func readPostgresDump(r io.Reader, tables map[string]bool, row rowFunc) error {
+	lines := bufio.NewReaderSize(r, 1<<20)
+	for {
+		line, err := lines.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		match := copyHeader.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		table := strings.Trim(match[1][strings.LastIndexByte(match[1], '.')+1:], `"`)
+		columns := strings.Split(match[2], ",")
+		for i := range columns {
+			columns[i] = strings.Trim(strings.TrimSpace(columns[i]), `"`)
+		}
+
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				return unexpected(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == `\.` {
+				break
+			}
+			if !tables[table] {
+				continue
+			}
+			fields := strings.Split(line, "\t")
+			if len(fields) != len(columns) {
+				return fmt.Errorf("postgres dump: %d fields for the %d columns of %s", len(fields), len(columns), table)
+			}
+			values := make(map[string]*string, len(columns))
+			for i, field := range fields {
+				if field != `\N` {
+					value := copyUnescape(field)
+					values[columns[i]] = &value
+				}
+			}
+			if err := row(table, values); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyUnescape décode un champ du format texte de COPY
+func copyUnescape(field string) string {
+	if strings.IndexByte(field, '\\') < 0 {
+		return field
+	}
+	var value bytes.Buffer
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i+1 == len(field) {
+			value.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = field[i]; c {
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'v':
+			value.WriteByte('\v')
+		case 'x':
+			end := i + 1
+			for end < len(field) && end < i+3 && isHex(field[end]) {
+				end++
+			}
+			code, _ := strconv.ParseUint(field[i+1:end], 16, 8)
+			value.WriteByte(byte(code))
+			i = end - 1
+		default:
+			if c >= '0' && c <= '7' {
+				end := i
+				for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+					end++
+				}
+				code, _ := strconv.ParseUint(field[i:end], 8, 8)
+				value.WriteByte(byte(code))
+				i = end - 1
+			} else {
+				value.WriteByte(c)
+			}
+		}
+	}
+	return value.String()
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
@@ -0,0 +1,214 @@
+// Package importer convertit les données d'autres logiciels de forum (phpBB,
+// Discourse) en archive du forum (voir le package archive), que forum import
+// restaure ensuite. Les identifiants d'origine des comptes, topics et messages sont
+// conservés, et une table de redirection associe les anciennes URL aux nouvelles.
+//
+// Le dump est lu une seule fois, dans l'ordre où il contient les tables, vers une
+// base SQLite temporaire ; l'archive est ensuite écrite depuis cette base dans
+// l'ordre des clés étrangères. La mémoire utilisée ne dépend pas de la taille du
+// forum.
+package importer
+
+import (
+	"encoding/csv"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/archive"
+)
+
+// Options règle une conversion
+type Options struct {
+	// TablePrefix est le préfixe des tables phpBB (phpbb_ par défaut)
+	TablePrefix string
+	// PasswordHashes garde les hachages bcrypt, que le forum sait vérifier ; les
+	// autres formats sont toujours abandonnés
+	PasswordHashes bool
+	// Redirects reçoit la table de redirection en CSV (ancienne URL, nouvelle URL)
+	Redirects io.Writer
+	// OldPrefix est ajouté devant les anciennes URL, par exemple /forum pour un phpBB
+	// installé dans un sous-répertoire
+	OldPrefix string
+}
+
+// Report résume une conversion
+type Report struct {
+	Counts map[string]int `json:"counts"` // enregistrements écrits dans l'archive
+	// Skipped compte ce qui n'a pas d'équivalent dans le forum, par motif
+	Skipped   map[string]int `json:"skipped"`
+	Redirects int            `json:"redirects"`
+}
+
+// Placeholder est le nom du compte qui reçoit les contenus dont l'auteur n'existe
+// plus, les topics et messages du forum ayant toujours un auteur
+const Placeholder = "Anonymous"
+
+// redirects écrit la table de redirection, si elle est demandée
+type redirects struct {
+	csv    *csv.Writer
+	prefix string
+	count  int
+}
+
+func newRedirects(options Options) *redirects {
+	r := &redirects{prefix: strings.TrimSuffix(options.OldPrefix, "/")}
+	if options.Redirects != nil {
+		r.csv = csv.NewWriter(options.Redirects)
+	}
+	return r
+}
+
+func (r *redirects) add(old, target string) error {
+	if r.csv == nil {
+		return nil
+	}
+	r.count++
+	return r.csv.Write([]string{r.prefix + old, target})
+}
+
+func (r *redirects) flush() error {
+	if r.csv == nil {
+		return nil
+	}
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+func topicURL(id int) string { return "/topic?id=" + strconv.Itoa(id) }
+
+func messageURL(topicID, messageID int) string {
+	return topicURL(topicID) + "#message-" + strconv.Itoa(messageID)
+}
+
+func categoryURL(slug string) string { return "/index?tags=" + url.QueryEscape(slug) }
+
+func userURL(username string) string { return "/user?name=" + url.QueryEscape(username) }
+
+// dateTime est le format des dates de la base, en heure locale
+const dateTime = "2006-01-02 15:04:05"
+
+// unixDate convertit un horodatage Unix de phpBB ; 0 signifie « jamais »
+func unixDate(seconds int64) *string {
+	if seconds <= 0 {
+		return nil
+	}
+	date := time.Unix(seconds, 0).Local().Format(dateTime)
+	return &date
+}
+
+// postgresDate convertit un timestamp sans fuseau de Discourse, enregistré en UTC
+func postgresDate(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", *value, time.UTC)
+	if err != nil {
+		return nil
+	}
+	date := parsed.Local().Format(dateTime)
+	return &date
+}
+
+var slugAccents = strings.NewReplacer(
+	"à", "a", "â", "a", "ä", "a", "á", "a", "ã", "a", "å", "a", "ç", "c", "é", "e", "è", "e",
+	"ê", "e", "ë", "e", "î", "i", "ï", "i", "í", "i", "ì", "i", "ñ", "n", "ô", "o", "ö", "o",
+	"ó", "o", "ò", "o", "õ", "o", "ù", "u", "û", "u", "ü", "u", "ú", "u", "ÿ", "y", "œ", "oe",
+	"æ", "ae", "ß", "ss",
+)
+
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugs attribue des slugs de catégorie uniques, au format accepté par forum
+// category add
+type slugs map[string]bool
+
+func (s slugs) make(name, fallback string) string {
+	slug := slugSeparators.ReplaceAllString(slugAccents.Replace(strings.ToLower(name)), "-")
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		slug = fallback
+	}
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	unique := slug
+	for i := 2; s[unique]; i++ {
+		suffix := "-" + strconv.Itoa(i)
+		unique = strings.TrimRight(slug[:min(len(slug), 50-len(suffix))], "-") + suffix
+	}
+	s[unique] = true
+	return unique
+}
+
+var (
+	phpbbMarkup   = regexp.MustCompile(`<!-- ([smwle])(.*?) -->(.*?)<!-- [smwle].*? -->`)
+	htmlHref      = regexp.MustCompile(`href="([^"]*)"`)
+	htmlTag       = regexp.MustCompile(`<[^>]*>`)
+	s9eParagraphs = strings.NewReplacer("<br/>\n", "\n", "<br/>", "\n", "</p>\n<p>", "\n\n", "</p><p>", "\n\n")
+)
+
+// phpbbText convertit un message phpBB en Markdown. phpBB 3.2 et suivants stockent
+// un XML dont le texte est le BBCode d'origine ; les versions précédentes stockent le
+// BBCode suffixé de bbcode_uid, avec smileys et liens automatiques en HTML.
+func phpbbText(text, uid string) string {
+	if strings.HasPrefix(text, "<r>") || strings.HasPrefix(text, "<t>") {
+		text = htmlTag.ReplaceAllString(s9eParagraphs.Replace(text), "")
+	} else {
+		text = phpbbMarkup.ReplaceAllStringFunc(text, func(markup string) string {
+			match := phpbbMarkup.FindStringSubmatch(markup)
+			if match[1] == "s" {
+				return match[2]
+			}
+			if href := htmlHref.FindStringSubmatch(match[3]); href != nil {
+				return strings.TrimPrefix(href[1], "mailto:")
+			}
+			return htmlTag.ReplaceAllString(match[3], "")
+		})
+		text = htmlTag.ReplaceAllString(strings.ReplaceAll(text, "<br />", "\n"), "")
+		if uid != "" {
+			text = strings.ReplaceAll(text, ":"+uid+"]", "]")
+			text = strings.NewReplacer("[/list:u]", "[/list]", "[/list:o]", "[/list]", "[/*:m]", "[/*]").Replace(text)
+		}
+	}
+	return bbcodeToMarkdown(html.UnescapeString(text), true)
+}
+
+// newWriter crée l'archive d'une conversion
+func newWriter(w io.Writer, source string, options Options) (*archive.Writer, error) {
+	return archive.NewWriter(w, archive.Header{Source: source, PasswordHashes: options.PasswordHashes})
+}
+
+func intValue(value *string) int {
+	if value == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(*value))
+	return n
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// firstValue renvoie la première des colonnes présente, les noms changeant d'une
+// version à l'autre
+func firstValue(values map[string]*string, columns ...string) *string {
+	for _, column := range columns {
+		if value, ok := values[column]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+func boolValue(value *string) bool {
+	return value != nil && (*value == "t" || *value == "true" || *value == "1")
+}
@@ -0,0 +1,289 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+
+	"forum/archive"
+	"forum/handlers"
+	"forum/store"
+)
+
+const phpbbSchema = `
+	CREATE TABLE forum (id INTEGER PRIMARY KEY, name TEXT, type INT, position INT);
+	CREATE TABLE user (id INTEGER PRIMARY KEY, username TEXT, mail TEXT, password TEXT, type INT,
+		group_id INT, regdate INT, lastvisit INT);
+	CREATE TABLE topic (id INTEGER PRIMARY KEY, forum_id INT, title TEXT, user_id INT, time INT,
+		first_post_id INT, status INT, moved_id INT, visibility INT);
+	CREATE TABLE post (id INTEGER PRIMARY KEY, topic_id INT, user_id INT, time INT, text TEXT,
+		uid TEXT, visibility INT);
+	CREATE TABLE thanks (post_id INT, user_id INT, PRIMARY KEY (post_id, user_id));`
+
+// Constantes de phpBB
+const (
+	phpbbItemLocked    = 1 // topic_status d'un sujet verrouillé
+	phpbbGroupAdmins   = 5
+	phpbbGroupGlobMods = 4
+)
+
+// Nettoyage de la base de travail avant l'écriture de l'archive ; les lignes
+// supprimées ou modifiées sont comptées sous le motif indiqué. Dans phpBB, les
+// forums de type 1 contiennent des sujets, les comptes de type 2 sont les invités et
+// les robots, et le compte 1 (Anonymous) reçoit les messages des invités et des
+// comptes supprimés.
+var phpbbCleanup = []cleanupStep{
+	{"moved_topic_shadows", "DELETE FROM topic WHERE moved_id <> 0"},
+	{"deleted_topics", "DELETE FROM topic WHERE visibility NOT IN (0, 1)"},
+	{"topics_outside_forums", "DELETE FROM topic WHERE forum_id NOT IN (SELECT id FROM forum WHERE type = 1)"},
+	{"deleted_posts", "DELETE FROM post WHERE visibility NOT IN (0, 1)"},
+	{"topics_without_first_post", "DELETE FROM topic WHERE first_post_id NOT IN (SELECT id FROM post)"},
+	{"posts_of_skipped_topics", "DELETE FROM post WHERE topic_id NOT IN (SELECT id FROM topic)"},
+	{"bots", "DELETE FROM user WHERE type = 2 AND id <> 1"},
+	{"", "INSERT OR IGNORE INTO user (id, username, mail, password, type, group_id) VALUES (1, '" + Placeholder + "', '', '', 2, 0)"},
+	{"topics_of_deleted_users", "UPDATE topic SET user_id = 1 WHERE user_id NOT IN (SELECT id FROM user)"},
+	{"posts_of_deleted_users", "UPDATE post SET user_id = 1 WHERE user_id NOT IN (SELECT id FROM user)"},
+	{"likes_of_skipped_content", "DELETE FROM thanks WHERE user_id NOT IN (SELECT id FROM user) OR post_id NOT IN (SELECT id FROM post)"},
+	{"likes_on_replies", "DELETE FROM thanks WHERE post_id NOT IN (SELECT first_post_id FROM topic)"},
+	{"", "CREATE INDEX topic_user ON topic (user_id)"},
+}
+
+// PhpBB convertit le dump MySQL d'un forum phpBB 3 (mysqldump, éventuellement
+// compressé en gzip) en archive écrite dans w.
+//
+// Chaque forum qui contient des sujets devient une catégorie ; le premier message
+// d'un sujet en devient la description et les suivants les messages. Les likes
+// viennent de l'extension « Thanks for posts » quand sa table est présente. Les
+// mots de passe ne sont gardés que s'ils sont hachés avec bcrypt (phpBB 3.1 et
+// suivants).
+func PhpBB(dump string, w io.Writer, options Options) (Report, error) {
+	prefix := options.TablePrefix
+	if prefix == "" {
+		prefix = "phpbb_"
+	}
+	report := Report{Skipped: map[string]int{}}
+
+	s, err := openStaging(phpbbSchema)
+	if err != nil {
+		return report, err
+	}
+	defer s.Close()
+
+	r, file, err := openDump(dump)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+	tables := map[string]bool{}
+	for _, table := range []string{"forums", "users", "topics", "posts", "thanks"} {
+		tables[prefix+table] = true
+	}
+	err = readMySQLDump(r, tables, func(table string, values map[string]*string) error {
+		return phpbbRow(s, strings.TrimPrefix(table, prefix), values)
+	})
+	if err != nil {
+		return report, err
+	}
+	if err := s.checkLoaded("forum", "user", "topic"); err != nil {
+		return report, fmt.Errorf("no phpBB tables with the %q prefix in the dump: %w", prefix, err)
+	}
+	if err := s.cleanup(phpbbCleanup, report.Skipped); err != nil {
+		return report, err
+	}
+
+	writer, err := newWriter(w, "phpbb", options)
+	if err != nil {
+		return report, err
+	}
+	redirects := newRedirects(options)
+
+	categories := map[int]string{}
+	used := slugs{}
+	err = s.each("SELECT id, name FROM forum WHERE type = 1 ORDER BY position, id", func(rows *sql.Rows) error {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		name = html.UnescapeString(name)
+		slug := used.make(name, "forum-"+strconv.Itoa(id))
+		categories[id] = slug
+		if err := writer.WriteCategory(archive.Category{Slug: slug, Label: name, Position: len(categories)}); err != nil {
+			return err
+		}
+		return redirects.add("/viewforum.php?f="+strconv.Itoa(id), categoryURL(slug))
+	})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT u.id, u.username, u.mail, u.password, u.group_id, u.regdate, u.lastvisit,
+			(SELECT COUNT(*) FROM topic t WHERE t.user_id = u.id AND t.visibility = 1)
+		FROM user u ORDER BY u.id`,
+		func(rows *sql.Rows) error {
+			var u archive.User
+			var password string
+			var group int
+			var registered, visited int64
+			err := rows.Scan(&u.ID, &u.Username, &u.Mail, &password, &group, &registered, &visited, &u.TopicNbr)
+			if err != nil {
+				return err
+			}
+			u.Username = html.UnescapeString(u.Username)
+			if options.PasswordHashes && strings.HasPrefix(password, "$2") {
+				u.PasswordHash = password
+			}
+			u.CreatedAt, u.LastConnection = unixDate(registered), unixDate(visited)
+			role := handlers.RoleUser
+			switch group {
+			case phpbbGroupAdmins:
+				role = handlers.RoleAdmin
+			case phpbbGroupGlobMods:
+				role = handlers.RoleModerator
+			}
+			u.RoleID = &role
+			if err := writer.WriteUser(u); err != nil {
+				return err
+			}
+			return redirects.add("/memberlist.php?mode=viewprofile&u="+strconv.Itoa(u.ID), userURL(u.Username))
+		})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT t.id, t.forum_id, t.title, t.user_id, t.time, t.status, t.visibility, t.first_post_id,
+			p.text, p.uid
+		FROM topic t JOIN post p ON p.id = t.first_post_id ORDER BY t.id`,
+		func(rows *sql.Rows) error {
+			var t archive.Topic
+			var forum, author, status, visibility, firstPost int
+			var created int64
+			var text, uid string
+			err := rows.Scan(&t.ID, &forum, &t.Title, &author, &created, &status, &visibility, &firstPost, &text, &uid)
+			if err != nil {
+				return err
+			}
+			tags, description := categories[forum], phpbbText(text, uid)
+			state := handlers.StateOpen
+			if status == phpbbItemLocked {
+				state = handlers.StateClosed
+			}
+			t.Title = html.UnescapeString(t.Title)
+			t.Tags, t.Description, t.UserID, t.StateID = &tags, &description, &author, &state
+			t.Status, t.HeldReason = phpbbStatus(visibility)
+			t.CreatedAt = unixDate(created)
+			t.UpdatedAt = t.CreatedAt
+			if err := writer.WriteTopic(t); err != nil {
+				return err
+			}
+			target := topicURL(t.ID)
+			for _, old := range []string{
+				"/viewtopic.php?t=" + strconv.Itoa(t.ID),
+				"/viewtopic.php?f=" + strconv.Itoa(forum) + "&t=" + strconv.Itoa(t.ID),
+				"/viewtopic.php?p=" + strconv.Itoa(firstPost),
+			} {
+				if err := redirects.add(old, target); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT p.id, p.topic_id, p.user_id, p.time, p.text, p.uid, p.visibility
+		FROM post p JOIN topic t ON t.id = p.topic_id
+		WHERE p.id <> t.first_post_id ORDER BY p.id`,
+		func(rows *sql.Rows) error {
+			var m archive.Message
+			var topic, author, visibility int
+			var created int64
+			var text, uid string
+			if err := rows.Scan(&m.ID, &topic, &author, &created, &text, &uid, &visibility); err != nil {
+				return err
+			}
+			m.TopicID, m.UserID, m.Content = &topic, &author, phpbbText(text, uid)
+			m.Status, m.HeldReason = phpbbStatus(visibility)
+			m.CreatedAt = unixDate(created)
+			if err := writer.WriteMessage(m); err != nil {
+				return err
+			}
+			return redirects.add("/viewtopic.php?p="+strconv.Itoa(m.ID), messageURL(topic, m.ID))
+		})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.each(`
+		SELECT DISTINCT th.user_id, t.id FROM thanks th JOIN topic t ON t.first_post_id = th.post_id
+		ORDER BY th.user_id, t.id`,
+		func(rows *sql.Rows) error {
+			like := archive.TopicLike{Liked: true}
+			if err := rows.Scan(&like.UserID, &like.TopicID); err != nil {
+				return err
+			}
+			return writer.WriteTopicLike(like)
+		})
+	if err != nil {
+		return report, err
+	}
+
+	if err := redirects.flush(); err != nil {
+		return report, err
+	}
+	report.Counts, report.Redirects = writer.Counts(), redirects.count
+	return report, writer.Close()
+}
+
+// phpbbRow copie dans la base de travail les colonnes utiles d'une ligne du dump. Les
+// noms des colonnes de visibilité ont changé avec phpBB 3.1.
+func phpbbRow(s *staging, table string, v map[string]*string) error {
+	switch table {
+	case "forums":
+		return s.insert("INSERT OR IGNORE INTO forum VALUES (?, ?, ?, ?)",
+			intValue(v["forum_id"]), stringValue(v["forum_name"]), intValue(v["forum_type"]), intValue(v["left_id"]))
+	case "users":
+		return s.insert("INSERT OR IGNORE INTO user VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			intValue(v["user_id"]), stringValue(v["username"]), stringValue(v["user_email"]),
+			stringValue(v["user_password"]), intValue(v["user_type"]), intValue(v["group_id"]),
+			intValue(v["user_regdate"]), intValue(v["user_lastvisit"]))
+	case "topics":
+		return s.insert("INSERT OR IGNORE INTO topic VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			intValue(v["topic_id"]), intValue(v["forum_id"]), stringValue(v["topic_title"]),
+			intValue(v["topic_poster"]), intValue(v["topic_time"]), intValue(v["topic_first_post_id"]),
+			intValue(v["topic_status"]), intValue(v["topic_moved_id"]),
+			phpbbVisibility(v, "topic_visibility", "topic_approved"))
+	case "posts":
+		return s.insert("INSERT OR IGNORE INTO post VALUES (?, ?, ?, ?, ?, ?, ?)",
+			intValue(v["post_id"]), intValue(v["topic_id"]), intValue(v["poster_id"]),
+			intValue(v["post_time"]), stringValue(v["post_text"]), stringValue(v["bbcode_uid"]),
+			phpbbVisibility(v, "post_visibility", "post_approved"))
+	case "thanks":
+		return s.insert("INSERT OR IGNORE INTO thanks VALUES (?, ?)", intValue(v["post_id"]), intValue(v["user_id"]))
+	}
+	return nil
+}
+
+// phpbbVisibility vaut 1 pour un contenu publié, 0 en attente de validation et plus
+// pour un contenu supprimé
+func phpbbVisibility(v map[string]*string, columns ...string) int {
+	if value := firstValue(v, columns...); value != nil {
+		return intValue(value)
+	}
+	return 1
+}
+
+func phpbbStatus(visibility int) (string, *string) {
+	if visibility == 0 {
+		reason := "en attente de validation dans phpBB"
+		return store.StatusPending, &reason
+	}
+	return store.StatusPublished, nil
+}
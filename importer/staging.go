@@ -0,0 +1,163 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// stagingBatch est le nombre de lignes écrites par transaction dans la base de
+// travail
+const stagingBatch = 5000
+
+// staging est une base SQLite temporaire qui reçoit les tables utiles du dump dans
+// l'ordre où il les contient, pour les relire ensuite dans l'ordre de l'archive
+// sans rien garder en mémoire
+type staging struct {
+	db         *sql.DB
+	dir        string
+	tx         *sql.Tx
+	statements map[string]*sql.Stmt
+	pending    int
+}
+
+func openStaging(schema string) (*staging, error) {
+	dir, err := os.MkdirTemp("", "forum-import-")
+	if err != nil {
+		return nil, err
+	}
+	// La base est jetable : ni journal ni synchronisation
+	source := "file:" + filepath.Join(dir, "staging.db") + "?_pragma=journal_mode(OFF)&_pragma=synchronous(OFF)"
+	db, err := sql.Open("sqlite", source)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	s := &staging{db: db, dir: dir}
+	if err := s.exec(schema); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// insert ajoute une ligne ; les écritures sont validées par lots
+func (s *staging) insert(query string, args ...interface{}) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx, s.statements = tx, map[string]*sql.Stmt{}
+	}
+	statement := s.statements[query]
+	if statement == nil {
+		var err error
+		if statement, err = s.tx.Prepare(query); err != nil {
+			return err
+		}
+		s.statements[query] = statement
+	}
+	if _, err := statement.Exec(args...); err != nil {
+		return err
+	}
+	if s.pending++; s.pending >= stagingBatch {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush valide les insertions en attente
+func (s *staging) flush() error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx, s.pending = nil, 0
+	return err
+}
+
+// exec exécute une requête de préparation des données, après les insertions en
+// attente
+func (s *staging) exec(query string, args ...interface{}) error {
+	_, err := s.affected(query, args...)
+	return err
+}
+
+// affected exécute query comme exec et renvoie le nombre de lignes touchées
+func (s *staging) affected(query string, args ...interface{}) (int, error) {
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (s *staging) Close() error {
+	if s.tx != nil {
+		s.tx.Rollback()
+	}
+	err := s.db.Close()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+// cleanupStep est une requête de nettoyage de la base de travail ; les lignes
+// touchées sont comptées sous skipped, sauf s'il est vide
+type cleanupStep struct {
+	skipped string
+	query   string
+}
+
+func (s *staging) cleanup(steps []cleanupStep, skipped map[string]int) error {
+	for _, step := range steps {
+		n, err := s.affected(step.query)
+		if err != nil {
+			return fmt.Errorf("%s: %w", step.query, err)
+		}
+		if step.skipped != "" && n > 0 {
+			skipped[step.skipped] += n
+		}
+	}
+	return nil
+}
+
+// checkLoaded vérifie que le dump a rempli les tables indispensables
+func (s *staging) checkLoaded(tables ...string) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		var rows int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM " + table + " LIMIT 1)").Scan(&rows); err != nil {
+			return err
+		}
+		if rows == 0 {
+			return fmt.Errorf("no %s rows found", table)
+		}
+	}
+	return nil
+}
+
+// each appelle scan pour chaque ligne de query
+func (s *staging) each(query string, scan func(rows *sql.Rows) error) error {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,152 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message représente un e-mail avec ses versions texte et HTML
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// En-têtes supplémentaires (List-Unsubscribe, Reply-To...)
+	Headers map[string]string
+}
+
+// Transport envoie des e-mails ; il peut être remplacé par CaptureTransport en test
+type Transport interface {
+	Send(msg Message) error
+}
+
+// SMTPTransport envoie les e-mails via un serveur SMTP
+type SMTPTransport struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (t *SMTPTransport) Send(msg Message) error {
+	body, err := Build(t.From, msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(t.Addr, t.Auth, t.From, []string{msg.To}, body)
+}
+
+// CaptureTransport conserve les e-mails en mémoire au lieu de les envoyer
+type CaptureTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (t *CaptureTransport) Send(msg Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, msg)
+	log.Printf("Captured mail to %s: %s", msg.To, msg.Subject)
+	return nil
+}
+
+// Messages renvoie une copie des e-mails capturés
+func (t *CaptureTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.messages...)
+}
+
+// FromEnv construit le transport à partir de SMTP_HOST, SMTP_PORT, SMTP_USER,
+// SMTP_PASS et MAIL_FROM ; sans SMTP_HOST les e-mails sont seulement capturés
+func FromEnv() Transport {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Println("SMTP_HOST non défini : les e-mails sont capturés localement")
+		return &CaptureTransport{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "forum@localhost"
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)
+	}
+	return &SMTPTransport{Addr: host + ":" + port, From: from, Auth: auth}
+}
+
+// Build produit le message MIME multipart/alternative prêt à être envoyé
+func Build(from string, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := map[string]string{
+		"From":         from,
+		"To":           msg.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+		"Content-Type": fmt.Sprintf("multipart/alternative; boundary=%q", writer.Boundary()),
+	}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var head bytes.Buffer
+	for _, key := range keys {
+		// Empêcher l'injection d'en-têtes via des retours à la ligne
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(headers[key])
+		fmt.Fprintf(&head, "%s: %s\r\n", key, value)
+	}
+	head.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, p := range parts {
+		if p.body == "" {
+			continue
+		}
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write([]byte(p.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return append(head.Bytes(), buf.Bytes()...), nil
+}
@@ -1,33 +1,41 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"forum/config"
 	"forum/handlers"
+	"forum/mailer"
+	"forum/scheduler"
+	"forum/store"
 
 	"github.com/dgrijalva/jwt-go"
-	_ "github.com/go-sql-driver/mysql"
 )
 
-var db *sql.DB
+var db store.Store
 
+// initDB ouvre la base choisie par DB_DRIVER : MySQL par défaut (DB_USER, DB_PASS,
+// DB_HOST, DB_PORT et DB_NAME) ou SQLite, sans serveur, dans le fichier DB_PATH
 func initDB() {
-	var err error
-	// Configuration pour WampServer par défaut
-	dsn := "root:@tcp(localhost:3306)/forum"
-	db, err = sql.Open("mysql", dsn)
-	if err != nil {
-		log.Fatal(err)
+	driver := config.Env("DB_DRIVER", "mysql")
+	source := config.Env("DB_PATH", "forum.db")
+	if driver == "mysql" {
+		// Configuration pour WampServer par défaut
+		source = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
+			config.Env("DB_USER", "root"), config.Env("DB_PASS", ""),
+			config.Env("DB_HOST", "localhost"), config.Env("DB_PORT", "3306"), config.Env("DB_NAME", "forum"))
 	}
 
-	err = db.Ping()
+	var err error
+	db, err = store.Open(driver, source)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -49,106 +57,10 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	// Récupérer le tag sélectionné
 	selectedTag := r.URL.Query().Get("tags")
 
-	// Construire la requête SQL de base
-	baseQuery := `
-		SELECT t.topic_id, t.title, t.description, t.tags, t.user_id, u.username, t.state_id,
-			(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = t.topic_id AND liked = TRUE) as likes,
-			(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = t.topic_id AND liked = FALSE) as dislikes
-		FROM topic t
-		JOIN user u ON t.user_id = u.user_id
-	`
-
-	// Ajouter le filtre par tag si un tag est sélectionné
-	var whereClause string
-	if selectedTag != "" {
-		whereClause = " WHERE t.tags LIKE ? OR t.tags LIKE ? OR t.tags LIKE ? OR t.tags = ?"
-	}
-
-	// Ajouter l'ordre de tri approprié
-	var orderBy string
-	switch sortBy {
-	case "likes":
-		orderBy = "ORDER BY likes DESC"
-	case "dislikes":
-		orderBy = "ORDER BY dislikes DESC"
-	default:
-		orderBy = "ORDER BY t.created_at DESC"
-	}
-
-	// Construire la requête finale
-	query := baseQuery
-	if whereClause != "" {
-		query += whereClause
-	}
-	query += " " + orderBy
-
-	// Exécuter la requête
-	var rows *sql.Rows
-	var err error
-	if selectedTag != "" {
-		// Préparer les paramètres pour la recherche de tag
-		// Format: "tag, ", ", tag, ", ", tag", "tag"
-		tagPatterns := []string{
-			selectedTag + ", %",         // tag au début
-			"%, " + selectedTag + ", %", // tag au milieu
-			"%, " + selectedTag,         // tag à la fin
-			selectedTag,                 // tag unique
-		}
-		rows, err = db.Query(query, tagPatterns[0], tagPatterns[1], tagPatterns[2], tagPatterns[3])
-	} else {
-		rows, err = db.Query(query)
-	}
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var topics []struct {
-		ID          int
-		Title       string
-		Description string
-		Tags        string
-		UserID      int
-		Username    string
-		StateID     int
-		Likes       int
-		Dislikes    int
-	}
-
-	for rows.Next() {
-		var topic struct {
-			ID          int
-			Title       string
-			Description string
-			Tags        string
-			UserID      int
-			Username    string
-			StateID     int
-			Likes       int
-			Dislikes    int
-		}
-		err := rows.Scan(
-			&topic.ID,
-			&topic.Title,
-			&topic.Description,
-			&topic.Tags,
-			&topic.UserID,
-			&topic.Username,
-			&topic.StateID,
-			&topic.Likes,
-			&topic.Dislikes,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		topics = append(topics, topic)
-	}
-
-	// Récupérer l'utilisateur connecté
+	// Récupérer l'utilisateur connecté et ses notifications non lues
 	cookie, _ := r.Cookie("token_form")
 	var username string
+	var userID, unread, unreadConversations int
 	if cookie != nil {
 		claims := &handlers.Claims{}
 		token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
@@ -156,54 +68,69 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		if err == nil && token.Valid {
 			username = claims.Username
+			userID = claims.UserID
+			unread = handlers.UnreadNotificationCount(db, claims.UserID)
+			unreadConversations = handlers.UnreadConversationCount(db, claims.UserID)
 		}
 	}
 
+	// Les topics en attente de validation ne sont visibles que de leur auteur
+	topics, err := db.Topics().List(store.TopicFilter{
+		Viewer: store.Viewer{UserID: userID},
+		Tag:    selectedTag,
+		Sort:   sortBy,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Préparer les thèmes avec leur état de sélection
+	categories, err := db.Categories().List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	themes := make([]struct {
 		ID       string
 		Label    string
 		Selected bool
-	}, len(config.Themes))
-	for i, theme := range config.Themes {
+	}, len(categories))
+	for i, category := range categories {
 		themes[i] = struct {
 			ID       string
 			Label    string
 			Selected bool
 		}{
-			ID:       theme.ID,
-			Label:    theme.Label,
-			Selected: theme.ID == selectedTag,
+			ID:       category.Slug,
+			Label:    category.Label,
+			Selected: category.Slug == selectedTag,
 		}
 	}
 
 	// Préparer les données pour le template
 	data := struct {
-		Topics []struct {
-			ID          int
-			Title       string
-			Description string
-			Tags        string
-			UserID      int
-			Username    string
-			StateID     int
-			Likes       int
-			Dislikes    int
-		}
-		Username string
-		Themes   []struct {
+		Topics              []handlers.Topic
+		Username            string
+		Unread              int
+		UnreadConversations int
+		Themes              []struct {
 			ID       string
 			Label    string
 			Selected bool
 		}
 		SortBy       string
 		SelectedTags string
+		Error        string
 	}{
-		Topics:       topics,
-		Username:     username,
-		Themes:       themes,
-		SortBy:       sortBy,
-		SelectedTags: selectedTag,
+		Topics:              topics,
+		Username:            username,
+		Unread:              unread,
+		UnreadConversations: unreadConversations,
+		Themes:              themes,
+		SortBy:              sortBy,
+		SelectedTags:        selectedTag,
+		Error:               r.URL.Query().Get("error"),
 	}
 
 	tmpl, err := template.ParseFiles("templates/index.html")
@@ -225,130 +152,144 @@ func contains(slice []string, str string) bool {
 }
 
 func topicPageHandler(w http.ResponseWriter, r *http.Request) {
-	topicID := r.URL.Query().Get("id")
-	if topicID == "" {
+	if r.URL.Query().Get("id") == "" {
 		http.Error(w, "Topic ID is required", http.StatusBadRequest)
 		return
 	}
+	topicID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
 
-	// Récupérer les détails du topic
-	var topic struct {
-		ID          int
-		Title       string
-		Description string
-		Tags        string
-		UserID      int
-		Username    string
-		StateID     int
-		Likes       int
-		Dislikes    int
-	}
-
-	err := db.QueryRow(`
-		SELECT t.topic_id, t.title, t.description, t.tags, t.user_id, u.username, t.state_id,
-			(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = t.topic_id AND liked = TRUE) as likes,
-			(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = t.topic_id AND liked = FALSE) as dislikes
-		FROM topic t
-		JOIN user u ON t.user_id = u.user_id
-		WHERE t.topic_id = ?
-	`, topicID).Scan(
-		&topic.ID,
-		&topic.Title,
-		&topic.Description,
-		&topic.Tags,
-		&topic.UserID,
-		&topic.Username,
-		&topic.StateID,
-		&topic.Likes,
-		&topic.Dislikes,
-	)
+	// Récupérer l'utilisateur connecté et son rôle
+	cookie, _ := r.Cookie("token_form")
+	var username string
+	var userID int
+	role := handlers.RoleUser
+	if cookie != nil {
+		claims := &handlers.Claims{}
+		token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
+			return []byte("votre_clé_secrète_jwt"), nil
+		})
+		if err == nil && token.Valid {
+			username = claims.Username
+			userID = claims.UserID
+			role, err = handlers.UserRole(db, claims.UserID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	isModerator := role >= handlers.RoleModerator
+
+	// Récupérer le topic et ses messages visibles de l'utilisateur
+	viewer := store.Viewer{UserID: userID, Moderator: isModerator}
+	topic, err := db.Topics().Get(topicID, viewer)
+	if err == store.ErrNotFound {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Récupérer les messages du topic
-	rows, err := db.Query(`
-		SELECT m.message_id, m.content, m.created_at, m.user_id, u.username
-		FROM message m
-		JOIN user u ON m.user_id = u.user_id
-		WHERE m.topic_id = ?
-		ORDER BY m.created_at ASC
-	`, topicID)
+	list, err := db.Messages().ListByTopic(topicID, viewer, 0, 0)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
 	var messages []struct {
-		ID        int
-		Content   string
-		CreatedAt string
-		UserID    int
-		Username  string
-	}
-
-	for rows.Next() {
-		var message struct {
-			ID        int
-			Content   string
-			CreatedAt string
-			UserID    int
-			Username  string
+		ID          int
+		Content     string
+		ContentHTML template.HTML
+		CreatedAt   string
+		UserID      int
+		Username    string
+		Status      string
+	}
+
+	for _, m := range list {
+		message := struct {
+			ID          int
+			Content     string
+			ContentHTML template.HTML
+			CreatedAt   string
+			UserID      int
+			Username    string
+			Status      string
+		}{
+			ID:          m.ID,
+			Content:     m.Content,
+			ContentHTML: template.HTML(m.ContentHTML),
+			CreatedAt:   m.CreatedAt,
+			UserID:      m.UserID,
+			Username:    m.Username,
+			Status:      m.Status,
 		}
-		err := rows.Scan(
-			&message.ID,
-			&message.Content,
-			&message.CreatedAt,
-			&message.UserID,
-			&message.Username,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// Les messages antérieurs au cache sont rendus à la volée
+		if m.ContentHTML == "" {
+			message.ContentHTML = handlers.RenderMarkdown(m.Content)
 		}
 		messages = append(messages, message)
 	}
 
-	// Récupérer l'utilisateur connecté
-	cookie, _ := r.Cookie("token_form")
-	var username string
-	if cookie != nil {
-		claims := &handlers.Claims{}
-		token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte("votre_clé_secrète_jwt"), nil
-		})
-		if err == nil && token.Valid {
-			username = claims.Username
+	// Récupérer l'abonnement de l'utilisateur connecté au topic
+	subscription := handlers.SubscriptionNone
+	if userID != 0 {
+		subscription, err = handlers.SubscriptionMode(db, userID, topic.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 	}
 
 	// Préparer les données pour le template
 	data := struct {
-		Topic struct {
+		Topic    handlers.Topic
+		Messages []struct {
 			ID          int
-			Title       string
-			Description string
-			Tags        string
+			Content     string
+			ContentHTML template.HTML
+			CreatedAt   string
 			UserID      int
 			Username    string
-			StateID     int
-			Likes       int
-			Dislikes    int
+			Status      string
 		}
-		Messages []struct {
-			ID        int
-			Content   string
-			CreatedAt string
-			UserID    int
-			Username  string
+		Username     string
+		Subscription string
+		IsModerator  bool
+		States       []struct {
+			ID    int
+			Label string
+		}
+		ReportReasons []struct {
+			ID    string
+			Label string
 		}
-		Username string
+		Reported     bool
+		Pending      bool
+		Error        string
+		OpenReports  int
+		PendingCount int
 	}{
-		Topic:    topic,
-		Messages: messages,
-		Username: username,
+		Topic:         topic,
+		Messages:      messages,
+		Username:      username,
+		Subscription:  subscription,
+		IsModerator:   isModerator,
+		States:        handlers.TopicStates,
+		ReportReasons: handlers.ReportReasons,
+		Reported:      r.URL.Query().Get("reported") != "",
+		Pending:       r.URL.Query().Get("pending") != "",
+		Error:         r.URL.Query().Get("error"),
+	}
+	if data.IsModerator {
+		data.OpenReports = handlers.OpenReportCount(db)
+		data.PendingCount = handlers.PendingCount(db)
 	}
 
 	tmpl, err := template.ParseFiles("templates/topic.html")
@@ -390,19 +331,25 @@ func createTopicHandler(w http.ResponseWriter, r *http.Request) {
 	tagsString := strings.Join(tags, ", ") // Convertit le tableau en chaîne séparée par des virgules
 
 	// Insérer le nouveau topic
-	_, err = db.Exec(`
-		INSERT INTO topic (title, description, tags, user_id, state_id)
-		VALUES (?, ?, ?, ?, ?)
-	`, title, description, tagsString, claims.UserID, 1)
+	submission, err := handlers.CreateTopic(db, claims.UserID, title, description, tagsString)
+	var sanctioned *handlers.SanctionedError
+	if errors.As(err, &sanctioned) {
+		http.Error(w, sanctioned.Error(), http.StatusForbidden)
+		return
+	}
+	var rejected *handlers.ContentRejectedError
+	if errors.As(err, &rejected) {
+		http.Redirect(w, r, "/index?error="+url.QueryEscape(rejected.UserMessage()), http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Mettre à jour le nombre de topics de l'utilisateur
-	_, err = db.Exec("UPDATE user SET topic_nbr = topic_nbr + 1 WHERE user_id = ?", claims.UserID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Un topic en attente de validation n'apparaît que pour son auteur
+	if submission.Pending {
+		http.Redirect(w, r, fmt.Sprintf("/topic?id=%d&pending=1", submission.ID), http.StatusSeeOther)
 		return
 	}
 
@@ -438,17 +385,38 @@ func createMessageHandler(w http.ResponseWriter, r *http.Request) {
 	content := r.FormValue("content")
 	topicID := r.FormValue("topic_id")
 
+	id, err := strconv.Atoi(topicID)
+	if err != nil {
+		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+
 	// Insérer le nouveau message
-	_, err = db.Exec(`
-		INSERT INTO message (content, topic_id, user_id)
-		VALUES (?, ?, ?)
-	`, content, topicID, claims.UserID)
+	submission, err := handlers.PostMessage(db, claims.UserID, id, content)
+	var sanctioned *handlers.SanctionedError
+	if errors.As(err, &sanctioned) {
+		http.Error(w, sanctioned.Error(), http.StatusForbidden)
+		return
+	}
+	var rejected *handlers.ContentRejectedError
+	if errors.As(err, &rejected) {
+		http.Redirect(w, r, fmt.Sprintf("/topic?id=%d&error=%s", id, url.QueryEscape(rejected.UserMessage())), http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, handlers.ErrTopicClosed) {
+		http.Error(w, "Ce sujet est fermé", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Rediriger vers la page du topic
+	if submission.Pending {
+		http.Redirect(w, r, fmt.Sprintf("/topic?id=%d&pending=1#message-%d", id, submission.ID), http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, fmt.Sprintf("/topic?id=%s", topicID), http.StatusSeeOther)
 }
 
@@ -470,20 +438,56 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/register", http.StatusSeeOther)
 }
 
-func main() {
-	initDB()
+// serve démarre le serveur web et les tâches de fond
+func serve(args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: forum serve")
+	}
+
+	// Tâches de fond : e-mails aux abonnés, résumés périodiques et réponses par e-mail
+	transport := mailer.FromEnv()
+	subscriptionMailer, err := handlers.NewSubscriptionMailer(db, transport)
+	if err != nil {
+		return err
+	}
+	digestMailer, err := handlers.NewDigestMailer(db, transport)
+	if err != nil {
+		return err
+	}
+	jobs := scheduler.New()
+	// Sans MAIL_SECRET, les liens de désabonnement ne peuvent pas être signés : les
+	// e-mails restent dans la file jusqu'à sa configuration
+	if handlers.MailSecretConfigured() {
+		jobs.Every(30*time.Second, "subscription-mails", func() { subscriptionMailer.Flush(false) })
+	} else {
+		log.Println("MAIL_SECRET is not set or equals the JWT secret: subscription mails are not sent")
+	}
+	jobs.Every(time.Hour, "digests", digestMailer.SendDue)
+	jobs.Every(5*time.Second, "webhooks", handlers.NewWebhookDispatcher(db).Deliver)
+	jobs.Every(10*time.Second, "content-rules", handlers.RefreshContentRules(db))
+	jobs.Every(5*time.Minute, "spam-model", handlers.RefreshSpamModel(db))
+	jobs.Every(time.Minute, "rate-limits", handlers.PruneRateLimits)
+	// Réponses par e-mail déposées dans un Maildir par le serveur de messagerie
+	if dir := config.Env("REPLY_MAILDIR", ""); dir != "" && config.Env("REPLY_DOMAIN", "") != "" {
+		poller, err := handlers.NewMaildirPoller(db, dir)
+		if err != nil {
+			return err
+		}
+		jobs.Every(time.Minute, "inbound-replies", poller.Poll)
+	}
+	jobs.Start()
+
 	// Servir les fichiers statiques
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 
 	// Routes publiques
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("token_form")
+		_, err := r.Cookie("token_form")
 		if err != nil {
 			http.Redirect(w, r, "/register", http.StatusSeeOther)
 			return
 		}
-		fmt.Println(cookie.Value)
 		if r.URL.Path == "/" {
 			http.Redirect(w, r, "/index", http.StatusSeeOther)
 			return
@@ -491,17 +495,40 @@ func main() {
 		http.NotFound(w, r)
 	})
 	http.HandleFunc("/register", registerHandler)
-	http.HandleFunc("/api/auth/login", handlers.LoginHandler(db))
+	http.HandleFunc("/api/auth/login", handlers.RateLimit(db, handlers.RateLogin, handlers.LoginHandler(db)))
 	http.HandleFunc("/api/auth/register", handlers.RegisterHandler(db))
+	http.HandleFunc("/unsubscribe", handlers.UnsubscribeHandler(db))
+	http.HandleFunc(handlers.APIPrefix+"/", handlers.APIHandler(db))
+	http.HandleFunc(handlers.APIPrefix+"/openapi.json", handlers.OpenAPIHandler())
+	for _, format := range []string{handlers.FeedAtom, handlers.FeedRSS} {
+		http.HandleFunc("/feeds/topics."+format, handlers.TopicsFeedHandler(db, format))
+		http.HandleFunc("/feeds/topic."+format, handlers.TopicFeedHandler(db, format))
+		http.HandleFunc("/feeds/user."+format, handlers.UserFeedHandler(db, format))
+	}
 
 	// Routes protégées
-	http.HandleFunc("/index", handlers.AuthMiddleware(indexHandler))
-	http.HandleFunc("/topic", handlers.AuthMiddleware(topicPageHandler))
-	http.HandleFunc("/topics", handlers.AuthMiddleware(createTopicHandler))
-	http.HandleFunc("/api/messages", handlers.AuthMiddleware(createMessageHandler))
-	http.HandleFunc("/logout", handlers.AuthMiddleware(logoutHandler))
-
-	http.HandleFunc("/api/topic", handlers.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/index", handlers.AuthMiddleware(db, indexHandler))
+	http.HandleFunc("/topic", handlers.AuthMiddleware(db, topicPageHandler))
+	http.HandleFunc("/topics", handlers.AuthMiddleware(db, handlers.RateLimit(db, handlers.RateTopic, createTopicHandler)))
+	http.HandleFunc("/api/messages", handlers.AuthMiddleware(db, handlers.RateLimit(db, handlers.RateMessage, createMessageHandler)))
+	http.HandleFunc("/api/messages/preview", handlers.AuthMiddleware(db, handlers.PreviewMarkdownHandler()))
+	http.HandleFunc("/logout", handlers.AuthMiddleware(db, logoutHandler))
+	http.HandleFunc("/user", handlers.AuthMiddleware(db, handlers.UserProfileHandler(db)))
+	http.HandleFunc("/api/user/block", handlers.AuthMiddleware(db, handlers.BlockUserHandler(db)))
+	http.HandleFunc("/account/export", handlers.AuthMiddleware(db, handlers.ExportAccountHandler(db)))
+	http.HandleFunc("/api/account/delete", handlers.AuthMiddleware(db, handlers.RateLimit(db, handlers.RateLogin, handlers.DeleteAccountHandler(db))))
+	http.HandleFunc("/notifications", handlers.AuthMiddleware(db, handlers.NotificationsPageHandler(db)))
+	http.HandleFunc("/api/notifications", handlers.AuthMiddleware(db, handlers.GetNotificationsHandler(db)))
+	http.HandleFunc("/api/notifications/read", handlers.AuthMiddleware(db, handlers.MarkNotificationsReadHandler(db)))
+	http.HandleFunc("/api/notifications/preferences", handlers.AuthMiddleware(db, handlers.NotificationPreferencesHandler(db)))
+	http.HandleFunc("/digest", handlers.AuthMiddleware(db, handlers.DigestSettingsHandler(db)))
+	http.HandleFunc("/inbox", handlers.AuthMiddleware(db, handlers.InboxHandler(db)))
+	http.HandleFunc("/conversation", handlers.AuthMiddleware(db, handlers.ConversationPageHandler(db)))
+	http.HandleFunc("/api/conversations", handlers.AuthMiddleware(db, handlers.CreateConversationHandler(db)))
+	http.HandleFunc("/api/conversations/messages", handlers.AuthMiddleware(db, handlers.SendPrivateMessageHandler(db)))
+	http.HandleFunc("/api/conversations/leave", handlers.AuthMiddleware(db, handlers.LeaveConversationHandler(db)))
+
+	http.HandleFunc("/api/topic", handlers.AuthMiddleware(db, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			handlers.GetTopicHandler(db)(w, r)
 		} else {
@@ -509,10 +536,36 @@ func main() {
 		}
 	}))
 
-	http.HandleFunc("/api/topic/like", handlers.AuthMiddleware(handlers.LikeTopicHandler(db)))
-	http.HandleFunc("/api/topic/dislike", handlers.AuthMiddleware(handlers.DislikeTopicHandler(db)))
+	http.HandleFunc("/api/topic/events", handlers.AuthMiddleware(db, handlers.TopicEventsHandler(db)))
+	http.HandleFunc("/api/topic/subscribe", handlers.AuthMiddleware(db, handlers.SubscribeTopicHandler(db)))
+	http.HandleFunc("/api/topic/like", handlers.AuthMiddleware(db, handlers.RateLimit(db, handlers.RateLike, handlers.LikeTopicHandler(db))))
+	http.HandleFunc("/api/topic/dislike", handlers.AuthMiddleware(db, handlers.RateLimit(db, handlers.RateLike, handlers.DislikeTopicHandler(db))))
+
+	// Routes de modération et d'administration
+	http.HandleFunc("/api/report", handlers.AuthMiddleware(db, handlers.CreateReportHandler(db)))
+	http.HandleFunc("/moderation/reports", handlers.RequireRole(db, handlers.RoleModerator, handlers.ReportQueueHandler(db)))
+	http.HandleFunc("/api/moderation/reports/resolve", handlers.RequireRole(db, handlers.RoleModerator, handlers.ResolveReportHandler(db)))
+	http.HandleFunc("/moderation/pending", handlers.RequireRole(db, handlers.RoleModerator, handlers.PendingQueueHandler(db)))
+	http.HandleFunc("/api/moderation/pending/review", handlers.RequireRole(db, handlers.RoleModerator, handlers.ReviewPendingHandler(db)))
+	http.HandleFunc("/moderation/bans", handlers.RequireRole(db, handlers.RoleModerator, handlers.BansPageHandler(db)))
+	http.HandleFunc("/api/moderation/sanctions", handlers.RequireRole(db, handlers.RoleModerator, handlers.SanctionUserHandler(db)))
+	http.HandleFunc("/api/moderation/sanctions/lift", handlers.RequireRole(db, handlers.RoleModerator, handlers.LiftSanctionHandler(db)))
+	http.HandleFunc("/api/moderation/registration-bans", handlers.RequireRole(db, handlers.RoleModerator, handlers.CreateRegistrationBanHandler(db)))
+	http.HandleFunc("/api/moderation/registration-bans/delete", handlers.RequireRole(db, handlers.RoleModerator, handlers.DeleteRegistrationBanHandler(db)))
+	http.HandleFunc("/api/topic/state", handlers.RequireRole(db, handlers.RoleModerator, handlers.SetTopicStateHandler(db)))
+	http.HandleFunc("/api/admin/users/role", handlers.RequireRole(db, handlers.RoleAdmin, handlers.SetUserRoleHandler(db)))
+	http.HandleFunc("/admin/audit", handlers.RequireRole(db, handlers.RoleAdmin, handlers.AuditPageHandler(db)))
+	http.HandleFunc("/admin/audit/export", handlers.RequireRole(db, handlers.RoleAdmin, handlers.AuditExportHandler(db)))
+	http.HandleFunc("/admin/rules", handlers.RequireRole(db, handlers.RoleAdmin, handlers.ContentRulesPageHandler(db)))
+	http.HandleFunc("/api/admin/rules", handlers.RequireRole(db, handlers.RoleAdmin, handlers.SaveContentRuleHandler(db)))
+	http.HandleFunc("/api/admin/rules/delete", handlers.RequireRole(db, handlers.RoleAdmin, handlers.DeleteContentRuleHandler(db)))
+	http.HandleFunc("/admin/webhooks", handlers.RequireRole(db, handlers.RoleAdmin, handlers.WebhooksPageHandler(db)))
+	http.HandleFunc("/api/admin/webhooks", handlers.RequireRole(db, handlers.RoleAdmin, handlers.CreateWebhookHandler(db)))
+	http.HandleFunc("/api/admin/webhooks/toggle", handlers.RequireRole(db, handlers.RoleAdmin, handlers.ToggleWebhookHandler(db)))
+	http.HandleFunc("/api/admin/webhooks/delete", handlers.RequireRole(db, handlers.RoleAdmin, handlers.DeleteWebhookHandler(db)))
+	http.HandleFunc("/api/admin/webhooks/redeliver", handlers.RequireRole(db, handlers.RoleAdmin, handlers.RedeliverWebhookHandler(db)))
 
 	// Démarrage du serveur
 	log.Println("Serveur démarré sur :8001")
-	log.Fatal(http.ListenAndServe(":8001", nil))
+	return http.ListenAndServe(":8001", nil)
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"forum/store"
+)
+
+// runMigrate implémente la sous-commande migrate : sans argument ou avec up, applique
+// toutes les migrations en attente ; down annule les n dernières (1 par défaut) ; to
+// amène le schéma à une version précise ; status affiche l'état de chaque migration
+func runMigrate(args []string) error {
+	flags, out := commandFlags("migrate")
+	positional, err := parseCommand(flags, args, 0, 2)
+	if err != nil {
+		return err
+	}
+	command := "up"
+	if len(positional) > 0 {
+		command = positional[0]
+	}
+
+	latest := len(db.Migrations())
+	current, err := db.SchemaVersion()
+	var schemaErr *store.SchemaError
+	legacy := errors.As(err, &schemaErr) && schemaErr.Legacy
+	if err != nil && !legacy {
+		return err
+	}
+	if legacy {
+		// Une base créée avec bdd.sql correspond à la migration 1
+		current = 1
+	}
+
+	target := latest
+	switch {
+	case command == "up" && len(positional) <= 1:
+	case command == "down" && len(positional) <= 2:
+		steps := 1
+		if len(positional) == 2 {
+			if steps, err = strconv.Atoi(positional[1]); err != nil || steps < 1 {
+				return fmt.Errorf("%w: invalid step count %q", errUsage, positional[1])
+			}
+		}
+		if target = current - steps; target < 0 {
+			target = 0
+		}
+	case command == "to" && len(positional) == 2:
+		if target, err = strconv.Atoi(positional[1]); err != nil {
+			return fmt.Errorf("%w: invalid version %q", errUsage, positional[1])
+		}
+	case command == "status" && len(positional) == 1:
+		return printSchemaStatus(out, current, legacy)
+	default:
+		return fmt.Errorf("%w: unknown migrate command", errUsage)
+	}
+
+	var steps []store.MigrationStep
+	if target != current || legacy {
+		steps, err = db.Migrate(target)
+	}
+
+	type stepResult struct {
+		Version   int    `json:"version"`
+		Name      string `json:"name"`
+		Direction string `json:"direction"`
+	}
+	result := struct {
+		Version int          `json:"version"`
+		Steps   []stepResult `json:"steps"`
+	}{Version: target, Steps: []stepResult{}}
+	for _, step := range steps {
+		result.Steps = append(result.Steps, stepResult{step.Version, step.Name, step.Direction})
+	}
+	if err != nil {
+		// Les étapes réussies avant l'échec restent appliquées
+		for _, step := range result.Steps {
+			fmt.Printf("%s %04d %s\n", step.Direction, step.Version, step.Name)
+		}
+		return err
+	}
+
+	return out.print(result, func(w io.Writer) {
+		for _, step := range result.Steps {
+			fmt.Fprintf(w, "%s\t%04d\t%s\n", step.Direction, step.Version, step.Name)
+		}
+		if len(result.Steps) == 0 {
+			fmt.Fprintf(w, "Schéma déjà à la version %d\n", result.Version)
+		} else {
+			fmt.Fprintf(w, "Schéma en version %d\n", result.Version)
+		}
+	})
+}
+
+func printSchemaStatus(out *cliOutput, current int, legacy bool) error {
+	type migrationStatus struct {
+		Version int    `json:"version"`
+		Name    string `json:"name"`
+		Applied bool   `json:"applied"`
+	}
+	status := struct {
+		Driver     string            `json:"driver"`
+		Version    int               `json:"version"`
+		Latest     int               `json:"latest"`
+		Legacy     bool              `json:"legacy"`
+		Migrations []migrationStatus `json:"migrations"`
+	}{Driver: db.Dialect().Name(), Version: current, Latest: len(db.Migrations()), Legacy: legacy}
+	if legacy {
+		// Rien n'est encore enregistré dans schema_version
+		status.Version = 0
+	}
+	for _, migration := range db.Migrations() {
+		status.Migrations = append(status.Migrations, migrationStatus{migration.Version, migration.Name, migration.Version <= status.Version})
+	}
+
+	return out.print(status, func(w io.Writer) {
+		fmt.Fprintf(w, "Moteur : %s\n", status.Driver)
+		if legacy {
+			fmt.Fprintln(w, "Base créée avec bdd.sql, sans version : `forum migrate` l'adopte en version 1")
+		}
+		for _, migration := range status.Migrations {
+			state := "en attente"
+			if migration.Applied {
+				state = "appliquée"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", migration.Version, migration.Name, state)
+		}
+		fmt.Fprintf(w, "Version %d sur %d\n", status.Version, status.Latest)
+	})
+}
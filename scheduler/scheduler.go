@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func()
+}
+
+// Scheduler exécute des tâches périodiques dans le processus du serveur.
+// Une tâche n'est jamais lancée deux fois en parallèle.
+type Scheduler struct {
+	mu   sync.Mutex
+	jobs []job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{stop: make(chan struct{})}
+}
+
+// Every enregistre une tâche à exécuter à intervalle régulier
+func (s *Scheduler) Every(interval time.Duration, name string, run func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Start lance chaque tâche dans sa propre goroutine
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Stop arrête les tâches et attend la fin de celles en cours
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(j job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.runSafely(j)
+		}
+	}
+}
+
+// runSafely empêche une tâche en panique d'arrêter le serveur
+func (s *Scheduler) runSafely(j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Scheduled job %s panicked: %v", j.name, r)
+		}
+	}()
+	j.run()
+}
@@ -0,0 +1,41 @@
+package store
+
+type categoryRepository struct {
+	s *sqlStore
+}
+
+func (r categoryRepository) List() ([]Category, error) {
+	rows, err := r.s.db.Query("SELECT category_id, slug, label FROM category ORDER BY position, category_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Slug, &category.Label); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (r categoryRepository) Get(slug string) (Category, error) {
+	var category Category
+	err := r.s.db.QueryRow("SELECT category_id, slug, label FROM category WHERE slug = ?", slug).
+		Scan(&category.ID, &category.Slug, &category.Label)
+	return category, err
+}
+
+func (r categoryRepository) Create(slug, label string) (int64, error) {
+	result, err := r.s.db.Exec(`
+		INSERT INTO category (slug, label, position)
+		SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM category
+	`, slug, label)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
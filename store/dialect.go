@@ -0,0 +1,57 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect fournit les morceaux de requêtes qui diffèrent entre MySQL et SQLite.
+// NOW(), GREATEST() et FIND_IN_SET() sont disponibles dans les deux bases (voir
+// sqlite.go) et n'ont pas besoin de passer par ici.
+type Dialect interface {
+	Name() string
+	// InsertIgnore commence un INSERT qui ignore les doublons de clé
+	InsertIgnore() string
+	// Upsert introduit la mise à jour d'une ligne existante après un INSERT ... VALUES,
+	// keys étant les colonnes de la clé en conflit ; les affectations suivent
+	Upsert(keys ...string) string
+	// Inserted désigne la valeur proposée pour column dans les affectations d'Upsert
+	Inserted(column string) string
+	// ForUpdate verrouille les lignes lues dans une transaction
+	ForUpdate() string
+	// GroupConcat concatène les valeurs de expr triées par orderBy
+	GroupConcat(expr, orderBy, separator string) string
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string                  { return "mysql" }
+func (mysqlDialect) InsertIgnore() string          { return "INSERT IGNORE" }
+func (mysqlDialect) Upsert(keys ...string) string  { return "ON DUPLICATE KEY UPDATE" }
+func (mysqlDialect) Inserted(column string) string { return "VALUES(" + column + ")" }
+func (mysqlDialect) ForUpdate() string             { return "FOR UPDATE" }
+
+func (mysqlDialect) GroupConcat(expr, orderBy, separator string) string {
+	return fmt.Sprintf("GROUP_CONCAT(%s ORDER BY %s SEPARATOR %s)", expr, orderBy, quote(separator))
+}
+
+// Les écritures SQLite sont sérialisées : les transactions commencent par BEGIN
+// IMMEDIATE (voir sqlite.go) et n'ont pas besoin de verrou de ligne
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                  { return "sqlite" }
+func (sqliteDialect) InsertIgnore() string          { return "INSERT OR IGNORE" }
+func (sqliteDialect) Inserted(column string) string { return "excluded." + column }
+func (sqliteDialect) ForUpdate() string             { return "" }
+
+func (sqliteDialect) Upsert(keys ...string) string {
+	return "ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET"
+}
+
+func (sqliteDialect) GroupConcat(expr, orderBy, separator string) string {
+	return fmt.Sprintf("GROUP_CONCAT(%s, %s ORDER BY %s)", expr, quote(separator), orderBy)
+}
+
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
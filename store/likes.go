@@ -0,0 +1,48 @@
+package store
+
+import "database/sql"
+
+type likeRepository struct {
+	s *sqlStore
+}
+
+func (r likeRepository) TopicVote(userID, topicID int) (*bool, error) {
+	var liked sql.NullBool
+	err := r.s.db.QueryRow("SELECT liked FROM topic_user_like WHERE user_id = ? AND topic_id = ?", userID, topicID).Scan(&liked)
+	if err == sql.ErrNoRows || !liked.Valid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &liked.Bool, nil
+}
+
+func (r likeRepository) TopicVotes(topicID int) (likes, dislikes int, err error) {
+	err = r.s.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = ? AND liked = TRUE),
+			(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = ? AND liked = FALSE)
+	`, topicID, topicID).Scan(&likes, &dislikes)
+	return likes, dislikes, err
+}
+
+func (r likeRepository) SetTopicVote(userID, topicID int, liked *bool) error {
+	return r.setVote("topic_user_like", "topic_id", userID, topicID, liked)
+}
+
+func (r likeRepository) SetReplyVote(userID, replyID int, liked *bool) error {
+	return r.setVote("response_user_like", "response_id", userID, replyID, liked)
+}
+
+func (r likeRepository) setVote(table, column string, userID, id int, liked *bool) error {
+	if liked == nil {
+		_, err := r.s.db.Exec("DELETE FROM "+table+" WHERE user_id = ? AND "+column+" = ?", userID, id)
+		return err
+	}
+	d := r.s.dialect
+	_, err := r.s.db.Exec(`
+		INSERT INTO `+table+` (user_id, `+column+`, liked) VALUES (?, ?, ?)
+		`+d.Upsert("user_id", column)+` liked = `+d.Inserted("liked"), userID, id, *liked)
+	return err
+}
@@ -0,0 +1,130 @@
+package store
+
+import "database/sql"
+
+type messageRepository struct {
+	s *sqlStore
+}
+
+const messageColumns = `
+	SELECT m.message_id, m.content, m.content_html, m.created_at, m.user_id, u.username, m.topic_id, m.status
+	FROM message m
+	JOIN user u ON m.user_id = u.user_id`
+
+func scanMessage(row scanner) (Message, error) {
+	var message Message
+	var contentHTML sql.NullString
+	err := row.Scan(&message.ID, &message.Content, &contentHTML, &message.CreatedAt,
+		&message.UserID, &message.Username, &message.TopicID, &message.Status)
+	message.ContentHTML = contentHTML.String
+	return message, err
+}
+
+func (r messageRepository) Create(message NewMessage) (int64, error) {
+	result, err := r.s.db.Exec(`
+		INSERT INTO message (content, content_html, topic_id, user_id, status, held_reason)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, message.Content, message.ContentHTML, message.TopicID, message.UserID, message.Status, message.HeldReason)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r messageRepository) Get(id int, viewer Viewer) (Message, error) {
+	visible, args := VisibleCondition("m", viewer)
+	args = append([]interface{}{id}, args...)
+	return scanMessage(r.s.db.QueryRow(messageColumns+" WHERE m.message_id = ? AND "+visible, args...))
+}
+
+func (r messageRepository) ListByTopic(topicID int, viewer Viewer, limit, offset int) ([]Message, error) {
+	visible, args := VisibleCondition("m", viewer)
+	limitSQL, limitArgs := limitClause(limit, offset)
+	args = append(append([]interface{}{topicID}, args...), limitArgs...)
+	rows, err := r.s.db.Query(messageColumns+`
+		WHERE m.topic_id = ? AND `+visible+`
+		ORDER BY m.created_at ASC, m.message_id ASC`+limitSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (r messageRepository) CountByTopic(topicID int, viewer Viewer) (int, error) {
+	visible, args := VisibleCondition("m", viewer)
+	var total int
+	err := r.s.db.QueryRow("SELECT COUNT(*) FROM message m WHERE m.topic_id = ? AND "+visible,
+		append([]interface{}{topicID}, args...)...).Scan(&total)
+	return total, err
+}
+
+const replyColumns = `
+	SELECT r.response_id, r.content, r.created_at, r.user_id, u.username, r.message_id, r.status,
+		(SELECT COUNT(*) FROM response_user_like WHERE response_id = r.response_id AND liked = TRUE) AS likes,
+		(SELECT COUNT(*) FROM response_user_like WHERE response_id = r.response_id AND liked = FALSE) AS dislikes
+	FROM response r
+	JOIN user u ON r.user_id = u.user_id`
+
+func scanReply(row scanner) (Reply, error) {
+	var reply Reply
+	err := row.Scan(&reply.ID, &reply.Content, &reply.CreatedAt, &reply.UserID, &reply.Username,
+		&reply.MessageID, &reply.Status, &reply.Likes, &reply.Dislikes)
+	return reply, err
+}
+
+func (r messageRepository) CreateReply(reply NewReply) (int64, error) {
+	result, err := r.s.db.Exec(`
+		INSERT INTO response (content, message_id, user_id, status, held_reason) VALUES (?, ?, ?, ?, ?)
+	`, reply.Content, reply.MessageID, reply.UserID, reply.Status, reply.HeldReason)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r messageRepository) GetReply(id int, viewer Viewer) (Reply, error) {
+	visible, args := VisibleCondition("r", viewer)
+	args = append([]interface{}{id}, args...)
+	return scanReply(r.s.db.QueryRow(replyColumns+" WHERE r.response_id = ? AND "+visible, args...))
+}
+
+func (r messageRepository) ListReplies(messageID int, viewer Viewer, limit, offset int) ([]Reply, error) {
+	visible, args := VisibleCondition("r", viewer)
+	limitSQL, limitArgs := limitClause(limit, offset)
+	args = append(append([]interface{}{messageID}, args...), limitArgs...)
+	rows, err := r.s.db.Query(replyColumns+`
+		WHERE r.message_id = ? AND `+visible+`
+		ORDER BY r.created_at ASC, r.response_id ASC`+limitSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replies []Reply
+	for rows.Next() {
+		reply, err := scanReply(rows)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, rows.Err()
+}
+
+func (r messageRepository) CountReplies(messageID int, viewer Viewer) (int, error) {
+	visible, args := VisibleCondition("r", viewer)
+	var total int
+	err := r.s.db.QueryRow("SELECT COUNT(*) FROM response r WHERE r.message_id = ? AND "+visible,
+		append([]interface{}{messageID}, args...)...).Scan(&total)
+	return total, err
+}
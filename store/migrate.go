@@ -0,0 +1,380 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Les migrations sont écrites une fois par moteur, dans migrations/<dialecte>/ :
+// NNNN_nom.up.sql applique l'étape NNNN et NNNN_nom.down.sql l'annule. Les numéros
+// se suivent à partir de 1 et une migration publiée ne se modifie plus.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration est une étape du schéma
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Directions d'une étape de migration
+const (
+	MigrateUp   = "up"
+	MigrateDown = "down"
+	// MigrateBaseline marque comme appliquée la migration 1 d'une base créée avec
+	// l'ancien bdd.sql, sans l'exécuter
+	MigrateBaseline = "baseline"
+)
+
+// MigrationStep est une migration appliquée ou annulée par Migrate
+type MigrationStep struct {
+	Migration
+	Direction string
+}
+
+// SchemaError indique que la base n'est pas à la version attendue par le programme
+type SchemaError struct {
+	Current  int
+	Expected int
+	Legacy   bool // base créée avec bdd.sql, sans table schema_version
+}
+
+func (e *SchemaError) Error() string {
+	if e.Legacy {
+		return "database schema has no version (created with bdd.sql): run `forum migrate` to adopt it"
+	}
+	if e.Current < e.Expected {
+		return fmt.Sprintf("database schema is at version %d, expected %d: run `forum migrate`", e.Current, e.Expected)
+	}
+	return fmt.Sprintf("database schema is at version %d, newer than the %d known to this binary", e.Current, e.Expected)
+}
+
+// migrationEngine est implémentée par les dialectes pour ce qui diffère entre moteurs
+// lors des migrations
+type migrationEngine interface {
+	// tableExists est une requête qui compte les tables nommées par son paramètre
+	tableExists() string
+	// tables est une requête qui liste les tables de la base
+	tables() string
+	// lock prend le verrou de migration sur conn ; les requêtes passent ensuite par
+	// l'execer renvoyé et finish libère le verrou, en validant ou non le travail
+	lock(ctx context.Context, conn *sql.Conn) (execer, func(commit bool) error, error)
+	// statements découpe un script de migration en requêtes exécutables
+	statements(script string) []string
+	// transactional indique qu'un échec annule toutes les étapes de la migration
+	transactional() bool
+}
+
+// execer est commun à *sql.Conn et *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// legacySchema est le schéma de l'ancien bdd.sql, que reprend la migration 1 : une
+// base sans table schema_version n'est adoptée que si ses tables et colonnes sont
+// exactement celles-ci
+var legacySchema = map[string][]string{
+	"role":               {"role_id", "name"},
+	"state":              {"state_id", "name"},
+	"user":               {"user_id", "username", "mail", "password", "bio", "last_connection", "topic_nbr", "created_at", "profil_img_path", "role_id"},
+	"topic":              {"topic_id", "title", "tags", "created_at", "description", "updated_at", "state_id", "user_id"},
+	"message":            {"message_id", "content", "created_at", "topic_id", "user_id"},
+	"response":           {"response_id", "content", "created_at", "message_id", "user_id"},
+	"response_user_like": {"user_id", "response_id", "liked"},
+	"topic_user_like":    {"user_id", "topic_id", "liked"},
+}
+
+const schemaVersionTable = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at VARCHAR(19) NOT NULL
+	)`
+
+// loadMigrations lit les migrations du dialecte dans l'ordre des versions
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		rawVersion, label, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(rawVersion)
+		if !ok || !found || err != nil || version <= 0 || (direction != MigrateUp && direction != MigrateDown) {
+			return nil, fmt.Errorf("invalid migration file name %s/%s", dir, name)
+		}
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: label}
+			byVersion[version] = migration
+		}
+		if migration.Name != label {
+			return nil, fmt.Errorf("migration %d has two names in %s: %s and %s", version, dir, migration.Name, label)
+		}
+		if direction == MigrateUp {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing in %s", i+1, dir)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d in %s needs both an up and a down file", migration.Version, dir)
+		}
+	}
+	return migrations, nil
+}
+
+func (s *sqlStore) Migrations() []Migration { return s.migrations }
+
+func (s *sqlStore) SchemaVersion() (int, error) {
+	version, legacy, err := s.schemaVersion(context.Background(), s.db)
+	if err == nil && legacy {
+		err = &SchemaError{Expected: len(s.migrations), Legacy: true}
+	}
+	return version, err
+}
+
+// schemaVersion lit la dernière migration appliquée ; legacy indique une base
+// créée avant les migrations
+func (s *sqlStore) schemaVersion(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}) (version int, legacy bool, err error) {
+	engine := s.dialect.(migrationEngine)
+	var tables int
+	if err := q.QueryRowContext(ctx, engine.tableExists(), "schema_version").Scan(&tables); err != nil {
+		return 0, false, err
+	}
+	if tables == 0 {
+		if err := q.QueryRowContext(ctx, engine.tableExists(), "user").Scan(&tables); err != nil {
+			return 0, false, err
+		}
+		return 0, tables > 0, nil
+	}
+	err = q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, false, err
+}
+
+// CheckSchema vérifie que la base est exactement à la dernière version connue du
+// programme ; le serveur refuse de démarrer sinon
+func CheckSchema(s Store) error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if expected := len(s.Migrations()); version != expected {
+		return &SchemaError{Current: version, Expected: expected}
+	}
+	return nil
+}
+
+func (s *sqlStore) Migrate(target int) ([]MigrationStep, error) {
+	if target < 0 || target > len(s.migrations) {
+		return nil, fmt.Errorf("unknown schema version %d (0 to %d)", target, len(s.migrations))
+	}
+
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	engine := s.dialect.(migrationEngine)
+	q, finish, err := engine.lock(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := s.migrate(ctx, engine, q, target)
+	if finishErr := finish(err == nil); err == nil {
+		err = finishErr
+	}
+	if err != nil && engine.transactional() {
+		// Rien n'a été appliqué
+		steps = nil
+	}
+	return steps, err
+}
+
+// migrate applique les étapes jusqu'à target ; la version est relue sous le verrou
+// pour ne pas rejouer ce qu'une autre instance vient d'appliquer
+func (s *sqlStore) migrate(ctx context.Context, engine migrationEngine, q execer, target int) ([]MigrationStep, error) {
+	version, legacy, err := s.schemaVersion(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.ExecContext(ctx, schemaVersionTable); err != nil {
+		return nil, err
+	}
+
+	var steps []MigrationStep
+	if legacy {
+		if err := checkLegacySchema(ctx, engine, q); err != nil {
+			return nil, err
+		}
+		if err := recordVersion(ctx, q, s.migrations[0]); err != nil {
+			return nil, err
+		}
+		version = 1
+		steps = append(steps, MigrationStep{Migration: s.migrations[0], Direction: MigrateBaseline})
+	}
+
+	for version != target {
+		var step MigrationStep
+		var script string
+		if version < target {
+			step = MigrationStep{Migration: s.migrations[version], Direction: MigrateUp}
+			script = step.Up
+		} else {
+			step = MigrationStep{Migration: s.migrations[version-1], Direction: MigrateDown}
+			script = step.Down
+		}
+
+		for _, statement := range engine.statements(script) {
+			if _, err := q.ExecContext(ctx, statement); err != nil {
+				return steps, fmt.Errorf("migration %d (%s) %s: %w", step.Version, step.Name, step.Direction, err)
+			}
+		}
+		if step.Direction == MigrateUp {
+			err = recordVersion(ctx, q, step.Migration)
+			version++
+		} else {
+			_, err = q.ExecContext(ctx, "DELETE FROM schema_version WHERE version = ?", step.Version)
+			version--
+		}
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// checkLegacySchema vérifie qu'une base sans version a été créée avec le bdd.sql
+// d'origine : une base créée avec une version plus récente du fichier contient déjà
+// une partie des migrations suivantes et ne peut pas être adoptée en version 1
+func checkLegacySchema(ctx context.Context, engine migrationEngine, q execer) error {
+	rows, err := q.QueryContext(ctx, engine.tables())
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var problems []string
+	found := map[string]bool{}
+	for _, table := range tables {
+		found[table] = true
+		if table == "schema_version" {
+			continue
+		}
+		if _, ok := legacySchema[table]; !ok {
+			problems = append(problems, "unexpected table "+table)
+		}
+	}
+	for table, expected := range legacySchema {
+		if !found[table] {
+			problems = append(problems, "missing table "+table)
+			continue
+		}
+		columns, err := tableColumns(ctx, q, table)
+		if err != nil {
+			return err
+		}
+		if strings.Join(columns, ",") != strings.Join(expected, ",") {
+			problems = append(problems, fmt.Sprintf("table %s has columns (%s), expected (%s)",
+				table, strings.Join(columns, ", "), strings.Join(expected, ", ")))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("database has no schema version but does not match the original bdd.sql, refusing to adopt it: %s",
+			strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// tableColumns renvoie les colonnes de table dans leur ordre de création
+func tableColumns(ctx context.Context, q execer, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT * FROM "+table+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+func recordVersion(ctx context.Context, q execer, migration Migration) error {
+	_, err := q.ExecContext(ctx, "INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, time.Now().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// splitStatements découpe un script sur les points-virgules de fin de ligne, en
+// ignorant les lignes de commentaire
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+var errMigrationLocked = errors.New("another instance is migrating the database schema")
@@ -0,0 +1,9 @@
+-- Supprime le schéma initial, dans l'ordre inverse des clés étrangères
+DROP TABLE IF EXISTS topic_user_like;
+DROP TABLE IF EXISTS response_user_like;
+DROP TABLE IF EXISTS response;
+DROP TABLE IF EXISTS message;
+DROP TABLE IF EXISTS topic;
+DROP TABLE IF EXISTS user;
+DROP TABLE IF EXISTS state;
+DROP TABLE IF EXISTS role;
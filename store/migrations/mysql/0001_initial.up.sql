@@ -1,3 +1,5 @@
+-- Schéma de l'ancien bdd.sql : une base créée avec ce fichier est adoptée en version 1
+
 -- Table des rôles
 CREATE TABLE role (
     role_id INT AUTO_INCREMENT PRIMARY KEY,
@@ -84,10 +86,3 @@ CREATE TABLE topic_user_like (
 -- Insertion des données par défaut
 INSERT INTO role (name) VALUES ('user');
 INSERT INTO state (name) VALUES ('ouvert');
-INSERT INTO user (username, mail, role_id) VALUES ('default_user', 'default@example.com', 1);
-
--- Ajouter la colonne tags à la table topic
-ALTER TABLE topic ADD COLUMN tags VARCHAR(255);
-
-SHOW COLUMNS FROM topic LIKE 'tags';
-
@@ -0,0 +1,7 @@
+-- Les comptes et topics concernés reviennent au rôle et à l'état par défaut
+UPDATE user SET role_id = (SELECT MIN(role_id) FROM role WHERE name = 'user')
+    WHERE role_id IN (SELECT role_id FROM role WHERE name IN ('moderator', 'admin'));
+UPDATE topic SET state_id = (SELECT MIN(state_id) FROM state WHERE name = 'ouvert')
+    WHERE state_id IN (SELECT state_id FROM state WHERE name IN ('fermé', 'archivé'));
+DELETE FROM role WHERE name IN ('moderator', 'admin');
+DELETE FROM state WHERE name IN ('fermé', 'archivé');
@@ -0,0 +1,3 @@
+-- Rendu HTML des messages, mis en cache à l'écriture
+ALTER TABLE message
+    ADD COLUMN content_html TEXT;
@@ -0,0 +1,27 @@
+-- Table des blocages entre utilisateurs
+CREATE TABLE user_block (
+    blocker_id INT,
+    blocked_id INT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker_id, blocked_id),
+    FOREIGN KEY (blocker_id) REFERENCES user(user_id),
+    FOREIGN KEY (blocked_id) REFERENCES user(user_id)
+);
+
+-- Table des notifications
+CREATE TABLE notification (
+    notification_id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    actor_id INT,
+    type VARCHAR(30) NOT NULL,
+    topic_id INT,
+    message_id INT,
+    detail VARCHAR(255),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    read_at DATETIME,
+    INDEX idx_notification_unread (user_id, read_at),
+    FOREIGN KEY (user_id) REFERENCES user(user_id),
+    FOREIGN KEY (actor_id) REFERENCES user(user_id),
+    FOREIGN KEY (topic_id) REFERENCES topic(topic_id),
+    FOREIGN KEY (message_id) REFERENCES message(message_id)
+);
@@ -0,0 +1,8 @@
+-- Table des préférences de notification (types mis en sourdine)
+CREATE TABLE notification_preference (
+    user_id INT,
+    type VARCHAR(30),
+    muted BOOLEAN DEFAULT FALSE,
+    PRIMARY KEY (user_id, type),
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS private_message;
+DROP TABLE IF EXISTS conversation_participant;
+DROP TABLE IF EXISTS conversation;
@@ -0,0 +1,33 @@
+-- Table des conversations privées
+CREATE TABLE conversation (
+    conversation_id INT AUTO_INCREMENT PRIMARY KEY,
+    subject VARCHAR(255) NOT NULL,
+    created_by INT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES user(user_id)
+);
+
+-- Table des participants aux conversations privées
+CREATE TABLE conversation_participant (
+    conversation_id INT,
+    user_id INT,
+    joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_read_at DATETIME,
+    left_at DATETIME,
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversation(conversation_id),
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
+
+-- Table des messages privés
+CREATE TABLE private_message (
+    private_message_id INT AUTO_INCREMENT PRIMARY KEY,
+    conversation_id INT NOT NULL,
+    user_id INT,
+    content TEXT NOT NULL,
+    content_html TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (conversation_id) REFERENCES conversation(conversation_id),
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
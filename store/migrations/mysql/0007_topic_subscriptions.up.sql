@@ -0,0 +1,26 @@
+-- Table des abonnements aux topics (mode : immediate, batched ou none)
+CREATE TABLE topic_subscription (
+    user_id INT,
+    topic_id INT,
+    mode VARCHAR(20) NOT NULL DEFAULT 'immediate',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, topic_id),
+    FOREIGN KEY (user_id) REFERENCES user(user_id),
+    FOREIGN KEY (topic_id) REFERENCES topic(topic_id)
+);
+
+-- File d'attente des e-mails aux abonnés
+CREATE TABLE mail_queue (
+    mail_id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    topic_id INT NOT NULL,
+    message_id INT NOT NULL,
+    mode VARCHAR(20) NOT NULL,
+    attempts INT DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    sent_at DATETIME,
+    INDEX idx_mail_queue_pending (sent_at, mode),
+    FOREIGN KEY (user_id) REFERENCES user(user_id),
+    FOREIGN KEY (topic_id) REFERENCES topic(topic_id),
+    FOREIGN KEY (message_id) REFERENCES message(message_id)
+);
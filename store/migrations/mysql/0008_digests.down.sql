@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS digest_log;
+DROP TABLE IF EXISTS category_follow;
+DROP TABLE IF EXISTS digest_preference;
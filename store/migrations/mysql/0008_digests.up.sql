@@ -0,0 +1,25 @@
+-- Table des préférences de résumé par e-mail (frequency : none, daily ou weekly)
+CREATE TABLE digest_preference (
+    user_id INT PRIMARY KEY,
+    frequency VARCHAR(20) NOT NULL DEFAULT 'none',
+    last_sent_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
+
+-- Table des catégories (thèmes) suivies pour les résumés
+CREATE TABLE category_follow (
+    user_id INT,
+    tag VARCHAR(50),
+    PRIMARY KEY (user_id, tag),
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
+
+-- Éléments déjà envoyés dans un résumé (item_type : topic, message ou mention)
+CREATE TABLE digest_log (
+    user_id INT,
+    item_type VARCHAR(20),
+    item_id INT,
+    sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, item_type, item_id),
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
@@ -0,0 +1,26 @@
+-- Webhooks sortants configurés par les administrateurs (events : liste séparée par des virgules)
+CREATE TABLE webhook (
+    webhook_id INT AUTO_INCREMENT PRIMARY KEY,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    events VARCHAR(255) NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Journal des livraisons de webhooks (status : pending, delivered ou failed)
+CREATE TABLE webhook_delivery (
+    delivery_id INT AUTO_INCREMENT PRIMARY KEY,
+    webhook_id INT NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    last_status_code INT,
+    last_error VARCHAR(255),
+    next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    delivered_at DATETIME,
+    INDEX idx_webhook_delivery_pending (status, next_attempt_at),
+    FOREIGN KEY (webhook_id) REFERENCES webhook(webhook_id)
+);
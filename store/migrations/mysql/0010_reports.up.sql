@@ -0,0 +1,18 @@
+-- Signalements de contenus (target_type : topic ou message ; status : open, dismissed ou resolved)
+CREATE TABLE report (
+    report_id INT AUTO_INCREMENT PRIMARY KEY,
+    reporter_id INT NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id INT NOT NULL,
+    reason VARCHAR(30) NOT NULL,
+    details TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    resolution VARCHAR(30),
+    note VARCHAR(255),
+    resolved_by INT,
+    resolved_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_report_target (target_type, target_id, status),
+    FOREIGN KEY (reporter_id) REFERENCES user(user_id),
+    FOREIGN KEY (resolved_by) REFERENCES user(user_id)
+);
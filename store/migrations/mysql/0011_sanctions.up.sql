@@ -0,0 +1,27 @@
+-- Sanctions prononcées par les modérateurs (type : warning, suspension ou ban ;
+-- un ban n'a pas de date de fin, revoked_at est renseigné quand la sanction est levée)
+CREATE TABLE sanction (
+    sanction_id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    moderator_id INT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME,
+    revoked_at DATETIME,
+    INDEX idx_sanction_user (user_id, type),
+    FOREIGN KEY (user_id) REFERENCES user(user_id),
+    FOREIGN KEY (moderator_id) REFERENCES user(user_id)
+);
+
+-- Inscriptions interdites (kind : ip, pour une adresse ou une plage CIDR, ou email_domain)
+CREATE TABLE registration_ban (
+    ban_id INT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    reason VARCHAR(255),
+    created_by INT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_registration_ban (kind, value),
+    FOREIGN KEY (created_by) REFERENCES user(user_id)
+);
@@ -0,0 +1,3 @@
+-- Le journal d'audit est en ajout seul : annuler la migration ne le supprime pas, et ses
+-- triggers continuent de refuser toute modification. Seul un administrateur de la base
+-- peut le supprimer, explicitement, avec DROP TABLE audit_log.
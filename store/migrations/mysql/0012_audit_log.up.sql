@@ -0,0 +1,28 @@
+-- Journal d'audit des actions privilégiées, en ajout seul : pas de clé étrangère pour
+-- survivre à la suppression des comptes, et toute modification est refusée par les triggers.
+-- Le retour arrière conserve la table : la réappliquer reprend le journal existant.
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    actor_id INT,
+    actor_username VARCHAR(255),
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(30) NOT NULL,
+    target_id INT NOT NULL,
+    target_label VARCHAR(255),
+    before_value TEXT,
+    after_value TEXT,
+    reason TEXT,
+    ip VARCHAR(45),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_audit_created (created_at),
+    INDEX idx_audit_actor (actor_username),
+    INDEX idx_audit_target (target_type, target_id)
+);
+
+DROP TRIGGER IF EXISTS audit_log_no_update;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+DROP TRIGGER IF EXISTS audit_log_no_delete;
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW
+    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS content_rule_hit;
+DROP TABLE IF EXISTS content_rule;
+ALTER TABLE message
+    DROP INDEX idx_message_status,
+    DROP COLUMN held_reason,
+    DROP COLUMN status;
+ALTER TABLE topic
+    DROP INDEX idx_topic_status,
+    DROP COLUMN held_reason,
+    DROP COLUMN status;
@@ -0,0 +1,43 @@
+-- Statut de publication : 'pending' pour un contenu retenu, visible de l'auteur et des
+-- modérateurs
+ALTER TABLE topic
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published',
+    ADD COLUMN held_reason VARCHAR(255),
+    ADD INDEX idx_topic_status (status);
+
+ALTER TABLE message
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published',
+    ADD COLUMN held_reason VARCHAR(255),
+    ADD INDEX idx_message_status (status);
+
+-- Règles de contenu appliquées aux nouveaux topics et messages ; pattern contient
+-- une entrée par ligne (mots, expression régulière ou domaines)
+CREATE TABLE content_rule (
+    rule_id INT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    pattern TEXT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    replacement VARCHAR(255),
+    message VARCHAR(255),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by INT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES user(user_id)
+);
+
+-- Correspondances des règles de contenu, y compris celles des règles en essai
+CREATE TABLE content_rule_hit (
+    hit_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    rule_id INT NOT NULL,
+    user_id INT,
+    target_type VARCHAR(20) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    excerpt VARCHAR(255),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_rule_hit_rule (rule_id),
+    FOREIGN KEY (rule_id) REFERENCES content_rule(rule_id),
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
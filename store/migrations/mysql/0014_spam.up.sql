@@ -0,0 +1,18 @@
+-- Classifieur de spam : nombre d'exemples de spam et de contenus légitimes contenant
+-- chaque jeton, et contenus appris (une ligne par contenu, tokens sert à désapprendre)
+CREATE TABLE spam_token (
+    token VARCHAR(64) PRIMARY KEY,
+    spam_count INT NOT NULL DEFAULT 0,
+    ham_count INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE spam_training (
+    target_type VARCHAR(20) NOT NULL,
+    target_id INT NOT NULL,
+    is_spam BOOLEAN NOT NULL,
+    tokens TEXT NOT NULL,
+    trained_by INT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (target_type, target_id),
+    FOREIGN KEY (trained_by) REFERENCES user(user_id)
+);
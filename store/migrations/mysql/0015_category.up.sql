@@ -0,0 +1,32 @@
+-- Catégories (thèmes) des topics, auparavant fixées dans le code ; slug est la valeur
+-- enregistrée dans topic.tags et position l'ordre d'affichage
+CREATE TABLE category (
+    category_id INT AUTO_INCREMENT PRIMARY KEY,
+    slug VARCHAR(50) NOT NULL,
+    label VARCHAR(100) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_category_slug (slug)
+);
+
+INSERT INTO category (slug, label, position) VALUES
+    ('technologie', 'Technologie', 1),
+    ('sport', 'Sport', 2),
+    ('musique', 'Musique', 3),
+    ('cinema', 'Cinéma', 4),
+    ('litterature', 'Littérature', 5),
+    ('cuisine', 'Cuisine', 6),
+    ('voyage', 'Voyage', 7),
+    ('science', 'Science', 8),
+    ('art', 'Art', 9),
+    ('jeux', 'Jeux', 10),
+    ('mode', 'Mode', 11),
+    ('sante', 'Santé', 12),
+    ('education', 'Éducation', 13),
+    ('politique', 'Politique', 14),
+    ('economie', 'Économie', 15),
+    ('environnement', 'Environnement', 16),
+    ('histoire', 'Histoire', 17),
+    ('philosophie', 'Philosophie', 18),
+    ('psychologie', 'Psychologie', 19),
+    ('societe', 'Société', 20);
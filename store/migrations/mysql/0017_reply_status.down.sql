@@ -0,0 +1,4 @@
+ALTER TABLE response
+    DROP INDEX idx_response_status,
+    DROP COLUMN held_reason,
+    DROP COLUMN status;
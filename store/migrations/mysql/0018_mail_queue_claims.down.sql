@@ -0,0 +1,2 @@
+ALTER TABLE mail_queue
+    DROP COLUMN locked_at;
@@ -0,0 +1,4 @@
+-- Réservation des e-mails par l'instance qui les envoie : une ligne réservée depuis
+-- moins de quelques minutes n'est pas reprise par une autre instance
+ALTER TABLE mail_queue
+    ADD COLUMN locked_at DATETIME;
@@ -0,0 +1,2 @@
+ALTER TABLE webhook_delivery
+    DROP COLUMN locked_at;
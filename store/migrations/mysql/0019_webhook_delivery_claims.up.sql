@@ -0,0 +1,3 @@
+-- Réservation des livraisons par l'instance qui les envoie, comme pour mail_queue
+ALTER TABLE webhook_delivery
+    ADD COLUMN locked_at DATETIME;
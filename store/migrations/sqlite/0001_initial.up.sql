@@ -0,0 +1,76 @@
+-- Schéma initial SQLite, équivalent de migrations/mysql/0001_initial.up.sql. Les dates
+-- sont stockées en texte au format "AAAA-MM-JJ HH:MM:SS", à l'heure locale comme NOW()
+-- sous MySQL.
+
+CREATE TABLE role (
+    role_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) NOT NULL
+);
+
+CREATE TABLE state (
+    state_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) NOT NULL
+);
+
+CREATE TABLE user (
+    user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(50) NOT NULL COLLATE NOCASE,
+    mail VARCHAR(100) NOT NULL COLLATE NOCASE,
+    password VARCHAR(255) NOT NULL,
+    bio TEXT,
+    last_connection TEXT,
+    topic_nbr INT DEFAULT 0,
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    profil_img_path VARCHAR(255),
+    role_id INT REFERENCES role(role_id)
+);
+
+CREATE TABLE topic (
+    topic_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title VARCHAR(255) NOT NULL,
+    tags VARCHAR(255),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    description TEXT,
+    updated_at TEXT DEFAULT (datetime('now', 'localtime')),
+    state_id INT REFERENCES state(state_id),
+    user_id INT REFERENCES user(user_id)
+);
+
+CREATE TRIGGER topic_updated_at AFTER UPDATE ON topic FOR EACH ROW
+BEGIN
+    UPDATE topic SET updated_at = datetime('now', 'localtime') WHERE topic_id = NEW.topic_id;
+END;
+
+CREATE TABLE message (
+    message_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    content TEXT NOT NULL,
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    topic_id INT REFERENCES topic(topic_id),
+    user_id INT REFERENCES user(user_id)
+);
+
+CREATE TABLE response (
+    response_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    content TEXT NOT NULL,
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    message_id INT REFERENCES message(message_id),
+    user_id INT REFERENCES user(user_id)
+);
+
+CREATE TABLE response_user_like (
+    user_id INT REFERENCES user(user_id),
+    response_id INT REFERENCES response(response_id),
+    liked BOOLEAN DEFAULT TRUE,
+    PRIMARY KEY (user_id, response_id)
+);
+
+CREATE TABLE topic_user_like (
+    user_id INT REFERENCES user(user_id),
+    topic_id INT REFERENCES topic(topic_id),
+    liked BOOLEAN DEFAULT TRUE,
+    PRIMARY KEY (user_id, topic_id)
+);
+
+-- Insertion des données par défaut
+INSERT INTO role (name) VALUES ('user');
+INSERT INTO state (name) VALUES ('ouvert');
@@ -0,0 +1,3 @@
+-- Rôles de modération et états des topics fermés ou archivés
+INSERT INTO role (name) VALUES ('moderator'), ('admin');
+INSERT INTO state (name) VALUES ('fermé'), ('archivé');
@@ -0,0 +1,19 @@
+CREATE TABLE user_block (
+    blocker_id INT REFERENCES user(user_id),
+    blocked_id INT REFERENCES user(user_id),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    PRIMARY KEY (blocker_id, blocked_id)
+);
+
+CREATE TABLE notification (
+    notification_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INT NOT NULL REFERENCES user(user_id),
+    actor_id INT REFERENCES user(user_id),
+    type VARCHAR(30) NOT NULL,
+    topic_id INT REFERENCES topic(topic_id),
+    message_id INT REFERENCES message(message_id),
+    detail VARCHAR(255),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    read_at TEXT
+);
+CREATE INDEX idx_notification_unread ON notification (user_id, read_at);
@@ -0,0 +1,6 @@
+CREATE TABLE notification_preference (
+    user_id INT REFERENCES user(user_id),
+    type VARCHAR(30),
+    muted BOOLEAN DEFAULT FALSE,
+    PRIMARY KEY (user_id, type)
+);
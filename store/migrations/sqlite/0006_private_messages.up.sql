@@ -0,0 +1,25 @@
+CREATE TABLE conversation (
+    conversation_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subject VARCHAR(255) NOT NULL,
+    created_by INT REFERENCES user(user_id),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    updated_at TEXT DEFAULT (datetime('now', 'localtime'))
+);
+
+CREATE TABLE conversation_participant (
+    conversation_id INT REFERENCES conversation(conversation_id),
+    user_id INT REFERENCES user(user_id),
+    joined_at TEXT DEFAULT (datetime('now', 'localtime')),
+    last_read_at TEXT,
+    left_at TEXT,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE TABLE private_message (
+    private_message_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INT NOT NULL REFERENCES conversation(conversation_id),
+    user_id INT REFERENCES user(user_id),
+    content TEXT NOT NULL,
+    content_html TEXT,
+    created_at TEXT DEFAULT (datetime('now', 'localtime'))
+);
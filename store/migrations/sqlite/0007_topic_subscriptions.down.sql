@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS mail_queue;
+DROP TABLE IF EXISTS topic_subscription;
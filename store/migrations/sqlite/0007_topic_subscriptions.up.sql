@@ -0,0 +1,19 @@
+CREATE TABLE topic_subscription (
+    user_id INT REFERENCES user(user_id),
+    topic_id INT REFERENCES topic(topic_id),
+    mode VARCHAR(20) NOT NULL DEFAULT 'immediate',
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    PRIMARY KEY (user_id, topic_id)
+);
+
+CREATE TABLE mail_queue (
+    mail_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INT NOT NULL REFERENCES user(user_id),
+    topic_id INT NOT NULL REFERENCES topic(topic_id),
+    message_id INT NOT NULL REFERENCES message(message_id),
+    mode VARCHAR(20) NOT NULL,
+    attempts INT DEFAULT 0,
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    sent_at TEXT
+);
+CREATE INDEX idx_mail_queue_pending ON mail_queue (sent_at, mode);
@@ -0,0 +1,19 @@
+CREATE TABLE digest_preference (
+    user_id INT PRIMARY KEY REFERENCES user(user_id),
+    frequency VARCHAR(20) NOT NULL DEFAULT 'none',
+    last_sent_at TEXT
+);
+
+CREATE TABLE category_follow (
+    user_id INT REFERENCES user(user_id),
+    tag VARCHAR(50),
+    PRIMARY KEY (user_id, tag)
+);
+
+CREATE TABLE digest_log (
+    user_id INT REFERENCES user(user_id),
+    item_type VARCHAR(20),
+    item_id INT,
+    sent_at TEXT DEFAULT (datetime('now', 'localtime')),
+    PRIMARY KEY (user_id, item_type, item_id)
+);
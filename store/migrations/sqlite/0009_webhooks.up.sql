@@ -0,0 +1,23 @@
+CREATE TABLE webhook (
+    webhook_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    events VARCHAR(255) NOT NULL,
+    active BOOLEAN DEFAULT TRUE,
+    created_at TEXT DEFAULT (datetime('now', 'localtime'))
+);
+
+CREATE TABLE webhook_delivery (
+    delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INT NOT NULL REFERENCES webhook(webhook_id),
+    event VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    last_status_code INT,
+    last_error VARCHAR(255),
+    next_attempt_at TEXT DEFAULT (datetime('now', 'localtime')),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    delivered_at TEXT
+);
+CREATE INDEX idx_webhook_delivery_pending ON webhook_delivery (status, next_attempt_at);
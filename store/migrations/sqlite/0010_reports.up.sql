@@ -0,0 +1,15 @@
+CREATE TABLE report (
+    report_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reporter_id INT NOT NULL REFERENCES user(user_id),
+    target_type VARCHAR(20) NOT NULL,
+    target_id INT NOT NULL,
+    reason VARCHAR(30) NOT NULL,
+    details TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    resolution VARCHAR(30),
+    note VARCHAR(255),
+    resolved_by INT REFERENCES user(user_id),
+    resolved_at TEXT,
+    created_at TEXT DEFAULT (datetime('now', 'localtime'))
+);
+CREATE INDEX idx_report_target ON report (target_type, target_id, status);
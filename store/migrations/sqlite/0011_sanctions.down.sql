@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS registration_ban;
+DROP TABLE IF EXISTS sanction;
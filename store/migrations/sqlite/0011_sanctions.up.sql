@@ -0,0 +1,21 @@
+CREATE TABLE sanction (
+    sanction_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INT NOT NULL REFERENCES user(user_id),
+    type VARCHAR(20) NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    moderator_id INT REFERENCES user(user_id),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    expires_at TEXT,
+    revoked_at TEXT
+);
+CREATE INDEX idx_sanction_user ON sanction (user_id, type);
+
+CREATE TABLE registration_ban (
+    ban_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(20) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    reason VARCHAR(255),
+    created_by INT REFERENCES user(user_id),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    UNIQUE (kind, value)
+);
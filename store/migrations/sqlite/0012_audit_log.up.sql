@@ -0,0 +1,28 @@
+-- Le retour arrière conserve la table : la réappliquer reprend le journal existant
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INT,
+    actor_username VARCHAR(255),
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(30) NOT NULL,
+    target_id INT NOT NULL,
+    target_label VARCHAR(255),
+    before_value TEXT,
+    after_value TEXT,
+    reason TEXT,
+    ip VARCHAR(45),
+    created_at TEXT DEFAULT (datetime('now', 'localtime'))
+);
+CREATE INDEX IF NOT EXISTS idx_audit_created ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_actor ON audit_log (actor_username);
+CREATE INDEX IF NOT EXISTS idx_audit_target ON audit_log (target_type, target_id);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS content_rule_hit;
+DROP TABLE IF EXISTS content_rule;
+DROP INDEX IF EXISTS idx_message_status;
+ALTER TABLE message DROP COLUMN held_reason;
+ALTER TABLE message DROP COLUMN status;
+DROP INDEX IF EXISTS idx_topic_status;
+ALTER TABLE topic DROP COLUMN held_reason;
+ALTER TABLE topic DROP COLUMN status;
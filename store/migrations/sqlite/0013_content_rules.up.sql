@@ -0,0 +1,41 @@
+-- Statut de publication : 'pending' pour un contenu retenu, visible de l'auteur et des
+-- modérateurs
+ALTER TABLE topic ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published';
+ALTER TABLE topic ADD COLUMN held_reason VARCHAR(255);
+CREATE INDEX idx_topic_status ON topic (status);
+
+ALTER TABLE message ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published';
+ALTER TABLE message ADD COLUMN held_reason VARCHAR(255);
+CREATE INDEX idx_message_status ON message (status);
+
+CREATE TABLE content_rule (
+    rule_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(20) NOT NULL,
+    pattern TEXT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    replacement VARCHAR(255),
+    message VARCHAR(255),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by INT REFERENCES user(user_id),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    updated_at TEXT DEFAULT (datetime('now', 'localtime'))
+);
+
+-- Remplace ON UPDATE CURRENT_TIMESTAMP : la date sert à détecter les règles modifiées
+CREATE TRIGGER content_rule_updated_at AFTER UPDATE ON content_rule FOR EACH ROW
+BEGIN
+    UPDATE content_rule SET updated_at = datetime('now', 'localtime') WHERE rule_id = NEW.rule_id;
+END;
+
+CREATE TABLE content_rule_hit (
+    hit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_id INT NOT NULL REFERENCES content_rule(rule_id),
+    user_id INT REFERENCES user(user_id),
+    target_type VARCHAR(20) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    excerpt VARCHAR(255),
+    created_at TEXT DEFAULT (datetime('now', 'localtime'))
+);
+CREATE INDEX idx_rule_hit_rule ON content_rule_hit (rule_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS spam_training;
+DROP TABLE IF EXISTS spam_token;
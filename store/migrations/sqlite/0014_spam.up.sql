@@ -0,0 +1,15 @@
+CREATE TABLE spam_token (
+    token VARCHAR(64) PRIMARY KEY,
+    spam_count INT NOT NULL DEFAULT 0,
+    ham_count INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE spam_training (
+    target_type VARCHAR(20) NOT NULL,
+    target_id INT NOT NULL,
+    is_spam BOOLEAN NOT NULL,
+    tokens TEXT NOT NULL,
+    trained_by INT REFERENCES user(user_id),
+    created_at TEXT DEFAULT (datetime('now', 'localtime')),
+    PRIMARY KEY (target_type, target_id)
+);
@@ -0,0 +1,7 @@
+-- Imports d'archives (forum import) : une base qui n'est plus vide n'accepte que la
+-- reprise d'une archive dont l'import a commencé
+CREATE TABLE archive_import (
+    archive_id VARCHAR(64) PRIMARY KEY,
+    started_at TEXT NOT NULL,
+    finished_at TEXT
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_response_status;
+ALTER TABLE response DROP COLUMN held_reason;
+ALTER TABLE response DROP COLUMN status;
@@ -0,0 +1,5 @@
+-- Statut de publication des réponses, comme pour les topics et messages : 'pending'
+-- pour une réponse retenue, visible de son auteur et des modérateurs
+ALTER TABLE response ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published';
+ALTER TABLE response ADD COLUMN held_reason VARCHAR(255);
+CREATE INDEX idx_response_status ON response (status);
@@ -0,0 +1 @@
+ALTER TABLE mail_queue DROP COLUMN locked_at;
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// OpenMySQL ouvre la base MySQL décrite par dsn (format de go-sql-driver/mysql) ;
+// le schéma se crée et se met à jour avec `forum migrate`
+func OpenMySQL(dsn string) (Store, error) {
+	migrations, err := loadMigrations(mysqlDialect{}.Name())
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: mysqlDialect{}, migrations: migrations}, nil
+}
+
+func (mysqlDialect) tableExists() string {
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+}
+
+func (mysqlDialect) tables() string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()"
+}
+
+// Le DDL MySQL valide implicitement les transactions : une migration s'exécute hors
+// transaction, sous un verrou nommé qu'une autre instance attend jusqu'à une minute
+func (mysqlDialect) lock(ctx context.Context, conn *sql.Conn) (execer, func(commit bool) error, error) {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK('forum_schema_migration', 60)").Scan(&acquired); err != nil {
+		return nil, nil, err
+	}
+	if acquired.Int64 != 1 {
+		return nil, nil, errMigrationLocked
+	}
+	release := func(bool) error {
+		_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK('forum_schema_migration')")
+		return err
+	}
+	return conn, release, nil
+}
+
+func (mysqlDialect) statements(script string) []string {
+	return splitStatements(script)
+}
+
+func (mysqlDialect) transactional() bool { return false }
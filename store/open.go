@@ -0,0 +1,15 @@
+package store
+
+import "fmt"
+
+// Open ouvre la base du pilote driver ("mysql" ou "sqlite") ; source est le DSN
+// MySQL ou le chemin du fichier SQLite
+func Open(driver, source string) (Store, error) {
+	switch driver {
+	case "mysql":
+		return OpenMySQL(source)
+	case "sqlite":
+		return OpenSQLite(source)
+	}
+	return nil, fmt.Errorf("unknown database driver %q (mysql or sqlite)", driver)
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// sqlStore implémente Store sur une base SQL ; seul le dialecte change entre MySQL et SQLite
+type sqlStore struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+func (s *sqlStore) Users() UserRepository          { return userRepository{s} }
+func (s *sqlStore) Topics() TopicRepository        { return topicRepository{s} }
+func (s *sqlStore) Messages() MessageRepository    { return messageRepository{s} }
+func (s *sqlStore) Likes() LikeRepository          { return likeRepository{s} }
+func (s *sqlStore) Categories() CategoryRepository { return categoryRepository{s} }
+func (s *sqlStore) Dialect() Dialect               { return s.dialect }
+func (s *sqlStore) Close() error                   { return s.db.Close() }
+
+func (s *sqlStore) Query(query string, args ...interface{}) (Rows, error) {
+	return s.db.Query(query, args...)
+}
+
+func (s *sqlStore) QueryRow(query string, args ...interface{}) Row {
+	return s.db.QueryRow(query, args...)
+}
+
+func (s *sqlStore) Exec(query string, args ...interface{}) (Result, error) {
+	return s.db.Exec(query, args...)
+}
+
+func (s *sqlStore) Begin() (Tx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{tx}, nil
+}
+
+// sqlTx et sqlStmt adaptent *sql.Tx et *sql.Stmt aux types du package
+type sqlTx struct {
+	tx *sql.Tx
+}
+
+func (t sqlTx) Query(query string, args ...interface{}) (Rows, error) {
+	return t.tx.Query(query, args...)
+}
+
+func (t sqlTx) QueryRow(query string, args ...interface{}) Row {
+	return t.tx.QueryRow(query, args...)
+}
+
+func (t sqlTx) Exec(query string, args ...interface{}) (Result, error) {
+	return t.tx.Exec(query, args...)
+}
+
+func (t sqlTx) Prepare(query string) (Stmt, error) {
+	stmt, err := t.tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return sqlStmt{stmt}, nil
+}
+
+func (t sqlTx) Commit() error   { return t.tx.Commit() }
+func (t sqlTx) Rollback() error { return t.tx.Rollback() }
+
+type sqlStmt struct {
+	stmt *sql.Stmt
+}
+
+func (s sqlStmt) Exec(args ...interface{}) (Result, error) {
+	return s.stmt.Exec(args...)
+}
+
+func (s sqlStmt) Close() error { return s.stmt.Close() }
+
+// VisibleCondition restreint une requête sur topic ou message (alias) aux contenus
+// que le lecteur peut voir : tout pour un modérateur, sinon les contenus publiés et
+// les siens
+func VisibleCondition(alias string, viewer Viewer) (string, []interface{}) {
+	if viewer.Moderator {
+		return "1 = 1", nil
+	}
+	if viewer.UserID == 0 {
+		return alias + ".status = '" + StatusPublished + "'", nil
+	}
+	return "(" + alias + ".status = '" + StatusPublished + "' OR " + alias + ".user_id = ?)", []interface{}{viewer.UserID}
+}
+
+// TagCondition filtre sur un tag la colonne column, où les tags sont stockés sous
+// la forme "tag1, tag2, tag3"
+func TagCondition(column, tag string) (string, []interface{}) {
+	condition := fmt.Sprintf("(%[1]s LIKE ? OR %[1]s LIKE ? OR %[1]s LIKE ? OR %[1]s = ?)", column)
+	return condition, []interface{}{
+		tag + ", %",         // tag au début
+		"%, " + tag + ", %", // tag au milieu
+		"%, " + tag,         // tag à la fin
+		tag,                 // tag unique
+	}
+}
+
+// limitClause renvoie la pagination d'une requête ; limit 0 pour toutes les lignes
+func limitClause(limit, offset int) (string, []interface{}) {
+	if limit <= 0 {
+		return "", nil
+	}
+	return " LIMIT ? OFFSET ?", []interface{}{limit, offset}
+}
+
+// scanner est commun à *sql.Row et *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// Fonctions MySQL utilisées par les requêtes du forum, recréées pour SQLite
+func init() {
+	sqlite.MustRegisterScalarFunction("NOW", 0, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return time.Now().Format("2006-01-02 15:04:05"), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("GREATEST", -1, sqliteGreatest)
+	sqlite.MustRegisterDeterministicScalarFunction("FIND_IN_SET", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		needle := fmt.Sprint(args[0])
+		for i, item := range strings.Split(fmt.Sprint(args[1]), ",") {
+			if item == needle {
+				return int64(i + 1), nil
+			}
+		}
+		return int64(0), nil
+	})
+}
+
+// sqliteGreatest renvoie le plus grand argument, ou NULL si l'un d'eux est NULL
+func sqliteGreatest(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("GREATEST needs at least one argument")
+	}
+	greatest := args[0]
+	for _, arg := range args {
+		if arg == nil {
+			return nil, nil
+		}
+		if sqliteLess(greatest, arg) {
+			greatest = arg
+		}
+	}
+	return greatest, nil
+}
+
+func sqliteLess(a, b driver.Value) bool {
+	toFloat := func(v driver.Value) (float64, bool) {
+		switch n := v.(type) {
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+		return 0, false
+	}
+	x, okA := toFloat(a)
+	y, okB := toFloat(b)
+	if okA && okB {
+		return x < y
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// OpenSQLite ouvre (ou crée) la base SQLite du fichier path ; le schéma se crée et
+// se met à jour avec `forum migrate`. Les transactions prennent le verrou d'écriture
+// dès BEGIN et une écriture concurrente attend jusqu'à 5 secondes.
+func OpenSQLite(path string) (Store, error) {
+	migrations, err := loadMigrations(sqliteDialect{}.Name())
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: sqliteDialect{}, migrations: migrations}, nil
+}
+
+func (sqliteDialect) tableExists() string {
+	return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+}
+
+func (sqliteDialect) tables() string {
+	return "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'"
+}
+
+// Le DDL SQLite est transactionnel : toutes les étapes s'exécutent dans une seule
+// transaction BEGIN IMMEDIATE, qui sert aussi de verrou, et sont annulées ensemble
+// en cas d'échec
+func (sqliteDialect) lock(ctx context.Context, conn *sql.Conn) (execer, func(commit bool) error, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	finish := func(commit bool) error {
+		if commit {
+			return tx.Commit()
+		}
+		return tx.Rollback()
+	}
+	return tx, finish, nil
+}
+
+func (sqliteDialect) statements(script string) []string {
+	return []string{script}
+}
+
+func (sqliteDialect) transactional() bool { return true }
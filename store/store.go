@@ -0,0 +1,275 @@
+// Package store isole l'accès aux données du forum. Les utilisateurs, topics,
+// messages et votes passent par des dépôts ; les autres fonctionnalités
+// (notifications, modération, webhooks...) écrivent leurs requêtes sur leurs propres
+// tables, portables entre MySQL et SQLite avec l'aide de Dialect, au travers des
+// types Rows, Row, Result et Tx du package : database/sql reste interne au store.
+package store
+
+import "database/sql"
+
+// ErrNotFound est renvoyée quand l'élément demandé n'existe pas ou n'est pas visible
+var ErrNotFound = sql.ErrNoRows
+
+// Statuts de publication des topics et messages
+const (
+	StatusPublished = "published"
+	StatusPending   = "pending"
+)
+
+// Store regroupe les dépôts et l'accès SQL d'une base
+type Store interface {
+	Users() UserRepository
+	Topics() TopicRepository
+	Messages() MessageRepository
+	Likes() LikeRepository
+	Categories() CategoryRepository
+
+	// Accès SQL des fonctionnalités qui n'ont pas de dépôt
+	Query(query string, args ...interface{}) (Rows, error)
+	QueryRow(query string, args ...interface{}) Row
+	Exec(query string, args ...interface{}) (Result, error)
+	Begin() (Tx, error)
+	Dialect() Dialect
+
+	// Migrations du schéma (voir migrate.go)
+	Migrations() []Migration
+	SchemaVersion() (int, error)
+	Migrate(target int) ([]MigrationStep, error)
+
+	Close() error
+}
+
+// Row est une ligne de résultat ; Scan renvoie ErrNotFound si la requête n'en a pas
+type Row interface {
+	Scan(dest ...interface{}) error
+}
+
+// Rows parcourt les lignes d'un résultat, à fermer après lecture. Une colonne
+// qui peut être NULL se lit dans un pointeur (*string, *int64...), nil pour NULL.
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
+}
+
+// Result décrit l'effet d'une requête d'écriture
+type Result interface {
+	LastInsertId() (int64, error)
+	RowsAffected() (int64, error)
+}
+
+// Stmt est une requête préparée dans une transaction
+type Stmt interface {
+	Exec(args ...interface{}) (Result, error)
+	Close() error
+}
+
+// Tx est une transaction, terminée par Commit ou Rollback
+type Tx interface {
+	Query(query string, args ...interface{}) (Rows, error)
+	QueryRow(query string, args ...interface{}) Row
+	Exec(query string, args ...interface{}) (Result, error)
+	Prepare(query string) (Stmt, error)
+	Commit() error
+	Rollback() error
+}
+
+// Viewer est l'utilisateur pour qui sont lus les topics et messages : les contenus
+// en attente ne sont visibles que de leur auteur et des modérateurs
+type Viewer struct {
+	UserID    int // 0 pour un visiteur anonyme
+	Moderator bool
+}
+
+// Account contient ce qu'il faut pour authentifier un utilisateur
+type Account struct {
+	ID           int
+	Username     string
+	PasswordHash string
+}
+
+// NewUser est un compte à créer
+type NewUser struct {
+	Username     string
+	Mail         string
+	PasswordHash string
+	RoleID       int
+}
+
+// UserProfile est le profil public d'un utilisateur
+type UserProfile struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	Bio       string `json:"bio"`
+	TopicNbr  int    `json:"topic_nbr"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Activity résume l'ancienneté et les contributions d'un compte
+type Activity struct {
+	CreatedAt     string // format "2006-01-02 15:04:05"
+	Published     int    // topics et messages publiés
+	LikesReceived int    // likes d'autres utilisateurs sur ses topics et réponses
+	RoleID        int
+}
+
+// UserRepository gère les comptes
+type UserRepository interface {
+	Create(user NewUser) (int64, error)
+	// Taken indique si le nom d'utilisateur ou l'adresse e-mail est déjà utilisé
+	Taken(username, mail string) (bool, error)
+	Account(username string) (Account, error)
+	// Exists indique si le compte existe encore
+	Exists(userID int) (bool, error)
+	// IDsByName renvoie l'identifiant des comptes existants parmi usernames, par nom enregistré
+	IDsByName(usernames []string) (map[string]int, error)
+	Mail(userID int) (string, error)
+	Profile(userID int) (UserProfile, error)
+	ProfileByName(username string) (UserProfile, error)
+	// Role renvoie le rôle du compte, 0 s'il n'en a pas
+	Role(userID int) (int, error)
+	SetRole(userID, roleID int) error
+	SetPassword(userID int, passwordHash string) error
+	Activity(userID int) (Activity, error)
+	TouchLastConnection(userID int) error
+	// AddTopicCount ajoute delta au nombre de topics publiés, sans descendre sous zéro
+	AddTopicCount(userID, delta int) error
+}
+
+type Topic struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Tags        string `json:"tags"`
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	StateID     int    `json:"state_id"`
+	Status      string `json:"status"`
+	Likes       int    `json:"likes"`
+	Dislikes    int    `json:"dislikes"`
+	CreatedAt   string `json:"created_at"`
+	UserLike    *bool  `json:"user_like,omitempty"`
+}
+
+// NewTopic est un topic à enregistrer
+type NewTopic struct {
+	Title       string
+	Description string
+	Tags        string
+	UserID      int
+	StateID     int
+	Status      string
+	HeldReason  string
+}
+
+// Tris des listes de topics
+const (
+	SortRecent   = "recent"
+	SortLikes    = "likes"
+	SortDislikes = "dislikes"
+)
+
+// TopicFilter sélectionne une page de topics
+type TopicFilter struct {
+	Viewer Viewer
+	Tag    string // tags stockés sous la forme "tag1, tag2, tag3"
+	Sort   string
+	Limit  int // 0 pour tous les topics
+	Offset int
+}
+
+// TopicRepository gère les topics ; les compteurs de votes et le vote du lecteur
+// (UserLike) sont renseignés à la lecture
+type TopicRepository interface {
+	Create(topic NewTopic) (int64, error)
+	Get(id int, viewer Viewer) (Topic, error)
+	List(filter TopicFilter) ([]Topic, error)
+	Count(filter TopicFilter) (int, error)
+	// Tags renvoie la colonne tags de chaque topic publié qui en a
+	Tags() ([]string, error)
+	SetState(id, stateID int) error
+	SetTags(id int, tags string) error
+}
+
+type Message struct {
+	ID          int    `json:"id"`
+	Content     string `json:"content"`
+	ContentHTML string `json:"content_html"` // vide si le rendu n'a pas été mis en cache
+	CreatedAt   string `json:"created_at"`
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	TopicID     int    `json:"topic_id"`
+	Status      string `json:"status"`
+}
+
+// NewMessage est un message à enregistrer
+type NewMessage struct {
+	Content     string
+	ContentHTML string
+	TopicID     int
+	UserID      int
+	Status      string
+	HeldReason  string
+}
+
+// Reply est une réponse à un message (table response)
+type Reply struct {
+	ID          int    `json:"id"`
+	Content     string `json:"content"`
+	ContentHTML string `json:"content_html"`
+	CreatedAt   string `json:"created_at"`
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	MessageID   int    `json:"message_id"`
+	Status      string `json:"status"`
+	Likes       int    `json:"likes"`
+	Dislikes    int    `json:"dislikes"`
+}
+
+// NewReply est une réponse à enregistrer
+type NewReply struct {
+	Content    string
+	MessageID  int
+	UserID     int
+	Status     string
+	HeldReason string
+}
+
+// MessageRepository gère les messages des topics et leurs réponses
+type MessageRepository interface {
+	Create(message NewMessage) (int64, error)
+	Get(id int, viewer Viewer) (Message, error)
+	// ListByTopic renvoie les messages du plus ancien au plus récent ; limit 0 pour tous
+	ListByTopic(topicID int, viewer Viewer, limit, offset int) ([]Message, error)
+	CountByTopic(topicID int, viewer Viewer) (int, error)
+
+	CreateReply(reply NewReply) (int64, error)
+	GetReply(id int, viewer Viewer) (Reply, error)
+	ListReplies(messageID int, viewer Viewer, limit, offset int) ([]Reply, error)
+	CountReplies(messageID int, viewer Viewer) (int, error)
+}
+
+// LikeRepository gère les votes : like (true), dislike (false) ou aucun vote (nil)
+type LikeRepository interface {
+	TopicVote(userID, topicID int) (*bool, error)
+	// TopicVotes compte les likes et dislikes d'un topic
+	TopicVotes(topicID int) (likes, dislikes int, err error)
+	SetTopicVote(userID, topicID int, liked *bool) error
+	SetReplyVote(userID, replyID int, liked *bool) error
+}
+
+// Category est une catégorie de topics ; Slug est la valeur enregistrée dans les tags
+type Category struct {
+	ID    int    `json:"id"`
+	Slug  string `json:"slug"`
+	Label string `json:"label"`
+}
+
+// CategoryRepository gère les catégories, dans leur ordre d'affichage
+type CategoryRepository interface {
+	List() ([]Category, error)
+	Get(slug string) (Category, error)
+	// Create ajoute une catégorie en dernière position
+	Create(slug, label string) (int64, error)
+}
@@ -0,0 +1,123 @@
+package store
+
+import "database/sql"
+
+type topicRepository struct {
+	s *sqlStore
+}
+
+// topicColumns lit un topic, ses votes et le vote du lecteur (premier paramètre)
+const topicColumns = `
+	SELECT t.topic_id, t.title, COALESCE(t.description, ''), COALESCE(t.tags, ''), t.user_id, u.username,
+		COALESCE(t.state_id, 0), t.status, t.created_at,
+		(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = t.topic_id AND liked = TRUE) AS likes,
+		(SELECT COUNT(*) FROM topic_user_like WHERE topic_id = t.topic_id AND liked = FALSE) AS dislikes,
+		(SELECT liked FROM topic_user_like WHERE topic_id = t.topic_id AND user_id = ?) AS user_like
+	FROM topic t
+	JOIN user u ON t.user_id = u.user_id`
+
+func scanTopic(row scanner) (Topic, error) {
+	var topic Topic
+	var userLike sql.NullBool
+	err := row.Scan(&topic.ID, &topic.Title, &topic.Description, &topic.Tags, &topic.UserID, &topic.Username,
+		&topic.StateID, &topic.Status, &topic.CreatedAt, &topic.Likes, &topic.Dislikes, &userLike)
+	if userLike.Valid {
+		topic.UserLike = &userLike.Bool
+	}
+	return topic, err
+}
+
+func (r topicRepository) Create(topic NewTopic) (int64, error) {
+	result, err := r.s.db.Exec(`
+		INSERT INTO topic (title, description, tags, user_id, state_id, status, held_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, topic.Title, topic.Description, topic.Tags, topic.UserID, topic.StateID, topic.Status, topic.HeldReason)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r topicRepository) Get(id int, viewer Viewer) (Topic, error) {
+	visible, args := VisibleCondition("t", viewer)
+	args = append([]interface{}{viewer.UserID, id}, args...)
+	return scanTopic(r.s.db.QueryRow(topicColumns+" WHERE t.topic_id = ? AND "+visible, args...))
+}
+
+// where renvoie les conditions communes à List et Count
+func (f TopicFilter) where() (string, []interface{}) {
+	visible, args := VisibleCondition("t", f.Viewer)
+	where := " WHERE " + visible
+	if f.Tag != "" {
+		condition, tagArgs := TagCondition("t.tags", f.Tag)
+		where += " AND " + condition
+		args = append(args, tagArgs...)
+	}
+	return where, args
+}
+
+func (r topicRepository) List(filter TopicFilter) ([]Topic, error) {
+	var orderBy string
+	switch filter.Sort {
+	case SortLikes:
+		orderBy = " ORDER BY likes DESC, t.topic_id DESC"
+	case SortDislikes:
+		orderBy = " ORDER BY dislikes DESC, t.topic_id DESC"
+	default:
+		orderBy = " ORDER BY t.created_at DESC, t.topic_id DESC"
+	}
+
+	where, args := filter.where()
+	limit, limitArgs := limitClause(filter.Limit, filter.Offset)
+	args = append(append([]interface{}{filter.Viewer.UserID}, args...), limitArgs...)
+	rows, err := r.s.db.Query(topicColumns+where+orderBy+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []Topic
+	for rows.Next() {
+		topic, err := scanTopic(rows)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
+}
+
+func (r topicRepository) Count(filter TopicFilter) (int, error) {
+	where, args := filter.where()
+	var total int
+	err := r.s.db.QueryRow("SELECT COUNT(*) FROM topic t"+where, args...).Scan(&total)
+	return total, err
+}
+
+func (r topicRepository) Tags() ([]string, error) {
+	rows, err := r.s.db.Query("SELECT tags FROM topic WHERE tags IS NOT NULL AND tags <> '' AND status = ?", StatusPublished)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		tags = append(tags, value)
+	}
+	return tags, rows.Err()
+}
+
+func (r topicRepository) SetState(id, stateID int) error {
+	_, err := r.s.db.Exec("UPDATE topic SET state_id = ? WHERE topic_id = ?", stateID, id)
+	return err
+}
+
+func (r topicRepository) SetTags(id int, tags string) error {
+	_, err := r.s.db.Exec("UPDATE topic SET tags = ? WHERE topic_id = ?", tags, id)
+	return err
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+)
+
+type userRepository struct {
+	s *sqlStore
+}
+
+func (r userRepository) Create(user NewUser) (int64, error) {
+	result, err := r.s.db.Exec(`
+		INSERT INTO user (username, mail, password, role_id) VALUES (?, ?, ?, ?)
+	`, user.Username, user.Mail, user.PasswordHash, user.RoleID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r userRepository) Taken(username, mail string) (bool, error) {
+	var exists bool
+	err := r.s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM user WHERE username = ? OR mail = ?)", username, mail).Scan(&exists)
+	return exists, err
+}
+
+func (r userRepository) Account(username string) (Account, error) {
+	var account Account
+	err := r.s.db.QueryRow("SELECT user_id, username, password FROM user WHERE username = ?", username).
+		Scan(&account.ID, &account.Username, &account.PasswordHash)
+	return account, err
+}
+
+func (r userRepository) Exists(userID int) (bool, error) {
+	var exists bool
+	err := r.s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM user WHERE user_id = ?)", userID).Scan(&exists)
+	return exists, err
+}
+
+func (r userRepository) IDsByName(usernames []string) (map[string]int, error) {
+	ids := make(map[string]int)
+	if len(usernames) == 0 {
+		return ids, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(usernames)), ", ")
+	args := make([]interface{}, len(usernames))
+	for i, name := range usernames {
+		args[i] = name
+	}
+	rows, err := r.s.db.Query("SELECT user_id, username FROM user WHERE username IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		var username string
+		if err := rows.Scan(&userID, &username); err != nil {
+			return nil, err
+		}
+		ids[username] = userID
+	}
+	return ids, rows.Err()
+}
+
+func (r userRepository) Mail(userID int) (string, error) {
+	var mail string
+	err := r.s.db.QueryRow("SELECT mail FROM user WHERE user_id = ?", userID).Scan(&mail)
+	return mail, err
+}
+
+const profileColumns = "SELECT user_id, username, COALESCE(bio, ''), COALESCE(topic_nbr, 0), created_at FROM user"
+
+func scanProfile(row scanner) (UserProfile, error) {
+	var profile UserProfile
+	err := row.Scan(&profile.ID, &profile.Username, &profile.Bio, &profile.TopicNbr, &profile.CreatedAt)
+	return profile, err
+}
+
+func (r userRepository) Profile(userID int) (UserProfile, error) {
+	return scanProfile(r.s.db.QueryRow(profileColumns+" WHERE user_id = ?", userID))
+}
+
+func (r userRepository) ProfileByName(username string) (UserProfile, error) {
+	return scanProfile(r.s.db.QueryRow(profileColumns+" WHERE username = ?", username))
+}
+
+func (r userRepository) Role(userID int) (int, error) {
+	var role sql.NullInt64
+	err := r.s.db.QueryRow("SELECT role_id FROM user WHERE user_id = ?", userID).Scan(&role)
+	return int(role.Int64), err
+}
+
+func (r userRepository) SetRole(userID, roleID int) error {
+	_, err := r.s.db.Exec("UPDATE user SET role_id = ? WHERE user_id = ?", roleID, userID)
+	return err
+}
+
+func (r userRepository) SetPassword(userID int, passwordHash string) error {
+	_, err := r.s.db.Exec("UPDATE user SET password = ? WHERE user_id = ?", passwordHash, userID)
+	return err
+}
+
+func (r userRepository) Activity(userID int) (Activity, error) {
+	var activity Activity
+	var roleID sql.NullInt64
+	err := r.s.db.QueryRow(`
+		SELECT COALESCE(u.created_at, ''),
+			(SELECT COUNT(*) FROM topic WHERE user_id = u.user_id AND status = 'published')
+				+ (SELECT COUNT(*) FROM message WHERE user_id = u.user_id AND status = 'published'),
+			(SELECT COUNT(*) FROM topic_user_like l JOIN topic t ON l.topic_id = t.topic_id
+				WHERE t.user_id = u.user_id AND l.user_id <> u.user_id AND l.liked = TRUE)
+				+ (SELECT COUNT(*) FROM response_user_like l JOIN response r ON l.response_id = r.response_id
+				WHERE r.user_id = u.user_id AND l.user_id <> u.user_id AND l.liked = TRUE),
+			u.role_id
+		FROM user u
+		WHERE u.user_id = ?
+	`, userID).Scan(&activity.CreatedAt, &activity.Published, &activity.LikesReceived, &roleID)
+	activity.RoleID = int(roleID.Int64)
+	return activity, err
+}
+
+func (r userRepository) TouchLastConnection(userID int) error {
+	_, err := r.s.db.Exec("UPDATE user SET last_connection = NOW() WHERE user_id = ?", userID)
+	return err
+}
+
+func (r userRepository) AddTopicCount(userID, delta int) error {
+	_, err := r.s.db.Exec(`
+		UPDATE user SET topic_nbr = GREATEST(COALESCE(topic_nbr, 0) + ?, 0) WHERE user_id = ?
+	`, delta, userID)
+	return err
+}